@@ -1,4 +1,4 @@
-// v10
+// v14
 // services/aggregator/internal/aggregation.go
 // Package internal hosts the aggregation routines used by the service runtime.
 package internal
@@ -12,6 +12,11 @@ import (
 // aggregate cleans overhead, removes outliers, and groups by device.
 // The zone parameter must be a pure zone identifier such as "zone-A".
 func aggregate(zone string, epoch EpochID, readings []Reading, zThresh float64, energyState *EnergyState) AggregatedEpoch {
+	return aggregateAt(zone, epoch, readings, zThresh, energyState, time.Now())
+}
+
+// aggregateAt is aggregate with an explicit ProducedAt so replays stay deterministic.
+func aggregateAt(zone string, epoch EpochID, readings []Reading, zThresh float64, energyState *EnergyState, producedAt time.Time) AggregatedEpoch {
 	clean := removeOutliers(readings, zThresh)
 	byDev := map[string][]Reading{}
 	var temps, powers, energies []float64
@@ -39,6 +44,7 @@ func aggregate(zone string, epoch EpochID, readings []Reading, zThresh float64,
 	if len(energies) > 0 {
 		summary["avgEnergyKWh"] = mean(energies)
 	}
+	sensorStats := summariseSensors(byDev, summary)
 	deviceEnergies, zoneEnergy := computeActuatorEnergy(zone, epoch, byDev, energyState)
 	summary["zoneEnergyKWhEpoch"] = zoneEnergy
 	return AggregatedEpoch{
@@ -47,20 +53,23 @@ func aggregate(zone string, epoch EpochID, readings []Reading, zThresh float64,
 		Epoch:                  epoch,
 		ByDevice:               byDev,
 		Summary:                summary,
-		ProducedAt:             time.Now(),
+		ProducedAt:             producedAt,
 		ActuatorEnergyKWhEpoch: deviceEnergies,
 		ZoneEnergyKWhEpoch:     zoneEnergy,
+		SensorStats:            sensorStats,
 	}
 }
 
 // EnergyState keeps the last known actuator power value to bridge epoch gaps.
+// The last completed epoch of every zone is tracked so that snapshots can be aged on restore.
 type EnergyState struct {
-	last map[string]map[string]float64
+	last   map[string]map[string]float64
+	epochs map[string]EpochID
 }
 
 // NewEnergyState creates an empty accumulator.
 func NewEnergyState() *EnergyState {
-	return &EnergyState{last: map[string]map[string]float64{}}
+	return &EnergyState{last: map[string]map[string]float64{}, epochs: map[string]EpochID{}}
 }
 
 func (s *EnergyState) remember(zone, device string, kw float64) {
@@ -217,6 +226,20 @@ func removeOutliers(rs []Reading, z float64) []Reading {
 	mt, st := meanStd(temps)
 	mp, sp := meanStd(powers)
 	me, se := meanStd(energies)
+	sensorVals := map[string][]float64{}
+	for _, r := range rs {
+		for key, v := range r.Sensors {
+			if typ, ok := sensorByKey(key); ok && typ.Kind == sensorContinuous {
+				sensorVals[key] = append(sensorVals[key], v)
+			}
+		}
+	}
+	type meanStdPair struct{ m, s float64 }
+	sensorMS := make(map[string]meanStdPair, len(sensorVals))
+	for key, vals := range sensorVals {
+		m, s := meanStd(vals)
+		sensorMS[key] = meanStdPair{m, s}
+	}
 	out := make([]Reading, 0, len(rs))
 	for _, r := range rs {
 		ok := true
@@ -229,6 +252,11 @@ func removeOutliers(rs []Reading, z float64) []Reading {
 		if r.EnergyKWh != nil && se > 0 && math.Abs((*r.EnergyKWh-me)/se) > z {
 			ok = false
 		}
+		for key, v := range r.Sensors {
+			if ms, tracked := sensorMS[key]; tracked && ms.s > 0 && math.Abs((v-ms.m)/ms.s) > z {
+				ok = false
+			}
+		}
 		if ok {
 			out = append(out, r)
 		}
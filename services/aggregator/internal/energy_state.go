@@ -0,0 +1,97 @@
+// Package internal v2
+// file: internal/energy_state.go
+package internal
+
+import (
+	"sort"
+	"time"
+)
+
+// EnergySnapshot is the persisted form of EnergyState. It is written next to the
+// partition offsets so that a restart resumes integration from the same carryover.
+// The top-level epoch is the latest-ending one; Zones holds the last epoch of every
+// zone and is used for aging when present.
+type EnergySnapshot struct {
+	EpochIndex int64                         `json:"epochIndex"`
+	EpochEnd   time.Time                     `json:"epochEnd"`
+	EpochMs    int64                         `json:"epochMs"`
+	Zones      map[string]EnergyZoneMark     `json:"zones,omitempty"`
+	LastKW     map[string]map[string]float64 `json:"lastKW"`
+}
+
+// EnergyZoneMark is the last epoch processed for one zone.
+type EnergyZoneMark struct {
+	EpochIndex int64     `json:"epochIndex"`
+	EpochEnd   time.Time `json:"epochEnd"`
+	EpochMs    int64     `json:"epochMs"`
+}
+
+// MarkEpoch records an epoch of the zone that has just been fully processed.
+func (s *EnergyState) MarkEpoch(zone string, epoch EpochID) {
+	if s == nil {
+		return
+	}
+	s.epochs[zone] = epoch
+}
+
+// Snapshot returns a deep copy of the carryover values, or nil when nothing was processed yet.
+func (s *EnergyState) Snapshot() *EnergySnapshot {
+	if s == nil || len(s.epochs) == 0 {
+		return nil
+	}
+	snap := &EnergySnapshot{
+		Zones:  make(map[string]EnergyZoneMark, len(s.epochs)),
+		LastKW: make(map[string]map[string]float64, len(s.last)),
+	}
+	for zone, ep := range s.epochs {
+		snap.Zones[zone] = EnergyZoneMark{EpochIndex: ep.Index, EpochEnd: ep.End, EpochMs: ep.Len.Milliseconds()}
+		if ep.End.After(snap.EpochEnd) {
+			snap.EpochIndex, snap.EpochEnd, snap.EpochMs = ep.Index, ep.End, ep.Len.Milliseconds()
+		}
+	}
+	for zone, devs := range s.last {
+		cp := make(map[string]float64, len(devs))
+		for dev, kw := range devs {
+			cp[dev] = kw
+		}
+		snap.LastKW[zone] = cp
+	}
+	return snap
+}
+
+// Restore loads carryover values from a snapshot, zone by zone, unless the zone's last
+// epoch ended more than maxAgeEpochs epochs of the zone's own length (epochFor) before
+// now. Stale zones are dropped so that the first epoch after a long outage does not
+// assume actuators kept drawing the same power. Snapshots without per-zone epochs are
+// aged by their top-level epoch. It returns the number of restored actuator entries and
+// the zones that were stale.
+func (s *EnergyState) Restore(snap *EnergySnapshot, now time.Time, epochFor func(zone string) time.Duration, maxAgeEpochs int) (int, []string) {
+	if s == nil || snap == nil || len(snap.LastKW) == 0 {
+		return 0, nil
+	}
+	zones := make([]string, 0, len(snap.LastKW))
+	for zone := range snap.LastKW {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	restored := 0
+	var stale []string
+	for _, zone := range zones {
+		mark, ok := snap.Zones[zone]
+		if !ok {
+			mark = EnergyZoneMark{EpochIndex: snap.EpochIndex, EpochEnd: snap.EpochEnd, EpochMs: snap.EpochMs}
+		}
+		epochLen := epochFor(zone)
+		if maxAgeEpochs <= 0 || epochLen <= 0 || now.Sub(mark.EpochEnd) > time.Duration(maxAgeEpochs)*epochLen {
+			stale = append(stale, zone)
+			continue
+		}
+		for dev, kw := range snap.LastKW[zone] {
+			s.remember(zone, dev, kw)
+			restored++
+		}
+		length := time.Duration(mark.EpochMs) * time.Millisecond
+		s.epochs[zone] = EpochID{Index: mark.EpochIndex, Start: mark.EpochEnd.Add(-length), End: mark.EpochEnd, Len: length}
+	}
+	return restored, stale
+}
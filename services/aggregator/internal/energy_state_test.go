@@ -0,0 +1,100 @@
+// Package internal v1
+// file: internal/energy_state_test.go
+package internal
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnergyStatePersistsWithOffsets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offsets.json")
+	base := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	epoch := EpochID{Start: base, End: base.Add(10 * time.Minute), Index: 1, Len: 10 * time.Minute}
+
+	off := NewOffsets(path)
+	state := NewEnergyState()
+	off.AttachEnergy(state)
+	_ = aggregate("zoneA", epoch, []Reading{makeActuatorReading("zoneA", "dev1", base.Add(time.Minute), 1.5)}, 0, state)
+	state.MarkEpoch("zoneA", epoch)
+	off.Set("device.readings.zoneA", 0, 41)
+	if err := off.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("expected temp file to be renamed away, stat err=%v", err)
+	}
+
+	reloaded := NewOffsets(path)
+	if got := reloaded.Get("device.readings.zoneA", 0); got != 41 {
+		t.Fatalf("expected offset 41, got %d", got)
+	}
+	restoredState := NewEnergyState()
+	n, stale := restoredState.Restore(reloaded.LoadedEnergy(), epoch.End.Add(time.Minute), func(string) time.Duration { return epoch.Len }, 3)
+	if len(stale) != 0 || n != 1 {
+		t.Fatalf("expected 1 restored entry, got n=%d stale=%v", n, stale)
+	}
+	next := EpochID{Start: epoch.End, End: epoch.End.Add(10 * time.Minute), Index: 2, Len: 10 * time.Minute}
+	agg := aggregate("zoneA", next, nil, 0, restoredState)
+	want := 1.5 * (10.0 / 60.0)
+	if got := agg.ActuatorEnergyKWhEpoch["dev1"]; math.Abs(got-want) > 1e-6 {
+		t.Fatalf("expected %.6f kWh carryover after restart, got %.6f", want, got)
+	}
+}
+
+func TestEnergyStateRestoreDiscardsStaleSnapshot(t *testing.T) {
+	end := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	snap := &EnergySnapshot{EpochIndex: 7, EpochEnd: end, EpochMs: 1000, LastKW: map[string]map[string]float64{"zoneA": {"dev1": 2}}}
+	state := NewEnergyState()
+	n, stale := state.Restore(snap, end.Add(11*time.Second), func(string) time.Duration { return time.Second }, 10)
+	if len(stale) != 1 || n != 0 {
+		t.Fatalf("expected stale snapshot to be dropped, got n=%d stale=%v", n, stale)
+	}
+	if _, ok := state.recall("zoneA", "dev1"); ok {
+		t.Fatalf("stale snapshot must not seed carryover")
+	}
+}
+
+func TestEnergyStateAgesEachZoneByItsEpoch(t *testing.T) {
+	base := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	lens := map[string]time.Duration{"fast": time.Second, "slow": time.Minute}
+	state := NewEnergyState()
+	state.remember("fast", "dev1", 1)
+	state.remember("slow", "dev2", 3)
+	state.MarkEpoch("slow", EpochID{Index: 1, Start: base.Add(-time.Minute), End: base, Len: time.Minute})
+	state.MarkEpoch("fast", EpochID{Index: 60, Start: base.Add(59 * time.Second), End: base.Add(time.Minute), Len: time.Second})
+	snap := state.Snapshot()
+	if snap.EpochEnd != base.Add(time.Minute) || snap.Zones["slow"].EpochEnd != base {
+		t.Fatalf("unexpected snapshot epochs: %+v", snap)
+	}
+
+	// Two minutes on, the slow zone is two of its epochs old while the fast one is 60.
+	restored := NewEnergyState()
+	n, stale := restored.Restore(snap, base.Add(2*time.Minute), func(zone string) time.Duration { return lens[zone] }, 10)
+	if n != 1 || len(stale) != 1 || stale[0] != "fast" {
+		t.Fatalf("expected only the fast zone to be stale, got n=%d stale=%v", n, stale)
+	}
+	if kw, ok := restored.recall("slow", "dev2"); !ok || kw != 3 {
+		t.Fatalf("slow zone carryover must survive, got %v %v", kw, ok)
+	}
+	if _, ok := restored.recall("fast", "dev1"); ok {
+		t.Fatalf("stale fast zone must not seed carryover")
+	}
+}
+
+func TestOffsetsLoadsLegacyLayout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offsets.json")
+	if err := os.WriteFile(path, []byte(`{"device.readings.zoneA":{"0":12}}`), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	off := NewOffsets(path)
+	if got := off.Get("device.readings.zoneA", 0); got != 12 {
+		t.Fatalf("expected legacy offset 12, got %d", got)
+	}
+	if off.LoadedEnergy() != nil {
+		t.Fatalf("legacy layout has no energy snapshot")
+	}
+}
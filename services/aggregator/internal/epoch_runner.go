@@ -1,4 +1,4 @@
-// v12
+// v16
 // services/aggregator/internal/epoch_runner.go
 package internal
 
@@ -9,10 +9,13 @@ import (
 	"math/rand"
 	"sort"
 	"time"
+
+	"NRG-CHAMP/aggregator/internal/metrics"
 )
 
 // Start runs the service main loop with epoch-based RR scheduling.
-func Start(ctx context.Context, log *slog.Logger, cfg Config, io IO, h *Health) error {
+// Every published epoch is also recorded in hist (may be nil) for the inspection API.
+func Start(ctx context.Context, log *slog.Logger, cfg Config, io IO, h *Health, hist *EpochHistory) error {
 	zones := map[string]struct{}{}
 	for _, topic := range cfg.Topics {
 		zone := extractZoneFromTopic(topic)
@@ -26,6 +29,9 @@ func Start(ctx context.Context, log *slog.Logger, cfg Config, io IO, h *Health)
 		orderedZones = append(orderedZones, zone)
 	}
 	sort.Strings(orderedZones)
+	if err := validateRollups(cfg, orderedZones); err != nil {
+		return err
+	}
 	if err := validateLedgerTopics(ctx, log, cfg.Brokers, cfg.LedgerTopicTmpl, orderedZones); err != nil {
 		return fmt.Errorf("ledger topic validation failed: %w", err)
 	}
@@ -41,11 +47,29 @@ func Start(ctx context.Context, log *slog.Logger, cfg Config, io IO, h *Health)
 		io.Producer = NewWriters(io.CB, cfg)
 	}
 
-	ticker := time.NewTicker(cfg.Epoch)
+	energyState := NewEnergyState()
+	if snap := off.LoadedEnergy(); snap != nil {
+		restored, stale := energyState.Restore(snap, time.Now(), cfg.EpochFor, cfg.EnergyMaxAgeEpochs)
+		if len(stale) > 0 {
+			log.Warn("energy_state_stale", "zones", stale, "snapshot_end", snap.EpochEnd, "max_age_epochs", cfg.EnergyMaxAgeEpochs)
+		}
+		log.Info("energy_state_restored", "snapshot_epoch", snap.EpochIndex, "entries", restored)
+	}
+	off.AttachEnergy(energyState)
+	rollups := NewRollups(cfg.Rollups)
+
+	// Tick at the greatest common divisor of all epoch lengths so each zone's boundary is hit;
+	// a zone is processed only on ticks that start a new epoch for it.
+	tick := cfg.Epoch
+	for _, topic := range cfg.Topics {
+		tick = gcdDuration(tick, cfg.EpochFor(extractZoneFromTopic(topic)))
+	}
+	log.Info("epoch_schedule", "tick_ms", tick.Milliseconds(), "default_epoch_ms", cfg.Epoch.Milliseconds(), "zone_epochs", len(cfg.ZoneEpochs), "rollups", len(cfg.Rollups))
+	lastIndex := map[string]int64{}
+	ticker := time.NewTicker(tick)
 	defer ticker.Stop()
 	// random delay to avoid thundering herd
 	time.Sleep(time.Duration(rand.Intn(50)) * time.Millisecond)
-	energyState := NewEnergyState()
 
 	for {
 		select {
@@ -54,17 +78,34 @@ func Start(ctx context.Context, log *slog.Logger, cfg Config, io IO, h *Health)
 			_ = off.Save()
 			return ctx.Err()
 		case now := <-ticker.C:
-			epoch := computeEpoch(now, cfg.Epoch)
-			if err := runEpoch(ctx, log, cfg, io, epoch, energyState); err != nil {
+			due := map[string]EpochID{}
+			for _, topic := range cfg.Topics {
+				ep := computeEpoch(now, cfg.EpochFor(extractZoneFromTopic(topic)))
+				if idx, ok := lastIndex[topic]; ok && idx == ep.Index {
+					continue
+				}
+				lastIndex[topic] = ep.Index
+				due[topic] = ep
+			}
+			if len(due) == 0 {
+				continue
+			}
+			began := time.Now()
+			if err := runEpoch(ctx, log, cfg, io, due, energyState, hist, rollups); err != nil {
+				metrics.IncEpochError()
 				if h != nil {
 					h.Error()
 				}
 			} else {
+				metrics.IncEpochProcessed()
 				if h != nil {
 					h.Tick()
 				}
 			}
-			_ = off.Save()
+			metrics.ObserveEpochLatency(time.Since(began).Seconds())
+			if err := off.Save(); err != nil {
+				log.Error("checkpoint_save_err", "path", cfg.OffsetsPath, "err", err)
+			}
 		}
 	}
 }
@@ -75,9 +116,21 @@ func computeEpoch(now time.Time, d time.Duration) EpochID {
 	return EpochID{Start: start, End: start.Add(d), Index: idx, Len: d}
 }
 
-func runEpoch(ctx context.Context, log *slog.Logger, cfg Config, io IO, ep EpochID, energyState *EnergyState) error {
-	log.Info("epoch_start", "index", ep.Index, "start", ep.Start, "end", ep.End, "len_ms", ep.Len.Milliseconds())
+func gcdDuration(a, b time.Duration) time.Duration {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// runEpoch processes, in configured topic order, every topic whose epoch is due.
+func runEpoch(ctx context.Context, log *slog.Logger, cfg Config, io IO, due map[string]EpochID, energyState *EnergyState, hist *EpochHistory, rollups *Rollups) error {
 	for ti, topic := range cfg.Topics {
+		ep, ok := due[topic]
+		if !ok {
+			continue
+		}
+		log.Info("epoch_start", "topic", topic, "index", ep.Index, "start", ep.Start, "end", ep.End, "len_ms", ep.Len.Milliseconds())
 		log.Info("topic_rr", "step", ti, "topic", topic)
 		zone := extractZoneFromTopic(topic)
 		parts, err := io.Consumer.Partitions(ctx, topic)
@@ -101,33 +154,60 @@ func runEpoch(ctx context.Context, log *slog.Logger, cfg Config, io IO, ep Epoch
 			}
 		}
 		agg := aggregate(zone, ep, allReadings, cfg.OutlierZ, energyState)
+		metrics.AddReadings(zone, len(allReadings))
+		metrics.AddOutliers(zone, len(allReadings)-countReadings(agg))
+		hist.Add(agg)
 		if err := io.Producer.SendToMAPE(ctx, zone, agg); err != nil {
+			metrics.IncProducerError("mape")
 			log.Error("produce_mape_err", "topic", topic, "zone", zone, "err", err)
 			return err
 		} else {
+			metrics.IncProduced("mape")
 			log.Info("produce_mape_ok", "topic", topic, "zone", zone, "epoch", ep.Index, "count", len(allReadings))
 		}
 		if err := io.Producer.SendToLedger(ctx, zone, agg); err != nil {
+			metrics.IncProducerError("ledger")
 			log.Error("produce_ledger_err", "topic", topic, "zone", zone, "partition", cfg.LedgerPartAgg, "err", err)
 			return err
 		} else {
+			metrics.IncProduced("ledger")
 			log.Info("produce_ledger_ok", "topic", topic, "zone", zone, "partition", cfg.LedgerPartAgg, "epoch", ep.Index, "count", len(allReadings))
 		}
+		for _, out := range rollups.Add(agg) {
+			if err := io.Producer.SendRollup(ctx, out.Topic, zone, out.Epoch); err != nil {
+				metrics.IncProducerError("rollup")
+				log.Error("produce_rollup_err", "topic", out.Topic, "zone", zone, "window_index", out.Epoch.Epoch.Index, "err", err)
+				continue
+			}
+			metrics.IncProduced("rollup")
+			log.Info("produce_rollup_ok", "topic", out.Topic, "zone", zone, "window_index", out.Epoch.Epoch.Index, "len_ms", out.Epoch.Epoch.Len.Milliseconds())
+		}
+		energyState.MarkEpoch(zone, ep)
+		log.Info("epoch_end", "topic", topic, "index", ep.Index)
 	}
-	log.Info("epoch_end", "index", ep.Index)
 	return nil
 }
 
+// countReadings returns how many readings survived outlier removal in an aggregated epoch.
+func countReadings(agg AggregatedEpoch) int {
+	n := 0
+	for _, rs := range agg.ByDevice {
+		n += len(rs)
+	}
+	return n
+}
+
 // Writers bundle
 
 type Writers struct {
+	prod   CBWrappedProducer
 	mape   *MAPEWriter
 	ledger *LedgerWriter
 }
 
 func NewWriters(cbFactory CircuitBreakerFactory, cfg Config) *Writers {
 	prod := cbFactory.NewKafkaProducer("aggregator-producer", cfg.Brokers)
-	return &Writers{mape: NewMAPEWriter(prod, cfg.MAPETopic), ledger: NewLedgerWriter(prod, cfg.LedgerTopicTmpl, cfg.LedgerPartAgg)}
+	return &Writers{prod: prod, mape: NewMAPEWriter(prod, cfg.MAPETopic), ledger: NewLedgerWriter(prod, cfg.LedgerTopicTmpl, cfg.LedgerPartAgg)}
 }
 func (w *Writers) SendToMAPE(ctx context.Context, zone string, epoch AggregatedEpoch) error {
 	return w.mape.Send(ctx, zone, epoch)
@@ -135,3 +215,8 @@ func (w *Writers) SendToMAPE(ctx context.Context, zone string, epoch AggregatedE
 func (w *Writers) SendToLedger(ctx context.Context, zone string, epoch AggregatedEpoch) error {
 	return w.ledger.Send(ctx, zone, epoch)
 }
+
+// SendRollup publishes a merged window keyed by zone, like the MAPE topic.
+func (w *Writers) SendRollup(ctx context.Context, topic, zone string, epoch AggregatedEpoch) error {
+	return NewMAPEWriter(w.prod, topic).Send(ctx, zone, epoch)
+}
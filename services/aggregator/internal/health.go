@@ -1,4 +1,4 @@
-// Package internal v8
+// Package internal v9
 // file: internal/health.go
 package internal
 
@@ -46,7 +46,7 @@ func (h *Health) Handler(w http.ResponseWriter, _ *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	_, err := w.Write([]byte("ok"))
 	if err != nil {
-		h.Log.Error("failed to write health response: %v", err)
+		h.Log.Error("health_write_err", "err", err)
 		return
 	}
 }
@@ -0,0 +1,98 @@
+// Package internal v0
+// file: internal/inspect.go
+package internal
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"NRG-CHAMP/aggregator/internal/metrics"
+)
+
+// EpochHistory keeps the most recent AggregatedEpoch values per zone for debugging.
+type EpochHistory struct {
+	mu    sync.RWMutex
+	size  int
+	zones map[string][]AggregatedEpoch
+}
+
+// NewEpochHistory creates a per-zone ring holding at most size epochs (minimum 1).
+func NewEpochHistory(size int) *EpochHistory {
+	if size < 1 {
+		size = 1
+	}
+	return &EpochHistory{size: size, zones: map[string][]AggregatedEpoch{}}
+}
+
+// Add appends an epoch to its zone ring, evicting the oldest entry when full.
+func (h *EpochHistory) Add(epoch AggregatedEpoch) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ring := append(h.zones[epoch.ZoneID], epoch)
+	if len(ring) > h.size {
+		ring = append([]AggregatedEpoch(nil), ring[len(ring)-h.size:]...)
+	}
+	h.zones[epoch.ZoneID] = ring
+}
+
+// Last returns up to n most recent epochs for the zone, newest first.
+func (h *EpochHistory) Last(zone string, n int) []AggregatedEpoch {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	ring := h.zones[zone]
+	if n > len(ring) {
+		n = len(ring)
+	}
+	out := make([]AggregatedEpoch, 0, n)
+	for i := len(ring) - 1; i >= len(ring)-n; i-- {
+		out = append(out, ring[i])
+	}
+	return out
+}
+
+// RegisterInspection mounts /metrics and the per-zone epoch inspection endpoints.
+func RegisterInspection(mux *http.ServeMux, log *slog.Logger, hist *EpochHistory) {
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write([]byte(metrics.Render())); err != nil {
+			log.Error("metrics_write_err", "err", err)
+		}
+	})
+	mux.HandleFunc("GET /zones/{zone}/epochs/latest", func(w http.ResponseWriter, r *http.Request) {
+		zone := r.PathValue("zone")
+		last := hist.Last(zone, 1)
+		if len(last) == 0 {
+			writeJSON(w, log, http.StatusNotFound, map[string]string{"error": "no epochs for zone " + zone})
+			return
+		}
+		writeJSON(w, log, http.StatusOK, last[0])
+	})
+	mux.HandleFunc("GET /zones/{zone}/epochs", func(w http.ResponseWriter, r *http.Request) {
+		n := 10
+		if v := r.URL.Query().Get("last"); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil || parsed < 1 {
+				writeJSON(w, log, http.StatusBadRequest, map[string]string{"error": "last must be a positive integer"})
+				return
+			}
+			n = parsed
+		}
+		zone := r.PathValue("zone")
+		writeJSON(w, log, http.StatusOK, map[string]any{"zoneId": zone, "epochs": hist.Last(zone, n)})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, log *slog.Logger, status int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		log.Error("http_write_err", "err", err)
+	}
+}
@@ -0,0 +1,59 @@
+// Package internal v0
+// file: internal/inspect_test.go
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"NRG-CHAMP/aggregator/internal/metrics"
+)
+
+func TestInspectionEndpoints(t *testing.T) {
+	hist := NewEpochHistory(2)
+	for i := int64(1); i <= 3; i++ {
+		hist.Add(AggregatedEpoch{ZoneID: "zone-A", Epoch: EpochID{Index: i}})
+	}
+	metrics.AddReadings("zone-A", 3)
+	mux := http.NewServeMux()
+	RegisterInspection(mux, slog.New(slog.NewTextHandler(io.Discard, nil)), hist)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/zones/zone-A/epochs/latest", nil))
+	var latest AggregatedEpoch
+	if err := json.NewDecoder(rec.Body).Decode(&latest); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("latest: status=%d err=%v", rec.Code, err)
+	}
+	if latest.Epoch.Index != 3 {
+		t.Fatalf("expected newest epoch 3, got %d", latest.Epoch.Index)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/zones/zone-A/epochs?last=5", nil))
+	var body struct {
+		Epochs []AggregatedEpoch `json:"epochs"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Epochs) != 2 || body.Epochs[0].Epoch.Index != 3 || body.Epochs[1].Epoch.Index != 2 {
+		t.Fatalf("expected ring of [3 2], got %+v", body.Epochs)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/zones/zone-B/epochs/latest", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown zone, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `aggregator_readings_total{zone="zone-A"} 3`) {
+		t.Fatalf("metrics missing zone reading count:\n%s", rec.Body.String())
+	}
+}
@@ -1,4 +1,4 @@
-// v11
+// v12
 // services/aggregator/internal/kafka_adapters.go
 package internal
 
@@ -170,7 +170,14 @@ func decodeReadingNewSchema(log *slog.Logger, topic string, b []byte, brokerTS t
 			}
 		}
 	default:
-		if readingObj != nil && len(readingObj) > 0 {
+		if st, ok := sensorRegistry[dtype]; ok {
+			if readingObj != nil {
+				if v, ok := decodeSensorValue(st, readingObj); ok {
+					r.Sensors = map[string]float64{st.Key: v}
+					hasData = true
+				}
+			}
+		} else if readingObj != nil && len(readingObj) > 0 {
 			r.Extra = readingObj
 			hasData = true
 		}
@@ -0,0 +1,128 @@
+// v1
+// services/aggregator/internal/metrics/metrics.go
+// Package metrics keeps the aggregator's counters and renders them in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the epoch duration histogram.
+var latencyBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2, 5}
+
+var (
+	mu              sync.Mutex
+	epochsProcessed uint64
+	epochErrors     uint64
+	latencyCounts   = make([]uint64, len(latencyBuckets))
+	latencySum      float64
+	latencyCount    uint64
+	readings        = map[string]uint64{} // by zone
+	outliers        = map[string]uint64{} // by zone
+	produced        = map[string]uint64{} // by target
+	producerErrs    = map[string]uint64{} // by target
+)
+
+// IncEpochProcessed increments the counter of epochs completed without errors.
+func IncEpochProcessed() {
+	mu.Lock()
+	epochsProcessed++
+	mu.Unlock()
+}
+
+// IncEpochError increments the counter of epochs aborted by an error.
+func IncEpochError() {
+	mu.Lock()
+	epochErrors++
+	mu.Unlock()
+}
+
+// ObserveEpochLatency records how long, in seconds, one epoch took to read, aggregate and publish.
+func ObserveEpochLatency(seconds float64) {
+	if seconds < 0 || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if i := sort.SearchFloat64s(latencyBuckets, seconds); i < len(latencyBuckets) {
+		latencyCounts[i]++
+	}
+	latencySum += seconds
+	latencyCount++
+}
+
+// AddReadings adds the number of decoded readings consumed for the provided zone.
+func AddReadings(zone string, n int) {
+	add(readings, zone, n)
+}
+
+// AddOutliers adds the number of readings discarded as outliers for the provided zone.
+func AddOutliers(zone string, n int) {
+	add(outliers, zone, n)
+}
+
+// IncProduced increments the successful publish counter for the provided target (mape, ledger or rollup).
+func IncProduced(target string) {
+	add(produced, target, 1)
+}
+
+// IncProducerError increments the failed publish counter for the provided target (mape, ledger or rollup).
+func IncProducerError(target string) {
+	add(producerErrs, target, 1)
+}
+
+func add(m map[string]uint64, label string, n int) {
+	if n <= 0 {
+		return
+	}
+	mu.Lock()
+	m[strings.TrimSpace(label)] += uint64(n)
+	mu.Unlock()
+}
+
+// Render builds the Prometheus exposition for all metrics.
+func Render() string {
+	mu.Lock()
+	defer mu.Unlock()
+	var b strings.Builder
+	fmt.Fprintf(&b, "# TYPE aggregator_epochs_processed_total counter\naggregator_epochs_processed_total{} %d\n\n", epochsProcessed)
+	fmt.Fprintf(&b, "# TYPE aggregator_epoch_errors_total counter\naggregator_epoch_errors_total{} %d\n\n", epochErrors)
+
+	b.WriteString("# TYPE aggregator_epoch_duration_seconds histogram\n")
+	var cumulative uint64
+	for i, upper := range latencyBuckets {
+		cumulative += latencyCounts[i]
+		fmt.Fprintf(&b, "aggregator_epoch_duration_seconds_bucket{le=\"%g\"} %d\n", upper, cumulative)
+	}
+	fmt.Fprintf(&b, "aggregator_epoch_duration_seconds_bucket{le=\"+Inf\"} %d\n", latencyCount)
+	fmt.Fprintf(&b, "aggregator_epoch_duration_seconds_sum %f\n", latencySum)
+	fmt.Fprintf(&b, "aggregator_epoch_duration_seconds_count %d\n\n", latencyCount)
+
+	writeCounter(&b, "aggregator_readings_total", "zone", readings)
+	writeCounter(&b, "aggregator_outliers_discarded_total", "zone", outliers)
+	writeCounter(&b, "aggregator_produced_total", "target", produced)
+	writeCounter(&b, "aggregator_producer_errors_total", "target", producerErrs)
+	return b.String()
+}
+
+func writeCounter(b *strings.Builder, name, label string, values map[string]uint64) {
+	fmt.Fprintf(b, "# TYPE %s counter\n", name)
+	if len(values) == 0 {
+		fmt.Fprintf(b, "%s{} 0\n\n", name)
+		return
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	escape := strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\"", "\\\"")
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s{%s=\"%s\"} %d\n", name, label, escape.Replace(k), values[k])
+	}
+	b.WriteByte('\n')
+}
@@ -1,4 +1,4 @@
-// Package internal v8
+// Package internal v9
 // file: internal/offsets.go
 package internal
 
@@ -10,10 +10,21 @@ import (
 )
 
 // Offsets tracks per-topic/partition offsets persisted on disk.
+// When an EnergyState is attached, its snapshot is written in the same file so that
+// offsets and energy carryover always describe the same processed epoch.
 type Offsets struct {
-	path string
-	mu   sync.Mutex
-	Data map[string]map[int]int64 `json:"data"`
+	path   string
+	mu     sync.Mutex
+	Data   map[string]map[int]int64 `json:"data"`
+	energy *EnergyState
+	loaded *EnergySnapshot
+}
+
+// offsetsFile is the on-disk layout. Files written by older versions contain only the
+// bare topic->partition->offset map and are still accepted by load.
+type offsetsFile struct {
+	Offsets map[string]map[int]int64 `json:"offsets"`
+	Energy  *EnergySnapshot          `json:"energy,omitempty"`
 }
 
 func NewOffsets(path string) *Offsets {
@@ -30,20 +41,54 @@ func (o *Offsets) load() {
 	if err != nil {
 		return
 	}
+	var probe map[string]json.RawMessage
+	if json.Unmarshal(b, &probe) != nil {
+		return
+	}
+	if _, ok := probe["offsets"]; ok {
+		var f offsetsFile
+		if json.Unmarshal(b, &f) == nil {
+			if f.Offsets != nil {
+				o.Data = f.Offsets
+			}
+			o.loaded = f.Energy
+		}
+		return
+	}
 	var tmp map[string]map[int]int64
 	if json.Unmarshal(b, &tmp) == nil {
 		o.Data = tmp
 	}
 }
 
+// AttachEnergy binds the energy accumulator whose snapshot is persisted on every Save.
+func (o *Offsets) AttachEnergy(state *EnergyState) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.energy = state
+}
+
+// LoadedEnergy returns the energy snapshot read from disk at construction time, if any.
+func (o *Offsets) LoadedEnergy() *EnergySnapshot {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.loaded
+}
+
+// Save writes offsets and the attached energy snapshot to a temporary file and renames it
+// over the previous one, so a crash never leaves a torn or mismatched checkpoint behind.
 func (o *Offsets) Save() error {
 	o.mu.Lock()
 	defer o.mu.Unlock()
-	b, err := json.MarshalIndent(o.Data, "", "  ")
+	b, err := json.MarshalIndent(offsetsFile{Offsets: o.Data, Energy: o.energy.Snapshot()}, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(o.path, b, 0o644)
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, o.path)
 }
 
 func (o *Offsets) Get(topic string, partition int) int64 {
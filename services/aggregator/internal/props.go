@@ -1,4 +1,4 @@
-// Package internal v9
+// Package internal v13
 // file: internal/props.go
 package internal
 
@@ -24,10 +24,34 @@ type Config struct {
 	LedgerPartMAPE  int
 	OutlierZ        float64
 	LogPath         string
+	// EnergyMaxAgeEpochs bounds how old (in epochs) a persisted energy snapshot may be
+	// before it is discarded on startup instead of seeding carryover power.
+	EnergyMaxAgeEpochs int
+	// HistorySize is the number of recent epochs kept per zone for the inspection API.
+	HistorySize int
+	// ZoneEpochs overrides Epoch for individual zones (epoch_ms.<zone>=...).
+	ZoneEpochs map[string]time.Duration
+	// Rollups lists the optional coarser windows published alongside the base epochs.
+	Rollups []RollupStage
+}
+
+// RollupStage describes one rollup window and the topic it is published to.
+type RollupStage struct {
+	Name   string
+	Window time.Duration
+	Topic  string
+}
+
+// EpochFor returns the epoch length configured for the zone, falling back to Epoch.
+func (c Config) EpochFor(zone string) time.Duration {
+	if d, ok := c.ZoneEpochs[zone]; ok {
+		return d
+	}
+	return c.Epoch
 }
 
 func DefaultConfig() Config {
-	return Config{Brokers: []string{"kafka:9092"}, Epoch: 500 * time.Millisecond, MaxPerPartition: 1000, OffsetsPath: filepath.Join("data", "offsets.json"), MAPETopic: "agg-to-mape", LedgerTopicTmpl: "zone.ledger.{zone}", LedgerPartAgg: 0, LedgerPartMAPE: 1, OutlierZ: 4.0, LogPath: filepath.Join("data", "aggregator.log")}
+	return Config{Brokers: []string{"kafka:9092"}, Epoch: 500 * time.Millisecond, MaxPerPartition: 1000, OffsetsPath: filepath.Join("data", "offsets.json"), MAPETopic: "agg-to-mape", LedgerTopicTmpl: "zone.ledger.{zone}", LedgerPartAgg: 0, LedgerPartMAPE: 1, OutlierZ: 4.0, LogPath: filepath.Join("data", "aggregator.log"), EnergyMaxAgeEpochs: 10, HistorySize: 100, ZoneEpochs: map[string]time.Duration{}}
 }
 
 func LoadProps(path string) Config {
@@ -43,6 +67,8 @@ func LoadProps(path string) Config {
 		}
 	}(f)
 	sc := bufio.NewScanner(f)
+	var rollupWindows []string
+	rollupTmpl := "agg.rollup.{window}"
 	for sc.Scan() {
 		line := strings.TrimSpace(sc.Text())
 		if line == "" || strings.HasPrefix(line, "#") {
@@ -86,7 +112,33 @@ func LoadProps(path string) Config {
 			}
 		case "log_path":
 			cfg.LogPath = v
+		case "rollup_windows":
+			rollupWindows = splitCSV(v)
+		case "rollup_topic_template":
+			rollupTmpl = v
+		case "history_size":
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				cfg.HistorySize = n
+			}
+		case "energy_state_max_age_epochs":
+			if n, err := strconv.Atoi(v); err == nil {
+				cfg.EnergyMaxAgeEpochs = n
+			}
+		default:
+			if zone, ok := strings.CutPrefix(k, "epoch_ms."); ok && zone != "" {
+				if ms, err := strconv.Atoi(v); err == nil && ms > 0 {
+					cfg.ZoneEpochs[zone] = time.Duration(ms) * time.Millisecond
+				}
+			}
+		}
+	}
+	for _, w := range rollupWindows {
+		d, err := time.ParseDuration(w)
+		if err != nil || d <= 0 {
+			log.Error("rollup_window_invalid", "window", w, "err", err)
+			continue
 		}
+		cfg.Rollups = append(cfg.Rollups, RollupStage{Name: w, Window: d, Topic: strings.ReplaceAll(rollupTmpl, "{window}", w)})
 	}
 	return cfg
 }
@@ -0,0 +1,238 @@
+// Package internal v1
+// file: internal/replay.go
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	circuitbreaker "github.com/nrg-champ/circuitbreaker"
+	"github.com/segmentio/kafka-go"
+)
+
+// ReplayOptions describes a historical backfill over [From, To).
+// Exactly one of OutTopic and OutFile must be set.
+type ReplayOptions struct {
+	From     time.Time
+	To       time.Time
+	OutTopic string
+	OutFile  string
+}
+
+// ParseReplayArgs parses `from=... to=... topic=...|file=...` arguments.
+// Timestamps are RFC3339.
+func ParseReplayArgs(args []string) (ReplayOptions, error) {
+	var opts ReplayOptions
+	for _, arg := range args {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok {
+			return opts, fmt.Errorf("replay argument %q must be key=value", arg)
+		}
+		switch strings.TrimSpace(k) {
+		case "from":
+			ts, err := time.Parse(time.RFC3339, strings.TrimSpace(v))
+			if err != nil {
+				return opts, fmt.Errorf("replay from: %w", err)
+			}
+			opts.From = ts
+		case "to":
+			ts, err := time.Parse(time.RFC3339, strings.TrimSpace(v))
+			if err != nil {
+				return opts, fmt.Errorf("replay to: %w", err)
+			}
+			opts.To = ts
+		case "topic":
+			opts.OutTopic = strings.TrimSpace(v)
+		case "file":
+			opts.OutFile = strings.TrimSpace(v)
+		default:
+			return opts, fmt.Errorf("unknown replay argument %q", k)
+		}
+	}
+	if opts.From.IsZero() || opts.To.IsZero() {
+		return opts, fmt.Errorf("replay requires both from= and to=")
+	}
+	if !opts.From.Before(opts.To) {
+		return opts, fmt.Errorf("replay from %s must be before to %s", opts.From, opts.To)
+	}
+	if (opts.OutTopic == "") == (opts.OutFile == "") {
+		return opts, fmt.Errorf("replay requires exactly one of topic= or file=")
+	}
+	return opts, nil
+}
+
+// replaySink receives recomputed epochs in order.
+type replaySink interface {
+	Write(ctx context.Context, zone string, epoch AggregatedEpoch) error
+	Close() error
+}
+
+type fileReplaySink struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+func newFileReplaySink(path string) (*fileReplaySink, error) {
+	_ = os.MkdirAll(filepath.Dir(path), 0o755)
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileReplaySink{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (s *fileReplaySink) Write(_ context.Context, _ string, epoch AggregatedEpoch) error {
+	b, err := json.Marshal(epoch)
+	if err != nil {
+		return err
+	}
+	if _, err := s.w.Write(b); err != nil {
+		return err
+	}
+	return s.w.WriteByte('\n')
+}
+
+func (s *fileReplaySink) Close() error {
+	if err := s.w.Flush(); err != nil {
+		_ = s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+type topicReplaySink struct {
+	prod  CBWrappedProducer
+	topic string
+}
+
+func (s *topicReplaySink) Write(ctx context.Context, zone string, epoch AggregatedEpoch) error {
+	b, err := json.Marshal(epoch)
+	if err != nil {
+		return err
+	}
+	return s.prod.Send(ctx, s.topic, []byte(zone), b)
+}
+
+func (s *topicReplaySink) Close() error { return nil }
+
+// RunReplay recomputes every epoch in the requested range from the raw device topics.
+// It never reads or writes the live offsets file, and energy integration starts from an
+// empty EnergyState so the output depends only on the data in the range.
+func RunReplay(ctx context.Context, log *slog.Logger, cfg Config, opts ReplayOptions) error {
+	var sink replaySink
+	if opts.OutFile != "" {
+		fs, err := newFileReplaySink(opts.OutFile)
+		if err != nil {
+			return fmt.Errorf("open replay file: %w", err)
+		}
+		sink = fs
+	} else {
+		prod := NewDefaultCBFactory(log).NewKafkaProducer("aggregator-replay-producer", cfg.Brokers)
+		sink = &topicReplaySink{prod: prod, topic: opts.OutTopic}
+	}
+	defer func() {
+		if err := sink.Close(); err != nil {
+			log.Error("replay_sink_close_err", "err", err)
+		}
+	}()
+
+	breaker, err := circuitbreaker.NewKafkaBreakerFromEnv("aggregator-replay", nil)
+	if err != nil {
+		log.Error("replay_cb_init_err", "err", err)
+	}
+	consumer := &KafkaGoConsumer{log: log, brokers: cfg.Brokers}
+	state := NewEnergyState()
+	log.Info("replay_start", "from", opts.From, "to", opts.To, "topics", cfg.Topics, "out_topic", opts.OutTopic, "out_file", opts.OutFile)
+	for _, topic := range cfg.Topics {
+		zone := extractZoneFromTopic(topic)
+		epochLen := cfg.EpochFor(zone)
+		parts, err := consumer.Partitions(ctx, topic)
+		if err != nil {
+			return fmt.Errorf("replay partitions %s: %w", topic, err)
+		}
+		buckets := map[int64][]Reading{}
+		for _, part := range parts {
+			n, err := readReplayRange(ctx, log, cfg.Brokers, breaker, topic, part, opts.From, opts.To, epochLen, buckets)
+			if err != nil {
+				return fmt.Errorf("replay read %s/%d: %w", topic, part, err)
+			}
+			log.Info("replay_partition_read", "topic", topic, "partition", part, "n", n)
+		}
+		epochs := replayEpochs(zone, opts.From, opts.To, epochLen, buckets, cfg.OutlierZ, state)
+		for _, agg := range epochs {
+			if err := sink.Write(ctx, zone, agg); err != nil {
+				return fmt.Errorf("replay write %s epoch %d: %w", zone, agg.Epoch.Index, err)
+			}
+		}
+		log.Info("replay_zone_done", "topic", topic, "zone", zone, "epochs", len(epochs))
+	}
+	log.Info("replay_done")
+	return nil
+}
+
+// readReplayRange reads a partition from the first offset at or after from up to the
+// high watermark, bucketing decoded readings by epoch index of their broker timestamp.
+func readReplayRange(ctx context.Context, log *slog.Logger, brokers []string, breaker *circuitbreaker.KafkaBreaker, topic string, partition int, from, to time.Time, epochLen time.Duration, buckets map[int64][]Reading) (int, error) {
+	conn, err := kafka.DialLeader(ctx, "tcp", brokers[0], topic, partition)
+	if err != nil {
+		return 0, err
+	}
+	start, err := conn.ReadOffset(from)
+	if err != nil {
+		_ = conn.Close()
+		return 0, fmt.Errorf("offset for time %s: %w", from, err)
+	}
+	end, err := conn.ReadLastOffset()
+	_ = conn.Close()
+	if err != nil {
+		return 0, fmt.Errorf("last offset: %w", err)
+	}
+	if start < 0 || start >= end {
+		return 0, nil
+	}
+	r := kafka.NewReader(kafka.ReaderConfig{Brokers: brokers, Topic: topic, Partition: partition, MinBytes: 1, MaxBytes: 10e6})
+	defer func(r *kafka.Reader) {
+		if err := r.Close(); err != nil {
+			log.Error("kafka_reader_close_err", "topic", topic, "partition", partition, "err", err)
+		}
+	}(r)
+	if err := r.SetOffset(start); err != nil {
+		return 0, err
+	}
+	wrapped := circuitbreaker.NewCBKafkaReader(r, breaker)
+	n := 0
+	for {
+		m, err := wrapped.FetchMessage(ctx)
+		if err != nil {
+			return n, err
+		}
+		if !m.Time.Before(to) {
+			return n, nil
+		}
+		if rec, ok := decodeReadingNewSchema(log, topic, m.Value, m.Time); ok {
+			idx := m.Time.UnixMilli() / epochLen.Milliseconds()
+			buckets[idx] = append(buckets[idx], rec)
+			n++
+		}
+		if m.Offset >= end-1 {
+			return n, nil
+		}
+	}
+}
+
+// replayEpochs aggregates every epoch overlapping [from, to) in order, including empty
+// ones, exactly like the live loop would have. ProducedAt is pinned to the epoch end.
+func replayEpochs(zone string, from, to time.Time, epochLen time.Duration, buckets map[int64][]Reading, zThresh float64, state *EnergyState) []AggregatedEpoch {
+	var out []AggregatedEpoch
+	for ep := computeEpoch(from, epochLen); ep.Start.Before(to); ep = computeEpoch(ep.End, epochLen) {
+		out = append(out, aggregateAt(zone, ep, buckets[ep.Index], zThresh, state, ep.End))
+	}
+	return out
+}
@@ -0,0 +1,60 @@
+// Package internal v0
+// file: internal/replay_test.go
+package internal
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseReplayArgs(t *testing.T) {
+	opts, err := ParseReplayArgs([]string{"from=2024-01-01T00:00:00Z", "to=2024-01-01T01:00:00Z", "file=out.jsonl"})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if opts.OutFile != "out.jsonl" || opts.To.Sub(opts.From) != time.Hour {
+		t.Fatalf("unexpected options %+v", opts)
+	}
+	bad := [][]string{
+		{"from=2024-01-01T00:00:00Z", "file=out.jsonl"},
+		{"from=2024-01-01T01:00:00Z", "to=2024-01-01T00:00:00Z", "file=out.jsonl"},
+		{"from=2024-01-01T00:00:00Z", "to=2024-01-01T01:00:00Z"},
+		{"from=2024-01-01T00:00:00Z", "to=2024-01-01T01:00:00Z", "file=a", "topic=b"},
+		{"from=2024-01-01T00:00:00Z", "to=2024-01-01T01:00:00Z", "file=a", "bogus"},
+	}
+	for _, args := range bad {
+		if _, err := ParseReplayArgs(args); err == nil {
+			t.Fatalf("expected error for %v", args)
+		}
+	}
+}
+
+func TestReplayEpochsDeterministic(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(30 * time.Minute)
+	epochLen := 10 * time.Minute
+	first := computeEpoch(from, epochLen)
+	buckets := map[int64][]Reading{
+		first.Index: {makeActuatorReading("zoneA", "dev1", from.Add(5*time.Minute), 1.2)},
+	}
+	run := func() []AggregatedEpoch {
+		return replayEpochs("zoneA", from, to, epochLen, buckets, 0, NewEnergyState())
+	}
+	a, b := run(), run()
+	if len(a) != 3 {
+		t.Fatalf("expected 3 epochs including empty ones, got %d", len(a))
+	}
+	for i := range a {
+		if !a[i].ProducedAt.Equal(a[i].Epoch.End) {
+			t.Fatalf("epoch %d: ProducedAt %s not pinned to epoch end %s", i, a[i].ProducedAt, a[i].Epoch.End)
+		}
+		if !a[i].ProducedAt.Equal(b[i].ProducedAt) || math.Abs(a[i].ZoneEnergyKWhEpoch-b[i].ZoneEnergyKWhEpoch) > 1e-9 {
+			t.Fatalf("epoch %d differs between runs", i)
+		}
+	}
+	// Carryover continues across the empty replayed epochs.
+	if want := 1.2 * (10.0 / 60.0); math.Abs(a[2].ZoneEnergyKWhEpoch-want) > 1e-6 {
+		t.Fatalf("expected carryover %.6f kWh in last epoch, got %.6f", want, a[2].ZoneEnergyKWhEpoch)
+	}
+}
@@ -0,0 +1,144 @@
+// Package internal v1
+// file: internal/rollup.go
+package internal
+
+import (
+	"fmt"
+	"time"
+)
+
+// rollupOutput is a completed coarse window ready to be published on Topic.
+type rollupOutput struct {
+	Topic string
+	Epoch AggregatedEpoch
+}
+
+// Rollups merges consecutive base epochs of each zone into the configured coarser windows.
+// Merged epochs keep the AggregatedEpoch schema; byDevice is left empty to keep messages
+// small, while summary averages are weighted by the readings of every merged epoch.
+type Rollups struct {
+	stages []RollupStage
+	acc    map[string][]*rollupAcc // zone -> one accumulator per stage
+}
+
+type rollupAcc struct {
+	window    EpochID
+	epochs    int
+	sums      map[string]float64
+	counts    map[string]int
+	actuators map[string]float64
+	zoneKWh   float64
+	sensors   map[string]SensorStats
+}
+
+// NewRollups creates the rollup stages; it returns nil when none are configured.
+func NewRollups(stages []RollupStage) *Rollups {
+	if len(stages) == 0 {
+		return nil
+	}
+	return &Rollups{stages: stages, acc: map[string][]*rollupAcc{}}
+}
+
+// validateRollups ensures every window is a whole multiple of each zone's epoch length.
+func validateRollups(cfg Config, zones []string) error {
+	for _, stage := range cfg.Rollups {
+		for _, zone := range zones {
+			base := cfg.EpochFor(zone)
+			if stage.Window < base || stage.Window%base != 0 {
+				return fmt.Errorf("rollup window %s is not a multiple of zone %s epoch %s", stage.Name, zone, base)
+			}
+		}
+	}
+	return nil
+}
+
+// Add folds a base epoch into every stage and returns the windows it completed.
+// A window is emitted once its last base epoch arrives, or earlier when an epoch from a
+// later window shows up (e.g. after a processing gap).
+func (r *Rollups) Add(agg AggregatedEpoch) []rollupOutput {
+	if r == nil {
+		return nil
+	}
+	accs, ok := r.acc[agg.ZoneID]
+	if !ok {
+		accs = make([]*rollupAcc, len(r.stages))
+		r.acc[agg.ZoneID] = accs
+	}
+	var out []rollupOutput
+	for i, stage := range r.stages {
+		w := computeEpoch(agg.Epoch.Start, stage.Window)
+		acc := accs[i]
+		if acc != nil && acc.window.Index != w.Index {
+			out = append(out, rollupOutput{Topic: stage.Topic, Epoch: acc.flush(agg.ZoneID)})
+			acc = nil
+		}
+		if acc == nil {
+			acc = &rollupAcc{window: w, sums: map[string]float64{}, counts: map[string]int{}, actuators: map[string]float64{}, sensors: map[string]SensorStats{}}
+		}
+		acc.add(agg)
+		if !agg.Epoch.End.Before(w.End) {
+			out = append(out, rollupOutput{Topic: stage.Topic, Epoch: acc.flush(agg.ZoneID)})
+			acc = nil
+		}
+		accs[i] = acc
+	}
+	return out
+}
+
+func (a *rollupAcc) add(agg AggregatedEpoch) {
+	a.epochs++
+	for _, readings := range agg.ByDevice {
+		for _, r := range readings {
+			if r.Temperature != nil {
+				a.sums["avgTemp"] += *r.Temperature
+				a.counts["avgTemp"]++
+			}
+			if r.PowerW != nil {
+				a.sums["avgPowerW"] += *r.PowerW
+				a.counts["avgPowerW"]++
+			}
+			if r.EnergyKWh != nil {
+				a.sums["avgEnergyKWh"] += *r.EnergyKWh
+				a.counts["avgEnergyKWh"]++
+			}
+		}
+	}
+	for dev, kwh := range agg.ActuatorEnergyKWhEpoch {
+		a.actuators[dev] += kwh
+	}
+	a.zoneKWh += agg.ZoneEnergyKWhEpoch
+	for key, st := range agg.SensorStats {
+		a.sensors[key] = mergeSensorStats(a.sensors[key], st)
+	}
+}
+
+func (a *rollupAcc) flush(zone string) AggregatedEpoch {
+	summary := map[string]float64{}
+	for k, sum := range a.sums {
+		if n := a.counts[k]; n > 0 {
+			summary[k] = sum / float64(n)
+		}
+	}
+	summary["zoneEnergyKWhEpoch"] = a.zoneKWh
+	summary["epochCount"] = float64(a.epochs)
+	var sensors map[string]SensorStats
+	if len(a.sensors) > 0 {
+		sensors = a.sensors
+		for key, st := range a.sensors {
+			if typ, ok := sensorByKey(key); ok {
+				summary[typ.SummaryKey] = sensorHeadline(typ, st)
+			}
+		}
+	}
+	return AggregatedEpoch{
+		SchemaVersion:          LedgerSchemaVersion,
+		ZoneID:                 zone,
+		Epoch:                  a.window,
+		ByDevice:               map[string][]Reading{},
+		Summary:                summary,
+		ProducedAt:             time.Now(),
+		ActuatorEnergyKWhEpoch: a.actuators,
+		ZoneEnergyKWhEpoch:     a.zoneKWh,
+		SensorStats:            sensors,
+	}
+}
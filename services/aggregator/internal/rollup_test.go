@@ -0,0 +1,57 @@
+// Package internal v0
+// file: internal/rollup_test.go
+package internal
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRollupsMergeConsecutiveEpochs(t *testing.T) {
+	r := NewRollups([]RollupStage{{Name: "3m", Window: 3 * time.Minute, Topic: "agg.rollup.3m"}})
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var out []rollupOutput
+	for i := 0; i < 3; i++ {
+		start := base.Add(time.Duration(i) * time.Minute)
+		temp := 20.0 + float64(i)
+		agg := AggregatedEpoch{
+			ZoneID:                 "zone-A",
+			Epoch:                  EpochID{Start: start, End: start.Add(time.Minute), Index: start.UnixMilli() / 60000, Len: time.Minute},
+			ByDevice:               map[string][]Reading{"t1": {{DeviceID: "t1", Temperature: &temp}}},
+			ActuatorEnergyKWhEpoch: map[string]float64{"h1": 0.5},
+			ZoneEnergyKWhEpoch:     0.5,
+		}
+		got := r.Add(agg)
+		if i < 2 && len(got) != 0 {
+			t.Fatalf("window emitted early at epoch %d", i)
+		}
+		out = append(out, got...)
+	}
+	if len(out) != 1 {
+		t.Fatalf("expected one completed window, got %d", len(out))
+	}
+	w := out[0]
+	if w.Topic != "agg.rollup.3m" || w.Epoch.Epoch.Len != 3*time.Minute || !w.Epoch.Epoch.Start.Equal(base) {
+		t.Fatalf("unexpected window %+v on %s", w.Epoch.Epoch, w.Topic)
+	}
+	if math.Abs(w.Epoch.ZoneEnergyKWhEpoch-1.5) > 1e-9 || math.Abs(w.Epoch.ActuatorEnergyKWhEpoch["h1"]-1.5) > 1e-9 {
+		t.Fatalf("expected summed energy 1.5, got zone=%.3f h1=%.3f", w.Epoch.ZoneEnergyKWhEpoch, w.Epoch.ActuatorEnergyKWhEpoch["h1"])
+	}
+	if w.Epoch.Summary["avgTemp"] != 21 || w.Epoch.Summary["epochCount"] != 3 {
+		t.Fatalf("unexpected summary %#v", w.Epoch.Summary)
+	}
+}
+
+func TestValidateRollupsRejectsMisalignedWindow(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Epoch = time.Second
+	cfg.ZoneEpochs["zone-B"] = 7 * time.Second
+	cfg.Rollups = []RollupStage{{Name: "1m", Window: time.Minute}}
+	if err := validateRollups(cfg, []string{"zone-A"}); err != nil {
+		t.Fatalf("1s epochs should roll up into 1m: %v", err)
+	}
+	if err := validateRollups(cfg, []string{"zone-A", "zone-B"}); err == nil {
+		t.Fatalf("expected error for 7s epochs in a 1m window")
+	}
+}
@@ -0,0 +1,201 @@
+// Package internal v0
+// file: internal/sensors.go
+package internal
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// sensorKind selects how a sensor's values are validated and summarised.
+type sensorKind int
+
+const (
+	// sensorContinuous values (e.g. humidity, CO2) are averaged and subject to outlier removal.
+	sensorContinuous sensorKind = iota
+	// sensorCount values (e.g. occupancy) are non-negative integers summarised by their maximum.
+	sensorCount
+	// sensorBinary values (door/window contacts) are 1 when open and 0 when closed.
+	sensorBinary
+)
+
+// SensorType describes how one environmental deviceType is decoded and aggregated.
+// Key is used in Reading.Sensors and AggregatedEpoch.SensorStats; SummaryKey is the
+// scalar surfaced in AggregatedEpoch.Summary for consumers that only read the summary.
+type SensorType struct {
+	DeviceType string
+	Key        string
+	Fields     []string
+	Unit       string
+	Kind       sensorKind
+	Min        float64
+	Max        float64
+	SummaryKey string
+}
+
+// sensorRegistry lists the environmental sensors understood besides temp_sensor.
+// Readings outside [Min, Max] are rejected at decode time as physically implausible.
+var sensorRegistry = map[string]SensorType{
+	"humidity_sensor":  {DeviceType: "humidity_sensor", Key: "humidity", Fields: []string{"humidityPct", "rh"}, Unit: "%RH", Kind: sensorContinuous, Min: 0, Max: 100, SummaryKey: "avgHumidityPct"},
+	"co2_sensor":       {DeviceType: "co2_sensor", Key: "co2", Fields: []string{"co2ppm", "co2"}, Unit: "ppm", Kind: sensorContinuous, Min: 0, Max: 10000, SummaryKey: "avgCO2ppm"},
+	"occupancy_sensor": {DeviceType: "occupancy_sensor", Key: "occupancy", Fields: []string{"count", "occupancy"}, Unit: "persons", Kind: sensorCount, Min: 0, Max: 10000, SummaryKey: "maxOccupancy"},
+	"contact_sensor":   {DeviceType: "contact_sensor", Key: "contact", Fields: []string{"open", "state"}, Unit: "open", Kind: sensorBinary, Min: 0, Max: 1, SummaryKey: "openContacts"},
+}
+
+// sensorByKey indexes the registry by Key for aggregation.
+func sensorByKey(key string) (SensorType, bool) {
+	for _, st := range sensorRegistry {
+		if st.Key == key {
+			return st, true
+		}
+	}
+	return SensorType{}, false
+}
+
+// SensorStats summarises one sensor type within an epoch. For contacts Mean is the
+// fraction of open samples and OpenDevices counts contacts whose last sample was open.
+type SensorStats struct {
+	Unit        string  `json:"unit"`
+	Count       int     `json:"count"`
+	Min         float64 `json:"min"`
+	Max         float64 `json:"max"`
+	Mean        float64 `json:"mean"`
+	Last        float64 `json:"last"`
+	OpenDevices int     `json:"openDevices,omitempty"`
+}
+
+// decodeSensorValue extracts the registered value for dtype from the reading object.
+func decodeSensorValue(st SensorType, reading map[string]any) (float64, bool) {
+	for _, field := range st.Fields {
+		raw, ok := reading[field]
+		if !ok {
+			continue
+		}
+		var v float64
+		switch t := raw.(type) {
+		case bool:
+			if t {
+				v = 1
+			}
+		case string:
+			switch strings.ToUpper(strings.TrimSpace(t)) {
+			case "OPEN", "ON", "TRUE":
+				v = 1
+			case "CLOSED", "OFF", "FALSE":
+				v = 0
+			default:
+				f, ok := toFloat(t)
+				if !ok {
+					continue
+				}
+				v = f
+			}
+		default:
+			f, ok := toFloat(t)
+			if !ok {
+				continue
+			}
+			v = f
+		}
+		if math.IsNaN(v) || v < st.Min || v > st.Max {
+			return 0, false
+		}
+		if st.Kind == sensorCount {
+			v = math.Round(v)
+		}
+		if st.Kind == sensorBinary && v != 0 {
+			v = 1
+		}
+		return v, true
+	}
+	return 0, false
+}
+
+// summariseSensors builds per-type statistics from readings already grouped by device
+// and writes each type's headline value into summary.
+func summariseSensors(byDev map[string][]Reading, summary map[string]float64) map[string]SensorStats {
+	stats := map[string]SensorStats{}
+	sums := map[string]float64{}
+	open := map[string]map[string]bool{}
+	devices := make([]string, 0, len(byDev))
+	for dev := range byDev {
+		devices = append(devices, dev)
+	}
+	sort.Strings(devices)
+	for _, dev := range devices {
+		rs := append([]Reading(nil), byDev[dev]...)
+		sort.SliceStable(rs, func(i, j int) bool { return rs[i].Timestamp.Before(rs[j].Timestamp) })
+		for _, r := range rs {
+			for key, v := range r.Sensors {
+				st, ok := sensorByKey(key)
+				if !ok {
+					continue
+				}
+				s, seen := stats[key]
+				if !seen {
+					s = SensorStats{Unit: st.Unit, Min: v, Max: v}
+				}
+				s.Count++
+				s.Min = math.Min(s.Min, v)
+				s.Max = math.Max(s.Max, v)
+				s.Last = v
+				sums[key] += v
+				stats[key] = s
+				if st.Kind == sensorBinary {
+					if open[key] == nil {
+						open[key] = map[string]bool{}
+					}
+					open[key][dev] = v == 1
+				}
+			}
+		}
+	}
+	for key, s := range stats {
+		s.Mean = sums[key] / float64(s.Count)
+		for _, isOpen := range open[key] {
+			if isOpen {
+				s.OpenDevices++
+			}
+		}
+		stats[key] = s
+		if st, ok := sensorByKey(key); ok {
+			summary[st.SummaryKey] = sensorHeadline(st, s)
+		}
+	}
+	if len(stats) == 0 {
+		return nil
+	}
+	return stats
+}
+
+func sensorHeadline(st SensorType, s SensorStats) float64 {
+	switch st.Kind {
+	case sensorCount:
+		return s.Max
+	case sensorBinary:
+		return float64(s.OpenDevices)
+	default:
+		return s.Mean
+	}
+}
+
+// mergeSensorStats combines two epochs' statistics; b is the later epoch.
+func mergeSensorStats(a, b SensorStats) SensorStats {
+	if a.Count == 0 {
+		return b
+	}
+	if b.Count == 0 {
+		return a
+	}
+	n := a.Count + b.Count
+	return SensorStats{
+		Unit:        b.Unit,
+		Count:       n,
+		Min:         math.Min(a.Min, b.Min),
+		Max:         math.Max(a.Max, b.Max),
+		Mean:        (a.Mean*float64(a.Count) + b.Mean*float64(b.Count)) / float64(n),
+		Last:        b.Last,
+		OpenDevices: b.OpenDevices,
+	}
+}
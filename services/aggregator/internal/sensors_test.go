@@ -0,0 +1,77 @@
+// Package internal v0
+// file: internal/sensors_test.go
+package internal
+
+import (
+	"io"
+	"log/slog"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDecodeRegisteredSensors(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cases := []struct {
+		payload string
+		key     string
+		want    float64
+	}{
+		{`{"deviceId":"h1","deviceType":"humidity_sensor","reading":{"humidityPct":45.5}}`, "humidity", 45.5},
+		{`{"deviceId":"c1","deviceType":"co2_sensor","reading":{"co2ppm":"812"}}`, "co2", 812},
+		{`{"deviceId":"o1","deviceType":"occupancy_sensor","reading":{"count":3}}`, "occupancy", 3},
+		{`{"deviceId":"d1","deviceType":"contact_sensor","reading":{"state":"OPEN"}}`, "contact", 1},
+		{`{"deviceId":"d2","deviceType":"contact_sensor","reading":{"open":false}}`, "contact", 0},
+	}
+	for _, c := range cases {
+		r, ok := decodeReadingNewSchema(logger, "device.readings.zone-A", []byte(c.payload), time.Unix(0, 0))
+		if !ok {
+			t.Fatalf("decode failed for %s", c.payload)
+		}
+		if got, ok := r.Sensors[c.key]; !ok || got != c.want {
+			t.Fatalf("%s: expected %s=%v, got %v", c.payload, c.key, c.want, r.Sensors)
+		}
+	}
+	if _, ok := decodeReadingNewSchema(logger, "device.readings.zone-A", []byte(`{"deviceId":"h1","deviceType":"humidity_sensor","reading":{"humidityPct":140}}`), time.Unix(0, 0)); ok {
+		t.Fatalf("expected implausible humidity to be rejected")
+	}
+}
+
+func TestAggregateSensorStats(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	epoch := EpochID{Start: start, End: start.Add(time.Minute), Len: time.Minute}
+	sensor := func(dev, key string, v float64, offset time.Duration) Reading {
+		return Reading{DeviceID: dev, ZoneID: "zone-A", Timestamp: start.Add(offset), Sensors: map[string]float64{key: v}}
+	}
+	readings := []Reading{
+		sensor("h1", "humidity", 40, time.Second),
+		sensor("h1", "humidity", 50, 2*time.Second),
+		sensor("o1", "occupancy", 2, time.Second),
+		sensor("o1", "occupancy", 5, 3*time.Second),
+		sensor("d1", "contact", 1, time.Second),
+		sensor("d1", "contact", 0, 4*time.Second),
+		sensor("d2", "contact", 1, 2*time.Second),
+	}
+	agg := aggregate("zone-A", epoch, readings, 0, NewEnergyState())
+	hum := agg.SensorStats["humidity"]
+	if hum.Count != 2 || math.Abs(hum.Mean-45) > 1e-9 || hum.Min != 40 || hum.Max != 50 || hum.Unit != "%RH" {
+		t.Fatalf("unexpected humidity stats %+v", hum)
+	}
+	if agg.Summary["avgHumidityPct"] != 45 || agg.Summary["maxOccupancy"] != 5 {
+		t.Fatalf("unexpected summary %#v", agg.Summary)
+	}
+	if got := agg.SensorStats["contact"].OpenDevices; got != 1 || agg.Summary["openContacts"] != 1 {
+		t.Fatalf("expected one contact left open, got %d", got)
+	}
+}
+
+func TestRemoveOutliersCoversContinuousSensors(t *testing.T) {
+	var rs []Reading
+	for i := 0; i < 20; i++ {
+		rs = append(rs, Reading{DeviceID: "c1", Sensors: map[string]float64{"co2": 600}})
+	}
+	rs = append(rs, Reading{DeviceID: "c1", Sensors: map[string]float64{"co2": 9000}})
+	if got := len(removeOutliers(rs, 3)); got != 20 {
+		t.Fatalf("expected CO2 spike to be removed, kept %d readings", got)
+	}
+}
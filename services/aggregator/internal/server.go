@@ -1,10 +1,11 @@
-// Package internal v9
+// Package internal v12
 // file: internal/server.go
 package internal
 
 import (
 	"context"
 	"errors"
+	"flag"
 	"io"
 	"log/slog"
 	"net/http"
@@ -16,26 +17,41 @@ import (
 )
 
 // StartCmd bootstraps from env var AGGREGATOR_PROPS and starts HTTP health.
+// With --replay it instead recomputes a historical range (see ParseReplayArgs) and exits.
 func StartCmd() error {
+	fs := flag.NewFlagSet("aggregator", flag.ContinueOnError)
+	replay := fs.Bool("replay", false, "recompute past epochs: --replay from=RFC3339 to=RFC3339 topic=<name>|file=<path>")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		return err
+	}
 	propsPath := os.Getenv("AGGREGATOR_PROPS")
 	if propsPath == "" {
 		propsPath = "./aggregator.properties"
 	}
 	cfg := LoadProps(propsPath)
-	_ = os.MkdirAll(filepath.Dir(cfg.LogPath), 0o755)
-	logFile, err := os.OpenFile(cfg.LogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
-	var logger *slog.Logger
-	if err != nil {
-		logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
-		logger.Error("log_file_open_failed", "path", cfg.LogPath, "err", err)
-	} else {
-		mw := io.MultiWriter(os.Stdout, logFile)
-		logger = slog.New(slog.NewJSONHandler(mw, nil))
+	logger := newLogger(cfg.LogPath)
+
+	if *replay {
+		opts, err := ParseReplayArgs(fs.Args())
+		if err != nil {
+			return err
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		return RunReplay(ctx, logger, cfg, opts)
 	}
 
-	h := NewHealth(logger, cfg.Epoch)
+	slowest := cfg.Epoch
+	for _, d := range cfg.ZoneEpochs {
+		if d > slowest {
+			slowest = d
+		}
+	}
+	h := NewHealth(logger, slowest)
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", h.Handler)
+	hist := NewEpochHistory(cfg.HistorySize)
+	RegisterInspection(mux, logger, hist)
 	httpSrv := &http.Server{Addr: ":8080", Handler: mux}
 	go func() {
 		logger.Info("http_listen_start", "addr", httpSrv.Addr)
@@ -47,7 +63,7 @@ func StartCmd() error {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 	errCh := make(chan error, 1)
-	go func() { errCh <- Start(ctx, logger, cfg, IO{}, h) }()
+	go func() { errCh <- Start(ctx, logger, cfg, IO{}, h, hist) }()
 	var runErr error
 	select {
 	case runErr = <-errCh:
@@ -65,3 +81,14 @@ func StartCmd() error {
 	logger.Info("shutdown_complete")
 	return nil
 }
+
+func newLogger(path string) *slog.Logger {
+	_ = os.MkdirAll(filepath.Dir(path), 0o755)
+	logFile, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+		logger.Error("log_file_open_failed", "path", path, "err", err)
+		return logger
+	}
+	return slog.New(slog.NewJSONHandler(io.MultiWriter(os.Stdout, logFile), nil))
+}
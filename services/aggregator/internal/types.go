@@ -1,4 +1,4 @@
-// v11
+// v14
 // services/aggregator/internal/types.go
 // Package internal provides aggregator domain primitives and wiring contracts.
 package internal
@@ -22,37 +22,38 @@ type EpochID struct {
 
 // Reading represents a validated device measurement with minimal overhead.
 type Reading struct {
-	DeviceID      string         `json:"deviceId"`
-	ZoneID        string         `json:"zoneId"`
-	DeviceType    string         `json:"deviceType"`
-	Timestamp     time.Time      `json:"timestamp"`
-	Temperature   *float64       `json:"temperature,omitempty"`   // tempC for temp_sensor
-	ActuatorState *string        `json:"actuatorState,omitempty"` // ON/OFF or 0/25/50/75/100
-	PowerW        *float64       `json:"powerW,omitempty"`
-	PowerKW       *float64       `json:"powerKW,omitempty"`
-	EnergyKWh     *float64       `json:"energyKWh,omitempty"`
-	Extra         map[string]any `json:"-"` // removed in aggregated payloads
+	DeviceID      string             `json:"deviceId"`
+	ZoneID        string             `json:"zoneId"`
+	DeviceType    string             `json:"deviceType"`
+	Timestamp     time.Time          `json:"timestamp"`
+	Temperature   *float64           `json:"temperature,omitempty"`   // tempC for temp_sensor
+	ActuatorState *string            `json:"actuatorState,omitempty"` // ON/OFF or 0/25/50/75/100
+	PowerW        *float64           `json:"powerW,omitempty"`
+	PowerKW       *float64           `json:"powerKW,omitempty"`
+	EnergyKWh     *float64           `json:"energyKWh,omitempty"`
+	Sensors       map[string]float64 `json:"sensors,omitempty"` // registry Key -> value (see sensors.go)
+	Extra         map[string]any     `json:"-"`                 // removed in aggregated payloads
 }
 
 // AggregatedEpoch groups readings for a zone within one epoch.
 type AggregatedEpoch struct {
-	SchemaVersion          string               `json:"schemaVersion"`
-	ZoneID                 string               `json:"zoneId"`
-	Epoch                  EpochID              `json:"epoch"`
-	ByDevice               map[string][]Reading `json:"byDevice"`
-	Summary                map[string]float64   `json:"summary"` // e.g., avgTemp, avgPowerW
-	ProducedAt             time.Time            `json:"producedAt"`
-	ActuatorEnergyKWhEpoch map[string]float64   `json:"actuatorEnergyKWhEpoch,omitempty"`
-	ZoneEnergyKWhEpoch     float64              `json:"zoneEnergyKWhEpoch,omitempty"`
+	SchemaVersion          string                 `json:"schemaVersion"`
+	ZoneID                 string                 `json:"zoneId"`
+	Epoch                  EpochID                `json:"epoch"`
+	ByDevice               map[string][]Reading   `json:"byDevice"`
+	Summary                map[string]float64     `json:"summary"` // e.g., avgTemp, avgPowerW
+	ProducedAt             time.Time              `json:"producedAt"`
+	ActuatorEnergyKWhEpoch map[string]float64     `json:"actuatorEnergyKWhEpoch,omitempty"`
+	ZoneEnergyKWhEpoch     float64                `json:"zoneEnergyKWhEpoch,omitempty"`
+	SensorStats            map[string]SensorStats `json:"sensorStats,omitempty"`
 }
 
 // Service wires everything.
 type Service struct {
-	Log   *slog.Logger
-	Cfg   Config
-	Now   func() time.Time
-	IO    IO
-	Stats *Stats
+	Log *slog.Logger
+	Cfg Config
+	Now func() time.Time
+	IO  IO
 }
 
 // IO groups adapters.
@@ -62,10 +63,11 @@ type IO struct {
 	CB       CircuitBreakerFactory
 }
 
-// ProducerMulti can write to MAPE and Ledger with circuit-breaker protection.
+// ProducerMulti can write to MAPE, Ledger and rollup topics with circuit-breaker protection.
 type ProducerMulti interface {
 	SendToMAPE(ctx context.Context, zone string, epoch AggregatedEpoch) error
 	SendToLedger(ctx context.Context, zone string, epoch AggregatedEpoch) error
+	SendRollup(ctx context.Context, topic, zone string, epoch AggregatedEpoch) error
 }
 
 // KafkaConsumer abstracts partitioned reads.
@@ -84,11 +86,3 @@ type CBWrappedProducer interface {
 	Send(ctx context.Context, topic string, key, value []byte) error
 	SendToPartition(ctx context.Context, topic string, partition int, key, value []byte) error
 }
-
-// Stats are exported counters for observability.
-type Stats struct {
-	ProcessedEpochs   int64
-	DiscardedOutliers int64
-	ProducedToMAPE    int64
-	ProducedToLedger  int64
-}
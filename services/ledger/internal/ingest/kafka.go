@@ -1,4 +1,4 @@
-// v7
+// v9
 // services/ledger/internal/ingest/kafka.go
 // Package ingest coordinates the Kafka pipelines that populate the ledger storage.
 package ingest
@@ -305,6 +305,17 @@ func (zc *zoneConsumer) handleAggregator(msg kafka.Message) ([]kafka.Message, er
 
 func (zc *zoneConsumer) handleMape(msg kafka.Message) ([]kafka.Message, error) {
 	zc.log.Debug("mape_msg", slog.Int64("offset", msg.Offset), slog.Int("partition", msg.Partition))
+	var kind struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(msg.Value, &kind); err == nil {
+		switch kind.Type {
+		case models.TransactionTypeSetpointAudit:
+			return zc.handleAudit(msg)
+		case models.TransactionTypePolicyAudit:
+			return zc.handlePolicyAudit(msg)
+		}
+	}
 	var led mapeLedgerEvent
 	if err := json.Unmarshal(msg.Value, &led); err != nil {
 		metrics.IncDecodeError("mape")
@@ -349,6 +360,69 @@ func (zc *zoneConsumer) handleMape(msg kafka.Message) ([]kafka.Message, error) {
 	return nil, nil
 }
 
+// handleAudit appends a MAPE setpoint audit to the hash chain straight away; audits are
+// not tied to an epoch and are never matched or imputed.
+func (zc *zoneConsumer) handleAudit(msg kafka.Message) ([]kafka.Message, error) {
+	var audit models.SetpointAudit
+	if err := json.Unmarshal(msg.Value, &audit); err != nil {
+		metrics.IncDecodeError("mape")
+		return []kafka.Message{msg}, fmt.Errorf("decode setpoint audit: %w", err)
+	}
+	if audit.SchemaVersion != schemaVersionV1 {
+		zc.mapeVersionUnknown.Add(1)
+		zc.log.Error("audit_schema_version_unknown", slog.String("schemaVersion", audit.SchemaVersion), slog.Bool("missing", audit.SchemaVersion == ""))
+		return []kafka.Message{msg}, fmt.Errorf("unsupported setpoint audit schema version %q", audit.SchemaVersion)
+	}
+	if audit.ZoneID != "" && !strings.EqualFold(audit.ZoneID, zc.zone) {
+		zc.log.Warn("zone_mismatch", slog.String("payloadZone", audit.ZoneID), slog.String("topic", zc.topic))
+	}
+	now := time.Now().UTC()
+	tx := &models.Transaction{
+		Type:           models.TransactionTypeSetpointAudit,
+		SchemaVersion:  models.TransactionSchemaVersionV1,
+		ZoneID:         zc.zone,
+		MAPEReceivedAt: now,
+		MatchedAt:      now,
+		Audit:          &audit,
+	}
+	if _, _, err := zc.storage.Append(tx); err != nil {
+		return nil, fmt.Errorf("append ledger: %w", err)
+	}
+	zc.log.Info("setpoint_audit_committed", slog.String("actor", audit.Actor), slog.Float64("oldC", audit.OldC), slog.Float64("newC", audit.NewC), slog.Int64("offset", msg.Offset))
+	return []kafka.Message{msg}, nil
+}
+
+// handlePolicyAudit appends a MAPE control policy audit to the hash chain, like handleAudit.
+func (zc *zoneConsumer) handlePolicyAudit(msg kafka.Message) ([]kafka.Message, error) {
+	var audit models.PolicyAudit
+	if err := json.Unmarshal(msg.Value, &audit); err != nil {
+		metrics.IncDecodeError("mape")
+		return []kafka.Message{msg}, fmt.Errorf("decode policy audit: %w", err)
+	}
+	if audit.SchemaVersion != schemaVersionV1 {
+		zc.mapeVersionUnknown.Add(1)
+		zc.log.Error("audit_schema_version_unknown", slog.String("schemaVersion", audit.SchemaVersion), slog.Bool("missing", audit.SchemaVersion == ""))
+		return []kafka.Message{msg}, fmt.Errorf("unsupported policy audit schema version %q", audit.SchemaVersion)
+	}
+	if audit.ZoneID != "" && !strings.EqualFold(audit.ZoneID, zc.zone) {
+		zc.log.Warn("zone_mismatch", slog.String("payloadZone", audit.ZoneID), slog.String("topic", zc.topic))
+	}
+	now := time.Now().UTC()
+	tx := &models.Transaction{
+		Type:           models.TransactionTypePolicyAudit,
+		SchemaVersion:  models.TransactionSchemaVersionV1,
+		ZoneID:         zc.zone,
+		MAPEReceivedAt: now,
+		MatchedAt:      now,
+		Policy:         &audit,
+	}
+	if _, _, err := zc.storage.Append(tx); err != nil {
+		return nil, fmt.Errorf("append ledger: %w", err)
+	}
+	zc.log.Info("policy_audit_committed", slog.String("actor", audit.Actor), slog.String("source", audit.Source), slog.Int64("offset", msg.Offset))
+	return []kafka.Message{msg}, nil
+}
+
 // finalize persists a matched epoch, optionally imputing missing sides, and returns messages to acknowledge.
 func (zc *zoneConsumer) finalize(epoch int64, state *matchState, allowImpute bool) ([]kafka.Message, error) {
 	if state == nil {
@@ -403,7 +477,7 @@ func (zc *zoneConsumer) finalize(epoch int64, state *matchState, allowImpute boo
 func (zc *zoneConsumer) persistMatch(epoch int64, agg aggregatedEpoch, aggReceived time.Time, led mapeLedgerEvent, ledReceived time.Time) (*models.Transaction, storage.BlockMetadata, error) {
 	matchedAt := time.Now().UTC()
 	tx := &models.Transaction{
-		Type:                 "epoch.match",
+		Type:                 models.TransactionTypeEpochMatch,
 		SchemaVersion:        models.TransactionSchemaVersionV1,
 		ZoneID:               zc.zone,
 		EpochIndex:           epoch,
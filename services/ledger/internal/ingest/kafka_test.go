@@ -1,4 +1,4 @@
-// v4
+// v6
 // services/ledger/internal/ingest/kafka_test.go
 // The tests in this file validate ingestion behaviour for versioned Kafka payloads.
 package ingest
@@ -245,6 +245,96 @@ func TestHandleMapeRejectsUnknownVersion(t *testing.T) {
 	}
 }
 
+func TestHandleMapeChainsSetpointAudit(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	tmp := t.TempDir()
+	st, err := storage.NewFileLedger(filepath.Join(tmp, "ledger.jsonl"), logger)
+	if err != nil {
+		t.Fatalf("ledger: %v", err)
+	}
+	hook := &recordingHook{}
+	consumer := newZoneConsumer("zone-A", "zone.ledger.zone-A", nil, nil, st, logger, 0, 1, 50*time.Millisecond, 2, hook)
+
+	audit := models.SetpointAudit{
+		SchemaVersion: schemaVersionV1, Type: models.TransactionTypeSetpointAudit, ZoneID: "zone-A",
+		Actor: "alice", Reason: "meeting", OldC: 21, NewC: 23.5, Timestamp: time.Now().UnixMilli(),
+	}
+	b, err := json.Marshal(audit)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	commits, err := consumer.handleMessage(kafka.Message{Partition: 1, Offset: 3, Value: b})
+	if err != nil || len(commits) != 1 {
+		t.Fatalf("expected the audit to be committed at once, commits=%d err=%v", len(commits), err)
+	}
+	if len(consumer.pending) != 0 {
+		t.Fatalf("audits must not wait for an epoch counterpart")
+	}
+	if _, _, calls := hook.snapshot(); calls != 0 {
+		t.Fatalf("audits must not be published as epochs, hook calls=%d", calls)
+	}
+
+	ev, err := st.GetByID(1)
+	if err != nil {
+		t.Fatalf("ledger get: %v", err)
+	}
+	var payload models.MatchRecord
+	if err := json.Unmarshal(ev.Payload, &payload); err != nil {
+		t.Fatalf("unmarshal payload: %v", err)
+	}
+	if ev.Type != models.TransactionTypeSetpointAudit || payload.Audit == nil || *payload.Audit != audit {
+		t.Fatalf("unexpected audit event %s %+v", ev.Type, payload.Audit)
+	}
+	if _, err := st.Verify(); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	bad := audit
+	bad.SchemaVersion = "v2"
+	b, _ = json.Marshal(bad)
+	if _, err := consumer.handleMessage(kafka.Message{Partition: 1, Offset: 4, Value: b}); err == nil {
+		t.Fatalf("expected error for unknown audit schema version")
+	}
+}
+
+func TestHandleMapeChainsPolicyAudit(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	st, err := storage.NewFileLedger(filepath.Join(t.TempDir(), "ledger.jsonl"), logger)
+	if err != nil {
+		t.Fatalf("ledger: %v", err)
+	}
+	consumer := newZoneConsumer("zone-A", "zone.ledger.zone-A", nil, nil, st, logger, 0, 1, 50*time.Millisecond, 2, &recordingHook{})
+
+	audit := models.PolicyAudit{
+		SchemaVersion: schemaVersionV1, Type: models.TransactionTypePolicyAudit, ZoneID: "zone-A", Actor: "alice",
+		Old:       models.ZonePolicy{HysteresisC: 0.5, FanSteps: []float64{0.5}, FanSpeeds: []int{50}, MinSetpointC: 10, MaxSetpointC: 35, Strategy: "hysteresis"},
+		New:       models.ZonePolicy{HysteresisC: 0.3, FanSteps: []float64{0.4}, FanSpeeds: []int{90}, MinSetpointC: 19, MaxSetpointC: 24, Strategy: "pid"},
+		Source:    "api",
+		Timestamp: time.Now().UnixMilli(),
+	}
+	b, err := json.Marshal(audit)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if commits, err := consumer.handleMessage(kafka.Message{Partition: 1, Offset: 3, Value: b}); err != nil || len(commits) != 1 {
+		t.Fatalf("expected the audit to be committed at once, commits=%d err=%v", len(commits), err)
+	}
+	ev, err := st.GetByID(1)
+	if err != nil {
+		t.Fatalf("ledger get: %v", err)
+	}
+	var payload models.MatchRecord
+	if err := json.Unmarshal(ev.Payload, &payload); err != nil {
+		t.Fatalf("unmarshal payload: %v", err)
+	}
+	if ev.Type != models.TransactionTypePolicyAudit || ev.Source != "ledger.audit" || payload.Policy == nil || payload.Policy.New.Strategy != "pid" || payload.Audit != nil {
+		t.Fatalf("unexpected policy audit event %s %+v", ev.Type, payload.Policy)
+	}
+	if _, err := st.Verify(); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+}
+
 func TestZoneConsumerInvokesFinalizationHook(t *testing.T) {
 	t.Helper()
 	logger := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelDebug}))
@@ -1,4 +1,4 @@
-// v3
+// v5
 // internal/models/models.go
 package models
 
@@ -45,6 +45,10 @@ const (
 	BlockVersionV2             = "v2"
 	BlockNonceBytes            = 16
 	TransactionSchemaVersionV1 = "v1"
+
+	TransactionTypeEpochMatch    = "epoch.match"
+	TransactionTypeSetpointAudit = "setpoint.audit"
+	TransactionTypePolicyAudit   = "policy.audit"
 )
 
 type AggregatedEpoch struct {
@@ -88,6 +92,49 @@ type MAPELedgerEvent struct {
 	Timestamp     int64   `json:"timestamp"`
 }
 
+// SetpointAudit is a setpoint change published by MAPE on its ledger partition. It is
+// recorded as a transaction of its own, outside the epoch matching.
+type SetpointAudit struct {
+	SchemaVersion string  `json:"schemaVersion"`
+	Type          string  `json:"type"`
+	ZoneID        string  `json:"zoneId"`
+	Actor         string  `json:"actor"`
+	Reason        string  `json:"reason,omitempty"`
+	OldC          float64 `json:"oldSetpointC"`
+	NewC          float64 `json:"newSetpointC"`
+	Timestamp     int64   `json:"timestamp"`
+}
+
+// ZonePolicy is a zone's control policy as carried by a PolicyAudit.
+type ZonePolicy struct {
+	HysteresisC  float64   `json:"hysteresisC"`
+	FanSteps     []float64 `json:"fanSteps"`
+	FanSpeeds    []int     `json:"fanSpeeds"`
+	MinSetpointC float64   `json:"minSetpointC"`
+	MaxSetpointC float64   `json:"maxSetpointC"`
+	Strategy     string    `json:"strategy"`
+}
+
+// PolicyAudit is a zone control policy change published by MAPE on its ledger partition,
+// recorded like a SetpointAudit.
+type PolicyAudit struct {
+	SchemaVersion string     `json:"schemaVersion"`
+	Type          string     `json:"type"`
+	ZoneID        string     `json:"zoneId"`
+	Actor         string     `json:"actor"`
+	Reason        string     `json:"reason,omitempty"`
+	Old           ZonePolicy `json:"oldPolicy"`
+	New           ZonePolicy `json:"newPolicy"`
+	Source        string     `json:"source"`
+	Timestamp     int64      `json:"timestamp"`
+}
+
+func (p ZonePolicy) clone() ZonePolicy {
+	p.FanSteps = append([]float64(nil), p.FanSteps...)
+	p.FanSpeeds = append([]int(nil), p.FanSpeeds...)
+	return p
+}
+
 type MatchRecord struct {
 	ZoneID             string          `json:"zoneId"`
 	EpochIndex         int64           `json:"epochIndex"`
@@ -96,6 +143,8 @@ type MatchRecord struct {
 	MAPE               MAPELedgerEvent `json:"mape"`
 	MAPEReceived       time.Time       `json:"mapeReceivedAt"`
 	MatchedAt          time.Time       `json:"matchedAt"`
+	Audit              *SetpointAudit  `json:"audit,omitempty"`
+	Policy             *PolicyAudit    `json:"policy,omitempty"`
 }
 
 type Transaction struct {
@@ -109,6 +158,8 @@ type Transaction struct {
 	MAPE                 MAPELedgerEvent `json:"mape"`
 	MAPEReceivedAt       time.Time       `json:"mapeReceivedAt"`
 	MatchedAt            time.Time       `json:"matchedAt"`
+	Audit                *SetpointAudit  `json:"audit,omitempty"`
+	Policy               *PolicyAudit    `json:"policy,omitempty"`
 	PrevHash             string          `json:"prevHash"`
 	Hash                 string          `json:"hash"`
 }
@@ -125,6 +176,8 @@ func (tx *Transaction) MatchRecord() MatchRecord {
 		MAPE:               tx.MAPE,
 		MAPEReceived:       tx.MAPEReceivedAt.UTC(),
 		MatchedAt:          tx.MatchedAt.UTC(),
+		Audit:              tx.Audit,
+		Policy:             tx.Policy,
 	}
 }
 
@@ -142,6 +195,8 @@ func (tx *Transaction) CanonicalJSON() ([]byte, error) {
 		MAPE                 MAPELedgerEvent `json:"mape"`
 		MAPEReceivedAt       time.Time       `json:"mapeReceivedAt"`
 		MatchedAt            time.Time       `json:"matchedAt"`
+		Audit                *SetpointAudit  `json:"audit,omitempty"`
+		Policy               *PolicyAudit    `json:"policy,omitempty"`
 		PrevHash             string          `json:"prevHash"`
 	}{
 		Type:                 tx.Type,
@@ -153,6 +208,8 @@ func (tx *Transaction) CanonicalJSON() ([]byte, error) {
 		MAPE:                 tx.MAPE,
 		MAPEReceivedAt:       tx.MAPEReceivedAt.UTC(),
 		MatchedAt:            tx.MatchedAt.UTC(),
+		Audit:                tx.Audit,
+		Policy:               tx.Policy,
 		PrevHash:             tx.PrevHash,
 	}
 	return json.Marshal(&payload)
@@ -176,6 +233,15 @@ func (tx *Transaction) Clone() *Transaction {
 	cp.AggregatorReceivedAt = cp.AggregatorReceivedAt.UTC()
 	cp.MAPEReceivedAt = cp.MAPEReceivedAt.UTC()
 	cp.MatchedAt = cp.MatchedAt.UTC()
+	if tx.Audit != nil {
+		audit := *tx.Audit
+		cp.Audit = &audit
+	}
+	if tx.Policy != nil {
+		policy := *tx.Policy
+		policy.Old, policy.New = policy.Old.clone(), policy.New.clone()
+		cp.Policy = &policy
+	}
 	return &cp
 }
 
@@ -1,4 +1,4 @@
-// v4
+// v6
 // internal/storage/file_ledger.go
 package storage
 
@@ -546,6 +546,14 @@ func transactionToEvent(tx *models.Transaction) (*models.Event, error) {
 		PrevHash:      tx.PrevHash,
 		Hash:          tx.Hash,
 	}
+	if tx.Audit != nil {
+		ev.Source = "ledger.audit"
+		ev.CorrelationID = fmt.Sprintf("%s-setpoint-%d", tx.ZoneID, tx.Audit.Timestamp)
+	}
+	if tx.Policy != nil {
+		ev.Source = "ledger.audit"
+		ev.CorrelationID = fmt.Sprintf("%s-policy-%d", tx.ZoneID, tx.Policy.Timestamp)
+	}
 	return ev, nil
 }
 
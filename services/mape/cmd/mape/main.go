@@ -1,4 +1,4 @@
-// v8
+// v14
 // services/mape/cmd/mape/main.go
 package main
 
@@ -34,7 +34,26 @@ func main() {
 		lg.Error("setpoints", "error", err)
 		os.Exit(1)
 	}
-	lg.Info("setpoints initialized", "min_c", cfg.SetpointMinC, "max_c", cfg.SetpointMaxC, "values", sp.All())
+	journal := internal.NewSetpointJournal(cfg.SetpointStorePath, lg)
+	restored, err := journal.Restore(sp)
+	if err != nil {
+		lg.Error("setpoint store", "error", err)
+		os.Exit(1)
+	}
+	journal.Track(sp)
+	lg.Info("setpoints initialized", "min_c", cfg.SetpointMinC, "max_c", cfg.SetpointMaxC, "values", sp.All(), "restored", restored, "store", cfg.SetpointStorePath)
+	policies := internal.NewPolicies(cfg, sp, cfg.PolicyStorePath, lg)
+	loaded, err := policies.Load()
+	if err != nil {
+		lg.Error("policy store", "error", err)
+		os.Exit(1)
+	}
+	cfg.Policies = policies
+	lg.Info("policies initialized", "overrides", loaded, "store", cfg.PolicyStorePath)
+
+	sched := internal.NewSchedules(sp, cfg.ScheduleLocation)
+	journal.TrackSchedules(sched)
+	dr := internal.NewDREvents(cfg)
 
 	io, err := internal.NewKafkaIO(cfg, lg)
 	if err != nil {
@@ -42,15 +61,28 @@ func main() {
 		os.Exit(1)
 	}
 	defer io.Close()
+	journal.SetPublisher(io)
+	policies.SetPublisher(io)
 
-	srv := internal.NewHTTPServer(cfg, sp, lg)
+	srv := internal.NewHTTPServer(cfg, sp, sched, dr, lg)
+	auth, err := internal.NewAuthFromConfig(cfg)
+	if err != nil {
+		lg.Error("auth", "error", err)
+		os.Exit(1)
+	}
+	if auth != nil {
+		srv.UseAuth(auth)
+		lg.Info("http authentication enabled", "api_keys", cfg.AuthAPIKeysPath, "jwks", cfg.AuthJWKSPath)
+	} else {
+		lg.Warn("http authentication disabled: set MAPE_AUTH_API_KEYS or MAPE_AUTH_JWKS")
+	}
 	go func() {
 		if err := srv.Start(); err != nil {
 			lg.Error("http", "error", err)
 		}
 	}()
 
-	eng := internal.NewEngine(cfg, sp, lg, io)
+	eng := internal.NewEngine(cfg, sp, sched, dr, lg, io)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go eng.Run(ctx)
@@ -0,0 +1,215 @@
+// v2
+// services/mape/internal/acks.go
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Anomaly event types published on the anomaly topic.
+const (
+	AnomalyActuatorFault     = "actuator_fault"
+	AnomalyActuatorRecovered = "actuator_recovered"
+)
+
+// AckParams configures command acknowledgement tracking. A zero Timeout disables it.
+type AckParams struct {
+	Timeout    time.Duration // wait for an acknowledgement before re-sending
+	MaxResends int           // re-sends without acknowledgement before the actuator is faulty
+	FaultAfter int           // consecutive acknowledgements disagreeing with the desired state
+}
+
+// ActuatorStatus is the desired versus reported state of one actuator, as shown on /status.
+type ActuatorStatus struct {
+	ZoneID      string    `json:"zoneId"`
+	Desired     string    `json:"desired"`
+	Reported    string    `json:"reported,omitempty"`
+	Pending     bool      `json:"pending"`
+	SentAt      time.Time `json:"sentAt"`
+	AckedAt     time.Time `json:"ackedAt,omitempty"`
+	Resends     int       `json:"resends"`
+	Mismatches  int       `json:"mismatches"`
+	Faulty      bool      `json:"faulty"`
+	FaultReason string    `json:"faultReason,omitempty"`
+}
+
+type trackedActuator struct {
+	ActuatorStatus
+	cmd PlanCommand
+}
+
+// AckTracker reconciles the commands MAPE issued with the acknowledgements actuators
+// publish. Commands whose desired state is not acknowledged within the timeout are due
+// for re-sending; actuators that stay silent or keep reporting another state are faulty.
+type AckTracker struct {
+	cfg *AppConfig
+	mu  sync.Mutex
+	act map[string]*trackedActuator
+	now func() time.Time
+}
+
+func NewAckTracker(cfg *AppConfig) *AckTracker {
+	return &AckTracker{cfg: cfg, act: map[string]*trackedActuator{}, now: time.Now}
+}
+
+// Enabled reports whether acknowledgements are expected at all.
+func (t *AckTracker) Enabled() bool { return t.cfg.Ack.Timeout > 0 }
+
+// Issued records commands that were published. Re-issuing the same desired state keeps
+// the original send time so that a silent actuator still times out.
+func (t *AckTracker) Issued(cmds []PlanCommand) {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, c := range cmds {
+		desired := strings.ToUpper(c.Mode)
+		a, ok := t.act[c.ActuatorID]
+		if !ok {
+			a = &trackedActuator{}
+			a.ZoneID = c.ZoneID
+			t.act[c.ActuatorID] = a
+		}
+		a.cmd = c
+		if a.Desired == desired && (a.Pending || a.Reported == desired) {
+			continue
+		}
+		a.Desired = desired
+		a.Pending = a.Reported != desired
+		a.SentAt = now
+		a.Resends = 0
+		a.Mismatches = 0
+	}
+}
+
+// Acked applies an acknowledgement and returns an anomaly when the actuator's fault
+// status changed.
+func (t *AckTracker) Acked(ack CommandAck) *AnomalyEvent {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a, ok := t.act[ack.ActuatorID]
+	if !ok {
+		return nil
+	}
+	a.Reported = strings.ToUpper(ack.State)
+	a.AckedAt = now
+	if a.Reported == a.Desired {
+		a.Pending = false
+		a.Mismatches = 0
+		a.Resends = 0
+		if a.Faulty {
+			a.Faulty, a.FaultReason = false, ""
+			return t.anomaly(ack.ActuatorID, a, AnomalyActuatorRecovered, "reported state matches desired", now)
+		}
+		return nil
+	}
+	// Only acknowledgements of the current command can disagree with it; late acks of an
+	// earlier command are expected while a change is in flight.
+	if ack.EpochIndex < a.cmd.EpochIndex {
+		return nil
+	}
+	a.Mismatches++
+	if !a.Faulty && t.cfg.Ack.FaultAfter > 0 && a.Mismatches >= t.cfg.Ack.FaultAfter {
+		return t.markFaulty(ack.ActuatorID, a, fmt.Sprintf("reported %s while %s was requested %d times", a.Reported, a.Desired, a.Mismatches), now)
+	}
+	return nil
+}
+
+// Due returns the commands to re-send now and the anomalies raised for actuators that
+// exhausted their re-sends.
+func (t *AckTracker) Due() ([]PlanCommand, []AnomalyEvent) {
+	if !t.Enabled() {
+		return nil, nil
+	}
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var resend []PlanCommand
+	var events []AnomalyEvent
+	for _, id := range t.sortedIDs() {
+		a := t.act[id]
+		if !a.Pending || a.Faulty || now.Sub(a.SentAt) < t.cfg.Ack.Timeout {
+			continue
+		}
+		if a.Resends >= t.cfg.Ack.MaxResends {
+			events = append(events, *t.markFaulty(id, a, fmt.Sprintf("no acknowledgement of %s after %d re-sends", a.Desired, a.Resends), now))
+			continue
+		}
+		a.Resends++
+		a.SentAt = now
+		cmd := a.cmd
+		cmd.IssuedAt = now.UnixMilli()
+		cmd.Reason = fmt.Sprintf("re-send %d: %s", a.Resends, a.cmd.Reason)
+		resend = append(resend, cmd)
+	}
+	return resend, events
+}
+
+func (t *AckTracker) markFaulty(id string, a *trackedActuator, reason string, now time.Time) *AnomalyEvent {
+	a.Faulty, a.FaultReason = true, reason
+	return t.anomaly(id, a, AnomalyActuatorFault, reason, now)
+}
+
+func (t *AckTracker) anomaly(id string, a *trackedActuator, typ, reason string, now time.Time) *AnomalyEvent {
+	return &AnomalyEvent{
+		SchemaVersion: LedgerSchemaVersion, Type: typ, ZoneID: a.ZoneID, ActuatorID: id,
+		Desired: a.Desired, Reported: a.Reported, Resends: a.Resends, Reason: reason, Timestamp: now.UnixMilli(),
+	}
+}
+
+// Forget stops tracking actuators removed by a reload.
+func (t *AckTracker) Forget(ids []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, id := range ids {
+		delete(t.act, id)
+	}
+}
+
+func (t *AckTracker) sortedIDs() []string {
+	ids := make([]string, 0, len(t.act))
+	for id := range t.act {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Status returns a copy of every tracked actuator keyed by actuator ID.
+func (t *AckTracker) Status() map[string]ActuatorStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.act) == 0 {
+		return nil
+	}
+	out := make(map[string]ActuatorStatus, len(t.act))
+	for id, a := range t.act {
+		out[id] = a.ActuatorStatus
+	}
+	return out
+}
+
+// IsFaulty reports whether the actuator is currently flagged as faulty.
+func (t *AckTracker) IsFaulty(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a, ok := t.act[id]
+	return ok && a.Faulty
+}
+
+// Faulty lists the actuators currently flagged as faulty.
+func (t *AckTracker) Faulty() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []string
+	for _, id := range t.sortedIDs() {
+		if t.act[id].Faulty {
+			out = append(out, id)
+		}
+	}
+	return out
+}
@@ -0,0 +1,60 @@
+// v0
+// services/mape/internal/acks_test.go
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAckTrackerResendsAndFaults(t *testing.T) {
+	cfg := &AppConfig{Ack: AckParams{Timeout: 2 * time.Second, MaxResends: 2, FaultAfter: 3}}
+	tr := NewAckTracker(cfg)
+	now := time.Unix(1000, 0)
+	tr.now = func() time.Time { return now }
+
+	tr.Issued([]PlanCommand{
+		{ZoneID: "zone-A", ActuatorID: "h1", Mode: "ON", EpochIndex: 1},
+		{ZoneID: "zone-A", ActuatorID: "c1", Mode: "OFF", EpochIndex: 1},
+	})
+	if ev := tr.Acked(CommandAck{ActuatorID: "c1", State: "OFF", EpochIndex: 1}); ev != nil {
+		t.Fatalf("matching ack must not raise %+v", ev)
+	}
+	if resend, _ := tr.Due(); len(resend) != 0 {
+		t.Fatalf("nothing is due before the timeout, got %v", resend)
+	}
+
+	// h1 stays silent: two re-sends, then it is flagged faulty.
+	for i := 1; i <= 2; i++ {
+		now = now.Add(2 * time.Second)
+		resend, events := tr.Due()
+		if len(resend) != 1 || resend[0].ActuatorID != "h1" || len(events) != 0 {
+			t.Fatalf("re-send %d: got %v %v", i, resend, events)
+		}
+	}
+	// Re-issuing the same desired state must not reset the timeout.
+	tr.Issued([]PlanCommand{{ZoneID: "zone-A", ActuatorID: "h1", Mode: "ON", EpochIndex: 3}})
+	now = now.Add(2 * time.Second)
+	resend, events := tr.Due()
+	if len(resend) != 0 || len(events) != 1 || events[0].Type != AnomalyActuatorFault {
+		t.Fatalf("expected fault after max re-sends, got %v %v", resend, events)
+	}
+	if f := tr.Faulty(); len(f) != 1 || f[0] != "h1" {
+		t.Fatalf("faulty list %v", f)
+	}
+	if ev := tr.Acked(CommandAck{ActuatorID: "h1", State: "ON", EpochIndex: 3}); ev == nil || ev.Type != AnomalyActuatorRecovered {
+		t.Fatalf("expected recovery event, got %+v", ev)
+	}
+
+	// c1 keeps reporting ON although OFF is requested: faulty after FaultAfter acks.
+	var last *AnomalyEvent
+	for i := 0; i < 3; i++ {
+		last = tr.Acked(CommandAck{ActuatorID: "c1", State: "ON", EpochIndex: 1})
+	}
+	if last == nil || last.Type != AnomalyActuatorFault || last.Reported != "ON" {
+		t.Fatalf("expected disagreement fault, got %+v", last)
+	}
+	if st := tr.Status()["c1"]; !st.Faulty || st.Desired != "OFF" || st.Reported != "ON" {
+		t.Fatalf("unexpected status %+v", st)
+	}
+}
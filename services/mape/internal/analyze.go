@@ -1,65 +1,197 @@
-// v9
+// v18
 // services/mape/internal/analyze.go
 package internal
 
 import (
-	"fmt"
 	"log/slog"
-	"math"
+	"sync"
+	"time"
 )
 
 type Analyze struct {
-	cfg *AppConfig
-	lg  *slog.Logger
-	sp  *ZoneSetpoints
+	cfg         *AppConfig
+	lg          *slog.Logger
+	sp          *ZoneSetpoints
+	sched       *Schedules
+	safety      *SafetyLayer
+	dr          *DREvents
+	occ         *Occupancy
+	controllers map[string]Controller
+	// shadow holds separate controller instances for candidate strategies so that their
+	// state never leaks into the live ones.
+	shadow   map[string]Controller
+	mu       sync.Mutex
+	lastMode map[string]string // last HEAT/COOL issued per zone, for pre-conditioning
 }
 
 type AnalysisResult struct {
 	HasTemp            bool
 	TempC              float64
 	Target             float64
+	TargetSource       string // default, schedule or override
 	Hyst               float64
 	Delta              float64
 	Action             string // HEAT/COOL/OFF
 	Fan                int
+	Duty               int    // 0..100, share of full output requested by the strategy
+	Strategy           string // controller that produced the decision
 	Reason             string
 	ZoneEnergyKWhEpoch float64
 	ZoneEnergySource   string
 	ActuatorEnergyKWh  map[string]float64
+	Tariff             TariffAdjustment
+	Occupancy          OccupancyState
+	CostEpoch          float64 // ZoneEnergyKWhEpoch priced at the epoch's tariff
+	// Shadow are the decisions of the zone's candidate strategies on the same input.
+	Shadow []AnalysisResult
+	// Interlocks are the safety rules that replaced the strategy's decision.
+	Interlocks []Interlock
 }
 
-func NewAnalyze(cfg *AppConfig, sp *ZoneSetpoints, lg *slog.Logger) *Analyze {
-	return &Analyze{cfg: cfg, lg: lg, sp: sp}
+// NewAnalyze wires the strategies; sched and dr may be nil, in which case only the static
+// setpoints apply and no demand-response events are known.
+func NewAnalyze(cfg *AppConfig, sp *ZoneSetpoints, sched *Schedules, dr *DREvents, lg *slog.Logger) *Analyze {
+	if sched == nil {
+		sched = NewSchedules(sp, cfg.ScheduleLocation)
+	}
+	if dr == nil {
+		dr = NewDREvents(cfg)
+	}
+	a := &Analyze{cfg: cfg, lg: lg, sp: sp, sched: sched, safety: NewSafetyLayer(cfg), dr: dr, controllers: newControllers(cfg), shadow: newControllers(cfg), lastMode: map[string]string{}, occ: NewOccupancy(cfg)}
+	return a
 }
 
-// Run decides the action based on the zone's avg temperature from the aggregator summary.
+// Run decides the action based on the zone's avg temperature from the aggregator summary,
+// delegating to the strategy configured for the zone (hysteresis by default). The target is
+// the setpoint in force at the epoch start: override, schedule or static default. Unoccupied
+// zones get a wider band, tariffs may then shift the target ahead of expensive periods and
+// DR events widen the band further.
+// Reports whose temperature is missing, stale or frozen yield the configured safe state.
 func (a *Analyze) Run(zone string, read Reading) AnalysisResult {
-	t, ok := a.sp.Get(zone)
+	at := epochTime(read)
+	t, source, ok := a.sched.Resolve(zone, at)
 	if !ok {
+		source = SourceDefault
 		t = a.cfg.ZoneTargets[zone]
 		a.lg.Warn("setpoint missing in store", "zone", zone, "fallback", t)
 	}
-	h := a.cfg.ZoneHysteresis[zone]
-	res := AnalysisResult{Target: t, Hyst: h, HasTemp: true, TempC: read.AvgTempC, ZoneEnergyKWhEpoch: read.ZoneEnergyKWhEpoch, ZoneEnergySource: read.ZoneEnergySource, ActuatorEnergyKWh: cloneEnergyMap(read.ActuatorEnergyKWh)}
-	res.Delta = res.TempC - t
-	if res.Delta > h {
-		res.Action = "COOL"
-		res.Fan = pickFan(math.Abs(res.Delta), a.cfg.FanSteps, a.cfg.FanSpeeds)
-		res.Reason = fmt.Sprintf("too hot by %.2fC", res.Delta)
-		return res
-	}
-	if res.Delta < -h {
-		res.Action = "HEAT"
-		res.Fan = pickFan(math.Abs(res.Delta), a.cfg.FanSteps, a.cfg.FanSpeeds)
-		res.Reason = fmt.Sprintf("too cold by %.2fC", -res.Delta)
-		return res
-	}
-	res.Action = "OFF"
-	res.Fan = 0
-	res.Reason = "within hysteresis"
+	h := a.cfg.HysteresisFor(zone)
+	occ := a.occ.Resolve(zone, read, at, t, h)
+	h += occ.SetbackC
+	a.mu.Lock()
+	lastMode := a.lastMode[zone]
+	a.mu.Unlock()
+	adj := a.dr.adjust(zone, at, lastMode)
+	t += adj.PrecondC
+	h += adj.WidenC
+	var res AnalysisResult
+	if ilk := a.safety.Inspect(zone, read); ilk != nil {
+		// Without a trustworthy temperature no strategy runs; the zone goes to its safe state.
+		a.lg.Warn("sensor interlock", "zone", zone, "epoch", read.EpochIndex, "type", ilk.Type, "reason", ilk.Reason)
+		res = a.safety.Fallback(*ilk)
+		res.Target, res.Hyst = t, h
+	} else {
+		in := ControlInput{TempC: read.AvgTempC, Target: t, Hyst: h, Dt: a.epochLen(read), ActuatorKWh: read.ActuatorEnergyKWh}
+		res = a.controllerFor(zone).Decide(zone, in)
+		for _, name := range a.cfg.ShadowFor(zone) {
+			if c, ok := a.shadow[name]; ok {
+				sr := c.Decide(zone, in)
+				sr.TargetSource = source
+				res.Shadow = append(res.Shadow, sr)
+			}
+		}
+	}
+	res.TargetSource = source
+	res.Tariff = adj
+	res.Occupancy = occ
+	res.CostEpoch = read.ZoneEnergyKWhEpoch * adj.Price
+	if adj.Reason != "" {
+		res.Reason += "; " + adj.Reason
+	}
+	res.ZoneEnergyKWhEpoch = read.ZoneEnergyKWhEpoch
+	res.ZoneEnergySource = read.ZoneEnergySource
+	res.ActuatorEnergyKWh = cloneEnergyMap(read.ActuatorEnergyKWh)
 	return res
 }
 
+// ObserveIssued forwards the issued action to the zone's controller when it learns from it.
+func (a *Analyze) ObserveIssued(zone, action string) {
+	if action == "HEAT" || action == "COOL" {
+		a.mu.Lock()
+		a.lastMode[zone] = action
+		a.mu.Unlock()
+	}
+	if obs, ok := a.controllerFor(zone).(IssueObserver); ok {
+		obs.ObserveIssued(zone, action)
+	}
+	// Shadow controllers learn from what the zone actually did, not from their own plans.
+	for _, name := range a.cfg.ShadowFor(zone) {
+		if obs, ok := a.shadow[name].(IssueObserver); ok {
+			obs.ObserveIssued(zone, action)
+		}
+	}
+}
+
+// Forget drops everything learned about a removed zone: its last mode, the state of every
+// live and shadow controller and its sensor history.
+func (a *Analyze) Forget(zone string) {
+	a.mu.Lock()
+	delete(a.lastMode, zone)
+	a.mu.Unlock()
+	for _, set := range []map[string]Controller{a.controllers, a.shadow} {
+		for _, c := range set {
+			if f, ok := c.(zoneForgetter); ok {
+				f.Forget(zone)
+			}
+		}
+	}
+	a.safety.Forget(zone)
+	a.occ.Forget(zone)
+}
+
+// MPCStatus returns the fitted thermal models of zones run by the MPC strategy.
+func (a *Analyze) MPCStatus() map[string]MPCStatus {
+	if m, ok := a.controllers[StrategyMPC].(*mpcController); ok {
+		return m.Status()
+	}
+	return nil
+}
+
+// newControllers returns one fresh instance of every strategy, keyed by name.
+func newControllers(cfg *AppConfig) map[string]Controller {
+	out := map[string]Controller{}
+	for _, c := range []Controller{&hysteresisController{cfg: cfg}, newPIDController(cfg), newMPCController(cfg)} {
+		out[c.Name()] = c
+	}
+	return out
+}
+
+func (a *Analyze) controllerFor(zone string) Controller {
+	name := a.cfg.StrategyFor(zone)
+	if c, ok := a.controllers[name]; ok {
+		return c
+	}
+	a.lg.Warn("unknown strategy; using hysteresis", "zone", zone, "strategy", name)
+	return a.controllers[StrategyHysteresis]
+}
+
+// epochLen returns the aggregator epoch length carried in the report (nanoseconds on the
+// wire), falling back to the poll interval for reports without it.
+func (a *Analyze) epochLen(read Reading) time.Duration {
+	if read.Raw.Epoch.Len > 0 {
+		return time.Duration(read.Raw.Epoch.Len)
+	}
+	return time.Duration(a.cfg.PollIntervalMs) * time.Millisecond
+}
+
+// epochTime returns the epoch start carried by the report, or now when it is missing.
+func epochTime(read Reading) time.Time {
+	if ts, err := time.Parse(time.RFC3339Nano, read.EpochStart); err == nil {
+		return ts
+	}
+	return time.Now()
+}
+
 func pickFan(absDelta float64, steps []float64, speeds []int) int {
 	for i, s := range steps {
 		if absDelta <= s {
@@ -1,4 +1,4 @@
-// v0
+// v1
 // services/mape/internal/analyze_test.go
 package internal
 
@@ -19,8 +19,8 @@ func TestAnalyzeUsesDynamicSetpoint(t *testing.T) {
 	if err != nil {
 		t.Fatalf("setpoints: %v", err)
 	}
-	analyzer := NewAnalyze(cfg, store, slog.New(slog.NewTextHandler(io.Discard, nil)))
-	reading := Reading{AvgTempC: 23.0, ZoneEnergyKWhEpoch: 1.0, ZoneEnergySource: "test"}
+	analyzer := NewAnalyze(cfg, store, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	reading := Reading{AvgTempC: 23.0, HasTemp: true, ZoneEnergyKWhEpoch: 1.0, ZoneEnergySource: "test"}
 	res := analyzer.Run("zone-A", reading)
 	if res.Action != "COOL" {
 		t.Fatalf("expected COOL with target 22, got %s", res.Action)
@@ -0,0 +1,434 @@
+// v0
+// services/mape/internal/auth.go
+package internal
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Roles, from least to most privileged. A zone-operator may change only its listed zones.
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "zone-operator"
+	RoleAdmin    = "admin"
+)
+
+var roleRank = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// ErrNoCredentials is returned by an Authenticator when the request carries no
+// credentials of its kind, so that the next one may be tried.
+var ErrNoCredentials = errors.New("no credentials")
+
+// ErrUnauthenticated wraps the reason presented credentials were rejected.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Principal is the authenticated caller of the HTTP API.
+type Principal struct {
+	Subject string   `json:"subject"`
+	Role    string   `json:"role"`
+	Zones   []string `json:"zones,omitempty"`
+	// Method is how the caller authenticated: "apikey" or "jwt".
+	Method string `json:"method"`
+}
+
+// allows reports whether the principal holds role, for zone when zone is not empty.
+// Zone scoping only restricts operators: viewers cannot change anything and admins may
+// change every zone.
+func (p Principal) allows(role, zone string) bool {
+	if roleRank[p.Role] < roleRank[role] {
+		return false
+	}
+	if p.Role != RoleOperator || role != RoleOperator {
+		return true
+	}
+	return zone != "" && slices.Contains(p.Zones, zone)
+}
+
+func (p Principal) validate() error {
+	if _, ok := roleRank[p.Role]; !ok {
+		return fmt.Errorf("unknown role %q", p.Role)
+	}
+	if p.Role == RoleOperator && len(p.Zones) == 0 {
+		return fmt.Errorf("role %s requires zones", RoleOperator)
+	}
+	return nil
+}
+
+type principalKey struct{}
+
+// PrincipalFrom returns the principal authenticated for the request context.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// Authenticator identifies the caller of a request. It returns ErrNoCredentials when the
+// request carries none it understands and an error wrapping ErrUnauthenticated when they
+// are invalid.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Principal, error)
+}
+
+// AuthChain tries each authenticator in turn until one finds credentials.
+type AuthChain []Authenticator
+
+func (c AuthChain) Authenticate(r *http.Request) (Principal, error) {
+	for _, a := range c {
+		p, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return p, err
+	}
+	return Principal{}, ErrNoCredentials
+}
+
+// NewAuthFromConfig builds the authenticators configured by MAPE_AUTH_API_KEYS and
+// MAPE_AUTH_JWKS; it returns nil when neither is set, which leaves the API open.
+func NewAuthFromConfig(cfg *AppConfig) (Authenticator, error) {
+	var chain AuthChain
+	if cfg.AuthAPIKeysPath != "" {
+		keys, err := LoadAPIKeys(cfg.AuthAPIKeysPath)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, keys)
+	}
+	if cfg.AuthJWKSPath != "" {
+		v, err := LoadJWTVerifier(cfg.AuthJWKSPath, cfg.AuthIssuer, cfg.AuthAudience)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, v)
+	}
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
+
+// APIKeys authenticates static keys sent in the X-API-Key header or as
+// "Authorization: ApiKey <key>". Keys are compared by their SHA-256 digest in constant time.
+type APIKeys struct {
+	keys []apiKey
+}
+
+type apiKey struct {
+	digest [sha256.Size]byte
+	p      Principal
+}
+
+type apiKeyFile struct {
+	Keys []struct {
+		Name  string   `json:"name"`
+		Key   string   `json:"key"`
+		Role  string   `json:"role"`
+		Zones []string `json:"zones"`
+	} `json:"keys"`
+}
+
+// LoadAPIKeys reads {"keys":[{"name","key","role","zones"}]}; the name is the principal
+// recorded with the changes made with the key.
+func LoadAPIKeys(path string) (*APIKeys, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("api keys: %w", err)
+	}
+	var f apiKeyFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("api keys %s: %w", path, err)
+	}
+	out := &APIKeys{}
+	seen := map[[sha256.Size]byte]bool{}
+	for i, k := range f.Keys {
+		p := Principal{Subject: k.Name, Role: k.Role, Zones: k.Zones, Method: "apikey"}
+		if k.Name == "" || k.Key == "" {
+			return nil, fmt.Errorf("api keys %s: entry %d needs a name and a key", path, i)
+		}
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("api keys %s: %s: %w", path, k.Name, err)
+		}
+		d := sha256.Sum256([]byte(k.Key))
+		if seen[d] {
+			return nil, fmt.Errorf("api keys %s: %s: duplicate key", path, k.Name)
+		}
+		seen[d] = true
+		out.keys = append(out.keys, apiKey{digest: d, p: p})
+	}
+	return out, nil
+}
+
+func (a *APIKeys) Authenticate(r *http.Request) (Principal, error) {
+	key := r.Header.Get("X-API-Key")
+	if scheme, cred, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "ApiKey") {
+		key = strings.TrimSpace(cred)
+	}
+	if key == "" {
+		return Principal{}, ErrNoCredentials
+	}
+	d := sha256.Sum256([]byte(key))
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare(d[:], k.digest[:]) == 1 {
+			return k.p, nil
+		}
+	}
+	return Principal{}, fmt.Errorf("%w: unknown api key", ErrUnauthenticated)
+}
+
+// JWTVerifier authenticates bearer tokens signed with HS256 (JWKS "oct" keys) or RS256
+// (JWKS "RSA" keys). Tokens must carry exp; iss and aud are checked when configured. The
+// role comes from the "role" claim, or the most privileged known entry of "roles", and
+// an operator's zones from "zones".
+type JWTVerifier struct {
+	keys     []jwk
+	issuer   string
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+
+	secret []byte
+	pub    *rsa.PublicKey
+}
+
+// LoadJWTVerifier reads the signing keys from a local JWKS file.
+func LoadJWTVerifier(path, issuer, audience string) (*JWTVerifier, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("jwks %s: %w", path, err)
+	}
+	v := &JWTVerifier{issuer: issuer, audience: audience, leeway: 30 * time.Second, now: time.Now}
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "oct":
+			if k.secret, err = base64.RawURLEncoding.DecodeString(k.K); err != nil || len(k.secret) == 0 {
+				return nil, fmt.Errorf("jwks %s: key %d: invalid k", path, i)
+			}
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+				return nil, fmt.Errorf("jwks %s: key %d: invalid n or e", path, i)
+			}
+			k.pub = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		default:
+			continue
+		}
+		v.keys = append(v.keys, k)
+	}
+	if len(v.keys) == 0 {
+		return nil, fmt.Errorf("jwks %s: no usable HS256 or RS256 signing key", path)
+	}
+	return v, nil
+}
+
+type jwtClaims struct {
+	Sub   string          `json:"sub"`
+	Iss   string          `json:"iss"`
+	Aud   json.RawMessage `json:"aud"`
+	Exp   *float64        `json:"exp"`
+	Nbf   *float64        `json:"nbf"`
+	Role  string          `json:"role"`
+	Roles []string        `json:"roles"`
+	Zones []string        `json:"zones"`
+}
+
+func (v *JWTVerifier) Authenticate(r *http.Request) (Principal, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return Principal{}, ErrNoCredentials
+	}
+	c, err := v.verify(strings.TrimSpace(token))
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	p := Principal{Subject: c.Sub, Role: c.Role, Zones: c.Zones, Method: "jwt"}
+	if p.Role == "" {
+		for _, role := range c.Roles {
+			if roleRank[role] > roleRank[p.Role] {
+				p.Role = role
+			}
+		}
+	}
+	if p.Subject == "" {
+		return Principal{}, fmt.Errorf("%w: token without sub", ErrUnauthenticated)
+	}
+	if err := p.validate(); err != nil {
+		return Principal{}, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	return p, nil
+}
+
+// verify checks the signature and time window of a compact JWS and returns its claims.
+func (v *JWTVerifier) verify(token string) (jwtClaims, error) {
+	var c jwtClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return c, errors.New("malformed token")
+	}
+	var hdr struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &hdr); err != nil {
+		return c, fmt.Errorf("header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return c, errors.New("malformed signature")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	if !v.checkSignature(hdr.Alg, hdr.Kid, signed, sig) {
+		return c, fmt.Errorf("invalid %s signature", hdr.Alg)
+	}
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return c, fmt.Errorf("claims: %w", err)
+	}
+	now := v.now()
+	if c.Exp == nil {
+		return c, errors.New("token without exp")
+	}
+	if now.After(time.UnixMilli(int64(*c.Exp * 1000)).Add(v.leeway)) {
+		return c, errors.New("token expired")
+	}
+	if c.Nbf != nil && now.Add(v.leeway).Before(time.UnixMilli(int64(*c.Nbf*1000))) {
+		return c, errors.New("token not yet valid")
+	}
+	if v.issuer != "" && c.Iss != v.issuer {
+		return c, fmt.Errorf("unexpected issuer %q", c.Iss)
+	}
+	if v.audience != "" && !audienceHas(c.Aud, v.audience) {
+		return c, errors.New("unexpected audience")
+	}
+	return c, nil
+}
+
+// checkSignature accepts HS256 only with "oct" keys and RS256 only with RSA keys, so a
+// public key can never be used as an HMAC secret. Without kid every key of the type is
+// tried.
+func (v *JWTVerifier) checkSignature(alg, kid string, signed, sig []byte) bool {
+	var kty string
+	switch alg {
+	case "HS256":
+		kty = "oct"
+	case "RS256":
+		kty = "RSA"
+	default:
+		return false
+	}
+	digest := sha256.Sum256(signed)
+	for _, k := range v.keys {
+		if k.Kty != kty || (kid != "" && k.Kid != kid) || (k.Alg != "" && k.Alg != alg) {
+			continue
+		}
+		if kty == "oct" {
+			mac := hmac.New(sha256.New, k.secret)
+			mac.Write(signed)
+			if hmac.Equal(mac.Sum(nil), sig) {
+				return true
+			}
+		} else if rsa.VerifyPKCS1v15(k.pub, crypto.SHA256, digest[:], sig) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, dst any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+// audienceHas accepts the aud claim as a string or an array of strings.
+func audienceHas(raw json.RawMessage, want string) bool {
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return one == want
+	}
+	var many []string
+	if json.Unmarshal(raw, &many) == nil {
+		return slices.Contains(many, want)
+	}
+	return false
+}
+
+// guard authenticates and authorises requests before h. Reads need the viewer role. A
+// change needs zone-operator rights on its zone when zoneOf finds one in the path, and
+// the admin role otherwise (reloads, DR events). The principal is put in the request
+// context, where actorOf finds it.
+func (s *HTTPServer) guard(h http.HandlerFunc, zoneOf func(*http.Request) string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.auth == nil {
+			h(w, r)
+			return
+		}
+		p, err := s.auth.Authenticate(r)
+		if err != nil {
+			if !errors.Is(err, ErrNoCredentials) {
+				s.lg.Warn("authentication failed", "path", r.URL.Path, "remote", r.RemoteAddr, "error", err)
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mape"`)
+			s.writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "authentication required"})
+			return
+		}
+		role, zone := RoleViewer, ""
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			role = RoleAdmin
+			if zoneOf != nil {
+				if zone = zoneOf(r); zone != "" {
+					role = RoleOperator
+				}
+			}
+		}
+		if !p.allows(role, zone) {
+			s.lg.Warn("access denied", "subject", p.Subject, "role", p.Role, "method", r.Method, "path", r.URL.Path)
+			s.writeJSON(w, http.StatusForbidden, map[string]string{"error": fmt.Sprintf("%s %s requires role %s", r.Method, r.URL.Path, role)})
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	}
+}
+
+// zoneAt returns the path segment following prefix, the zone of zone-scoped routes.
+func zoneAt(prefix string) func(*http.Request) string {
+	return func(r *http.Request) string {
+		zone, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		return zone
+	}
+}
@@ -0,0 +1,151 @@
+// v0
+// services/mape/internal/auth_test.go
+package internal
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// signToken builds a compact JWS; key is an HMAC secret or an *rsa.PrivateKey.
+func signToken(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	hdr, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+	signed := b64(hdr) + "." + b64(body)
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		d := sha256.Sum256([]byte(signed))
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, d[:]); err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+	}
+	return signed + "." + b64(sig)
+}
+
+func writeJSONFile(t *testing.T, path string, v any) {
+	t.Helper()
+	b, _ := json.Marshal(v)
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func TestHTTPAuthRoles(t *testing.T) {
+	dir := t.TempDir()
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa: %v", err)
+	}
+	cfg := &AppConfig{
+		Zones:           []string{"zone-A", "zone-B"},
+		ZoneTargets:     map[string]float64{"zone-A": 21, "zone-B": 21},
+		AuthAPIKeysPath: filepath.Join(dir, "keys.json"),
+		AuthJWKSPath:    filepath.Join(dir, "jwks.json"),
+		AuthIssuer:      "nrgchamp-auth",
+	}
+	writeJSONFile(t, cfg.AuthAPIKeysPath, map[string]any{"keys": []map[string]any{
+		{"name": "dashboard", "key": "view-key", "role": RoleViewer},
+		{"name": "floor-a", "key": "op-key", "role": RoleOperator, "zones": []string{"zone-A"}},
+	}})
+	writeJSONFile(t, cfg.AuthJWKSPath, map[string]any{"keys": []map[string]any{
+		{"kty": "oct", "kid": "hs", "alg": "HS256", "k": b64(secret)},
+		{"kty": "RSA", "kid": "rs", "alg": "RS256", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+	}})
+	auth, err := NewAuthFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("auth: %v", err)
+	}
+	store, err := NewZoneSetpoints(cfg.Zones, cfg.ZoneTargets, 10.0, 35.0)
+	if err != nil {
+		t.Fatalf("setpoints: %v", err)
+	}
+	var actors []string
+	store.Observe(func(c SetpointChange) { actors = append(actors, c.Actor) })
+	srv := NewHTTPServer(cfg, store, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	srv.UseAuth(auth)
+
+	exp := time.Now().Add(time.Hour).Unix()
+	adminJWT := signToken(t, "RS256", "rs", rsaKey, map[string]any{"sub": "carol", "iss": "nrgchamp-auth", "exp": exp, "roles": []string{"viewer", "admin"}})
+	opJWT := signToken(t, "HS256", "hs", secret, map[string]any{"sub": "bob", "iss": "nrgchamp-auth", "exp": exp, "role": RoleOperator, "zones": []string{"zone-B"}})
+	expired := signToken(t, "HS256", "hs", secret, map[string]any{"sub": "bob", "iss": "nrgchamp-auth", "exp": time.Now().Add(-time.Hour).Unix(), "role": RoleAdmin})
+	wrongIss := signToken(t, "HS256", "hs", secret, map[string]any{"sub": "bob", "iss": "elsewhere", "exp": exp, "role": RoleAdmin})
+	forged := signToken(t, "HS256", "hs", []byte("not-the-secret"), map[string]any{"sub": "eve", "iss": "nrgchamp-auth", "exp": exp, "role": RoleAdmin})
+	// A public key must never be accepted as an HMAC secret.
+	confused := signToken(t, "HS256", "rs", rsaKey.N.Bytes(), map[string]any{"sub": "eve", "iss": "nrgchamp-auth", "exp": exp, "role": RoleAdmin})
+	unsigned := strings.TrimSuffix(signToken(t, "none", "", nil, map[string]any{"sub": "eve", "exp": exp, "role": RoleAdmin}), ".")
+
+	do := func(method, path, cred string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"setpointC":22}`))
+		switch {
+		case strings.HasSuffix(cred, "-key"):
+			req.Header.Set("X-API-Key", cred)
+		case cred != "":
+			req.Header.Set("Authorization", "Bearer "+cred)
+		}
+		req.Header.Set("X-Actor", "spoofed")
+		rec := httptest.NewRecorder()
+		srv.http.Handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for _, tc := range []struct {
+		name, method, path, cred string
+		code                     int
+	}{
+		{"health is public", http.MethodGet, "/health", "", http.StatusOK},
+		{"no credentials", http.MethodGet, "/config/temperature", "", http.StatusUnauthorized},
+		{"unknown key", http.MethodGet, "/config/temperature", "bad-key", http.StatusUnauthorized},
+		{"viewer reads", http.MethodGet, "/config/temperature/zone-A", "view-key", http.StatusOK},
+		{"viewer cannot write", http.MethodPut, "/config/temperature/zone-A", "view-key", http.StatusForbidden},
+		{"operator in scope", http.MethodPut, "/config/temperature/zone-A", "op-key", http.StatusOK},
+		{"operator out of scope", http.MethodPut, "/config/temperature/zone-B", "op-key", http.StatusForbidden},
+		{"operator cannot reload", http.MethodPost, "/config/reload", "op-key", http.StatusForbidden},
+		{"jwt operator in scope", http.MethodPut, "/config/temperature/zone-B", opJWT, http.StatusOK},
+		{"jwt operator out of scope", http.MethodPut, "/zones/zone-A/policy", opJWT, http.StatusForbidden},
+		{"jwt admin", http.MethodPut, "/config/temperature/zone-A", adminJWT, http.StatusOK},
+		{"expired", http.MethodGet, "/status", expired, http.StatusUnauthorized},
+		{"wrong issuer", http.MethodGet, "/status", wrongIss, http.StatusUnauthorized},
+		{"forged", http.MethodGet, "/status", forged, http.StatusUnauthorized},
+		{"alg confusion", http.MethodGet, "/status", confused, http.StatusUnauthorized},
+		{"alg none", http.MethodGet, "/status", unsigned, http.StatusUnauthorized},
+	} {
+		if got := do(tc.method, tc.path, tc.cred); got != tc.code {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.code, got)
+		}
+	}
+	if strings.Join(actors, ",") != "floor-a,bob,carol" {
+		t.Fatalf("changes must be recorded with the principal, got %v", actors)
+	}
+}
+
+func TestLoadAPIKeysRejectsUnscopedOperator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeJSONFile(t, path, map[string]any{"keys": []map[string]any{{"name": "floor", "key": "k", "role": RoleOperator}}})
+	if _, err := LoadAPIKeys(path); err == nil {
+		t.Fatalf("an operator key without zones must be rejected")
+	}
+}
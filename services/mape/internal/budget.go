@@ -0,0 +1,304 @@
+// v3
+// services/mape/internal/budget.go
+package internal
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BudgetParams configures site-level coordination. Both limits are optional; with neither
+// set the coordinator grants every request.
+type BudgetParams struct {
+	CapKW       float64            // instantaneous heating+cooling power cap for the site
+	EnergyKWh   float64            // energy allowed per Period
+	Period      time.Duration      // energy budget period, aligned to the Unix epoch (e.g. 24h)
+	DefaultKW   float64            // demand assumed for a zone/mode before it has been metered
+	StaggerBias float64            // priority gained per consecutive throttled epoch
+	Weights     map[string]float64 // per-zone priority weight, default 1
+}
+
+func (b *BudgetParams) set(param string, v float64) error {
+	switch param {
+	case "cap_kw":
+		b.CapKW = v
+	case "energy_kwh":
+		b.EnergyKWh = v
+	case "period_h":
+		b.Period = time.Duration(v * float64(time.Hour))
+	case "default_kw":
+		b.DefaultKW = v
+	case "stagger_bias":
+		b.StaggerBias = v
+	default:
+		return fmt.Errorf("unknown budget parameter %q", param)
+	}
+	return nil
+}
+
+// Enabled reports whether any site limit is configured.
+func (b BudgetParams) Enabled() bool { return b.CapKW > 0 || (b.EnergyKWh > 0 && b.Period > 0) }
+
+// ZoneAllocation is the coordinator's decision for one zone in one round.
+type ZoneAllocation struct {
+	Requested string  `json:"requested"`
+	Granted   bool    `json:"granted"`
+	DemandKW  float64 `json:"demandKW"`
+	Priority  float64 `json:"priority"`
+	Reason    string  `json:"reason,omitempty"`
+}
+
+// BudgetStatus is the latest allocation of every zone, as shown on /status.
+// AllocatedKW is the demand of all zones currently holding a grant.
+type BudgetStatus struct {
+	CapKW           float64                   `json:"capKW,omitempty"`
+	EffectiveCapKW  float64                   `json:"effectiveCapKW"`
+	AllocatedKW     float64                   `json:"allocatedKW"`
+	PeriodStart     time.Time                 `json:"periodStart,omitempty"`
+	PeriodUsedKWh   float64                   `json:"periodUsedKWh,omitempty"`
+	PeriodBudgetKWh float64                   `json:"periodBudgetKWh,omitempty"`
+	Zones           map[string]ZoneAllocation `json:"zones"`
+}
+
+// zoneRequest is one zone's analysed decision submitted for coordination.
+type zoneRequest struct {
+	Zone string
+	Res  *AnalysisResult
+	Dt   time.Duration
+}
+
+// BudgetCoordinator grants HEAT/COOL requests across zones so that the estimated site
+// demand stays within the power cap and the remaining energy budget. Demand per zone and
+// mode is learned from the metered actuator energy of epochs in which the mode ran.
+//
+// Zones are submitted as their reports arrive, alone or in groups. The grants still held
+// by the other zones count against the cap, and their throttled requests keep competing
+// by priority, so a running zone yields once a waiting zone outranks it.
+type BudgetCoordinator struct {
+	cfg     *AppConfig
+	mu      sync.Mutex
+	demand  map[string]float64 // zone|mode -> kW
+	waited  map[string]int     // consecutive throttled epochs per zone
+	granted map[string]float64 // zone -> kW of the HEAT/COOL grant it currently holds
+	claims  map[string]claim   // zone -> its last throttled request
+	period  time.Time
+	used    float64
+	last    BudgetStatus
+	now     func() time.Time
+}
+
+// claim is a throttled request remembered until the zone submits again.
+type claim struct {
+	action string
+	delta  float64
+}
+
+func NewBudgetCoordinator(cfg *AppConfig) *BudgetCoordinator {
+	return &BudgetCoordinator{
+		cfg: cfg, demand: map[string]float64{}, waited: map[string]int{}, granted: map[string]float64{},
+		claims: map[string]claim{}, last: BudgetStatus{Zones: map[string]ZoneAllocation{}}, now: time.Now,
+	}
+}
+
+// Observe folds one zone's metered epoch into the demand estimates and the period total.
+// prevAction is the action that was applied during that epoch.
+func (b *BudgetCoordinator) Observe(zone, prevAction string, read Reading, dt time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollPeriod()
+	b.used += read.ZoneEnergyKWhEpoch
+	ids := modeActuators(b.cfg.Actuators[zone], prevAction)
+	if len(ids) == 0 || dt <= 0 {
+		return
+	}
+	var kwh float64
+	for _, id := range ids {
+		kwh += read.ActuatorEnergyKWh[id]
+	}
+	if kwh <= 0 {
+		return
+	}
+	key := zone + "|" + prevAction
+	kw := kwh / dt.Hours()
+	if old, ok := b.demand[key]; ok {
+		kw = 0.8*old + 0.2*kw
+	}
+	b.demand[key] = kw
+}
+
+func (b *BudgetCoordinator) rollPeriod() {
+	p := b.cfg.Budget.Period
+	if p <= 0 {
+		return
+	}
+	start := b.now().Truncate(p)
+	if !start.Equal(b.period) {
+		b.period, b.used = start, 0
+	}
+}
+
+func (b *BudgetCoordinator) demandKW(zone, action string) float64 {
+	if kw, ok := b.demand[zone+"|"+action]; ok {
+		return kw
+	}
+	if b.cfg.Budget.DefaultKW > 0 {
+		return b.cfg.Budget.DefaultKW
+	}
+	return 1
+}
+
+// effectiveCap combines the power cap with the power that would spend the rest of the
+// energy budget evenly over the rest of the period.
+func (b *BudgetCoordinator) effectiveCap() float64 {
+	p := b.cfg.Budget
+	limit := math.Inf(1)
+	if p.CapKW > 0 {
+		limit = p.CapKW
+	}
+	if p.EnergyKWh > 0 && p.Period > 0 {
+		left := b.period.Add(p.Period).Sub(b.now()).Hours()
+		remaining := math.Max(0, p.EnergyKWh-b.used)
+		if left > 0 {
+			limit = math.Min(limit, remaining/left)
+		}
+	}
+	return limit
+}
+
+// Allocate rewrites the requests in place: ungranted HEAT/COOL become OFF. Zones are
+// served by descending priority, weight × (|deviation| + StaggerBias × throttled epochs),
+// so a zone that keeps being throttled eventually gets its turn. Zones not submitted keep
+// their grants, and their pending claims reserve capacity ahead of lower priorities.
+func (b *BudgetCoordinator) Allocate(reqs []zoneRequest) map[string]ZoneAllocation {
+	p := b.cfg.Budget
+	if !p.Enabled() || len(reqs) == 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollPeriod()
+	limit := b.effectiveCap()
+	out := make(map[string]ZoneAllocation, len(reqs))
+	submitted := make(map[string]bool, len(reqs))
+	var active []zoneRequest
+	for _, r := range reqs {
+		submitted[r.Zone] = true
+		delete(b.granted, r.Zone)
+		delete(b.claims, r.Zone)
+		if r.Res.Action == "HEAT" || r.Res.Action == "COOL" {
+			active = append(active, r)
+			continue
+		}
+		b.waited[r.Zone] = 0
+		out[r.Zone] = ZoneAllocation{Requested: r.Res.Action, Granted: true}
+	}
+	var allocated float64
+	for zone, kw := range b.granted {
+		if !submitted[zone] {
+			allocated += kw
+		}
+	}
+	// Claims of waiting zones compete as reservations: they are never granted here.
+	reserved := map[string]bool{}
+	for zone, c := range b.claims {
+		if !submitted[zone] {
+			active = append(active, zoneRequest{Zone: zone, Res: &AnalysisResult{Action: c.action, Delta: c.delta}})
+			reserved[zone] = true
+		}
+	}
+	priority := func(r zoneRequest) float64 {
+		w, ok := p.Weights[r.Zone]
+		if !ok {
+			w = 1
+		}
+		return w * (math.Abs(r.Res.Delta) + p.StaggerBias*float64(b.waited[r.Zone]))
+	}
+	sort.SliceStable(active, func(i, j int) bool {
+		pi, pj := priority(active[i]), priority(active[j])
+		if pi != pj {
+			return pi > pj
+		}
+		return active[i].Zone < active[j].Zone
+	})
+	for _, r := range active {
+		a := ZoneAllocation{Requested: r.Res.Action, DemandKW: b.demandKW(r.Zone, r.Res.Action), Priority: priority(r)}
+		if reserved[r.Zone] {
+			if allocated+a.DemandKW <= limit+1e-9 {
+				allocated += a.DemandKW
+			}
+			continue
+		}
+		if r.Res.Strategy == StrategySafety {
+			// A safe-state decision is never throttled, but its demand still counts.
+			a.Granted, a.Reason = true, "safe state"
+			allocated += a.DemandKW
+			b.waited[r.Zone] = 0
+		} else if allocated+a.DemandKW <= limit+1e-9 {
+			a.Granted = true
+			allocated += a.DemandKW
+			b.waited[r.Zone] = 0
+		} else {
+			a.Reason = fmt.Sprintf("site budget: %.2f kW allocated of %.2f kW, zone needs %.2f kW", allocated, limit, a.DemandKW)
+			b.waited[r.Zone]++
+			r.Res.Action = "OFF"
+			r.Res.Fan = 0
+			r.Res.Duty = 0
+			r.Res.Reason = a.Reason
+			b.claims[r.Zone] = claim{action: a.Requested, delta: r.Res.Delta}
+		}
+		if a.Granted {
+			b.granted[r.Zone] = a.DemandKW
+		}
+		out[r.Zone] = a
+	}
+	var held float64
+	for _, kw := range b.granted {
+		held += kw
+	}
+	for zone, a := range out {
+		b.last.Zones[zone] = a
+	}
+	b.last.CapKW, b.last.EffectiveCapKW, b.last.AllocatedKW = p.CapKW, limit, held
+	if math.IsInf(limit, 1) {
+		b.last.EffectiveCapKW = 0
+	}
+	if p.EnergyKWh > 0 && p.Period > 0 {
+		b.last.PeriodStart, b.last.PeriodUsedKWh, b.last.PeriodBudgetKWh = b.period, b.used, p.EnergyKWh
+	}
+	return out
+}
+
+// Forget releases the grant and claim of a removed zone and drops its demand estimates.
+func (b *BudgetCoordinator) Forget(zone string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.last.AllocatedKW -= b.granted[zone]
+	delete(b.granted, zone)
+	delete(b.claims, zone)
+	delete(b.waited, zone)
+	delete(b.last.Zones, zone)
+	for key := range b.demand {
+		if strings.HasPrefix(key, zone+"|") {
+			delete(b.demand, key)
+		}
+	}
+}
+
+// Status returns the latest allocation round, or nil when coordination is disabled.
+func (b *BudgetCoordinator) Status() *BudgetStatus {
+	if !b.cfg.Budget.Enabled() {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := b.last
+	st.Zones = make(map[string]ZoneAllocation, len(b.last.Zones))
+	for z, a := range b.last.Zones {
+		st.Zones[z] = a
+	}
+	return &st
+}
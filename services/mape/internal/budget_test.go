@@ -0,0 +1,87 @@
+// v1
+// services/mape/internal/budget_test.go
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBudgetPrioritisesAndStaggers(t *testing.T) {
+	cfg := &AppConfig{Budget: BudgetParams{CapKW: 2, DefaultKW: 1.5, StaggerBias: 1, Weights: map[string]float64{"zone-B": 2}}}
+	b := NewBudgetCoordinator(cfg)
+	round := func(deltaA, deltaB float64) (AnalysisResult, AnalysisResult, map[string]ZoneAllocation) {
+		a := AnalysisResult{Action: "HEAT", Delta: deltaA, Fan: 50}
+		c := AnalysisResult{Action: "HEAT", Delta: deltaB, Fan: 50}
+		alloc := b.Allocate([]zoneRequest{{Zone: "zone-A", Res: &a}, {Zone: "zone-B", Res: &c}})
+		return a, c, alloc
+	}
+	// zone-B wins on weight (2×1.0 > 1×1.5); the cap fits only one 1.5 kW zone.
+	a, c, alloc := round(-1.5, -1.0)
+	if c.Action != "HEAT" || a.Action != "OFF" || alloc["zone-A"].Granted || alloc["zone-A"].Reason == "" {
+		t.Fatalf("expected zone-B granted and zone-A throttled, got A=%s B=%s %+v", a.Action, c.Action, alloc)
+	}
+	// zone-A gains StaggerBias per throttled epoch and eventually takes its turn.
+	a, c, _ = round(-1.5, -1.0)
+	if a.Action != "HEAT" || c.Action != "OFF" {
+		t.Fatalf("expected staggering to serve zone-A, got A=%s B=%s", a.Action, c.Action)
+	}
+	if st := b.Status(); st == nil || st.AllocatedKW != 1.5 || st.EffectiveCapKW != 2 {
+		t.Fatalf("unexpected status %+v", st)
+	}
+}
+
+func TestBudgetLearnsDemandAndEnergyBudget(t *testing.T) {
+	cfg := &AppConfig{
+		Actuators: map[string]ZoneActuators{"zone-A": {Heating: []string{"h1"}}},
+		Budget:    BudgetParams{EnergyKWh: 30, Period: 24 * time.Hour, DefaultKW: 5},
+	}
+	b := NewBudgetCoordinator(cfg)
+	now := time.Date(2025, 1, 6, 12, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+	// 1 kWh in a 1 h epoch: the heater draws 1 kW, and 1 kWh of the 30 kWh budget is spent.
+	b.Observe("zone-A", "HEAT", Reading{ZoneEnergyKWhEpoch: 1, ActuatorEnergyKWh: map[string]float64{"h1": 1}}, time.Hour)
+	res := AnalysisResult{Action: "HEAT", Delta: -1}
+	alloc := b.Allocate([]zoneRequest{{Zone: "zone-A", Res: &res}})
+	if got := alloc["zone-A"]; !got.Granted || got.DemandKW != 1 {
+		t.Fatalf("expected learned 1 kW demand to fit 29 kWh over 12 h, got %+v", got)
+	}
+	b.Observe("zone-A", "HEAT", Reading{ZoneEnergyKWhEpoch: 29}, time.Hour)
+	res = AnalysisResult{Action: "HEAT", Delta: -1}
+	if alloc := b.Allocate([]zoneRequest{{Zone: "zone-A", Res: &res}}); alloc["zone-A"].Granted || res.Action != "OFF" {
+		t.Fatalf("exhausted energy budget must throttle heating, got %+v", alloc)
+	}
+	now = now.Add(12 * time.Hour) // next period
+	res = AnalysisResult{Action: "HEAT", Delta: -1}
+	if alloc := b.Allocate([]zoneRequest{{Zone: "zone-A", Res: &res}}); !alloc["zone-A"].Granted {
+		t.Fatalf("budget must reset with the period, got %+v", alloc)
+	}
+}
+
+func TestBudgetCoordinatesZonesSubmittedAlone(t *testing.T) {
+	cfg := &AppConfig{Budget: BudgetParams{CapKW: 2, DefaultKW: 1.5, StaggerBias: 0.1}}
+	b := NewBudgetCoordinator(cfg)
+	submit := func(zone string, delta float64) (AnalysisResult, ZoneAllocation) {
+		res := AnalysisResult{Action: "HEAT", Delta: delta, Fan: 50}
+		alloc := b.Allocate([]zoneRequest{{Zone: zone, Res: &res}})
+		return res, alloc[zone]
+	}
+	if res, _ := submit("zone-A", -1.0); res.Action != "HEAT" {
+		t.Fatalf("zone-A fits the cap alone, got %s", res.Action)
+	}
+	// zone-A's running grant leaves no room for zone-B.
+	if res, a := submit("zone-B", -1.5); res.Action != "OFF" || a.Granted {
+		t.Fatalf("expected zone-B throttled by zone-A's grant, got %s %+v", res.Action, a)
+	}
+	// zone-B's waiting claim outranks zone-A, which yields on its next report...
+	if res, _ := submit("zone-A", -1.0); res.Action != "OFF" {
+		t.Fatalf("expected zone-A to yield to zone-B's claim, got %s", res.Action)
+	}
+	// ...and zone-B takes the freed capacity.
+	if res, _ := submit("zone-B", -1.5); res.Action != "HEAT" {
+		t.Fatalf("expected zone-B granted, got %s", res.Action)
+	}
+	if st := b.Status(); st.AllocatedKW != 1.5 || len(st.Zones) != 2 || st.Zones["zone-A"].Granted {
+		t.Fatalf("unexpected status %+v", st)
+	}
+}
@@ -1,4 +1,4 @@
-// v8
+// v30
 // services/mape/internal/config.go
 package internal
 
@@ -9,16 +9,50 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// cfgMu is held for reading by every engine round and by HTTP handlers that consult the
+// live configuration, and exclusively while a reload swaps it, so none sees half of a
+// reload. A holder must not take it again.
+var cfgMu sync.RWMutex
+
+// swapConfig replaces the live configuration with next.
+func swapConfig(cfg *AppConfig, next AppConfig) {
+	cfgMu.Lock()
+	*cfg = next
+	cfgMu.Unlock()
+}
+
+// hasZone reports whether the zone is configured.
+func (c *AppConfig) hasZone(zone string) bool {
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	_, ok := c.ZoneTargets[zone]
+	return ok
+}
+
 type AppConfig struct {
-	HTTPBind           string
-	KafkaBrokers       []string
-	AggregatorTopic    string
-	ActuatorTopicPref  string
-	LedgerTopicPref    string
-	MAPEPartitionID    int
-	PropertiesPath     string
+	HTTPBind          string
+	KafkaBrokers      []string
+	AggregatorTopic   string
+	ActuatorTopicPref string
+	LedgerTopicPref   string
+	MAPEPartitionID   int
+	AckTopicPref      string
+	AnomalyTopic      string
+	PropertiesPath    string
+	SetpointStorePath string
+	PolicyStorePath   string
+	OffsetStorePath   string
+	// AuthAPIKeysPath and AuthJWKSPath enable authentication of the HTTP API; with
+	// neither set the API is open. AuthIssuer and AuthAudience, when set, must match the
+	// iss and aud claims of tokens.
+	AuthAPIKeysPath    string
+	AuthJWKSPath       string
+	AuthIssuer         string
+	AuthAudience       string
 	PollIntervalMs     int
 	ActuatorPartitions int
 	TopicReplication   int
@@ -30,8 +64,41 @@ type AppConfig struct {
 	FanSpeeds      []int
 	Actuators      map[string]ZoneActuators
 
+	// Strategy is the default controller; ZoneStrategy overrides it per zone.
+	Strategy     string
+	ZoneStrategy map[string]string
+	PID          PIDParams
+	ZonePID      map[string]PIDParams
+	MPC          MPCParams
+	// Shadow lists candidate strategies evaluated alongside the live one without being
+	// executed; ZoneShadow overrides it per zone. ShadowHistory bounds the recorded plans.
+	Shadow        []string
+	ZoneShadow    map[string][]string
+	ShadowHistory int
+	// DecisionHistory bounds the decisions kept per zone for GET /zones/{zone}/decisions.
+	DecisionHistory int
+	// Guard limits actuator switching; ZoneGuard overrides it per zone.
+	Guard       GuardParams
+	ZoneGuard   map[string]GuardParams
+	Ack         AckParams
+	Budget      BudgetParams
+	Safety      SafetyParams
+	Diagnostics DiagnosticsParams
+	Occupancy   OccupancyParams
+	Staging     StagingParams
+	Simulate    SimulateParams
+	Engine      EngineParams
+	Tariff      TariffParams
+	// TariffCalendar is loaded from Tariff.File on every (re)load; nil without a file.
+	TariffCalendar *TariffCalendar
+
 	SetpointMinC float64
 	SetpointMaxC float64
+	// ScheduleLocation is the time zone in which schedule times of day are interpreted.
+	ScheduleLocation *time.Location
+	// Policies holds the zone policies set over the HTTP API, which take precedence over
+	// the hysteresis, fan curve and strategy properties; nil when there are none.
+	Policies *Policies
 }
 
 func LoadEnvAndFiles() (*AppConfig, error) {
@@ -41,8 +108,17 @@ func LoadEnvAndFiles() (*AppConfig, error) {
 		AggregatorTopic:    getenv("AGGREGATOR_TOPIC", "agg-to-mape"),
 		ActuatorTopicPref:  getenv("ACTUATOR_TOPIC_PREFIX", "zone.commands."),
 		LedgerTopicPref:    getenv("LEDGER_TOPIC_PREFIX", "zone.ledger."),
+		AckTopicPref:       getenv("ACK_TOPIC_PREFIX", "zone.acks."),
+		AnomalyTopic:       getenv("ANOMALY_TOPIC", "mape.anomalies"),
 		MAPEPartitionID:    geti("LEDGER_MAPE_PARTITION", 1),
 		PropertiesPath:     getenv("PROPERTIES_PATH", "./configs/mape.properties"),
+		SetpointStorePath:  getenv("MAPE_SETPOINT_STORE", "./data/setpoints.json"),
+		PolicyStorePath:    getenv("MAPE_POLICY_STORE", "./data/policies.json"),
+		OffsetStorePath:    getenv("MAPE_OFFSET_STORE", "./data/offsets.json"),
+		AuthAPIKeysPath:    getenv("MAPE_AUTH_API_KEYS", ""),
+		AuthJWKSPath:       getenv("MAPE_AUTH_JWKS", ""),
+		AuthIssuer:         getenv("MAPE_AUTH_JWT_ISSUER", ""),
+		AuthAudience:       getenv("MAPE_AUTH_JWT_AUDIENCE", ""),
 		PollIntervalMs:     geti("POLL_INTERVAL_MS", 250),
 		ActuatorPartitions: geti("ACTUATOR_PARTITIONS", 3), // heat/cool/vent
 		TopicReplication:   geti("TOPIC_REPLICATION", 1),
@@ -55,6 +131,11 @@ func LoadEnvAndFiles() (*AppConfig, error) {
 	if c.SetpointMinC > c.SetpointMaxC {
 		return nil, fmt.Errorf("setpoint min %.2f greater than max %.2f", c.SetpointMinC, c.SetpointMaxC)
 	}
+	loc, err := time.LoadLocation(getenv("MAPE_SCHEDULE_TZ", "UTC"))
+	if err != nil {
+		return nil, fmt.Errorf("MAPE_SCHEDULE_TZ: %w", err)
+	}
+	c.ScheduleLocation = loc
 	if err := c.loadProperties(c.PropertiesPath); err != nil {
 		return nil, err
 	}
@@ -88,6 +169,26 @@ func (c *AppConfig) loadProperties(path string) error {
 	var hystDefault float64
 	var hasHystDefault bool
 	hystOverrides := map[string]float64{}
+	strategy := StrategyHysteresis
+	zoneStrategy := map[string]string{}
+	pid := DefaultPIDParams()
+	pidOverrides := map[string]map[string]float64{}
+	mpc := DefaultMPCParams()
+	var shadow []string
+	zoneShadow := map[string][]string{}
+	shadowHistory := 100
+	decisionHistory := 200
+	var guard GuardParams
+	ack := AckParams{MaxResends: 3, FaultAfter: 5}
+	tariff := TariffParams{PreconditionMinutes: 60, PreconditionRatio: 1.5, PreconditionOffsetC: 1, DRDefaultWidenC: 1, DRMaxWidenC: 2}
+	safety := DefaultSafetyParams()
+	engine := DefaultEngineParams()
+	diag := DefaultDiagnosticsParams()
+	occupancy := DefaultOccupancyParams()
+	var staging StagingParams
+	simulate := DefaultSimulateParams()
+	budget := BudgetParams{DefaultKW: 1.5, StaggerBias: 0.1, Weights: map[string]float64{}}
+	guardOverrides := map[string]map[string]float64{}
 
 	for s.Scan() {
 		line := strings.TrimSpace(s.Text())
@@ -126,6 +227,128 @@ func (c *AppConfig) loadProperties(path string) error {
 					speeds = append(speeds, i)
 				}
 			}
+		case k == "strategy":
+			strategy = strings.ToLower(v)
+		case strings.HasPrefix(k, "strategy."):
+			zoneStrategy[strings.TrimPrefix(k, "strategy.")] = strings.ToLower(v)
+		case k == "shadow.strategies":
+			shadow = split(strings.ToLower(v))
+		case strings.HasPrefix(k, "shadow.strategies."):
+			zoneShadow[strings.TrimPrefix(k, "shadow.strategies.")] = split(strings.ToLower(v))
+		case k == "shadow.history":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return fmt.Errorf("shadow.history must be a positive integer, got %q", v)
+			}
+			shadowHistory = n
+		case k == "decisions.history":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return fmt.Errorf("decisions.history must be a positive integer, got %q", v)
+			}
+			decisionHistory = n
+		case strings.HasPrefix(k, "pid."):
+			param, zone, _ := strings.Cut(strings.TrimPrefix(k, "pid."), ".")
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+			if zone == "" {
+				if err := pid.set(param, f); err != nil {
+					return err
+				}
+				continue
+			}
+			if pidOverrides[zone] == nil {
+				pidOverrides[zone] = map[string]float64{}
+			}
+			pidOverrides[zone][param] = f
+		case strings.HasPrefix(k, "guard."):
+			param, zone, _ := strings.Cut(strings.TrimPrefix(k, "guard."), ".")
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+			if zone == "" {
+				if err := guard.set(param, f); err != nil {
+					return err
+				}
+				continue
+			}
+			if guardOverrides[zone] == nil {
+				guardOverrides[zone] = map[string]float64{}
+			}
+			guardOverrides[zone][param] = f
+		case strings.HasPrefix(k, "engine."):
+			if err := engine.set(strings.TrimPrefix(k, "engine."), v); err != nil {
+				return err
+			}
+		case strings.HasPrefix(k, "diagnostics."):
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+			if err := diag.set(strings.TrimPrefix(k, "diagnostics."), f); err != nil {
+				return err
+			}
+		case strings.HasPrefix(k, "staging."):
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+			if err := staging.set(strings.TrimPrefix(k, "staging."), f); err != nil {
+				return err
+			}
+		case strings.HasPrefix(k, "simulate."):
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+			if err := simulate.set(strings.TrimPrefix(k, "simulate."), f); err != nil {
+				return err
+			}
+		case strings.HasPrefix(k, "occupancy."):
+			if err := occupancy.set(strings.TrimPrefix(k, "occupancy."), v); err != nil {
+				return err
+			}
+		case strings.HasPrefix(k, "safety."):
+			if err := safety.set(strings.TrimPrefix(k, "safety."), v); err != nil {
+				return err
+			}
+		case strings.HasPrefix(k, "tariff."):
+			if err := tariff.set(strings.TrimPrefix(k, "tariff."), v); err != nil {
+				return err
+			}
+		case strings.HasPrefix(k, "budget.weight."):
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f < 0 {
+				return fmt.Errorf("%s: invalid weight %q", k, v)
+			}
+			budget.Weights[strings.TrimPrefix(k, "budget.weight.")] = f
+		case strings.HasPrefix(k, "budget."):
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+			if err := budget.set(strings.TrimPrefix(k, "budget."), f); err != nil {
+				return err
+			}
+		case strings.HasPrefix(k, "ack."):
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+			if err := ack.set(strings.TrimPrefix(k, "ack."), f); err != nil {
+				return err
+			}
+		case strings.HasPrefix(k, "mpc."):
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+			if err := mpc.set(strings.TrimPrefix(k, "mpc."), f); err != nil {
+				return err
+			}
 		case strings.HasPrefix(k, "target."):
 			z := strings.TrimPrefix(k, "target.")
 			if f, err := strconv.ParseFloat(v, 64); err == nil {
@@ -162,6 +385,14 @@ func (c *AppConfig) loadProperties(path string) error {
 	if len(steps) != len(speeds) {
 		return fmt.Errorf("fan.steps and fan.speeds length mismatch: %d vs %d", len(steps), len(speeds))
 	}
+	// A zone's aggregator partition is its position in the list, so names must be unique.
+	seen := make(map[string]bool, len(zones))
+	for _, z := range zones {
+		if seen[z] {
+			return fmt.Errorf("zone %s listed twice in zones", z)
+		}
+		seen[z] = true
+	}
 	c.Zones = zones
 	for _, z := range zones {
 		if v, ok := targetOverrides[z]; ok {
@@ -184,6 +415,245 @@ func (c *AppConfig) loadProperties(path string) error {
 	}
 	c.FanSteps = steps
 	c.FanSpeeds = speeds
+	if _, ok := knownStrategies[strategy]; !ok {
+		return fmt.Errorf("unknown strategy %q", strategy)
+	}
+	for z, name := range zoneStrategy {
+		if _, ok := knownStrategies[name]; !ok {
+			return fmt.Errorf("zone %s: unknown strategy %q", z, name)
+		}
+	}
+	for _, name := range shadow {
+		if _, ok := knownStrategies[name]; !ok {
+			return fmt.Errorf("unknown shadow strategy %q", name)
+		}
+	}
+	for z, names := range zoneShadow {
+		for _, name := range names {
+			if _, ok := knownStrategies[name]; !ok {
+				return fmt.Errorf("zone %s: unknown shadow strategy %q", z, name)
+			}
+		}
+	}
+	c.Strategy = strategy
+	c.ZoneStrategy = zoneStrategy
+	c.Shadow = shadow
+	c.ZoneShadow = zoneShadow
+	c.ShadowHistory = shadowHistory
+	c.DecisionHistory = decisionHistory
+	c.PID = pid
+	c.ZonePID = map[string]PIDParams{}
+	for z, overrides := range pidOverrides {
+		p := pid
+		for param, f := range overrides {
+			if err := p.set(param, f); err != nil {
+				return fmt.Errorf("zone %s: %w", z, err)
+			}
+		}
+		c.ZonePID[z] = p
+	}
+	if mpc.Horizon < 1 || mpc.Horizon > 8 {
+		return fmt.Errorf("mpc.horizon must be between 1 and 8, got %d", mpc.Horizon)
+	}
+	if mpc.Forgetting <= 0 || mpc.Forgetting > 1 {
+		return fmt.Errorf("mpc.forgetting must be in (0,1], got %g", mpc.Forgetting)
+	}
+	c.MPC = mpc
+	c.Guard = guard
+	c.Ack = ack
+	if budget.EnergyKWh > 0 && budget.Period <= 0 {
+		return errors.New("budget.energy_kwh requires budget.period_h")
+	}
+	c.Budget = budget
+	if safety.MinTempC >= safety.MaxTempC {
+		return fmt.Errorf("safety.min_temp_c %.2f must be below safety.max_temp_c %.2f", safety.MinTempC, safety.MaxTempC)
+	}
+	c.Safety = safety
+	c.Diagnostics = diag
+	c.Occupancy = occupancy
+	c.Staging = staging
+	c.Simulate = simulate
+	c.Engine = engine
+	var cal *TariffCalendar
+	if tariff.File != "" {
+		loaded, err := LoadTariffCalendar(tariff.File)
+		if err != nil {
+			return err
+		}
+		cal = loaded
+	}
+	if tariff.DRDefaultWidenC > tariff.DRMaxWidenC {
+		return fmt.Errorf("tariff.dr_default_widen_c %.2f exceeds tariff.dr_max_widen_c %.2f", tariff.DRDefaultWidenC, tariff.DRMaxWidenC)
+	}
+	c.Tariff = tariff
+	c.TariffCalendar = cal
+	c.ZoneGuard = map[string]GuardParams{}
+	for z, overrides := range guardOverrides {
+		g := guard
+		for param, f := range overrides {
+			if err := g.set(param, f); err != nil {
+				return fmt.Errorf("zone %s: %w", z, err)
+			}
+		}
+		c.ZoneGuard[z] = g
+	}
+	return nil
+}
+
+// StrategyFor returns the controller name in force for the zone.
+func (c *AppConfig) StrategyFor(zone string) string {
+	if p, ok := c.Policies.override(zone); ok {
+		return p.Strategy
+	}
+	return c.propertyStrategy(zone)
+}
+
+// propertyStrategy returns the controller name the properties configure for the zone.
+func (c *AppConfig) propertyStrategy(zone string) string {
+	if s, ok := c.ZoneStrategy[zone]; ok {
+		return s
+	}
+	if c.Strategy == "" {
+		return StrategyHysteresis
+	}
+	return c.Strategy
+}
+
+// HysteresisFor returns the zone's hysteresis band, from its policy if one was set.
+func (c *AppConfig) HysteresisFor(zone string) float64 {
+	if p, ok := c.Policies.override(zone); ok {
+		return p.HysteresisC
+	}
+	return c.ZoneHysteresis[zone]
+}
+
+// FanFor returns the zone's fan step curve, from its policy if one was set.
+func (c *AppConfig) FanFor(zone string) ([]float64, []int) {
+	if p, ok := c.Policies.override(zone); ok {
+		return p.FanSteps, p.FanSpeeds
+	}
+	return c.FanSteps, c.FanSpeeds
+}
+
+// fanFor picks the zone's fan speed for a temperature error of absDelta.
+func (c *AppConfig) fanFor(zone string, absDelta float64) int {
+	steps, speeds := c.FanFor(zone)
+	return pickFan(absDelta, steps, speeds)
+}
+
+// ShadowFor returns the candidate strategies evaluated in shadow for the zone, leaving out
+// the one that is live.
+func (c *AppConfig) ShadowFor(zone string) []string {
+	names, ok := c.ZoneShadow[zone]
+	if !ok {
+		names = c.Shadow
+	}
+	live := c.StrategyFor(zone)
+	out := make([]string, 0, len(names))
+	for _, n := range names {
+		if n != live {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// PIDFor returns the PID parameters for the zone, including per-zone overrides.
+func (c *AppConfig) PIDFor(zone string) PIDParams {
+	if p, ok := c.ZonePID[zone]; ok {
+		return p
+	}
+	if c.PID == (PIDParams{}) {
+		return DefaultPIDParams()
+	}
+	return c.PID
+}
+
+// location is the time zone for schedules and tariffs.
+func (c *AppConfig) location() *time.Location {
+	if c.ScheduleLocation == nil {
+		return time.UTC
+	}
+	return c.ScheduleLocation
+}
+
+// GuardFor returns the anti-short-cycling limits for the zone.
+func (c *AppConfig) GuardFor(zone string) GuardParams {
+	if g, ok := c.ZoneGuard[zone]; ok {
+		return g
+	}
+	return c.Guard
+}
+
+// MPCConfig returns the model-predictive parameters, falling back to the defaults.
+func (c *AppConfig) MPCConfig() MPCParams {
+	if c.MPC == (MPCParams{}) {
+		return DefaultMPCParams()
+	}
+	return c.MPC
+}
+
+// SimulateConfig returns the what-if simulation parameters, falling back to the defaults.
+func (c *AppConfig) SimulateConfig() SimulateParams {
+	if c.Simulate == (SimulateParams{}) {
+		return DefaultSimulateParams()
+	}
+	return c.Simulate
+}
+
+func (a *AckParams) set(param string, v float64) error {
+	switch param {
+	case "timeout_ms":
+		a.Timeout = time.Duration(v) * time.Millisecond
+	case "max_resends":
+		a.MaxResends = int(v)
+	case "fault_after":
+		a.FaultAfter = int(v)
+	default:
+		return fmt.Errorf("unknown ack parameter %q", param)
+	}
+	return nil
+}
+
+func (p *MPCParams) set(param string, v float64) error {
+	switch param {
+	case "horizon":
+		p.Horizon = int(v)
+	case "step_epochs":
+		p.StepEpochs = int(v)
+	case "heat_kw":
+		p.HeatKW = v
+	case "cool_kw":
+		p.CoolKW = v
+	case "comfort_weight":
+		p.ComfortWeight = v
+	case "forgetting":
+		p.Forgetting = v
+	case "min_samples":
+		p.MinSamples = int(v)
+	default:
+		return fmt.Errorf("unknown mpc parameter %q", param)
+	}
+	return nil
+}
+
+func (p *PIDParams) set(param string, v float64) error {
+	switch param {
+	case "kp":
+		p.Kp = v
+	case "ki":
+		p.Ki = v
+	case "kd":
+		p.Kd = v
+	case "integral_limit":
+		p.IntegralLimit = v
+	case "min_duty":
+		p.MinDuty = v
+	case "cycle_epochs":
+		p.CycleEpochs = int(v)
+	default:
+		return fmt.Errorf("unknown pid parameter %q", param)
+	}
 	return nil
 }
 
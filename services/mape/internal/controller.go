@@ -0,0 +1,222 @@
+// v3
+// services/mape/internal/controller.go
+package internal
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Strategy names accepted by `strategy` and `strategy.<zone>` in mape.properties.
+const (
+	StrategyHysteresis = "hysteresis"
+	StrategyPID        = "pid"
+)
+
+// knownStrategies is consulted when properties are loaded so that typos fail fast.
+var knownStrategies = map[string]struct{}{
+	StrategyHysteresis: {},
+	StrategyPID:        {},
+}
+
+// ControlInput is the per-epoch information a Controller needs to decide an action.
+type ControlInput struct {
+	TempC  float64
+	Target float64
+	Hyst   float64
+	Dt     time.Duration
+	// ActuatorKWh is the per-actuator energy of the epoch, used by learning strategies.
+	ActuatorKWh map[string]float64
+}
+
+// IssueObserver is implemented by controllers that learn from the action Plan actually
+// issued for the zone, which may differ from the one they proposed.
+type IssueObserver interface {
+	ObserveIssued(zone, action string)
+}
+
+// zoneForgetter is implemented by controllers that keep per-zone state, which a reload
+// drops for removed zones.
+type zoneForgetter interface {
+	Forget(zone string)
+}
+
+// Controller decides the zone action for one epoch. Implementations may keep per-zone
+// state between calls and must be safe for concurrent use across zones.
+type Controller interface {
+	Name() string
+	Decide(zone string, in ControlInput) AnalysisResult
+}
+
+// hysteresisController is the original bang-bang policy: HEAT/COOL outside the band and
+// a fan speed picked from the configured |delta| steps.
+type hysteresisController struct {
+	cfg *AppConfig
+}
+
+func (h *hysteresisController) Name() string { return StrategyHysteresis }
+
+func (h *hysteresisController) Decide(zone string, in ControlInput) AnalysisResult {
+	res := AnalysisResult{Target: in.Target, Hyst: in.Hyst, HasTemp: true, TempC: in.TempC, Strategy: StrategyHysteresis}
+	res.Delta = res.TempC - in.Target
+	if res.Delta > in.Hyst {
+		res.Action = "COOL"
+		res.Fan = h.cfg.fanFor(zone, math.Abs(res.Delta))
+		res.Duty = 100
+		res.Reason = fmt.Sprintf("too hot by %.2fC", res.Delta)
+		return res
+	}
+	if res.Delta < -in.Hyst {
+		res.Action = "HEAT"
+		res.Fan = h.cfg.fanFor(zone, math.Abs(res.Delta))
+		res.Duty = 100
+		res.Reason = fmt.Sprintf("too cold by %.2fC", -res.Delta)
+		return res
+	}
+	res.Action = "OFF"
+	res.Fan = 0
+	res.Reason = "within hysteresis"
+	return res
+}
+
+// PIDParams holds the gains and output shaping for the PID strategy. The controller
+// output is a signed duty in percent: positive heats, negative cools.
+type PIDParams struct {
+	Kp            float64
+	Ki            float64
+	Kd            float64
+	IntegralLimit float64 // absolute clamp on the integral term contribution, in percent
+	MinDuty       float64 // duties below this are treated as OFF
+	CycleEpochs   int     // time-proportioning window for on/off actuators
+}
+
+// DefaultPIDParams are conservative gains for a zone measured in °C with 1 s epochs.
+func DefaultPIDParams() PIDParams {
+	return PIDParams{Kp: 40, Ki: 0.5, Kd: 0, IntegralLimit: 60, MinDuty: 10, CycleEpochs: 10}
+}
+
+type pidState struct {
+	integral  float64
+	prevErr   float64
+	hasPrev   bool
+	cyclePos  int
+	cycleSign int
+}
+
+// pidController keeps per-zone integral state with anti-windup and maps the signed duty
+// onto heater/cooler on-time (time-proportioning over CycleEpochs) and fan percent.
+type pidController struct {
+	cfg   *AppConfig
+	mu    sync.Mutex
+	state map[string]*pidState
+}
+
+func newPIDController(cfg *AppConfig) *pidController {
+	return &pidController{cfg: cfg, state: map[string]*pidState{}}
+}
+
+func (p *pidController) Name() string { return StrategyPID }
+
+// Forget drops the zone's integral and duty-cycle state.
+func (p *pidController) Forget(zone string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.state, zone)
+}
+
+func (p *pidController) Decide(zone string, in ControlInput) AnalysisResult {
+	params := p.cfg.PIDFor(zone)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st, ok := p.state[zone]
+	if !ok {
+		st = &pidState{}
+		p.state[zone] = st
+	}
+	dt := in.Dt.Seconds()
+	if dt <= 0 {
+		dt = 1
+	}
+	e := in.Target - in.TempC
+	var deriv float64
+	if st.hasPrev {
+		deriv = (e - st.prevErr) / dt
+	}
+	st.prevErr, st.hasPrev = e, true
+
+	candidate := st.integral + params.Ki*e*dt
+	if params.IntegralLimit > 0 {
+		candidate = clamp(candidate, -params.IntegralLimit, params.IntegralLimit)
+	}
+	u := params.Kp*e + candidate + params.Kd*deriv
+	// Conditional integration: only keep the new integral when the output is not
+	// saturated, or when the error is already pulling it back out of saturation.
+	if math.Abs(u) <= 100 || math.Signbit(e) != math.Signbit(u) {
+		st.integral = candidate
+	}
+	u = clamp(params.Kp*e+st.integral+params.Kd*deriv, -100, 100)
+
+	res := AnalysisResult{Target: in.Target, Hyst: in.Hyst, HasTemp: true, TempC: in.TempC, Delta: in.TempC - in.Target, Strategy: StrategyPID}
+	duty := math.Abs(u)
+	if duty < params.MinDuty {
+		st.cyclePos, st.cycleSign = 0, 0
+		res.Action = "OFF"
+		res.Reason = fmt.Sprintf("pid u=%.1f%% below min duty", u)
+		return res
+	}
+	sign := 1
+	if u < 0 {
+		sign = -1
+	}
+	if sign != st.cycleSign {
+		st.cyclePos, st.cycleSign = 0, sign
+	}
+	cycle := params.CycleEpochs
+	if cycle < 1 {
+		cycle = 1
+	}
+	onEpochs := int(math.Round(duty / 100 * float64(cycle)))
+	on := st.cyclePos < onEpochs
+	st.cyclePos = (st.cyclePos + 1) % cycle
+
+	res.Duty = int(math.Round(duty))
+	_, speeds := p.cfg.FanFor(zone)
+	res.Fan = fanForDuty(res.Duty, speeds)
+	switch {
+	case !on:
+		res.Action = "OFF"
+		res.Reason = fmt.Sprintf("pid u=%.1f%% off-phase of duty cycle", u)
+	case sign > 0:
+		res.Action = "HEAT"
+		res.Reason = fmt.Sprintf("pid u=%.1f%% (e=%.2fC)", u, e)
+	default:
+		res.Action = "COOL"
+		res.Reason = fmt.Sprintf("pid u=%.1f%% (e=%.2fC)", u, e)
+	}
+	if !on {
+		res.Fan = 0
+	}
+	return res
+}
+
+// fanForDuty returns the smallest configured speed that covers duty, or the top speed.
+func fanForDuty(duty int, speeds []int) int {
+	if duty <= 0 {
+		return 0
+	}
+	for _, s := range speeds {
+		if s >= duty {
+			return s
+		}
+	}
+	if n := len(speeds); n > 0 {
+		return speeds[n-1]
+	}
+	return duty
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
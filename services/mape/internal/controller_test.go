@@ -0,0 +1,92 @@
+// v1
+// services/mape/internal/controller_test.go
+package internal
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPIDDutyCycleAndAntiWindup(t *testing.T) {
+	cfg := &AppConfig{FanSpeeds: []int{25, 50, 75, 100}, PID: PIDParams{Kp: 20, Ki: 1, IntegralLimit: 30, MinDuty: 5, CycleEpochs: 4}}
+	pid := newPIDController(cfg)
+	in := ControlInput{TempC: 21.0, Target: 22.0, Hyst: 0.5, Dt: time.Second}
+	var on int
+	for i := 0; i < 4; i++ {
+		res := pid.Decide("zone-A", in)
+		if res.Strategy != StrategyPID {
+			t.Fatalf("unexpected strategy %q", res.Strategy)
+		}
+		if res.Action == "HEAT" {
+			on++
+		} else if res.Action != "OFF" {
+			t.Fatalf("expected HEAT/OFF when too cold, got %s", res.Action)
+		}
+	}
+	if on == 0 || on == 4 {
+		t.Fatalf("expected partial duty cycle over 4 epochs, heater on %d times", on)
+	}
+	// A long cold spell saturates the output; the integral must stay within its limit.
+	cold := ControlInput{TempC: 10.0, Target: 22.0, Hyst: 0.5, Dt: time.Second}
+	for i := 0; i < 200; i++ {
+		pid.Decide("zone-A", cold)
+	}
+	if got := pid.state["zone-A"].integral; got > 30 {
+		t.Fatalf("integral wound up to %.1f beyond limit", got)
+	}
+	// Once the zone overshoots, the controller must switch to cooling promptly.
+	hot := ControlInput{TempC: 25.0, Target: 22.0, Hyst: 0.5, Dt: time.Second}
+	if res := pid.Decide("zone-A", hot); res.Action != "COOL" {
+		t.Fatalf("expected COOL right after overshoot, got %s (%s)", res.Action, res.Reason)
+	}
+}
+
+func TestAnalyzeSelectsStrategyPerZone(t *testing.T) {
+	cfg := &AppConfig{
+		ZoneTargets:    map[string]float64{"zone-A": 22.0, "zone-B": 22.0},
+		ZoneHysteresis: map[string]float64{"zone-A": 0.5, "zone-B": 0.5},
+		FanSteps:       []float64{0.5, 1.0},
+		FanSpeeds:      []int{25, 50},
+		ZoneStrategy:   map[string]string{"zone-B": StrategyPID},
+	}
+	store, err := NewZoneSetpoints([]string{"zone-A", "zone-B"}, cfg.ZoneTargets, 10.0, 35.0)
+	if err != nil {
+		t.Fatalf("setpoints: %v", err)
+	}
+	analyzer := NewAnalyze(cfg, store, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if res := analyzer.Run("zone-A", Reading{AvgTempC: 21.0, HasTemp: true}); res.Strategy != StrategyHysteresis {
+		t.Fatalf("zone-A should default to hysteresis, got %q", res.Strategy)
+	}
+	if res := analyzer.Run("zone-B", Reading{AvgTempC: 21.0, HasTemp: true}); res.Strategy != StrategyPID {
+		t.Fatalf("zone-B should use pid, got %q", res.Strategy)
+	}
+}
+
+func TestLoadPropertiesStrategyAndPID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mape.properties")
+	body := "zones=zone-A,zone-B\ntarget=22.0\nhysteresis=0.5\n" +
+		"strategy.zone-B=pid\npid.kp=30\npid.ki.zone-B=0.2\n"
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	cfg := &AppConfig{}
+	if err := cfg.loadProperties(path); err != nil {
+		t.Fatalf("loadProperties: %v", err)
+	}
+	if cfg.StrategyFor("zone-A") != StrategyHysteresis || cfg.StrategyFor("zone-B") != StrategyPID {
+		t.Fatalf("unexpected strategies %q/%q", cfg.StrategyFor("zone-A"), cfg.StrategyFor("zone-B"))
+	}
+	if p := cfg.PIDFor("zone-B"); p.Kp != 30 || p.Ki != 0.2 {
+		t.Fatalf("expected zone-B kp=30 ki=0.2, got %+v", p)
+	}
+	if err := os.WriteFile(path, []byte(body+"strategy=bogus\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := cfg.loadProperties(path); err == nil {
+		t.Fatalf("expected unknown strategy to be rejected")
+	}
+}
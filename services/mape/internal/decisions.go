@@ -0,0 +1,217 @@
+// v2
+// services/mape/internal/decisions.go
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DecisionInput is the report a decision was taken on. Silent marks the safe-state
+// decisions taken because the zone stopped reporting, which have no report of their own.
+type DecisionInput struct {
+	EpochStart        string             `json:"epochStart,omitempty"`
+	EpochEnd          string             `json:"epochEnd,omitempty"`
+	HasTemp           bool               `json:"hasTemp"`
+	TempC             float64            `json:"tempC"`
+	ZoneEnergyKWh     float64            `json:"zoneEnergyKWhEpoch,omitempty"`
+	EnergySource      string             `json:"energySource,omitempty"`
+	ActuatorEnergyKWh map[string]float64 `json:"actuatorEnergyKWhEpoch,omitempty"`
+	Silent            bool               `json:"silent,omitempty"`
+}
+
+// DecisionSetpoint is the setpoint in force and what shaped it: BaseC comes from the
+// override, schedule or default named by Source, TargetC adds tariff pre-conditioning and
+// HysteresisC includes the unoccupied setback and any demand-response widening.
+type DecisionSetpoint struct {
+	BaseC        float64         `json:"baseC"`
+	TargetC      float64         `json:"targetC"`
+	Source       string          `json:"source"`
+	HysteresisC  float64         `json:"hysteresisC"`
+	PrecondC     float64         `json:"preconditionC,omitempty"`
+	BandWidenC   float64         `json:"bandWidenC,omitempty"`
+	DREvent      string          `json:"drEvent,omitempty"`
+	TariffPeriod string          `json:"tariffPeriod,omitempty"`
+	PriceKWh     float64         `json:"priceKWh,omitempty"`
+	Occupancy    *OccupancyState `json:"occupancy,omitempty"`
+}
+
+// DecisionAnalysis is the strategy's own decision, before the budget, guards and
+// interlocks had their say.
+type DecisionAnalysis struct {
+	Strategy string  `json:"strategy"`
+	Action   string  `json:"action"`
+	Fan      int     `json:"fan"`
+	Duty     int     `json:"duty,omitempty"`
+	DeltaC   float64 `json:"deltaC"`
+	Reason   string  `json:"reason"`
+}
+
+// Decision explains one zone round: what MAPE saw, which setpoint applied, what the
+// strategy asked for, what the budget, guards and interlocks made of it and the commands
+// issued. Executed is false when publishing the plan failed.
+type Decision struct {
+	ZoneID      string           `json:"zoneId"`
+	EpochIndex  int64            `json:"epochIndex"`
+	Timestamp   int64            `json:"timestamp"`
+	Input       DecisionInput    `json:"input"`
+	Setpoint    DecisionSetpoint `json:"setpoint"`
+	Analysis    DecisionAnalysis `json:"analysis"`
+	Budget      *ZoneAllocation  `json:"budget,omitempty"`
+	Requested   string           `json:"requestedAction,omitempty"`
+	Suppressed  string           `json:"suppressedReason,omitempty"`
+	Interlocks  []Interlock      `json:"interlocks,omitempty"`
+	Staging     string           `json:"staging,omitempty"`
+	Action      string           `json:"action"`
+	Fan         int              `json:"fan"`
+	Commands    []PlanCommand    `json:"commands"`
+	Executed    bool             `json:"executed"`
+	Explanation string           `json:"explanation"`
+}
+
+// newDecision assembles the record of a round from its input, the strategy's decision
+// (live) and the plan built from it.
+func newDecision(read Reading, silent bool, live AnalysisResult, cmds []PlanCommand, led LedgerEvent) Decision {
+	d := Decision{
+		ZoneID: led.ZoneID, EpochIndex: led.EpochIndex, Timestamp: led.Timestamp,
+		Input: DecisionInput{
+			EpochStart: led.Start, EpochEnd: led.End, HasTemp: live.HasTemp, TempC: live.TempC,
+			ZoneEnergyKWh: read.ZoneEnergyKWhEpoch, EnergySource: read.ZoneEnergySource,
+			ActuatorEnergyKWh: cloneEnergyMap(read.ActuatorEnergyKWh), Silent: silent,
+		},
+		Setpoint: DecisionSetpoint{
+			BaseC: live.Target - live.Tariff.PrecondC, TargetC: live.Target, Source: live.TargetSource, HysteresisC: live.Hyst,
+			PrecondC: live.Tariff.PrecondC, BandWidenC: live.Tariff.WidenC, DREvent: live.Tariff.DREventID,
+			TariffPeriod: live.Tariff.Period, PriceKWh: live.Tariff.Price,
+		},
+		Analysis: DecisionAnalysis{
+			Strategy: live.Strategy, Action: live.Action, Fan: live.Fan, Duty: live.Duty, DeltaC: live.Delta, Reason: live.Reason,
+		},
+		Budget: led.Budget, Requested: led.Requested, Suppressed: led.Suppressed,
+		Interlocks: append([]Interlock(nil), led.Interlocks...), Staging: led.Staging,
+		Action: led.Action, Fan: led.Fan, Commands: append([]PlanCommand(nil), cmds...),
+	}
+	if d.Setpoint.Source == "" {
+		d.Setpoint.Source = SourceDefault
+	}
+	if live.Occupancy.State != "" {
+		occ := live.Occupancy
+		d.Setpoint.Occupancy = &occ
+	}
+	d.Explanation = d.explain()
+	return d
+}
+
+// annotate copies the reasoning into the ledger event published for the round.
+func (d Decision) annotate(led *LedgerEvent) {
+	if d.Input.HasTemp {
+		t := d.Input.TempC
+		led.TempC = &t
+	}
+	led.StrategyAction, led.Reason, led.Explanation = d.Analysis.Action, d.Analysis.Reason, d.Explanation
+}
+
+// explain summarises the decision in one sentence per stage, in the order they applied.
+func (d Decision) explain() string {
+	var parts []string
+	switch {
+	case d.Input.Silent:
+		parts = append(parts, "no report received")
+	case !d.Input.HasTemp:
+		parts = append(parts, "no usable temperature")
+	default:
+		parts = append(parts, fmt.Sprintf("zone at %.2fC, setpoint %.2fC (%s) ±%.2fC", d.Input.TempC, d.Setpoint.TargetC, d.Setpoint.Source, d.Setpoint.HysteresisC))
+	}
+	if occ := d.Setpoint.Occupancy; occ != nil {
+		switch occ.State {
+		case OccupancyUnoccupied:
+			parts = append(parts, fmt.Sprintf("zone unoccupied (%s), band widened %.2fC", occ.Source, occ.SetbackC))
+		case OccupancyPreconditioning:
+			parts = append(parts, fmt.Sprintf("pre-conditioning for occupancy at %s (lead %.0f min)", occ.NextOccupied.Format("15:04"), occ.LeadMinutes))
+		}
+	}
+	if d.Setpoint.PrecondC != 0 {
+		parts = append(parts, fmt.Sprintf("setpoint shifted %+.2fC ahead of the %s tariff", d.Setpoint.PrecondC, d.Setpoint.TariffPeriod))
+	}
+	if d.Setpoint.DREvent != "" {
+		parts = append(parts, fmt.Sprintf("band widened %.2fC by demand-response event %s", d.Setpoint.BandWidenC, d.Setpoint.DREvent))
+	}
+	parts = append(parts, fmt.Sprintf("%s chose %s: %s", d.Analysis.Strategy, d.Analysis.Action, d.Analysis.Reason))
+	if d.Budget != nil && !d.Budget.Granted {
+		parts = append(parts, fmt.Sprintf("%s throttled (%s)", d.Budget.Requested, d.Budget.Reason))
+	}
+	if d.Suppressed != "" {
+		parts = append(parts, "guards: "+d.Suppressed)
+	}
+	for _, ilk := range d.Interlocks {
+		parts = append(parts, fmt.Sprintf("interlock %s: %s", ilk.Type, ilk.Reason))
+	}
+	if d.Staging != "" {
+		parts = append(parts, d.Staging)
+	}
+	parts = append(parts, fmt.Sprintf("applied %s with %d commands", d.Action, len(d.Commands)))
+	return strings.Join(parts, "; ")
+}
+
+// DecisionLog keeps the latest decisions of every zone, bounded by decisions.history.
+type DecisionLog struct {
+	cfg   *AppConfig
+	mu    sync.Mutex
+	zones map[string][]Decision
+}
+
+func NewDecisionLog(cfg *AppConfig) *DecisionLog {
+	return &DecisionLog{cfg: cfg, zones: map[string][]Decision{}}
+}
+
+// Record appends d to its zone's history, evicting the oldest entries beyond the limit.
+func (l *DecisionLog) Record(d Decision) {
+	if d.Timestamp == 0 {
+		d.Timestamp = time.Now().UnixMilli()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	hist := append(l.zones[d.ZoneID], d)
+	if keep := l.cfg.DecisionHistory; keep > 0 && len(hist) > keep {
+		hist = hist[len(hist)-keep:]
+	}
+	l.zones[d.ZoneID] = hist
+}
+
+// Last returns up to n of the zone's latest decisions, newest first.
+func (l *DecisionLog) Last(zone string, n int) []Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	hist := l.zones[zone]
+	if n <= 0 || n > len(hist) {
+		n = len(hist)
+	}
+	out := make([]Decision, 0, n)
+	for i := len(hist) - 1; i >= len(hist)-n; i-- {
+		out = append(out, hist[i])
+	}
+	return out
+}
+
+// Epoch returns the zone's latest decision for the epoch; a silent zone may decide more
+// than once on the same epoch index.
+func (l *DecisionLog) Epoch(zone string, epoch int64) (Decision, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	hist := l.zones[zone]
+	for i := len(hist) - 1; i >= 0; i-- {
+		if hist[i].EpochIndex == epoch {
+			return hist[i], true
+		}
+	}
+	return Decision{}, false
+}
+
+// Forget drops the history of a removed zone.
+func (l *DecisionLog) Forget(zone string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.zones, zone)
+}
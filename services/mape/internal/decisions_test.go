@@ -0,0 +1,111 @@
+// v0
+// services/mape/internal/decisions_test.go
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ledgerRecorder keeps the ledger events published per zone.
+type ledgerRecorder struct {
+	mu  sync.Mutex
+	led []LedgerEvent
+}
+
+func (p *ledgerRecorder) PublishCommandsAndLedger(_ context.Context, _ string, _ []PlanCommand, led LedgerEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.led = append(p.led, led)
+	return nil
+}
+
+func (p *ledgerRecorder) events() []LedgerEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]LedgerEvent(nil), p.led...)
+}
+
+func TestDecisionHistoryExplainsRounds(t *testing.T) {
+	cfg := &AppConfig{
+		Zones:           []string{"zone-A"},
+		ZoneTargets:     map[string]float64{"zone-A": 21},
+		ZoneHysteresis:  map[string]float64{"zone-A": 0.5},
+		FanSteps:        []float64{0.5, 1.0, 2.0},
+		FanSpeeds:       []int{0, 25, 50, 100},
+		Actuators:       map[string]ZoneActuators{"zone-A": {Heating: []string{"h1"}, Cooling: []string{"c1"}}},
+		Guard:           GuardParams{MinOn: time.Hour},
+		PollIntervalMs:  10,
+		DecisionHistory: 2,
+	}
+	store, err := NewZoneSetpoints(cfg.Zones, cfg.ZoneTargets, 10.0, 35.0)
+	if err != nil {
+		t.Fatalf("setpoints: %v", err)
+	}
+	prev := engineRef
+	defer func() { engineRef = prev }()
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	e := NewEngine(cfg, store, nil, nil, lg, nil)
+	src := chanSource{"zone-A": make(chan Reading)}
+	pub := &ledgerRecorder{}
+	e.mon.src, e.exe.pub = src, pub
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+
+	// Heating starts, then the heater's minimum run time holds it on although the zone is
+	// now too warm; the third round evicts the first from the two-entry history.
+	for i, temp := range []float64{19, 23, 23.2} {
+		src["zone-A"] <- Reading{ZoneID: "zone-A", EpochIndex: int64(i + 1), AvgTempC: temp, HasTemp: true}
+		waitFor(t, "ledger event", func() bool { return len(pub.events()) == i+1 })
+	}
+	led := pub.events()[1]
+	if led.TempC == nil || *led.TempC != 23 || led.StrategyAction != "COOL" || led.Action != "HEAT" || !strings.Contains(led.Explanation, "guards: COOL suppressed") {
+		t.Fatalf("ledger event lacks the reasoning: %+v", led)
+	}
+
+	srv := NewHTTPServer(cfg, store, nil, nil, lg)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.http.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	var list struct {
+		Decisions []Decision `json:"decisions"`
+	}
+	rec := get("/zones/zone-A/decisions?last=5")
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list.Decisions) != 2 || list.Decisions[0].EpochIndex != 3 {
+		t.Fatalf("list: %d %s", rec.Code, rec.Body.String())
+	}
+	var d Decision
+	rec = get("/zones/zone-A/decisions/2")
+	if err := json.Unmarshal(rec.Body.Bytes(), &d); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("epoch: %d %s", rec.Code, rec.Body.String())
+	}
+	if d.Input.TempC != 23 || d.Setpoint.TargetC != 21 || d.Setpoint.Source != SourceDefault || d.Analysis.Action != "COOL" ||
+		d.Requested != "COOL" || d.Action != "HEAT" || len(d.Commands) != 2 || !d.Executed {
+		t.Fatalf("unexpected decision: %+v", d)
+	}
+	if !strings.HasPrefix(d.Explanation, "zone at 23.00C, setpoint 21.00C (default)") {
+		t.Fatalf("unexpected explanation: %q", d.Explanation)
+	}
+	for path, code := range map[string]int{
+		"/zones/zone-A/decisions/1":      http.StatusNotFound, // evicted
+		"/zones/zone-A/decisions/x":      http.StatusBadRequest,
+		"/zones/zone-A/decisions?last=0": http.StatusBadRequest,
+		"/zones/zone-Z/decisions":        http.StatusNotFound,
+		"/zones/zone-A/history":          http.StatusNotFound,
+	} {
+		if rec := get(path); rec.Code != code {
+			t.Fatalf("%s: expected %d, got %d", path, code, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+// v1
+// services/mape/internal/diagnostics.go
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Diagnostic anomaly types, published on the anomaly topic next to the acknowledgement
+// faults.
+const (
+	AnomalyStuckOff          = "actuator_stuck_off"
+	AnomalyStuckOn           = "actuator_stuck_on"
+	AnomalyDegraded          = "actuator_degraded"
+	AnomalyNoResponse        = "zone_no_response"
+	AnomalyDiagnosticCleared = "diagnostic_cleared"
+)
+
+// DiagnosticsParams configures actuator fault detection. Zero Epochs disables it.
+type DiagnosticsParams struct {
+	Alpha      float64 // weight of a new observation in the learned averages
+	MinSamples int     // observations before a learned average is trusted
+	Epochs     int     // consecutive epochs a deviation, or its absence, must last
+	OffKW      float64 // draw separating a powered actuator from an unpowered one
+	LowRatio   float64 // draw below this fraction of the learned one is degraded
+	SlopeRatio float64 // temperature response below this fraction of the learned one is missing
+}
+
+// DefaultDiagnosticsParams learn over ten epochs and report deviations lasting three.
+func DefaultDiagnosticsParams() DiagnosticsParams {
+	return DiagnosticsParams{Alpha: 0.1, MinSamples: 10, Epochs: 3, OffKW: 0.05, LowRatio: 0.5, SlopeRatio: 0.25}
+}
+
+func (p *DiagnosticsParams) set(param string, f float64) error {
+	switch param {
+	case "alpha":
+		if f <= 0 || f > 1 {
+			return fmt.Errorf("diagnostics.alpha must be in (0,1], got %g", f)
+		}
+		p.Alpha = f
+	case "min_samples":
+		p.MinSamples = int(f)
+	case "epochs":
+		p.Epochs = int(f)
+	case "off_kw":
+		p.OffKW = f
+	case "low_ratio":
+		p.LowRatio = f
+	case "slope_ratio":
+		p.SlopeRatio = f
+	default:
+		return fmt.Errorf("unknown diagnostics parameter %q", param)
+	}
+	return nil
+}
+
+// Learned is an exponentially weighted average and the number of observations behind it.
+type Learned struct {
+	Mean    float64 `json:"mean"`
+	Samples int     `json:"samples"`
+}
+
+func (l *Learned) add(v, alpha float64) {
+	if l.Samples == 0 {
+		l.Mean = v
+	} else {
+		l.Mean += alpha * (v - l.Mean)
+	}
+	l.Samples++
+}
+
+// DiagnosticFinding is a deviation that lasted diagnostics.epochs epochs.
+type DiagnosticFinding struct {
+	Type          string  `json:"type"`
+	ZoneID        string  `json:"zoneId"`
+	ActuatorID    string  `json:"actuatorId,omitempty"`
+	SinceEpoch    int64   `json:"sinceEpoch"`
+	Reason        string  `json:"reason"`
+	ExpectedKW    float64 `json:"expectedKW,omitempty"`
+	ObservedKW    float64 `json:"observedKW,omitempty"`
+	ExpectedSlope float64 `json:"expectedSlopeCPerH,omitempty"`
+	ObservedSlope float64 `json:"observedSlopeCPerH,omitempty"`
+}
+
+func (f DiagnosticFinding) event(typ, reason string, now time.Time) AnomalyEvent {
+	return AnomalyEvent{
+		SchemaVersion: LedgerSchemaVersion, Type: typ, ZoneID: f.ZoneID, ActuatorID: f.ActuatorID, Reason: reason,
+		EpochIndex: f.SinceEpoch, ExpectedKW: f.ExpectedKW, ObservedKW: f.ObservedKW,
+		ExpectedSlope: f.ExpectedSlope, ObservedSlope: f.ObservedSlope, Timestamp: now.UnixMilli(),
+	}
+}
+
+// tracker debounces findings: a candidate, including "no deviation", must be seen for
+// diagnostics.epochs epochs in a row before it replaces the active finding.
+type tracker struct {
+	cand   string
+	streak int
+	active *DiagnosticFinding
+}
+
+// update feeds one epoch's finding (nil when healthy) and returns the events due.
+func (t *tracker) update(f *DiagnosticFinding, epochs int, now time.Time) []AnomalyEvent {
+	typ := ""
+	if f != nil {
+		typ = f.Type
+	}
+	if typ == t.cand {
+		t.streak++
+	} else {
+		t.cand, t.streak = typ, 1
+	}
+	activeType := ""
+	if t.active != nil {
+		activeType = t.active.Type
+	}
+	if t.streak < epochs || typ == activeType {
+		if t.active != nil && f != nil && typ == activeType {
+			// Keep the figures current while the finding lasts.
+			since := t.active.SinceEpoch
+			*t.active = *f
+			t.active.SinceEpoch = since
+		}
+		return nil
+	}
+	var out []AnomalyEvent
+	if t.active != nil {
+		out = append(out, t.active.event(AnomalyDiagnosticCleared, t.active.Type+" no longer observed", now))
+		t.active = nil
+	}
+	if f != nil {
+		found := *f
+		t.active = &found
+		out = append(out, found.event(found.Type, found.Reason, now))
+	}
+	return out
+}
+
+type actuatorDiag struct {
+	zone     string
+	role     string // heating, cooling or ventilation
+	issued   string // mode last commanded
+	power    map[string]*Learned
+	findings tracker
+}
+
+type zoneDiag struct {
+	prevTemp  float64
+	prevEpoch int64
+	hasPrev   bool
+	slope     map[string]*Learned // HEAT/COOL: temperature change in the mode's direction, °C/h
+	findings  tracker
+}
+
+// Diagnostics learns, per actuator and commanded mode, the power an actuator draws and,
+// per zone and mode, how fast the temperature responds, from the metered energy and the
+// temperature of each report. The report of an epoch is compared with the commands in
+// force during it:
+//   - a powered actuator drawing nothing is stuck off (relay, breaker, dead element);
+//   - an actuator commanded OFF that keeps drawing is stuck on (welded relay);
+//   - one drawing well below its learned power is degraded (failing coil or element);
+//   - a zone whose heating or cooling draws normally but whose temperature does not
+//     respond likely has its sensor in another zone, or its actuators serve another one.
+type Diagnostics struct {
+	cfg   *AppConfig
+	mu    sync.Mutex
+	acts  map[string]*actuatorDiag
+	zones map[string]*zoneDiag
+	now   func() time.Time
+}
+
+func NewDiagnostics(cfg *AppConfig) *Diagnostics {
+	return &Diagnostics{cfg: cfg, acts: map[string]*actuatorDiag{}, zones: map[string]*zoneDiag{}, now: time.Now}
+}
+
+// Issued records the commands now in force, to be checked against the next report.
+func (d *Diagnostics) Issued(cmds []PlanCommand) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, c := range cmds {
+		d.actuator(c.ZoneID, c.ActuatorID).issued = c.Mode
+	}
+}
+
+func (d *Diagnostics) actuator(zone, id string) *actuatorDiag {
+	a, ok := d.acts[id]
+	if !ok {
+		a = &actuatorDiag{power: map[string]*Learned{}}
+		d.acts[id] = a
+	}
+	a.zone = zone
+	return a
+}
+
+// commandedOn reports whether a command mode runs the actuator: ON for heaters and
+// coolers, a non-zero fan percent for ventilation.
+func commandedOn(mode string) bool { return mode != "" && mode != "OFF" && mode != "0" }
+
+// Observe checks the zone's report against the commands in force during its epoch and
+// returns the anomalies raised or cleared; dt is the epoch length.
+func (d *Diagnostics) Observe(zone string, read Reading, dt time.Duration) []AnomalyEvent {
+	p := d.cfg.Diagnostics
+	if p.Epochs <= 0 || dt <= 0 {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	var out []AnomalyEvent
+	acts := d.cfg.Actuators[zone]
+	// powered collects, per mode, the draw of its actuators when none of them deviates.
+	powered := map[string]float64{}
+	deviating := map[string]bool{}
+	for _, r := range []struct {
+		role, mode string
+		ids        []string
+	}{{"heating", "HEAT", acts.Heating}, {"cooling", "COOL", acts.Cooling}, {"ventilation", "", acts.Ventilation}} {
+		for _, id := range r.ids {
+			a, ok := d.acts[id]
+			kwh, metered := read.ActuatorEnergyKWh[id]
+			if !ok || a.issued == "" || !metered {
+				continue
+			}
+			a.role = r.role
+			f := d.checkPower(zone, id, a, kwh/dt.Hours(), read.EpochIndex, p)
+			out = append(out, a.findings.update(f, p.Epochs, now)...)
+			if r.mode == "" || !commandedOn(a.issued) {
+				continue
+			}
+			if f != nil || a.findings.active != nil {
+				deviating[r.mode] = true
+			} else {
+				powered[r.mode] += kwh / dt.Hours()
+			}
+		}
+	}
+
+	z, ok := d.zones[zone]
+	if !ok {
+		z = &zoneDiag{slope: map[string]*Learned{}}
+		d.zones[zone] = z
+	}
+	if !read.HasTemp {
+		return out
+	}
+	mode := d.zoneMode(acts)
+	if kw, ok := powered[mode]; ok && !deviating[mode] && z.hasPrev && read.EpochIndex == z.prevEpoch+1 {
+		resp := (read.AvgTempC - z.prevTemp) / dt.Hours()
+		if mode == "COOL" {
+			resp = -resp
+		}
+		l := z.slope[mode]
+		if l == nil {
+			l = &Learned{}
+			z.slope[mode] = l
+		}
+		var f *DiagnosticFinding
+		if l.Samples >= p.MinSamples && l.Mean > 0 && resp < p.SlopeRatio*l.Mean {
+			verb := map[string]string{"HEAT": "rise", "COOL": "fall"}[mode]
+			f = &DiagnosticFinding{
+				Type: AnomalyNoResponse, ZoneID: zone, SinceEpoch: read.EpochIndex, ObservedKW: kw,
+				ExpectedSlope: l.Mean, ObservedSlope: resp,
+				Reason: fmt.Sprintf("%s draws %.2f kW but the zone temperature does not %s (%.2f vs learned %.2f C/h): sensor or actuators may be in another zone",
+					strings.ToLower(mode), kw, verb, resp, l.Mean),
+			}
+		} else {
+			l.add(resp, p.Alpha)
+		}
+		out = append(out, z.findings.update(f, p.Epochs, now)...)
+	}
+	z.prevTemp, z.prevEpoch, z.hasPrev = read.AvgTempC, read.EpochIndex, true
+	return out
+}
+
+// checkPower compares one actuator's draw with its command and learned power, learning
+// from draws that look healthy.
+func (d *Diagnostics) checkPower(zone, id string, a *actuatorDiag, kw float64, epoch int64, p DiagnosticsParams) *DiagnosticFinding {
+	f := &DiagnosticFinding{ZoneID: zone, ActuatorID: id, SinceEpoch: epoch, ObservedKW: kw}
+	if !commandedOn(a.issued) {
+		if kw > p.OffKW {
+			f.Type = AnomalyStuckOn
+			f.Reason = fmt.Sprintf("commanded %s but draws %.2f kW: relay may be stuck closed", a.issued, kw)
+			return f
+		}
+		return nil
+	}
+	l := a.power[a.issued]
+	if l == nil {
+		l = &Learned{}
+		a.power[a.issued] = l
+	}
+	f.ExpectedKW = l.Mean
+	switch {
+	case kw < p.OffKW:
+		f.Type = AnomalyStuckOff
+		f.Reason = fmt.Sprintf("commanded %s but draws no power: relay stuck open, tripped breaker or dead element", a.issued)
+	case l.Samples >= p.MinSamples && kw < p.LowRatio*l.Mean:
+		f.Type = AnomalyDegraded
+		f.Reason = fmt.Sprintf("draws %.2f kW, %.0f%% of the learned %.2f kW: degraded coil or element", kw, 100*kw/l.Mean, l.Mean)
+	default:
+		l.add(kw, p.Alpha)
+		return nil
+	}
+	return f
+}
+
+// zoneMode is HEAT or COOL when the zone's heaters or coolers were commanded on.
+func (d *Diagnostics) zoneMode(acts ZoneActuators) string {
+	for _, r := range []struct {
+		mode string
+		ids  []string
+	}{{"HEAT", acts.Heating}, {"COOL", acts.Cooling}} {
+		for _, id := range r.ids {
+			if a, ok := d.acts[id]; ok && commandedOn(a.issued) {
+				return r.mode
+			}
+		}
+	}
+	return "OFF"
+}
+
+// Faulted reports whether the actuator has an active finding.
+func (d *Diagnostics) Faulted(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	a, ok := d.acts[id]
+	return ok && a.findings.active != nil
+}
+
+// Forget drops the state of a removed zone (zone set) or of released actuators.
+func (d *Diagnostics) Forget(zone string, ids []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, id := range ids {
+		delete(d.acts, id)
+	}
+	if zone == "" {
+		return
+	}
+	delete(d.zones, zone)
+	for id, a := range d.acts {
+		if a.zone == zone {
+			delete(d.acts, id)
+		}
+	}
+}
+
+// ActuatorDiagnostics is what GET /diagnostics shows for one actuator.
+type ActuatorDiagnostics struct {
+	ZoneID    string             `json:"zoneId"`
+	Role      string             `json:"role,omitempty"`
+	Commanded string             `json:"commanded"`
+	PowerKW   map[string]Learned `json:"learnedKW"`
+	Finding   *DiagnosticFinding `json:"finding,omitempty"`
+}
+
+// ZoneDiagnostics is what GET /diagnostics shows for one zone.
+type ZoneDiagnostics struct {
+	SlopeCPerH map[string]Learned `json:"learnedSlopeCPerH"`
+	Finding    *DiagnosticFinding `json:"finding,omitempty"`
+}
+
+// DiagnosticsStatus is the answer of GET /diagnostics.
+type DiagnosticsStatus struct {
+	Actuators map[string]ActuatorDiagnostics `json:"actuators"`
+	Zones     map[string]ZoneDiagnostics     `json:"zones"`
+	Findings  []DiagnosticFinding            `json:"findings"`
+}
+
+// Status returns what was learned and the active findings, of one zone when zone is set.
+func (d *Diagnostics) Status(zone string) DiagnosticsStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	st := DiagnosticsStatus{Actuators: map[string]ActuatorDiagnostics{}, Zones: map[string]ZoneDiagnostics{}, Findings: []DiagnosticFinding{}}
+	for id, a := range d.acts {
+		if zone != "" && a.zone != zone {
+			continue
+		}
+		ad := ActuatorDiagnostics{ZoneID: a.zone, Role: a.role, Commanded: a.issued, PowerKW: copyLearned(a.power)}
+		if f := a.findings.active; f != nil {
+			cp := *f
+			ad.Finding = &cp
+			st.Findings = append(st.Findings, cp)
+		}
+		st.Actuators[id] = ad
+	}
+	for name, z := range d.zones {
+		if zone != "" && name != zone {
+			continue
+		}
+		zd := ZoneDiagnostics{SlopeCPerH: copyLearned(z.slope)}
+		if f := z.findings.active; f != nil {
+			cp := *f
+			zd.Finding = &cp
+			st.Findings = append(st.Findings, cp)
+		}
+		st.Zones[name] = zd
+	}
+	sort.Slice(st.Findings, func(i, j int) bool {
+		a, b := st.Findings[i], st.Findings[j]
+		if a.ZoneID != b.ZoneID {
+			return a.ZoneID < b.ZoneID
+		}
+		return a.ActuatorID < b.ActuatorID
+	})
+	return st
+}
+
+func copyLearned(src map[string]*Learned) map[string]Learned {
+	out := make(map[string]Learned, len(src))
+	for k, v := range src {
+		if v.Samples > 0 {
+			out[k] = *v
+		}
+	}
+	return out
+}
@@ -0,0 +1,137 @@
+// v0
+// services/mape/internal/diagnostics_test.go
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDiagnosticsFindsActuatorFaults(t *testing.T) {
+	cfg := &AppConfig{
+		Zones:       []string{"zone-A"},
+		ZoneTargets: map[string]float64{"zone-A": 21},
+		Actuators:   map[string]ZoneActuators{"zone-A": {Heating: []string{"h1"}, Cooling: []string{"c1"}, Ventilation: []string{"v1"}}},
+		Diagnostics: DiagnosticsParams{Alpha: 0.2, MinSamples: 3, Epochs: 2, OffKW: 0.05, LowRatio: 0.5, SlopeRatio: 0.25},
+	}
+	const dt = 15 * time.Minute
+	d := NewDiagnostics(cfg)
+	var epoch int64
+	temp := 18.0
+	// step runs one heating epoch: kW per actuator and the temperature change over it.
+	step := func(heater, cooler, rise float64) []AnomalyEvent {
+		d.Issued([]PlanCommand{
+			{ZoneID: "zone-A", ActuatorID: "h1", Mode: "ON"},
+			{ZoneID: "zone-A", ActuatorID: "c1", Mode: "OFF"},
+			{ZoneID: "zone-A", ActuatorID: "v1", Mode: "50"},
+		})
+		epoch++
+		temp += rise
+		return d.Observe("zone-A", Reading{ZoneID: "zone-A", EpochIndex: epoch, AvgTempC: temp, HasTemp: true, ActuatorEnergyKWh: map[string]float64{
+			"h1": heater * dt.Hours(), "c1": cooler * dt.Hours(), "v1": 0.4 * dt.Hours(),
+		}}, dt)
+	}
+	expect := func(name string, evs []AnomalyEvent, types ...string) {
+		t.Helper()
+		if len(evs) != len(types) {
+			t.Fatalf("%s: expected %v, got %+v", name, types, evs)
+		}
+		for i, typ := range types {
+			if evs[i].Type != typ {
+				t.Fatalf("%s: expected %v, got %+v", name, types, evs)
+			}
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		expect("learning", step(2, 0, 0.5))
+	}
+	st := d.Status("")
+	if l := st.Actuators["h1"].PowerKW["ON"]; l.Samples != 5 || l.Mean < 1.99 || l.Mean > 2.01 {
+		t.Fatalf("heater power not learned: %+v", st.Actuators["h1"])
+	}
+	if l := st.Zones["zone-A"].SlopeCPerH["HEAT"]; l.Samples != 4 || l.Mean < 1.99 || l.Mean > 2.01 {
+		t.Fatalf("heating response not learned: %+v", st.Zones["zone-A"])
+	}
+
+	// A weak heater is reported on the second epoch and cleared once it recovers; the
+	// temperature response is not judged meanwhile.
+	expect("degraded, first epoch", step(0.6, 0, 0))
+	evs := step(0.6, 0, 0)
+	expect("degraded", evs, AnomalyDegraded)
+	if ev := evs[0]; ev.ActuatorID != "h1" || ev.EpochIndex != 7 || ev.ExpectedKW < 1.99 || ev.ObservedKW != 0.6 {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+	expect("recovering", step(2, 0, 0.5))
+	expect("recovered", step(2, 0, 0.5), AnomalyDiagnosticCleared)
+
+	// A cooler drawing power while commanded OFF is stuck on.
+	expect("stuck on, first epoch", step(2, 1.5, 0.5))
+	expect("stuck on", step(2, 1.5, 0.5), AnomalyStuckOn)
+	expect("released", step(2, 0, 0.5))
+	expect("released", step(2, 0, 0.5), AnomalyDiagnosticCleared)
+
+	// A heater without power is stuck off, learned figure or not.
+	step(0, 0, 0)
+	expect("stuck off", step(0, 0, 0), AnomalyStuckOff)
+	step(2, 0, 0.5)
+	expect("restored", step(2, 0, 0.5), AnomalyDiagnosticCleared)
+
+	// The heater draws normally but the zone does not warm up.
+	step(2, 0, 0)
+	evs = step(2, 0, 0)
+	expect("no response", evs, AnomalyNoResponse)
+	if evs[0].ExpectedSlope < 1.9 || evs[0].ObservedSlope != 0 {
+		t.Fatalf("unexpected event: %+v", evs[0])
+	}
+	if st := d.Status("zone-A"); len(st.Findings) != 1 || st.Findings[0].Type != AnomalyNoResponse {
+		t.Fatalf("finding not reported: %+v", st.Findings)
+	}
+
+	d.Forget("zone-A", nil)
+	if st := d.Status(""); len(st.Actuators) != 0 || len(st.Zones) != 0 {
+		t.Fatalf("removed zone still tracked: %+v", st)
+	}
+}
+
+func TestDiagnosticsEndpoint(t *testing.T) {
+	cfg := &AppConfig{
+		Zones:       []string{"zone-A"},
+		ZoneTargets: map[string]float64{"zone-A": 21},
+		Actuators:   map[string]ZoneActuators{"zone-A": {Heating: []string{"h1"}}},
+		Diagnostics: DiagnosticsParams{Alpha: 0.1, MinSamples: 10, Epochs: 1, OffKW: 0.05, LowRatio: 0.5, SlopeRatio: 0.25},
+	}
+	store, err := NewZoneSetpoints(cfg.Zones, cfg.ZoneTargets, 10.0, 35.0)
+	if err != nil {
+		t.Fatalf("setpoints: %v", err)
+	}
+	prev := engineRef
+	defer func() { engineRef = prev }()
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	e := NewEngine(cfg, store, nil, nil, lg, nil)
+	e.diag.Issued([]PlanCommand{{ZoneID: "zone-A", ActuatorID: "h1", Mode: "ON"}})
+	e.diag.Observe("zone-A", Reading{ZoneID: "zone-A", EpochIndex: 1, ActuatorEnergyKWh: map[string]float64{"h1": 0}}, time.Hour)
+
+	srv := NewHTTPServer(cfg, store, nil, nil, lg)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.http.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	var st DiagnosticsStatus
+	rec := get("/diagnostics?zone=zone-A")
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("get: %d %s", rec.Code, rec.Body.String())
+	}
+	if len(st.Findings) != 1 || st.Findings[0].Type != AnomalyStuckOff || st.Actuators["h1"].Commanded != "ON" {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	if rec := get("/diagnostics?zone=zone-Z"); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown zone: expected 404, got %d", rec.Code)
+	}
+}
@@ -1,84 +1,536 @@
-// v9
+// v26
 // services/mape/internal/engine.go
 package internal
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Engine struct {
-	cfg   *AppConfig
-	lg    *slog.Logger
-	io    *KafkaIO
-	sp    *ZoneSetpoints
-	mon   *Monitor
-	an    *Analyze
-	pln   *Plan
-	exe   *Execute
+	cfg  *AppConfig
+	lg   *slog.Logger
+	io   *KafkaIO
+	sp   *ZoneSetpoints
+	mon  *Monitor
+	an   *Analyze
+	pln  *Plan
+	exe  *Execute
+	acks *AckTracker
+	bud  *BudgetCoordinator
+	shad *ShadowLog
+	dec  *DecisionLog
+	diag *Diagnostics
+	// wire rewires Kafka and switches actuators off when a reload changes the zones.
+	wire zoneWiring
+	// workers hold each zone's loop; a fetcher and a worker goroutine run per zone. wmu
+	// guards them and ctx, Run's context, since a reload adds and removes zones.
+	wmu     sync.Mutex
+	ctx     context.Context
+	workers map[string]*zoneWorker
+	// slots bounds how many zones are analysed and executed at once (engine.workers); nil
+	// when every zone may run in parallel.
+	slots chan struct{}
+	// params are the engine.* settings the engine started with; a reload leaves them.
+	params EngineParams
+	// mu guards stats and zones, which every worker updates.
+	mu    sync.Mutex
 	stats Stats
+	zones map[string]*ZoneLoopStats
+	// resends is updated by the reconciler goroutine, hence atomic.
+	resends atomic.Int64
 }
 
 var engineRef *Engine
 
-func NewEngine(cfg *AppConfig, sp *ZoneSetpoints, lg *slog.Logger, io *KafkaIO) *Engine {
+func NewEngine(cfg *AppConfig, sp *ZoneSetpoints, sched *Schedules, dr *DREvents, lg *slog.Logger, io *KafkaIO) *Engine {
 	e := &Engine{cfg: cfg, sp: sp, lg: lg, io: io}
+	if io != nil {
+		e.wire = io
+	}
 	e.mon = NewMonitor(cfg, lg, io)
-	e.an = NewAnalyze(cfg, sp, lg)
+	e.an = NewAnalyze(cfg, sp, sched, dr, lg)
 	e.pln = NewPlan(cfg, sp, lg)
 	e.exe = NewExecute(lg, io)
+	e.acks = NewAckTracker(cfg)
+	e.bud = NewBudgetCoordinator(cfg)
+	e.shad = NewShadowLog(cfg)
+	e.dec = NewDecisionLog(cfg)
+	e.diag = NewDiagnostics(cfg)
+	e.pln.staging.faulted = func(id string) bool { return e.acks.IsFaulty(id) || e.diag.Faulted(id) }
+	now := time.Now()
+	e.workers = make(map[string]*zoneWorker, len(cfg.Zones))
+	e.zones = make(map[string]*ZoneLoopStats, len(cfg.Zones))
+	for _, zone := range cfg.Zones {
+		e.workers[zone] = newZoneWorker(zone, now, cfg.Engine)
+		e.zones[zone] = &ZoneLoopStats{}
+	}
+	if n := cfg.Engine.Workers; n > 0 {
+		e.slots = make(chan struct{}, n)
+	}
+	e.params = cfg.Engine
 	e.stats.ZoneEnergy = map[string]float64{}
 	e.stats.EnergyField = map[string]string{}
 	engineRef = e
 	return e
 }
 
+// Run starts a fetcher and a worker per zone and blocks until ctx is done. Fetchers wait
+// on their zone partition and hand each report to the worker through a latest-wins
+// mailbox, or an ordered queue with engine.consume=every; workers analyse, plan and execute
+// as soon as a report arrives, at most engine.workers zones at a time, so a slow zone never
+// delays the others.
 func (e *Engine) Run(ctx context.Context) {
+	e.lg.Info("engine start", "zones", e.cfg.Zones, "workers", cap(e.slots), "acks", e.acks.Enabled(), "consume", e.params.Consume, "catch_up", e.params.CatchUp)
+	if e.acks.Enabled() {
+		go e.reconcile(ctx)
+	}
+	e.wmu.Lock()
+	e.ctx = ctx
+	for _, w := range e.workers {
+		e.start(w)
+	}
+	e.wmu.Unlock()
+	<-ctx.Done()
+	e.wmu.Lock()
+	for _, w := range e.workers {
+		w.stop()
+	}
+	e.wmu.Unlock()
+	e.lg.Info("engine stop")
+}
+
+// start runs the zone's fetcher, worker and, with acknowledgements enabled, ack consumer
+// under a context of their own so that a reload can stop one zone. Callers hold wmu.
+func (e *Engine) start(w *zoneWorker) {
+	ctx, cancel := context.WithCancel(e.ctx)
+	w.cancel = cancel
+	w.wg.Add(2)
+	go func() {
+		defer w.wg.Done()
+		e.fetch(ctx, w)
+	}()
+	go func() {
+		defer w.wg.Done()
+		e.work(ctx, w)
+	}()
+	if e.acks.Enabled() {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			e.io.ConsumeAcks(ctx, w.zone, e.onAck)
+		}()
+	}
+}
+
+// fetch feeds the zone's mailbox until ctx is done, backing off while the source fails.
+func (e *Engine) fetch(ctx context.Context, w *zoneWorker) {
+	backoff := 250 * time.Millisecond
+	for ctx.Err() == nil {
+		read, err := e.mon.Next(ctx, w.zone)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			e.lg.Error("monitor error", "zone", w.zone, "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, 5*time.Second)
+			continue
+		}
+		backoff = 250 * time.Millisecond
+		in, replaced := inbound{read: read, at: time.Now()}, false
+		if w.box.queue {
+			if !w.box.push(ctx, in) {
+				return
+			}
+		} else {
+			replaced = w.box.put(in)
+		}
+		e.mu.Lock()
+		e.stats.MessagesIn++
+		zs := e.zones[w.zone]
+		if replaced {
+			zs.Superseded++
+			zs.Behind = true
+		}
+		e.mu.Unlock()
+		if replaced {
+			e.lg.Warn("zone behind, older report superseded", "zone", w.zone, "epoch", read.EpochIndex)
+		}
+	}
+}
+
+// work processes the zone's reports as they arrive and, while none do, checks whether the
+// zone has gone silent every POLL_INTERVAL_MS.
+func (e *Engine) work(ctx context.Context, w *zoneWorker) {
 	interval := time.Duration(e.cfg.PollIntervalMs) * time.Millisecond
-	e.lg.Info("engine start", "interval_ms", e.cfg.PollIntervalMs, "zones", e.cfg.Zones)
+	if interval <= 0 {
+		interval = 250 * time.Millisecond
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
 	for {
 		select {
 		case <-ctx.Done():
-			e.lg.Info("engine stop")
 			return
-		default:
+		case in := <-w.box.ch:
+			e.round(ctx, w, e.catchUp(ctx, w, in))
+		case <-tick.C:
+			e.checkSilent(ctx, w)
 		}
-		for _, zone := range e.cfg.Zones {
-			read, ok, err := e.mon.Latest(ctx, zone)
-			if err != nil {
-				e.lg.Error("monitor error", "zone", zone, "error", err)
-				continue
-			}
-			if !ok {
-				continue
-			}
-			e.stats.MessagesIn++
-			if e.stats.ZoneEnergy == nil {
-				e.stats.ZoneEnergy = map[string]float64{}
-			}
-			if e.stats.EnergyField == nil {
-				e.stats.EnergyField = map[string]string{}
+	}
+}
+
+// acquire waits for a worker slot; it fails only when ctx is done.
+func (e *Engine) acquire(ctx context.Context) bool {
+	if e.slots == nil {
+		return ctx.Err() == nil
+	}
+	select {
+	case e.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (e *Engine) release() {
+	if e.slots != nil {
+		<-e.slots
+	}
+}
+
+// round analyses one report, submits the decision to the budget coordinator alongside the
+// grants other zones hold, then plans and executes it.
+func (e *Engine) round(ctx context.Context, w *zoneWorker, in inbound) {
+	if !e.acquire(ctx) {
+		return
+	}
+	defer e.release()
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	zone, read := w.zone, in.read
+	w.seen, w.lost, w.epoch = time.Now(), false, read.EpochIndex
+	e.mu.Lock()
+	e.stats.ZoneEnergy[zone] = read.ZoneEnergyKWhEpoch
+	e.stats.EnergyField[zone] = read.ZoneEnergySource
+	e.mu.Unlock()
+	e.bud.Observe(zone, w.applied, read, e.an.epochLen(read))
+	for _, ev := range e.diag.Observe(zone, read, e.an.epochLen(read)) {
+		e.raise(ctx, ev)
+	}
+	res := e.an.Run(zone, read)
+	// live keeps the undisturbed decision for the shadow comparison, since the budget and
+	// guards may rewrite the request.
+	live := res
+	alloc := e.bud.Allocate([]zoneRequest{{Zone: zone, Res: &res}})
+	cmds, led := e.pln.Build(zone, read.EpochIndex, read.EpochStart, read.EpochEnd, res)
+	if a, ok := alloc[zone]; ok {
+		led.Budget = &a
+	}
+	ok := e.executeExplained(ctx, w, read, false, live, cmds, led)
+	e.finish(w, read.EpochIndex, in.at)
+	e.mon.Planned(zone, read)
+	if !ok {
+		return
+	}
+	if rec := e.shad.Record(zone, read.EpochIndex, live, led.Action); rec != nil {
+		for _, sp := range rec.Shadows {
+			if sp.Diverged {
+				e.lg.Info("shadow plan diverged", "zone", zone, "epoch", read.EpochIndex, "live", rec.LiveStrategy, "live_action", rec.LiveAction, "shadow", sp.Strategy, "shadow_action", sp.Action, "shadow_fan", sp.Fan)
 			}
-			e.stats.ZoneEnergy[zone] = read.ZoneEnergyKWhEpoch
-			e.stats.EnergyField[zone] = read.ZoneEnergySource
-			res := e.an.Run(zone, read)
-			cmds, led := e.pln.Build(zone, read.EpochIndex, read.EpochStart, read.EpochEnd, res)
-			if err := e.exe.Do(ctx, zone, cmds, led); err != nil {
-				e.lg.Error("execute error", "zone", zone, "error", err)
+		}
+	}
+}
+
+// catchUp applies engine.catch_up=latest to a queue: when newer reports already wait
+// behind in, they are all taken, the newest is returned to be planned and the others are
+// recorded as skipped. Otherwise in is returned as is.
+func (e *Engine) catchUp(ctx context.Context, w *zoneWorker, in inbound) inbound {
+	if !w.box.queue || w.box.catchUp != CatchUpLatest || !w.box.waiting() {
+		return in
+	}
+	var skipped []Reading
+	for w.box.waiting() {
+		skipped = append(skipped, in.read)
+		in = <-w.box.ch
+	}
+	e.lg.Warn("zone behind, catching up to the latest epoch", "zone", w.zone, "skipped", len(skipped), "epoch", in.read.EpochIndex)
+	for _, read := range skipped {
+		e.skip(ctx, w, read, in.read.EpochIndex)
+	}
+	return in
+}
+
+// skip publishes a ledger event for an epoch that is not planned because the zone caught up
+// to latest, so the ledger can pair it with its aggregator report instead of imputing the
+// MAPE side. No commands are issued: the actuators keep the last applied action. The
+// epoch's measurements still reach the budget and diagnostics.
+func (e *Engine) skip(ctx context.Context, w *zoneWorker, read Reading, latest int64) {
+	if !e.acquire(ctx) {
+		return
+	}
+	defer e.release()
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	zone := w.zone
+	e.bud.Observe(zone, w.applied, read, e.an.epochLen(read))
+	for _, ev := range e.diag.Observe(zone, read, e.an.epochLen(read)) {
+		e.raise(ctx, ev)
+	}
+	kept := w.applied
+	if kept == "" {
+		kept = "no action"
+	}
+	led := LedgerEvent{
+		SchemaVersion: LedgerSchemaVersion,
+		EpochIndex:    read.EpochIndex, ZoneID: zone,
+		Planned: "skipped", Start: read.EpochStart, End: read.EpochEnd, Timestamp: time.Now().UnixMilli(),
+		ZoneEnergy: read.ZoneEnergyKWhEpoch, EnergyFrom: read.ZoneEnergySource, ActEnergy: cloneEnergyMap(read.ActuatorEnergyKWh),
+		Action: w.applied, SkippedFor: latest,
+		Reason:      fmt.Sprintf("zone behind, caught up to epoch %d", latest),
+		Explanation: fmt.Sprintf("epoch not planned: zone behind, caught up to epoch %d; %s kept", latest, kept),
+	}
+	if read.HasTemp {
+		temp := read.AvgTempC
+		led.TempC = &temp
+	}
+	if t := e.cfg.Engine.ExecuteTimeout; t > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t)
+		defer cancel()
+	}
+	err := e.exe.Do(ctx, zone, nil, led)
+	e.mu.Lock()
+	zs := e.zones[zone]
+	zs.Skipped++
+	if err != nil {
+		zs.ExecuteErrors++
+	} else {
+		e.stats.LedgerWrites++
+	}
+	e.mu.Unlock()
+	if err != nil {
+		e.lg.Error("execute error", "zone", zone, "epoch", read.EpochIndex, "error", err)
+	}
+}
+
+// finish records the round's latency and whether a newer report is already waiting.
+func (e *Engine) finish(w *zoneWorker, epoch int64, fetched time.Time) {
+	took := time.Since(fetched)
+	e.mu.Lock()
+	zs := e.zones[w.zone]
+	zs.observeLatency(took)
+	zs.LastEpoch = epoch
+	zs.Behind = w.box.waiting()
+	zs.Queued = len(w.box.ch)
+	e.stats.Loops++
+	e.mu.Unlock()
+	if slow := e.cfg.Engine.SlowAfter; slow > 0 && took > slow {
+		e.lg.Warn("zone round slow", "zone", w.zone, "epoch", epoch, "latency_ms", took.Milliseconds(), "limit_ms", slow.Milliseconds())
+	}
+}
+
+// executeExplained annotates the ledger event with the round's reasoning, executes the
+// plan and keeps the decision in the zone's history.
+func (e *Engine) executeExplained(ctx context.Context, w *zoneWorker, read Reading, silent bool, live AnalysisResult, cmds []PlanCommand, led LedgerEvent) bool {
+	d := newDecision(read, silent, live, cmds, led)
+	d.annotate(&led)
+	d.Executed = e.execute(ctx, w, cmds, led)
+	e.dec.Record(d)
+	return d.Executed
+}
+
+// execute publishes a zone plan and records its outcome; it reports whether it succeeded.
+func (e *Engine) execute(ctx context.Context, w *zoneWorker, cmds []PlanCommand, led LedgerEvent) bool {
+	if t := e.cfg.Engine.ExecuteTimeout; t > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t)
+		defer cancel()
+	}
+	start := time.Now()
+	err := e.exe.Do(ctx, w.zone, cmds, led)
+	e.mu.Lock()
+	zs := e.zones[w.zone]
+	zs.observeExecute(time.Since(start))
+	if err != nil {
+		zs.ExecuteErrors++
+	} else {
+		e.stats.CommandsOut += int64(len(cmds))
+		e.stats.LedgerWrites++
+	}
+	e.mu.Unlock()
+	if err != nil {
+		e.lg.Error("execute error", "zone", w.zone, "error", err)
+		return false
+	}
+	w.applied = led.Action
+	e.an.safety.Observe(w.zone, led.Interlocks)
+	e.an.ObserveIssued(w.zone, led.Action)
+	e.diag.Issued(cmds)
+	if e.acks.Enabled() {
+		e.acks.Issued(cmds)
+	}
+	return true
+}
+
+// checkSilent switches a zone that stopped reporting to its safe state, once per outage.
+// Zones that never reported are measured from engine start. The safe state releases any
+// budget grant the zone held.
+func (e *Engine) checkSilent(ctx context.Context, w *zoneWorker) {
+	limit := e.cfg.Safety.StaleAfter
+	if limit <= 0 || w.lost {
+		return
+	}
+	silent := time.Since(w.seen)
+	if silent <= limit {
+		return
+	}
+	if !e.acquire(ctx) {
+		return
+	}
+	defer e.release()
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	ilk := Interlock{Type: InterlockStaleData, Reason: fmt.Sprintf("no report for %s (limit %s)", silent.Round(time.Second), limit)}
+	e.lg.Warn("sensor interlock", "zone", w.zone, "type", ilk.Type, "reason", ilk.Reason)
+	res := e.an.safety.Fallback(ilk)
+	alloc := e.bud.Allocate([]zoneRequest{{Zone: w.zone, Res: &res}})
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	cmds, led := e.pln.Build(w.zone, w.epoch, now, now, res)
+	if a, ok := alloc[w.zone]; ok {
+		led.Budget = &a
+	}
+	w.lost = e.executeExplained(ctx, w, Reading{ZoneID: w.zone, EpochIndex: w.epoch}, true, res, cmds, led)
+}
+
+func (e *Engine) onAck(ack CommandAck) {
+	if ev := e.acks.Acked(ack); ev != nil {
+		e.raise(context.Background(), *ev)
+	}
+}
+
+// reconcile periodically re-sends unacknowledged commands and raises faults for
+// actuators that exhausted their re-sends.
+func (e *Engine) reconcile(ctx context.Context) {
+	every := e.cfg.Ack.Timeout / 2
+	if every < 250*time.Millisecond {
+		every = 250 * time.Millisecond
+	}
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		cfgMu.RLock()
+		resend, events := e.acks.Due()
+		byZone := map[string][]PlanCommand{}
+		for _, c := range resend {
+			byZone[c.ZoneID] = append(byZone[c.ZoneID], c)
+		}
+		for zone, cmds := range byZone {
+			if err := e.io.PublishCommands(ctx, zone, cmds); err != nil {
+				e.lg.Error("command re-send", "zone", zone, "error", err)
 				continue
 			}
-			e.stats.CommandsOut += int64(len(cmds))
-			e.stats.LedgerWrites++
+			e.resends.Add(int64(len(cmds)))
+			e.lg.Warn("commands re-sent", "zone", zone, "count", len(cmds))
 		}
-		e.stats.Loops++
-		time.Sleep(interval)
+		for _, ev := range events {
+			e.raise(ctx, ev)
+		}
+		cfgMu.RUnlock()
+	}
+}
+
+func (e *Engine) raise(ctx context.Context, ev AnomalyEvent) {
+	e.lg.Warn("actuator anomaly", "type", ev.Type, "zone", ev.ZoneID, "actuator", ev.ActuatorID, "desired", ev.Desired, "reported", ev.Reported, "reason", ev.Reason)
+	if e.io == nil {
+		return
+	}
+	if err := e.io.PublishAnomaly(ctx, ev); err != nil {
+		e.lg.Error("anomaly publish", "actuator", ev.ActuatorID, "error", err)
+	}
+}
+
+// snapshot copies the counters and per-zone figures under the lock.
+func (e *Engine) snapshot() Stats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st := e.stats
+	st.ZoneEnergy = make(map[string]float64, len(e.stats.ZoneEnergy))
+	for z, v := range e.stats.ZoneEnergy {
+		st.ZoneEnergy[z] = v
+	}
+	st.EnergyField = make(map[string]string, len(e.stats.EnergyField))
+	for z, v := range e.stats.EnergyField {
+		st.EnergyField[z] = v
+	}
+	st.Zones = make(map[string]ZoneLoopStats, len(e.zones))
+	for z, zs := range e.zones {
+		st.Zones[z] = *zs
+	}
+	return st
+}
+
+// globalDecisions returns the running engine's decision history, or nil before the engine
+// exists.
+func globalDecisions() *DecisionLog {
+	if engineRef == nil {
+		return nil
+	}
+	return engineRef.dec
+}
+
+// globalDiagnostics returns the running engine's actuator diagnostics, or nil before the
+// engine exists.
+func globalDiagnostics() *Diagnostics {
+	if engineRef == nil {
+		return nil
+	}
+	return engineRef.diag
+}
+
+// globalOccupancy returns the running engine's occupancy tracker, or nil before the engine
+// exists.
+func globalOccupancy() *Occupancy {
+	if engineRef == nil {
+		return nil
+	}
+	return engineRef.an.occ
+}
+
+// globalShadow returns the running engine's shadow log, or nil before the engine exists.
+func globalShadow() *ShadowLog {
+	if engineRef == nil {
+		return nil
 	}
+	return engineRef.shad
 }
 
 func globalStats() Stats {
 	if engineRef == nil {
 		return Stats{}
 	}
-	return engineRef.stats
+	st := engineRef.snapshot()
+	st.MPC = engineRef.an.MPCStatus()
+	st.Resends = engineRef.resends.Load()
+	st.Actuators = engineRef.acks.Status()
+	st.Faulty = engineRef.acks.Faulty()
+	st.Runtime = engineRef.pln.staging.Status()
+	st.Budget = engineRef.bud.Status()
+	safety := engineRef.an.safety.Status()
+	st.Safety = &safety
+	return st
 }
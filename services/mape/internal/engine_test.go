@@ -0,0 +1,253 @@
+// v3
+// services/mape/internal/engine_test.go
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+type chanSource map[string]chan Reading
+
+func (s chanSource) NextZoneReading(ctx context.Context, zone string) (Reading, error) {
+	select {
+	case r := <-s[zone]:
+		return r, nil
+	case <-ctx.Done():
+		return Reading{}, ctx.Err()
+	}
+}
+
+// trackedSource is a chanSource that records the epochs reported as planned.
+type trackedSource struct {
+	chanSource
+	mu      sync.Mutex
+	planned []int64
+}
+
+func (s *trackedSource) Planned(_ string, read Reading) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.planned = append(s.planned, read.EpochIndex)
+}
+
+func (s *trackedSource) epochs() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int64(nil), s.planned...)
+}
+
+// gatedPublisher records published ledger events per zone; zones with a gate block until
+// it closes, counting the publishes that reached it.
+type gatedPublisher struct {
+	mu      sync.Mutex
+	gates   map[string]chan struct{}
+	got     map[string][]LedgerEvent
+	blocked map[string]int
+}
+
+func (p *gatedPublisher) PublishCommandsAndLedger(ctx context.Context, zone string, _ []PlanCommand, led LedgerEvent) error {
+	if g, ok := p.gates[zone]; ok {
+		p.mu.Lock()
+		if p.blocked == nil {
+			p.blocked = map[string]int{}
+		}
+		p.blocked[zone]++
+		p.mu.Unlock()
+		select {
+		case <-g:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.got[zone] = append(p.got[zone], led)
+	return nil
+}
+
+func (p *gatedPublisher) events(zone string) []LedgerEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]LedgerEvent(nil), p.got[zone]...)
+}
+
+func (p *gatedPublisher) gated(zone string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.blocked[zone]
+}
+
+func (p *gatedPublisher) epochs(zone string) []int64 {
+	var out []int64
+	for _, led := range p.events(zone) {
+		out = append(out, led.EpochIndex)
+	}
+	return out
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEngineZonesRunIndependently(t *testing.T) {
+	cfg := &AppConfig{
+		Zones:          []string{"zone-A", "zone-B"},
+		ZoneTargets:    map[string]float64{"zone-A": 21, "zone-B": 21},
+		ZoneHysteresis: map[string]float64{"zone-A": 0.5, "zone-B": 0.5},
+		FanSteps:       []float64{0.5, 1.0, 2.0},
+		FanSpeeds:      []int{0, 25, 50, 100},
+		Actuators:      map[string]ZoneActuators{"zone-A": {Heating: []string{"h1"}}, "zone-B": {Heating: []string{"h2"}}},
+		PollIntervalMs: 10,
+		Engine:         DefaultEngineParams(),
+	}
+	store, err := NewZoneSetpoints(cfg.Zones, cfg.ZoneTargets, 10.0, 35.0)
+	if err != nil {
+		t.Fatalf("setpoints: %v", err)
+	}
+	prev := engineRef
+	defer func() { engineRef = prev }()
+	e := NewEngine(cfg, store, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	src := chanSource{"zone-A": make(chan Reading), "zone-B": make(chan Reading)}
+	gateA := make(chan struct{})
+	pub := &gatedPublisher{gates: map[string]chan struct{}{"zone-A": gateA}, got: map[string][]LedgerEvent{}}
+	e.mon.src, e.exe.pub = src, pub
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	read := func(zone string, epoch int64) Reading {
+		return Reading{ZoneID: zone, EpochIndex: epoch, AvgTempC: 19, HasTemp: true}
+	}
+
+	// zone-A's publish hangs; zone-B is still served.
+	src["zone-A"] <- read("zone-A", 1)
+	src["zone-B"] <- read("zone-B", 1)
+	waitFor(t, "zone-B plan", func() bool { return len(pub.epochs("zone-B")) == 1 })
+
+	// While zone-A is stuck, epoch 3 supersedes epoch 2 in its mailbox.
+	src["zone-A"] <- read("zone-A", 2)
+	src["zone-A"] <- read("zone-A", 3)
+	waitFor(t, "superseded report", func() bool { return globalStats().Zones["zone-A"].Superseded == 1 })
+	close(gateA)
+	waitFor(t, "zone-A plans", func() bool { return len(pub.epochs("zone-A")) == 2 })
+	if got := pub.epochs("zone-A"); got[0] != 1 || got[1] != 3 {
+		t.Fatalf("expected epochs 1 and 3, got %v", got)
+	}
+
+	st := globalStats()
+	za := st.Zones["zone-A"]
+	if za.Rounds != 2 || za.LastEpoch != 3 || za.Behind || za.MaxLatencyMs < za.LastLatencyMs || za.MaxExecuteMs <= 0 {
+		t.Fatalf("unexpected zone-A stats: %+v", za)
+	}
+	if st.MessagesIn != 4 || st.Loops != 3 || st.LedgerWrites != 3 {
+		t.Fatalf("unexpected totals: %+v", st)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("engine did not stop")
+	}
+}
+
+func TestEngineProcessesEveryEpoch(t *testing.T) {
+	for _, catchUp := range []string{CatchUpAll, CatchUpLatest} {
+		t.Run(catchUp, func(t *testing.T) {
+			cfg := &AppConfig{
+				Zones:          []string{"zone-A"},
+				ZoneTargets:    map[string]float64{"zone-A": 21},
+				ZoneHysteresis: map[string]float64{"zone-A": 0.5},
+				FanSteps:       []float64{0.5, 1.0, 2.0},
+				FanSpeeds:      []int{0, 25, 50, 100},
+				Actuators:      map[string]ZoneActuators{"zone-A": {Heating: []string{"h1"}}},
+				PollIntervalMs: 10,
+				Engine:         DefaultEngineParams(),
+			}
+			cfg.Engine.Consume, cfg.Engine.CatchUp = ConsumeEvery, catchUp
+			store, err := NewZoneSetpoints(cfg.Zones, cfg.ZoneTargets, 10.0, 35.0)
+			if err != nil {
+				t.Fatalf("setpoints: %v", err)
+			}
+			prev := engineRef
+			defer func() { engineRef = prev }()
+			e := NewEngine(cfg, store, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+			src := &trackedSource{chanSource: chanSource{"zone-A": make(chan Reading)}}
+			gate := make(chan struct{})
+			pub := &gatedPublisher{gates: map[string]chan struct{}{"zone-A": gate}, got: map[string][]LedgerEvent{}}
+			e.mon.src, e.exe.pub = src, pub
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go e.Run(ctx)
+
+			// Epoch 1's publish hangs while epochs 2 to 4 queue up behind it.
+			box := e.workers["zone-A"].box
+			for epoch := int64(1); epoch <= 4; epoch++ {
+				src.chanSource["zone-A"] <- Reading{ZoneID: "zone-A", EpochIndex: epoch, AvgTempC: 19, HasTemp: true}
+				if epoch == 1 {
+					waitFor(t, "epoch 1 publishing", func() bool { return pub.gated("zone-A") == 1 })
+				}
+			}
+			waitFor(t, "queued reports", func() bool { return len(box.ch) == 3 })
+			close(gate)
+			waitFor(t, "every epoch on the ledger", func() bool { return len(pub.epochs("zone-A")) == 4 })
+			leds := pub.events("zone-A")
+			for i, led := range leds {
+				if led.EpochIndex != int64(i+1) {
+					t.Fatalf("epochs out of order: %v", pub.epochs("zone-A"))
+				}
+				skipped := catchUp == CatchUpLatest && (i == 1 || i == 2)
+				if skipped != (led.SkippedFor == 4) || skipped != (led.Planned == "skipped") {
+					t.Fatalf("epoch %d: unexpected event %+v", led.EpochIndex, led)
+				}
+				if skipped && (led.Action != "HEAT" || led.TempC == nil) {
+					t.Fatalf("a skipped epoch keeps the applied action: %+v", led)
+				}
+			}
+			za := globalStats().Zones["zone-A"]
+			wantRounds, wantSkipped := int64(4), int64(0)
+			if catchUp == CatchUpLatest {
+				wantRounds, wantSkipped = 2, 2
+			}
+			if za.Rounds != wantRounds || za.Skipped != wantSkipped || za.Superseded != 0 || za.LastEpoch != 4 || za.Queued != 0 {
+				t.Fatalf("unexpected zone stats: %+v", za)
+			}
+			// Skipped epochs precede the planned one, so resuming after it loses none.
+			want := "[1 2 3 4]"
+			if catchUp == CatchUpLatest {
+				want = "[1 4]"
+			}
+			if got := fmt.Sprint(src.epochs()); got != want {
+				t.Fatalf("expected planned epochs %s, got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestMailboxKeepsLatest(t *testing.T) {
+	m := newMailbox()
+	if m.put(inbound{read: Reading{EpochIndex: 1}}) {
+		t.Fatalf("empty mailbox cannot supersede")
+	}
+	if !m.put(inbound{read: Reading{EpochIndex: 2}}) || !m.waiting() {
+		t.Fatalf("second report must replace the first")
+	}
+	if in := <-m.ch; in.read.EpochIndex != 2 || m.waiting() {
+		t.Fatalf("expected only epoch 2, got %d", in.read.EpochIndex)
+	}
+}
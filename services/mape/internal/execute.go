@@ -1,4 +1,4 @@
-// Package internal v7
+// Package internal v8
 // execute.go
 package internal
 
@@ -7,14 +7,25 @@ import (
 	"log/slog"
 )
 
+// planPublisher delivers a zone's commands and ledger event.
+type planPublisher interface {
+	PublishCommandsAndLedger(ctx context.Context, zone string, cmds []PlanCommand, led LedgerEvent) error
+}
+
 type Execute struct {
-	lg *slog.Logger
-	io *KafkaIO
+	lg  *slog.Logger
+	pub planPublisher
 }
 
-func NewExecute(lg *slog.Logger, io *KafkaIO) *Execute { return &Execute{lg: lg, io: io} }
+func NewExecute(lg *slog.Logger, io *KafkaIO) *Execute {
+	x := &Execute{lg: lg}
+	if io != nil {
+		x.pub = io
+	}
+	return x
+}
 
 func (x *Execute) Do(ctx context.Context, zone string, cmds []PlanCommand, led LedgerEvent) error {
 	x.lg.Info("execute.publish", "zone", zone, "commands", len(cmds))
-	return x.io.PublishCommandsAndLedger(ctx, zone, cmds, led)
+	return x.pub.PublishCommandsAndLedger(ctx, zone, cmds, led)
 }
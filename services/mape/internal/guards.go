@@ -0,0 +1,233 @@
+// v3
+// services/mape/internal/guards.go
+package internal
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// GuardParams protects compressors and boilers from short cycling. Zero values disable
+// the corresponding check.
+type GuardParams struct {
+	MinOn            time.Duration // minimum run time once started
+	MinOff           time.Duration // minimum rest time once stopped
+	MaxStartsPerHour int           // starts allowed in any rolling hour
+}
+
+func (g *GuardParams) set(param string, v float64) error {
+	switch param {
+	case "min_on_s":
+		g.MinOn = time.Duration(v * float64(time.Second))
+	case "min_off_s":
+		g.MinOff = time.Duration(v * float64(time.Second))
+	case "max_starts_per_hour":
+		g.MaxStartsPerHour = int(v)
+	default:
+		return fmt.Errorf("unknown guard parameter %q", param)
+	}
+	return nil
+}
+
+type actuatorState struct {
+	on     bool
+	since  time.Time
+	starts []time.Time // start times within the last hour
+}
+
+// ActuatorGuards keeps the on/off history of every heater and cooler and decides whether a
+// requested zone action may be applied now. Ventilation is not guarded.
+type ActuatorGuards struct {
+	cfg    *AppConfig
+	mu     sync.Mutex
+	state  map[string]*actuatorState
+	action map[string]string // last applied action per zone
+	now    func() time.Time
+}
+
+func NewActuatorGuards(cfg *AppConfig) *ActuatorGuards {
+	return &ActuatorGuards{cfg: cfg, state: map[string]*actuatorState{}, action: map[string]string{}, now: time.Now}
+}
+
+// Check returns the action that may be applied for the zone given the requested one, with
+// a non-empty reason when the request was suppressed. Stopping the running mode is refused
+// while any of its actuators is within its minimum run time (the zone keeps its current
+// action); starting a mode is refused while none of its actuators may start because each
+// is resting or has exhausted its starts (the zone falls back to OFF). Nothing is recorded
+// until Commit.
+func (g *ActuatorGuards) Check(zone, requested string) (string, string) {
+	params := g.cfg.GuardFor(zone)
+	acts := g.cfg.Actuators[zone]
+	now := g.now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.check(zone, requested, acts, params, now)
+}
+
+// Apply is Check followed by recording every actuator of the resulting mode as running,
+// for callers that switch a zone's units together.
+func (g *ActuatorGuards) Apply(zone, requested string) (string, string) {
+	params := g.cfg.GuardFor(zone)
+	acts := g.cfg.Actuators[zone]
+	now := g.now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	effective, reason := g.check(zone, requested, acts, params, now)
+	g.record(modeActuators(acts, "HEAT"), effective == "HEAT", now)
+	g.record(modeActuators(acts, "COOL"), effective == "COOL", now)
+	g.action[zone] = effective
+	return effective, reason
+}
+
+// Commit records the action applied for the zone and, per heater and cooler, whether its
+// final command switches it ON. Units staged on and off one by one are timed and counted
+// individually, and actions imposed by safety interlocks are recorded as commanded.
+func (g *ActuatorGuards) Commit(zone, action string, cmds []PlanCommand) {
+	acts := g.cfg.Actuators[zone]
+	guarded := map[string]bool{}
+	for _, id := range append(append([]string(nil), acts.Heating...), acts.Cooling...) {
+		guarded[id] = true
+	}
+	on := map[string]bool{}
+	for _, c := range cmds {
+		if guarded[c.ActuatorID] && c.Mode == "ON" {
+			on[c.ActuatorID] = true
+		}
+	}
+	now := g.now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for id := range guarded {
+		g.record([]string{id}, on[id], now)
+	}
+	g.action[zone] = action
+}
+
+// mustRun reports whether the unit is running and within its minimum run time.
+func (g *ActuatorGuards) mustRun(id string, p GuardParams, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	id, _ = g.mustKeepRunning([]string{id}, p, now)
+	return id != ""
+}
+
+// mayStart reports whether the unit is running or may be started now.
+func (g *ActuatorGuards) mayStart(id string, p GuardParams, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.startBlocked(id, p, now) == ""
+}
+
+// Forget drops the last action of a removed zone, when zone is not empty, and the
+// history of the given actuators.
+func (g *ActuatorGuards) Forget(zone string, ids []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if zone != "" {
+		delete(g.action, zone)
+	}
+	for _, id := range ids {
+		delete(g.state, id)
+	}
+}
+
+func modeActuators(acts ZoneActuators, action string) []string {
+	switch action {
+	case "HEAT":
+		return acts.Heating
+	case "COOL":
+		return acts.Cooling
+	}
+	return nil
+}
+
+func (g *ActuatorGuards) get(id string) *actuatorState {
+	st, ok := g.state[id]
+	if !ok {
+		st = &actuatorState{}
+		g.state[id] = st
+	}
+	return st
+}
+
+func (g *ActuatorGuards) mustKeepRunning(ids []string, p GuardParams, now time.Time) (string, time.Duration) {
+	if p.MinOn <= 0 {
+		return "", 0
+	}
+	for _, id := range ids {
+		st := g.get(id)
+		if ran := now.Sub(st.since); st.on && ran < p.MinOn {
+			return id, p.MinOn - ran
+		}
+	}
+	return "", 0
+}
+
+func (g *ActuatorGuards) check(zone, requested string, acts ZoneActuators, params GuardParams, now time.Time) (string, string) {
+	current := g.action[zone]
+	if current == "" {
+		current = "OFF"
+	}
+	if requested == current {
+		return requested, ""
+	}
+	if id, left := g.mustKeepRunning(modeActuators(acts, current), params, now); id != "" {
+		return current, fmt.Sprintf("%s suppressed: %s must run %s more (min on %s)", requested, id, left.Round(time.Second), params.MinOn)
+	}
+	if why := g.cannotStart(modeActuators(acts, requested), params, now); why != "" {
+		return "OFF", fmt.Sprintf("%s suppressed: %s", requested, why)
+	}
+	return requested, ""
+}
+
+// cannotStart returns why none of ids may start, or "" when one may.
+func (g *ActuatorGuards) cannotStart(ids []string, p GuardParams, now time.Time) string {
+	why := ""
+	for _, id := range ids {
+		w := g.startBlocked(id, p, now)
+		if w == "" {
+			return ""
+		}
+		if why == "" {
+			why = w
+		}
+	}
+	return why
+}
+
+func (g *ActuatorGuards) startBlocked(id string, p GuardParams, now time.Time) string {
+	st := g.get(id)
+	if st.on {
+		return ""
+	}
+	if rested := now.Sub(st.since); p.MinOff > 0 && !st.since.IsZero() && rested < p.MinOff {
+		return fmt.Sprintf("%s must rest %s more (min off %s)", id, (p.MinOff - rested).Round(time.Second), p.MinOff)
+	}
+	st.starts = pruneStarts(st.starts, now)
+	if p.MaxStartsPerHour > 0 && len(st.starts) >= p.MaxStartsPerHour {
+		return fmt.Sprintf("%s reached %d starts in the last hour", id, p.MaxStartsPerHour)
+	}
+	return ""
+}
+
+func (g *ActuatorGuards) record(ids []string, on bool, now time.Time) {
+	for _, id := range ids {
+		st := g.get(id)
+		if st.on == on {
+			continue
+		}
+		st.on, st.since = on, now
+		if on {
+			st.starts = append(pruneStarts(st.starts, now), now)
+		}
+	}
+}
+
+func pruneStarts(starts []time.Time, now time.Time) []time.Time {
+	cut := 0
+	for cut < len(starts) && now.Sub(starts[cut]) >= time.Hour {
+		cut++
+	}
+	return starts[cut:]
+}
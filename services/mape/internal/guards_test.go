@@ -0,0 +1,78 @@
+// v0
+// services/mape/internal/guards_test.go
+package internal
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActuatorGuardsMinTimesAndStarts(t *testing.T) {
+	cfg := &AppConfig{
+		Actuators: map[string]ZoneActuators{"zone-A": {Heating: []string{"h1"}, Cooling: []string{"c1"}}},
+		Guard:     GuardParams{MinOn: 3 * time.Minute, MinOff: 2 * time.Minute, MaxStartsPerHour: 2},
+	}
+	g := NewActuatorGuards(cfg)
+	now := time.Date(2025, 1, 6, 8, 0, 0, 0, time.UTC)
+	g.now = func() time.Time { return now }
+	step := func(d time.Duration, requested string) (string, string) {
+		now = now.Add(d)
+		return g.Apply("zone-A", requested)
+	}
+
+	if got, why := step(0, "HEAT"); got != "HEAT" || why != "" {
+		t.Fatalf("first start must pass, got %s (%s)", got, why)
+	}
+	if got, why := step(time.Second, "OFF"); got != "HEAT" || !strings.Contains(why, "min on") {
+		t.Fatalf("expected heater held by min on time, got %s (%s)", got, why)
+	}
+	if got, why := step(time.Second, "COOL"); got != "HEAT" || why == "" {
+		t.Fatalf("switching to cooling must wait for min on time too, got %s (%s)", got, why)
+	}
+	if got, _ := step(3*time.Minute, "OFF"); got != "OFF" {
+		t.Fatalf("expected OFF after min on time, got %s", got)
+	}
+	if got, why := step(time.Minute, "HEAT"); got != "OFF" || !strings.Contains(why, "min off") {
+		t.Fatalf("expected restart blocked by min off time, got %s (%s)", got, why)
+	}
+	if got, _ := step(time.Minute, "HEAT"); got != "HEAT" {
+		t.Fatalf("expected second start after rest, got %s", got)
+	}
+	step(3*time.Minute, "OFF")
+	if got, why := step(3*time.Minute, "HEAT"); got != "OFF" || !strings.Contains(why, "starts") {
+		t.Fatalf("expected start limit to block a third start, got %s (%s)", got, why)
+	}
+	if got, _ := step(time.Hour, "HEAT"); got != "HEAT" {
+		t.Fatalf("expected starts to be available again after an hour, got %s", got)
+	}
+}
+
+func TestPlanRecordsSuppressedAction(t *testing.T) {
+	cfg := &AppConfig{
+		ZoneTargets: map[string]float64{"zone-A": 22},
+		FanSteps:    []float64{1}, FanSpeeds: []int{50},
+		Actuators: map[string]ZoneActuators{"zone-A": {Heating: []string{"h1"}, Ventilation: []string{"v1"}}},
+		Guard:     GuardParams{MinOn: time.Hour},
+	}
+	store, err := NewZoneSetpoints([]string{"zone-A"}, cfg.ZoneTargets, 10, 35)
+	if err != nil {
+		t.Fatalf("setpoints: %v", err)
+	}
+	p := NewPlan(cfg, store, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	p.Build("zone-A", 1, "", "", AnalysisResult{Action: "HEAT", Fan: 50, Target: 22, Delta: -1})
+	cmds, led := p.Build("zone-A", 2, "", "", AnalysisResult{Action: "OFF", Target: 22, Delta: 0})
+	if led.Action != "HEAT" || led.Requested != "OFF" || led.Suppressed == "" {
+		t.Fatalf("ledger must record the suppression, got %+v", led)
+	}
+	for _, c := range cmds {
+		if c.ActuatorID == "h1" && c.Mode != "ON" {
+			t.Fatalf("heater must stay ON while suppressed, got %s", c.Mode)
+		}
+		if c.ActuatorID == "v1" && c.FanPercent == 0 {
+			t.Fatalf("ventilation must keep running with the heater")
+		}
+	}
+}
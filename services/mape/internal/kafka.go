@@ -1,4 +1,4 @@
-// v11
+// v23
 // services/mape/internal/kafka.go
 package internal
 
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
 	circuitbreaker "github.com/nrg-champ/circuitbreaker"
@@ -15,15 +16,39 @@ import (
 )
 
 type KafkaIO struct {
-	cfg *AppConfig
-	lg  *slog.Logger
+	lg *slog.Logger
+	// Broker, topic and partition settings come from the environment, which a reload
+	// leaves alone; they are copied so that publishing never reads the live config.
+	brokers       []string
+	aggTopic      string
+	ledgerPref    string
+	mapePartition int
 
-	zoneReaders    map[string]*kafka.Reader
-	zoneCBReaders  map[string]*circuitbreaker.CBKafkaReader
-	actuatorWriter map[string]*kafka.Writer
-	actuatorCB     map[string]*circuitbreaker.CBKafkaWriter
-	ledgerWriter   map[string]*kafka.Writer
-	ledgerCB       map[string]*circuitbreaker.CBKafkaWriter
+	readerBreaker *circuitbreaker.KafkaBreaker
+	writerBreaker *circuitbreaker.KafkaBreaker
+	// positions holds the last planned offset per partition with engine.consume=every;
+	// nil otherwise.
+	positions *readPositions
+
+	// mu guards zones, which a properties reload may change while workers publish.
+	mu            sync.RWMutex
+	zones         map[string]*zoneIO
+	anomalyWriter *kafka.Writer
+	anomalyCB     *circuitbreaker.CBKafkaWriter
+}
+
+// zoneIO is the Kafka plumbing of one zone: its Aggregator -> MAPE partition reader, the
+// actuator and ledger writers and, with acknowledgements enabled, the ack reader.
+type zoneIO struct {
+	partition  int
+	reader     *kafka.Reader
+	readerCB   *circuitbreaker.CBKafkaReader
+	actuator   *kafka.Writer
+	actuatorCB *circuitbreaker.CBKafkaWriter
+	ledger     *kafka.Writer
+	ledgerCB   *circuitbreaker.CBKafkaWriter
+	ack        *kafka.Reader
+	ackCB      *circuitbreaker.CBKafkaReader
 }
 
 func NewKafkaIO(cfg *AppConfig, lg *slog.Logger) (*KafkaIO, error) {
@@ -39,47 +64,104 @@ func NewKafkaIO(cfg *AppConfig, lg *slog.Logger) (*KafkaIO, error) {
 		return nil, fmt.Errorf("writer breaker: %w", err)
 	}
 	io := &KafkaIO{
-		cfg:            cfg,
-		lg:             lg,
-		zoneReaders:    map[string]*kafka.Reader{},
-		zoneCBReaders:  map[string]*circuitbreaker.CBKafkaReader{},
-		actuatorWriter: map[string]*kafka.Writer{},
-		actuatorCB:     map[string]*circuitbreaker.CBKafkaWriter{},
-		ledgerWriter:   map[string]*kafka.Writer{},
-		ledgerCB:       map[string]*circuitbreaker.CBKafkaWriter{},
-	}
-	if err := io.ensureTopics(context.Background()); err != nil {
+		brokers:       cfg.KafkaBrokers,
+		aggTopic:      cfg.AggregatorTopic,
+		ledgerPref:    cfg.LedgerTopicPref,
+		mapePartition: cfg.MAPEPartitionID,
+		lg:            lg,
+		readerBreaker: readerBreaker,
+		writerBreaker: writerBreaker,
+		zones:         map[string]*zoneIO{},
+	}
+	// The zone readers join no consumer group and start at the tail of their partition,
+	// so a restart does not plan and publish the backlog before the live epoch. A queue
+	// that plans every epoch resumes each partition right after the last report it
+	// planned, as recorded in MAPE_OFFSET_STORE: epochs published while MAPE was down are
+	// planned in order and none is planned twice.
+	if cfg.Engine.Consume == ConsumeEvery {
+		pos, err := newReadPositions(cfg.OffsetStorePath, lg)
+		if err != nil {
+			return nil, err
+		}
+		io.positions = pos
+	}
+	if err := io.ensureTopics(context.Background(), cfg, cfg.Zones); err != nil {
 		return nil, err
 	}
 	lg.Info("kafka breaker", "component", "reader", "enabled", readerBreaker != nil && readerBreaker.Enabled())
 	lg.Info("kafka breaker", "component", "writer", "enabled", writerBreaker != nil && writerBreaker.Enabled())
 	for idx, zone := range cfg.Zones {
-		rawReader := kafka.NewReader(kafka.ReaderConfig{
-			Brokers:   cfg.KafkaBrokers,
-			Topic:     cfg.AggregatorTopic,
-			Partition: idx, // one partition per zone (Aggregator -> MAPE)
-			MinBytes:  1, MaxBytes: 10e6, MaxWait: 200 * time.Millisecond,
-		})
-		io.zoneReaders[zone] = rawReader
-		io.zoneCBReaders[zone] = circuitbreaker.NewCBKafkaReader(rawReader, readerBreaker)
-		act := cfg.ActuatorTopicPref + zone
-		led := cfg.LedgerTopicPref + zone
-		rawActuator := &kafka.Writer{Addr: kafka.TCP(cfg.KafkaBrokers...), Topic: act, Balancer: &kafka.Hash{}, RequiredAcks: kafka.RequireAll}
-		rawLedger := &kafka.Writer{Addr: kafka.TCP(cfg.KafkaBrokers...), Topic: led, RequiredAcks: kafka.RequireAll}
-		io.actuatorWriter[zone] = rawActuator
-		io.ledgerWriter[zone] = rawLedger
-		io.actuatorCB[zone] = circuitbreaker.NewCBKafkaWriter(rawActuator, writerBreaker)
-		io.ledgerCB[zone] = circuitbreaker.NewCBKafkaWriter(rawLedger, writerBreaker)
-		lg.Info("kafka wired", "zone", zone, "aggTopic", cfg.AggregatorTopic, "partition", idx, "actTopic", act, "ledgerTopic", led)
+		io.zones[zone] = io.openZone(cfg, zone, idx)
 	}
+	io.anomalyWriter = &kafka.Writer{Addr: kafka.TCP(cfg.KafkaBrokers...), Topic: cfg.AnomalyTopic, Balancer: &kafka.Hash{}, RequiredAcks: kafka.RequireAll}
+	io.anomalyCB = circuitbreaker.NewCBKafkaWriter(io.anomalyWriter, writerBreaker)
 	return io, nil
 }
 
-func (ioh *KafkaIO) ensureTopics(ctx context.Context) error {
-	broker := ioh.cfg.KafkaBrokers[0]
+// openZone creates the zone's readers and writers; partition is its Aggregator -> MAPE
+// partition, the zone's position in the zones list.
+func (ioh *KafkaIO) openZone(cfg *AppConfig, zone string, partition int) *zoneIO {
+	z := &zoneIO{partition: partition}
+	ioh.openReader(cfg, z)
+	act := cfg.ActuatorTopicPref + zone
+	led := cfg.LedgerTopicPref + zone
+	z.actuator = &kafka.Writer{Addr: kafka.TCP(cfg.KafkaBrokers...), Topic: act, Balancer: &kafka.Hash{}, RequiredAcks: kafka.RequireAll}
+	z.ledger = &kafka.Writer{Addr: kafka.TCP(cfg.KafkaBrokers...), Topic: led, RequiredAcks: kafka.RequireAll}
+	z.actuatorCB = circuitbreaker.NewCBKafkaWriter(z.actuator, ioh.writerBreaker)
+	z.ledgerCB = circuitbreaker.NewCBKafkaWriter(z.ledger, ioh.writerBreaker)
+	if cfg.Ack.Timeout > 0 {
+		z.ack = kafka.NewReader(kafka.ReaderConfig{
+			Brokers: cfg.KafkaBrokers, Topic: cfg.AckTopicPref + zone, GroupID: "mape-acks",
+			MinBytes: 1, MaxBytes: 10e6, MaxWait: 200 * time.Millisecond,
+		})
+		z.ackCB = circuitbreaker.NewCBKafkaReader(z.ack, ioh.readerBreaker)
+	}
+	ioh.lg.Info("kafka wired", "zone", zone, "aggTopic", cfg.AggregatorTopic, "partition", partition, "actTopic", act, "ledgerTopic", led)
+	return z
+}
+
+// openReader opens the reader of the zone's Aggregator -> MAPE partition.
+func (ioh *KafkaIO) openReader(cfg *AppConfig, z *zoneIO) {
+	z.reader = kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     cfg.KafkaBrokers,
+		Topic:       cfg.AggregatorTopic,
+		Partition:   z.partition, // one partition per zone (Aggregator -> MAPE)
+		StartOffset: kafka.LastOffset,
+		MinBytes:    1, MaxBytes: 10e6, MaxWait: 200 * time.Millisecond,
+	})
+	if off, ok := ioh.positions.next(cfg.AggregatorTopic, z.partition); ok {
+		if err := z.reader.SetOffset(off); err != nil {
+			ioh.lg.Error("zone reader resume failed", "partition", z.partition, "offset", off, "error", err)
+		} else {
+			ioh.lg.Info("zone reader resumed", "partition", z.partition, "offset", off)
+		}
+	}
+	z.readerCB = circuitbreaker.NewCBKafkaReader(z.reader, ioh.readerBreaker)
+}
+
+func (z *zoneIO) close() {
+	_ = z.reader.Close()
+	_ = z.actuator.Close()
+	_ = z.ledger.Close()
+	if z.ack != nil {
+		_ = z.ack.Close()
+	}
+}
+
+// zone returns the plumbing of a configured zone.
+func (ioh *KafkaIO) zone(name string) (*zoneIO, bool) {
+	ioh.mu.RLock()
+	defer ioh.mu.RUnlock()
+	z, ok := ioh.zones[name]
+	return z, ok
+}
+
+// controller dials the cluster controller; the caller closes the connection.
+func (ioh *KafkaIO) controller(ctx context.Context) (*kafka.Conn, error) {
+	broker := ioh.brokers[0]
 	conn, err := kafka.DialContext(ctx, "tcp", broker)
 	if err != nil {
-		return fmt.Errorf("dial broker: %w", err)
+		return nil, fmt.Errorf("dial broker: %w", err)
 	}
 	defer func(conn *kafka.Conn) {
 		err := conn.Close()
@@ -89,11 +171,21 @@ func (ioh *KafkaIO) ensureTopics(ctx context.Context) error {
 	}(conn)
 	ctrl, err := conn.Controller()
 	if err != nil {
-		return fmt.Errorf("controller: %w", err)
+		return nil, fmt.Errorf("controller: %w", err)
 	}
 	c, err := kafka.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", ctrl.Host, ctrl.Port))
 	if err != nil {
-		return fmt.Errorf("dial controller: %w", err)
+		return nil, fmt.Errorf("dial controller: %w", err)
+	}
+	return c, nil
+}
+
+// ensureTopics creates the topics of the given zones under cfg, grows the aggregator topic
+// to one partition per configured zone and validates the zones' ledger topics.
+func (ioh *KafkaIO) ensureTopics(ctx context.Context, cfg *AppConfig, zones []string) error {
+	c, err := ioh.controller(ctx)
+	if err != nil {
+		return err
 	}
 	defer func(c *kafka.Conn) {
 		err := c.Close()
@@ -102,78 +194,201 @@ func (ioh *KafkaIO) ensureTopics(ctx context.Context) error {
 		}
 	}(c)
 
-	cfgs := []kafka.TopicConfig{{Topic: ioh.cfg.AggregatorTopic, NumPartitions: len(ioh.cfg.Zones), ReplicationFactor: ioh.cfg.TopicReplication}}
-	for _, z := range ioh.cfg.Zones {
-		cfgs = append(cfgs, kafka.TopicConfig{Topic: ioh.cfg.ActuatorTopicPref + z, NumPartitions: ioh.cfg.ActuatorPartitions, ReplicationFactor: ioh.cfg.TopicReplication})
+	cfgs := []kafka.TopicConfig{{Topic: cfg.AggregatorTopic, NumPartitions: len(cfg.Zones), ReplicationFactor: cfg.TopicReplication}}
+	for _, z := range zones {
+		cfgs = append(cfgs, kafka.TopicConfig{Topic: cfg.ActuatorTopicPref + z, NumPartitions: cfg.ActuatorPartitions, ReplicationFactor: cfg.TopicReplication})
+		if cfg.Ack.Timeout > 0 {
+			cfgs = append(cfgs, kafka.TopicConfig{Topic: cfg.AckTopicPref + z, NumPartitions: 1, ReplicationFactor: cfg.TopicReplication})
+		}
 	}
+	cfgs = append(cfgs, kafka.TopicConfig{Topic: cfg.AnomalyTopic, NumPartitions: 1, ReplicationFactor: cfg.TopicReplication})
 	if err := c.CreateTopics(cfgs...); err != nil {
 		ioh.lg.Warn("CreateTopics", "error", err)
 	}
-	ledgerTopics := make([]string, 0, len(ioh.cfg.Zones))
-	for _, z := range ioh.cfg.Zones {
-		ledgerTopics = append(ledgerTopics, ioh.cfg.LedgerTopicPref+z)
+	if err := ioh.growAggregatorTopic(ctx, c, cfg); err != nil {
+		return err
+	}
+	ledgerTopics := make([]string, 0, len(zones))
+	for _, z := range zones {
+		ledgerTopics = append(ledgerTopics, cfg.LedgerTopicPref+z)
 	}
 	if err := ioh.validateLedgerTopics(ctx, c, ledgerTopics); err != nil {
 		return err
 	}
-	ioh.lg.Info("topics ensured", "zones", ioh.cfg.Zones, "act.partitions", ioh.cfg.ActuatorPartitions)
+	ioh.lg.Info("topics ensured", "zones", zones, "act.partitions", cfg.ActuatorPartitions)
+	return nil
+}
+
+// growAggregatorTopic adds partitions to the aggregator topic when zones outnumber them.
+func (ioh *KafkaIO) growAggregatorTopic(ctx context.Context, c *kafka.Conn, cfg *AppConfig) error {
+	parts, err := c.ReadPartitions(cfg.AggregatorTopic)
+	if err != nil {
+		return fmt.Errorf("aggregator topic %s metadata: %w", cfg.AggregatorTopic, err)
+	}
+	if len(parts) >= len(cfg.Zones) {
+		return nil
+	}
+	client := &kafka.Client{Addr: kafka.TCP(cfg.KafkaBrokers...)}
+	res, err := client.CreatePartitions(ctx, &kafka.CreatePartitionsRequest{
+		Topics: []kafka.TopicPartitionsConfig{{Name: cfg.AggregatorTopic, Count: int32(len(cfg.Zones))}},
+	})
+	if err == nil {
+		err = res.Errors[cfg.AggregatorTopic]
+	}
+	if err != nil {
+		return fmt.Errorf("grow %s to %d partitions: %w", cfg.AggregatorTopic, len(cfg.Zones), err)
+	}
+	ioh.lg.Info("aggregator topic grown", "topic", cfg.AggregatorTopic, "from", len(parts), "to", len(cfg.Zones))
 	return nil
 }
 
+// Prepare ensures the topics that next, the configuration a reload is about to apply,
+// needs for added and moved zones. A reload that fails here changes nothing.
+func (ioh *KafkaIO) Prepare(ctx context.Context, next *AppConfig, added, moved []string) error {
+	if len(added) == 0 && len(moved) == 0 {
+		return nil
+	}
+	return ioh.ensureTopics(ctx, next, added)
+}
+
+// Rewire brings the zones in line with next once Prepare succeeded: plumbing for added
+// zones, readers reopened on moved partitions, and removed zones closed. Callers stop the
+// affected zones' workers first.
+func (ioh *KafkaIO) Rewire(next *AppConfig, added, moved, removed []string) {
+	index := make(map[string]int, len(next.Zones))
+	for i, z := range next.Zones {
+		index[z] = i
+	}
+	ioh.mu.Lock()
+	defer ioh.mu.Unlock()
+	for _, zone := range removed {
+		if z, ok := ioh.zones[zone]; ok {
+			z.close()
+			delete(ioh.zones, zone)
+			ioh.lg.Info("kafka unwired", "zone", zone)
+		}
+	}
+	for _, zone := range moved {
+		z, ok := ioh.zones[zone]
+		if !ok {
+			continue
+		}
+		_ = z.reader.Close()
+		z.partition = index[zone]
+		ioh.openReader(next, z)
+		ioh.lg.Info("zone partition moved", "zone", zone, "partition", z.partition)
+	}
+	for _, zone := range added {
+		ioh.zones[zone] = ioh.openZone(next, zone, index[zone])
+	}
+}
+
 func (ioh *KafkaIO) Close() {
-	for z, r := range ioh.zoneReaders {
-		_ = r.Close()
-		ioh.lg.Info("reader closed", "zone", z)
+	ioh.mu.Lock()
+	defer ioh.mu.Unlock()
+	for name, z := range ioh.zones {
+		z.close()
+		ioh.lg.Info("zone io closed", "zone", name)
+	}
+	if ioh.anomalyWriter != nil {
+		_ = ioh.anomalyWriter.Close()
 	}
-	for z, w := range ioh.actuatorWriter {
-		_ = w.Close()
-		ioh.lg.Info("actuator writer closed", "zone", z)
+}
+
+// ConsumeAcks delivers the zone's command acknowledgements to fn until ctx is done.
+func (ioh *KafkaIO) ConsumeAcks(ctx context.Context, zone string, fn func(CommandAck)) {
+	z, ok := ioh.zone(zone)
+	if !ok || z.ackCB == nil {
+		return
+	}
+	r, raw := z.ackCB, z.ack
+	for {
+		msg, err := r.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			ioh.lg.Warn("ack read", "zone", zone, "error", err)
+			time.Sleep(500 * time.Millisecond)
+			continue
+		}
+		var ack CommandAck
+		if err := json.Unmarshal(msg.Value, &ack); err != nil {
+			ioh.lg.Error("bad ack json", "zone", zone, "error", err)
+		} else {
+			fn(ack)
+		}
+		if err := raw.CommitMessages(ctx, msg); err != nil && ctx.Err() == nil {
+			ioh.lg.Warn("ack commit", "zone", zone, "error", err)
+		}
+	}
+}
+
+// PublishCommands writes commands without a ledger event; used for re-sends.
+func (ioh *KafkaIO) PublishCommands(ctx context.Context, zone string, cmds []PlanCommand) error {
+	z, ok := ioh.zone(zone)
+	if !ok {
+		return fmt.Errorf("no actuator writer for %s", zone)
 	}
-	for z, w := range ioh.ledgerWriter {
-		_ = w.Close()
-		ioh.lg.Info("ledger writer closed", "zone", z)
+	aw := z.actuatorCB
+	msgs := make([]kafka.Message, 0, len(cmds))
+	for _, c := range cmds {
+		b, _ := json.Marshal(c)
+		msgs = append(msgs, kafka.Message{Key: []byte(c.ActuatorID), Value: b, Time: time.Now()})
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	if err := aw.WriteMessages(ctx, msgs...); err != nil {
+		return fmt.Errorf("actuator write: %w", err)
+	}
+	return nil
+}
+
+// PublishAnomaly writes an anomaly event keyed by actuator. Anomalies go to their own
+// topic so the ledger's MAPE partition only ever carries per-epoch decisions.
+func (ioh *KafkaIO) PublishAnomaly(ctx context.Context, ev AnomalyEvent) error {
+	b, _ := json.Marshal(ev)
+	if err := ioh.anomalyCB.WriteMessages(ctx, kafka.Message{Key: []byte(ev.ActuatorID), Value: b, Time: time.Now()}); err != nil {
+		return fmt.Errorf("anomaly write: %w", err)
 	}
+	return nil
 }
 
-// DrainZonePartitionLatest reads ALL messages currently in the zone partition and keeps only the most recent,
-// as per the round-robin scan requirement (Aggregator -> MAPE). The actual decision uses only the latest message.
-func (ioh *KafkaIO) DrainZonePartitionLatest(ctx context.Context, zone string) (Reading, bool, error) {
-	r, ok := ioh.zoneCBReaders[zone]
+// NextZoneReading blocks until the zone partition (Aggregator -> MAPE) delivers a report
+// that decodes, or ctx is done. Each zone's worker is its partition's only consumer.
+func (ioh *KafkaIO) NextZoneReading(ctx context.Context, zone string) (Reading, error) {
+	z, ok := ioh.zone(zone)
 	if !ok {
-		return Reading{}, false, fmt.Errorf("no reader for zone %s", zone)
+		return Reading{}, fmt.Errorf("no reader for zone %s", zone)
 	}
-	var latest AggregatedReport
-	var got bool
-	deadline := time.Now().Add(350 * time.Millisecond)
+	r := z.readerCB
 	for {
-		ctx2, cancel := context.WithTimeout(ctx, 120*time.Millisecond)
-		msg, err := r.FetchMessage(ctx2)
-		cancel()
+		msg, err := r.FetchMessage(ctx)
 		if err != nil {
-			if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
-				break
-			}
-			if !got {
-				return Reading{}, false, err
-			}
-			break
+			return Reading{}, err
 		}
 		var rep AggregatedReport
 		if err := json.Unmarshal(msg.Value, &rep); err != nil {
 			ioh.lg.Error("bad json", "zone", zone, "error", err)
 			continue
-		} else {
-			ioh.lg.Info("reading", "zone", zone, "epoch", rep.Epoch.Index, "zone_energy_kwh_epoch", rep.ZoneEnergyKWhEpoch, "actuators_energy_entries", len(rep.ActuatorEnergyKWhEpoch))
 		}
-		latest = rep
-		got = true
-		if time.Now().After(deadline) {
-			break
-		}
-	}
-	if !got {
-		return Reading{}, false, nil
+		ioh.lg.Info("reading", "zone", zone, "epoch", rep.Epoch.Index, "zone_energy_kwh_epoch", rep.ZoneEnergyKWhEpoch, "actuators_energy_entries", len(rep.ActuatorEnergyKWhEpoch))
+		read := ioh.readingFrom(zone, rep)
+		read.Partition, read.Offset = msg.Partition, msg.Offset
+		return read, nil
 	}
+}
+
+// Planned records that the engine planned read, so that with engine.consume=every the
+// zone's reader resumes after it on restart.
+func (ioh *KafkaIO) Planned(zone string, read Reading) {
+	ioh.positions.done(ioh.aggTopic, read.Partition, read.Offset)
+}
+
+// readingFrom maps an aggregator report to a Reading, resolving the zone energy from the
+// per-epoch field or, for older aggregators, from the legacy summary fields.
+func (ioh *KafkaIO) readingFrom(zone string, latest AggregatedReport) Reading {
 	zoneEnergy := latest.ZoneEnergyKWhEpoch
 	energySource := "epoch.zoneEnergyKWhEpoch"
 	if latest.ActuatorEnergyKWhEpoch == nil {
@@ -199,24 +414,51 @@ func (ioh *KafkaIO) DrainZonePartitionLatest(ctx context.Context, zone string) (
 		EpochIndex:         latest.Epoch.Index,
 		EpochStart:         latest.Epoch.Start,
 		EpochEnd:           latest.Epoch.End,
-		AvgTempC:           latest.Summary.AvgTemp,
 		ZoneEnergyKWhEpoch: zoneEnergy,
 		ZoneEnergySource:   energySource,
 		ActuatorEnergyKWh:  cloneEnergyMap(latest.ActuatorEnergyKWhEpoch),
 		Raw:                latest,
 	}
-	return read, true, nil
+	if latest.Summary.AvgTemp != nil {
+		read.AvgTempC, read.HasTemp = *latest.Summary.AvgTemp, true
+	}
+	if st, ok := latest.SensorStats["occupancy"]; ok && st.Count > 0 {
+		read.Occupants, read.HasOccupancy = int(st.Max), true
+	} else if latest.Summary.MaxOccupancy != nil {
+		read.Occupants, read.HasOccupancy = int(*latest.Summary.MaxOccupancy), true
+	}
+	return read
 }
 
-func (ioh *KafkaIO) PublishCommandsAndLedger(ctx context.Context, zone string, cmds []PlanCommand, led LedgerEvent) error {
-	aw, ok := ioh.actuatorCB[zone]
+// PublishAudit writes a setpoint audit to the MAPE partition of the zone's ledger topic.
+func (ioh *KafkaIO) PublishAudit(ctx context.Context, ev SetpointAudit) error {
+	return ioh.publishAudit(ctx, ev.ZoneID, ev)
+}
+
+// PublishPolicyAudit writes a control policy audit next to the setpoint audits.
+func (ioh *KafkaIO) PublishPolicyAudit(ctx context.Context, ev PolicyAudit) error {
+	return ioh.publishAudit(ctx, ev.ZoneID, ev)
+}
+
+func (ioh *KafkaIO) publishAudit(ctx context.Context, zone string, ev any) error {
+	z, ok := ioh.zone(zone)
 	if !ok {
-		return fmt.Errorf("no actuator writer for %s", zone)
+		return fmt.Errorf("no ledger writer for %s", zone)
+	}
+	lw := z.ledgerCB
+	b, _ := json.Marshal(ev)
+	if err := lw.WriteMessages(ctx, kafka.Message{Value: b, Time: time.Now(), Partition: ioh.mapePartition}); err != nil {
+		return fmt.Errorf("audit write: %w", err)
 	}
-	lw, ok := ioh.ledgerCB[zone]
+	return nil
+}
+
+func (ioh *KafkaIO) PublishCommandsAndLedger(ctx context.Context, zone string, cmds []PlanCommand, led LedgerEvent) error {
+	z, ok := ioh.zone(zone)
 	if !ok {
-		return fmt.Errorf("no ledger writer for %s", zone)
+		return fmt.Errorf("no actuator writer for %s", zone)
 	}
+	aw, lw := z.actuatorCB, z.ledgerCB
 
 	msgs := make([]kafka.Message, 0, len(cmds))
 	for _, c := range cmds {
@@ -229,12 +471,12 @@ func (ioh *KafkaIO) PublishCommandsAndLedger(ctx context.Context, zone string, c
 		}
 	}
 	b, _ := json.Marshal(led)
-	topic := ioh.cfg.LedgerTopicPref + zone
-	lm := kafka.Message{Value: b, Time: time.Now(), Partition: ioh.cfg.MAPEPartitionID}
+	topic := ioh.ledgerPref + zone
+	lm := kafka.Message{Value: b, Time: time.Now(), Partition: ioh.mapePartition}
 	if err := lw.WriteMessages(ctx, lm); err != nil {
-		ioh.lg.Error("ledger_write_err", "zone", zone, "topic", topic, "partition", ioh.cfg.MAPEPartitionID, "err", err)
+		ioh.lg.Error("ledger_write_err", "zone", zone, "topic", topic, "partition", ioh.mapePartition, "err", err)
 		return fmt.Errorf("ledger write: %w", err)
 	}
-	ioh.lg.Info("ledger_write_ok", "zone", zone, "topic", topic, "partition", ioh.cfg.MAPEPartitionID, "epoch", led.EpochIndex)
+	ioh.lg.Info("ledger_write_ok", "zone", zone, "topic", topic, "partition", ioh.mapePartition, "epoch", led.EpochIndex)
 	return nil
 }
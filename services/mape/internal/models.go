@@ -1,4 +1,4 @@
-// v9
+// v26
 // services/mape/internal/models.go
 // Package internal declares data contracts shared across the MAPE pipeline stages.
 package internal
@@ -18,9 +18,20 @@ type Epoch struct {
 type Summary struct {
 	AvgEnergyKWh float64  `json:"avgEnergyKWh"`
 	AvgPowerW    float64  `json:"avgPowerW"`
-	AvgTemp      float64  `json:"avgTemp"`
+	AvgTemp      *float64 `json:"avgTemp,omitempty"` // absent when the epoch had no temperature readings
 	ZoneEnergy   *float64 `json:"zoneEnergyKWh,omitempty"`
 	ZoneEpoch    *float64 `json:"zoneEnergyKWhEpoch,omitempty"`
+	MaxOccupancy *float64 `json:"maxOccupancy,omitempty"` // head count, with an occupancy sensor
+}
+
+// SensorStats summarises one environmental sensor type of an epoch, e.g. "occupancy".
+type SensorStats struct {
+	Unit  string  `json:"unit"`
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Mean  float64 `json:"mean"`
+	Last  float64 `json:"last"`
 }
 
 type AggregatedReport struct {
@@ -31,6 +42,7 @@ type AggregatedReport struct {
 	ProducedAt             string                      `json:"producedAt"` // RFC3339
 	ZoneEnergyKWhEpoch     float64                     `json:"zoneEnergyKWhEpoch,omitempty"`
 	ActuatorEnergyKWhEpoch map[string]float64          `json:"actuatorEnergyKWhEpoch,omitempty"`
+	SensorStats            map[string]SensorStats      `json:"sensorStats,omitempty"`
 }
 
 // Reading Internal derived reading passed across phases.
@@ -40,10 +52,16 @@ type Reading struct {
 	EpochStart         string
 	EpochEnd           string
 	AvgTempC           float64
+	HasTemp            bool // false when the report carried no temperature
+	Occupants          int
+	HasOccupancy       bool // false without an occupancy sensor in the zone
 	ZoneEnergyKWhEpoch float64
 	ZoneEnergySource   string
 	ActuatorEnergyKWh  map[string]float64
 	Raw                AggregatedReport
+	// Partition and Offset locate the report on the aggregator topic.
+	Partition int
+	Offset    int64
 }
 
 type PlanCommand struct {
@@ -71,15 +89,144 @@ type LedgerEvent struct {
 	ZoneEnergy    float64            `json:"zoneEnergyKWhEpoch,omitempty"`
 	EnergyFrom    string             `json:"energySource,omitempty"`
 	ActEnergy     map[string]float64 `json:"actuatorEnergyKWhEpoch,omitempty"`
+	Strategy      string             `json:"strategy,omitempty"`
+	Duty          int                `json:"duty,omitempty"`
+	// SetpointSource tells whether TargetC came from an override, a schedule or the default.
+	SetpointSource string `json:"setpointSource,omitempty"`
+	// Action is the applied action; Requested and Suppressed are set when the
+	// anti-short-cycling guards overrode the strategy.
+	Action     string `json:"action,omitempty"`
+	Requested  string `json:"requestedAction,omitempty"`
+	Suppressed string `json:"suppressedReason,omitempty"`
+	// Budget is the site coordinator's allocation for the zone, when enabled.
+	Budget *ZoneAllocation `json:"budget,omitempty"`
+	// Tariff context: price in force, cost of the epoch's metered energy and any
+	// demand-response event or pre-conditioning that shaped the target/band.
+	PriceKWh     float64 `json:"priceKWh,omitempty"`
+	CostEpoch    float64 `json:"costEpoch,omitempty"`
+	Currency     string  `json:"currency,omitempty"`
+	TariffPeriod string  `json:"tariffPeriod,omitempty"`
+	DREvent      string  `json:"drEvent,omitempty"`
+	BandWidenC   float64 `json:"bandWidenC,omitempty"`
+	PrecondC     float64 `json:"preconditionC,omitempty"`
+	// Occupancy is whether the zone was controlled for the occupied or unoccupied band.
+	Occupancy *OccupancyState `json:"occupancy,omitempty"`
+	// Interlocks lists the safety rules that overrode the decision in this epoch.
+	Interlocks []Interlock `json:"interlocks,omitempty"`
+	// Staging tells how many of the active mode's units run when not all of them do.
+	Staging string `json:"staging,omitempty"`
+	// SkippedFor is set on the events of epochs that were not planned because the zone
+	// caught up to a later one (engine.catch_up=latest); it names that epoch.
+	SkippedFor int64 `json:"skippedFor,omitempty"`
+	// Reasoning, as kept in the decision history: the temperature decided on, the
+	// strategy's own action and reason and a one-line explanation of the round.
+	TempC          *float64 `json:"tempC,omitempty"`
+	StrategyAction string   `json:"strategyAction,omitempty"`
+	Reason         string   `json:"reason,omitempty"`
+	Explanation    string   `json:"explanation,omitempty"`
+}
+
+// AuditTypeSetpoint discriminates setpoint audits from epoch events on the ledger topic.
+const AuditTypeSetpoint = "setpoint.audit"
+
+// SetpointAudit records one setpoint change on the zone's ledger topic.
+type SetpointAudit struct {
+	SchemaVersion string  `json:"schemaVersion"`
+	Type          string  `json:"type"`
+	ZoneID        string  `json:"zoneId"`
+	Actor         string  `json:"actor"`
+	Reason        string  `json:"reason,omitempty"`
+	OldC          float64 `json:"oldSetpointC"`
+	NewC          float64 `json:"newSetpointC"`
+	Timestamp     int64   `json:"timestamp"`
+}
+
+// AuditTypePolicy discriminates control policy audits on the ledger topic.
+const AuditTypePolicy = "policy.audit"
+
+// PolicyAudit records one change of a zone's control policy on its ledger topic. Source
+// tells whether New was set over the API or is the properties baseline it reverted to.
+type PolicyAudit struct {
+	SchemaVersion string     `json:"schemaVersion"`
+	Type          string     `json:"type"`
+	ZoneID        string     `json:"zoneId"`
+	Actor         string     `json:"actor"`
+	Reason        string     `json:"reason,omitempty"`
+	Old           ZonePolicy `json:"oldPolicy"`
+	New           ZonePolicy `json:"newPolicy"`
+	Source        string     `json:"source"`
+	Timestamp     int64      `json:"timestamp"`
+}
+
+// CommandAck is published by an actuator on zone.acks.<zone> after applying a command,
+// carrying the state it is actually in afterwards.
+type CommandAck struct {
+	ZoneID     string `json:"zoneId"`
+	ActuatorID string `json:"actuatorId"`
+	EpochIndex int64  `json:"epochIndex"`
+	Requested  string `json:"requestedMode"`
+	State      string `json:"state"` // ON/OFF, or the fan percent for ventilation
+	Applied    bool   `json:"applied"`
+	Error      string `json:"error,omitempty"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// AnomalyEvent reports actuators that MAPE considers faulty, or that recovered.
+type AnomalyEvent struct {
+	SchemaVersion string `json:"schemaVersion"`
+	Type          string `json:"type"`
+	ZoneID        string `json:"zoneId"`
+	ActuatorID    string `json:"actuatorId"`
+	Desired       string `json:"desired"`
+	Reported      string `json:"reported,omitempty"`
+	Resends       int    `json:"resends"`
+	Reason        string `json:"reason"`
+	// Diagnostic anomalies carry the epoch they started in and the expected and observed
+	// figures behind them.
+	EpochIndex    int64   `json:"epochIndex,omitempty"`
+	ExpectedKW    float64 `json:"expectedKW,omitempty"`
+	ObservedKW    float64 `json:"observedKW,omitempty"`
+	ExpectedSlope float64 `json:"expectedSlopeCPerH,omitempty"`
+	ObservedSlope float64 `json:"observedSlopeCPerH,omitempty"`
+	Timestamp     int64   `json:"timestamp"`
 }
 
 type Stats struct {
-	Loops        int64              `json:"loops"`
-	MessagesIn   int64              `json:"messagesIn"`
-	CommandsOut  int64              `json:"commandsOut"`
-	LedgerWrites int64              `json:"ledgerWrites"`
-	ZoneEnergy   map[string]float64 `json:"lastZoneEnergyKWhEpoch,omitempty"`
-	EnergyField  map[string]string  `json:"energyField,omitempty"`
+	Loops        int64                     `json:"loops"`
+	MessagesIn   int64                     `json:"messagesIn"`
+	CommandsOut  int64                     `json:"commandsOut"`
+	LedgerWrites int64                     `json:"ledgerWrites"`
+	ZoneEnergy   map[string]float64        `json:"lastZoneEnergyKWhEpoch,omitempty"`
+	EnergyField  map[string]string         `json:"energyField,omitempty"`
+	MPC          map[string]MPCStatus      `json:"mpc,omitempty"`
+	Resends      int64                     `json:"resends"`
+	Actuators    map[string]ActuatorStatus `json:"actuators,omitempty"`
+	Faulty       []string                  `json:"faultyActuators,omitempty"`
+	Budget       *BudgetStatus             `json:"budget,omitempty"`
+	Safety       *SafetyStatus             `json:"safety,omitempty"`
+	Runtime      map[string]UnitRuntime    `json:"runtime,omitempty"`
+	Zones        map[string]ZoneLoopStats  `json:"zones,omitempty"`
+}
+
+// ZoneLoopStats are one zone worker's counters. Latency runs from a report being fetched
+// to its plan being published, including the wait for a free worker slot; Execute is the
+// publish alone. Superseded counts reports replaced by a newer one before the worker got
+// to them, and Behind is set while a newer report is already waiting.
+type ZoneLoopStats struct {
+	Rounds        int64   `json:"rounds"`
+	Superseded    int64   `json:"superseded"`
+	ExecuteErrors int64   `json:"executeErrors"`
+	LastEpoch     int64   `json:"lastEpoch"`
+	LastLatencyMs float64 `json:"lastLatencyMs"`
+	AvgLatencyMs  float64 `json:"avgLatencyMs"` // exponentially weighted, alpha 0.2
+	MaxLatencyMs  float64 `json:"maxLatencyMs"`
+	LastExecuteMs float64 `json:"lastExecuteMs"`
+	MaxExecuteMs  float64 `json:"maxExecuteMs"`
+	Behind        bool    `json:"behind"`
+	// Skipped and Queued apply with engine.consume=every: epochs recorded as skipped by
+	// engine.catch_up=latest, and reports waiting after the last round.
+	Skipped int64 `json:"skipped,omitempty"`
+	Queued  int   `json:"queued,omitempty"`
 }
 
 // Per-zone actuators, grouped by function.
@@ -1,4 +1,4 @@
-// v7
+// v9
 // monitor.go
 package internal
 
@@ -7,18 +7,42 @@ import (
 	"log/slog"
 )
 
+// readingSource yields a zone's aggregator reports as they arrive.
+type readingSource interface {
+	NextZoneReading(ctx context.Context, zone string) (Reading, error)
+}
+
+// plannedTracker is implemented by sources that resume after the last planned report.
+type plannedTracker interface {
+	Planned(zone string, read Reading)
+}
+
 type Monitor struct {
 	cfg *AppConfig
 	lg  *slog.Logger
-	io  *KafkaIO
+	src readingSource
 }
 
 func NewMonitor(cfg *AppConfig, lg *slog.Logger, io *KafkaIO) *Monitor {
-	return &Monitor{cfg: cfg, lg: lg, io: io}
+	m := &Monitor{cfg: cfg, lg: lg}
+	if io != nil {
+		m.src = io
+	}
+	return m
+}
+
+// Next blocks until the zone's next report arrives or ctx is done.
+func (m *Monitor) Next(ctx context.Context, zone string) (Reading, error) {
+	read, err := m.src.NextZoneReading(ctx, zone)
+	if err == nil {
+		m.lg.Debug("monitor.next", "zone", zone, "epoch", read.EpochIndex)
+	}
+	return read, err
 }
 
-// Latest drains the zone partition and returns ONLY the most recent AggregatedReport-derived Reading.
-func (m *Monitor) Latest(ctx context.Context, zone string) (Reading, bool, error) {
-	m.lg.Info("monitor.latest", "zone", zone)
-	return m.io.DrainZonePartitionLatest(ctx, zone)
+// Planned tells the source that the zone's report was planned.
+func (m *Monitor) Planned(zone string, read Reading) {
+	if t, ok := m.src.(plannedTracker); ok {
+		t.Planned(zone, read)
+	}
 }
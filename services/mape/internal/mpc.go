@@ -0,0 +1,365 @@
+// v3
+// services/mape/internal/mpc.go
+package internal
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// StrategyMPC selects the model-predictive controller.
+const StrategyMPC = "mpc"
+
+func init() { knownStrategies[StrategyMPC] = struct{}{} }
+
+// MPCParams configures the model-predictive strategy.
+type MPCParams struct {
+	Horizon       int     // number of prediction steps
+	StepEpochs    int     // epochs each step holds its action (move blocking)
+	HeatKW        float64 // assumed heater power until learned from energy readings
+	CoolKW        float64 // assumed cooler power until learned from energy readings
+	ComfortWeight float64 // cost per (°C outside band)² per step, in kWh-equivalents
+	Forgetting    float64 // RLS forgetting factor in (0,1]
+	MinSamples    int     // model updates required before MPC takes over from hysteresis
+}
+
+// DefaultMPCParams returns a short horizon that is cheap to enumerate exhaustively.
+func DefaultMPCParams() MPCParams {
+	return MPCParams{Horizon: 5, StepEpochs: 10, HeatKW: 2, CoolKW: 2, ComfortWeight: 10, Forgetting: 0.995, MinSamples: 20}
+}
+
+// thermalModel is a first-order RC model fitted online with recursive least squares:
+//
+//	T[k+1] - T[k] = θ0 + θ1·T[k] + θ2·heat[k] + θ3·cool[k]
+//
+// θ0 + θ1·T captures envelope loss towards an implicit outdoor temperature -θ0/θ1,
+// θ2 and θ3 are the per-epoch actuator gains.
+type thermalModel struct {
+	theta   [4]float64
+	p       [4][4]float64
+	samples int
+	heatN   int // samples with the heater on
+	coolN   int // samples with the cooler on
+}
+
+func newThermalModel() *thermalModel {
+	m := &thermalModel{}
+	for i := range m.p {
+		m.p[i][i] = 1000
+	}
+	return m
+}
+
+func (m *thermalModel) update(x [4]float64, y, lambda float64) {
+	var px [4]float64
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			px[i] += m.p[i][j] * x[j]
+		}
+	}
+	denom := lambda
+	for i := 0; i < 4; i++ {
+		denom += x[i] * px[i]
+	}
+	if denom <= 0 || math.IsNaN(denom) {
+		return
+	}
+	var pred float64
+	for i := 0; i < 4; i++ {
+		pred += m.theta[i] * x[i]
+	}
+	errv := y - pred
+	for i := 0; i < 4; i++ {
+		m.theta[i] += px[i] / denom * errv
+	}
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			m.p[i][j] = (m.p[i][j] - px[i]*px[j]/denom) / lambda
+		}
+	}
+	m.samples++
+	if x[2] != 0 {
+		m.heatN++
+	}
+	if x[3] != 0 {
+		m.coolN++
+	}
+}
+
+// minActuatorSamples is how often a mode must have been observed before its gain is trusted.
+const minActuatorSamples = 3
+
+// actions returns OFF plus every mode whose gain has been identified with a plausible sign,
+// so a zone that has never cooled is not planned with a made-up cooler.
+func (m *thermalModel) actions() []string {
+	out := []string{"OFF"}
+	if m.heatN >= minActuatorSamples && m.theta[2] > 0 {
+		out = append(out, "HEAT")
+	}
+	if m.coolN >= minActuatorSamples && m.theta[3] < 0 {
+		out = append(out, "COOL")
+	}
+	return out
+}
+
+func (m *thermalModel) step(t float64, action string) float64 {
+	var h, c float64
+	switch action {
+	case "HEAT":
+		h = 1
+	case "COOL":
+		c = 1
+	}
+	return t + m.theta[0] + m.theta[1]*t + m.theta[2]*h + m.theta[3]*c
+}
+
+// MPCStatus is the inspection view published on /status.
+type MPCStatus struct {
+	Samples        int        `json:"samples"`
+	Theta          [4]float64 `json:"theta"`
+	OutdoorEstC    *float64   `json:"outdoorEstimateC,omitempty"`
+	HeatGainCPerEp float64    `json:"heatGainCPerEpoch"`
+	CoolGainCPerEp float64    `json:"coolGainCPerEpoch"`
+	HeatKW         float64    `json:"heatKW"`
+	CoolKW         float64    `json:"coolKW"`
+	Active         bool       `json:"active"`
+	Plan           []string   `json:"plan,omitempty"`
+	Trajectory     []float64  `json:"predictedTempC,omitempty"`
+}
+
+type mpcZone struct {
+	model      *thermalModel
+	prevT      float64
+	prevAction string
+	hasPrev    bool
+	heatKW     float64
+	coolKW     float64
+	dt         time.Duration // epoch length the model was fitted on
+	status     MPCStatus
+}
+
+// mpcController fits a thermalModel per zone from consecutive epochs and the action that
+// was actually issued, then picks the action sequence over the horizon that minimises
+// predicted energy plus a quadratic penalty for leaving the comfort band.
+type mpcController struct {
+	cfg      *AppConfig
+	fallback Controller
+	mu       sync.Mutex
+	zones    map[string]*mpcZone
+}
+
+func newMPCController(cfg *AppConfig) *mpcController {
+	return &mpcController{cfg: cfg, fallback: &hysteresisController{cfg: cfg}, zones: map[string]*mpcZone{}}
+}
+
+func (m *mpcController) Name() string { return StrategyMPC }
+
+// Forget drops the zone's fitted model.
+func (m *mpcController) Forget(zone string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.zones, zone)
+}
+
+// ObserveIssued records the action that Plan finally issued, which is what the model must
+// learn from even when later guards overrode the strategy's choice.
+func (m *mpcController) ObserveIssued(zone, action string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if z, ok := m.zones[zone]; ok {
+		z.prevAction = action
+	}
+}
+
+func (m *mpcController) Decide(zone string, in ControlInput) AnalysisResult {
+	params := m.cfg.MPCConfig()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	z, ok := m.zones[zone]
+	if !ok {
+		z = &mpcZone{model: newThermalModel(), heatKW: params.HeatKW, coolKW: params.CoolKW}
+		m.zones[zone] = z
+	}
+	if z.hasPrev {
+		var h, c float64
+		switch z.prevAction {
+		case "HEAT":
+			h = 1
+		case "COOL":
+			c = 1
+		}
+		z.model.update([4]float64{1, z.prevT, h, c}, in.TempC-z.prevT, params.Forgetting)
+		m.learnPower(zone, z, in)
+	}
+	z.prevT, z.hasPrev = in.TempC, true
+	if in.Dt > 0 {
+		z.dt = in.Dt
+	}
+
+	var res AnalysisResult
+	th := z.model.theta
+	actions := z.model.actions()
+	active := z.model.samples >= params.MinSamples && len(actions) > 1
+	if active {
+		seq, traj := m.optimise(z, in, params, actions)
+		res = AnalysisResult{Target: in.Target, Hyst: in.Hyst, HasTemp: true, TempC: in.TempC, Delta: in.TempC - in.Target, Strategy: StrategyMPC}
+		res.Action = seq[0]
+		if res.Action != "OFF" {
+			res.Duty = 100
+			res.Fan = m.cfg.fanFor(zone, math.Abs(res.Delta))
+		}
+		res.Reason = fmt.Sprintf("mpc plan %v, predicted end %.2fC", seq, traj[len(traj)-1])
+		z.status.Plan, z.status.Trajectory = seq, traj
+	} else {
+		res = m.fallback.Decide(zone, in)
+		res.Strategy = StrategyMPC
+		res.Reason = fmt.Sprintf("mpc warming up (%d/%d samples): %s", z.model.samples, params.MinSamples, res.Reason)
+		z.status.Plan, z.status.Trajectory = nil, nil
+	}
+	z.prevAction = res.Action
+	z.status.Samples = z.model.samples
+	z.status.Theta = th
+	z.status.HeatGainCPerEp = th[2]
+	z.status.CoolGainCPerEp = th[3]
+	z.status.HeatKW, z.status.CoolKW = z.heatKW, z.coolKW
+	z.status.Active = active
+	z.status.OutdoorEstC = nil
+	if th[1] < 0 {
+		est := -th[0] / th[1]
+		z.status.OutdoorEstC = &est
+	}
+	return res
+}
+
+// learnPower refines the per-mode power estimate from the actuator energy of the epoch in
+// which that mode was active.
+func (m *mpcController) learnPower(zone string, z *mpcZone, in ControlInput) {
+	hours := in.Dt.Hours()
+	if hours <= 0 || len(in.ActuatorKWh) == 0 {
+		return
+	}
+	acts := m.cfg.Actuators[zone]
+	sum := func(ids []string) (float64, bool) {
+		var total float64
+		found := false
+		for _, id := range ids {
+			if v, ok := in.ActuatorKWh[id]; ok {
+				total += v
+				found = true
+			}
+		}
+		return total, found
+	}
+	const alpha = 0.1
+	switch z.prevAction {
+	case "HEAT":
+		if kwh, ok := sum(acts.Heating); ok && kwh > 0 {
+			z.heatKW = (1-alpha)*z.heatKW + alpha*kwh/hours
+		}
+	case "COOL":
+		if kwh, ok := sum(acts.Cooling); ok && kwh > 0 {
+			z.coolKW = (1-alpha)*z.coolKW + alpha*kwh/hours
+		}
+	}
+}
+
+// optimise enumerates every action sequence over the horizon (len(actions)^Horizon
+// candidates) and returns the cheapest one with its predicted end-of-step temperatures.
+func (m *mpcController) optimise(z *mpcZone, in ControlInput, params MPCParams, actions []string) ([]string, []float64) {
+	n := len(actions)
+	horizon := params.Horizon
+	if horizon < 1 {
+		horizon = 1
+	}
+	stepEpochs := params.StepEpochs
+	if stepEpochs < 1 {
+		stepEpochs = 1
+	}
+	hours := in.Dt.Hours() * float64(stepEpochs)
+	lo, hi := in.Target-in.Hyst, in.Target+in.Hyst
+	total := int(math.Pow(float64(n), float64(horizon)))
+	bestCost := math.Inf(1)
+	var bestSeq []string
+	var bestTraj []float64
+	seq := make([]string, horizon)
+	traj := make([]float64, horizon)
+	for code := 0; code < total; code++ {
+		c := code
+		t := in.TempC
+		var cost float64
+		for k := 0; k < horizon; k++ {
+			seq[k] = actions[c%n]
+			c /= n
+			for e := 0; e < stepEpochs; e++ {
+				t = z.model.step(t, seq[k])
+			}
+			traj[k] = t
+			switch seq[k] {
+			case "HEAT":
+				cost += z.heatKW * hours
+			case "COOL":
+				cost += z.coolKW * hours
+			}
+			if t < lo {
+				cost += params.ComfortWeight * (lo - t) * (lo - t)
+			} else if t > hi {
+				cost += params.ComfortWeight * (t - hi) * (t - hi)
+			}
+		}
+		// Strict improvement keeps the earliest candidate on ties, which starts with OFF.
+		if cost < bestCost-1e-12 {
+			bestCost = cost
+			bestSeq = append([]string(nil), seq...)
+			bestTraj = append([]float64(nil), traj...)
+		}
+	}
+	return bestSeq, bestTraj
+}
+
+// fitted returns a copy of the zone's model once it is trusted to plan, as Decide would
+// use it; simulations start from it.
+func (m *mpcController) fitted(zone string) (mpcZone, bool) {
+	params := m.cfg.MPCConfig()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	z, ok := m.zones[zone]
+	if !ok || z.model.samples < params.MinSamples || len(z.model.actions()) < 2 {
+		return mpcZone{}, false
+	}
+	return z.clone(), true
+}
+
+// adopt installs a copy of a fitted zone model in place of the zone's state.
+func (m *mpcController) adopt(zone string, z mpcZone) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := z.clone()
+	m.zones[zone] = &c
+}
+
+func (z mpcZone) clone() mpcZone {
+	model := *z.model
+	z.model = &model
+	z.status.Plan = append([]string(nil), z.status.Plan...)
+	z.status.Trajectory = append([]float64(nil), z.status.Trajectory...)
+	return z
+}
+
+// Status returns a copy of every zone's fitted model and latest plan.
+func (m *mpcController) Status() map[string]MPCStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.zones) == 0 {
+		return nil
+	}
+	out := make(map[string]MPCStatus, len(m.zones))
+	for zone, z := range m.zones {
+		st := z.status
+		st.Plan = append([]string(nil), st.Plan...)
+		st.Trajectory = append([]float64(nil), st.Trajectory...)
+		out[zone] = st
+	}
+	return out
+}
@@ -0,0 +1,74 @@
+// v0
+// services/mape/internal/mpc_test.go
+package internal
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// plant is a first-order zone losing heat to a 10°C outdoor with a 0.3°C/epoch heater.
+func plant(t float64, action string) float64 {
+	next := t + 0.02*(10-t)
+	if action == "HEAT" {
+		next += 0.3
+	}
+	return next
+}
+
+func TestMPCLearnsModelAndHoldsBand(t *testing.T) {
+	cfg := &AppConfig{
+		FanSteps:  []float64{0.5, 1.0},
+		FanSpeeds: []int{50, 100},
+		Actuators: map[string]ZoneActuators{"zone-A": {Heating: []string{"heat-1"}}},
+		MPC:       MPCParams{Horizon: 4, StepEpochs: 2, HeatKW: 2, CoolKW: 2, ComfortWeight: 10, Forgetting: 1, MinSamples: 20},
+	}
+	mpc := newMPCController(cfg)
+	temp := 18.0
+	var action string
+	for i := 0; i < 300; i++ {
+		in := ControlInput{TempC: temp, Target: 21, Hyst: 0.5, Dt: time.Second}
+		if action == "HEAT" {
+			in.ActuatorKWh = map[string]float64{"heat-1": 3.0 / 3600}
+		}
+		res := mpc.Decide("zone-A", in)
+		if res.Action == "COOL" {
+			t.Fatalf("cooler was never observed and must not be planned: %s", res.Reason)
+		}
+		action = res.Action
+		temp = plant(temp, action)
+	}
+	st := mpc.Status()["zone-A"]
+	if !st.Active {
+		t.Fatalf("expected MPC to be active after warm-up: %+v", st)
+	}
+	if math.Abs(st.HeatGainCPerEp-0.3) > 0.01 {
+		t.Fatalf("heater gain %.4f, want ~0.3", st.HeatGainCPerEp)
+	}
+	if st.OutdoorEstC == nil || math.Abs(*st.OutdoorEstC-10) > 0.5 {
+		t.Fatalf("outdoor estimate %v, want ~10", st.OutdoorEstC)
+	}
+	if st.HeatKW < 2.5 {
+		t.Fatalf("heater power should move towards the metered 3 kW, got %.2f", st.HeatKW)
+	}
+	if len(st.Trajectory) != 4 || len(st.Plan) != 4 {
+		t.Fatalf("expected a 4-step plan and trajectory, got %v %v", st.Plan, st.Trajectory)
+	}
+	if temp < 20.3 || temp > 21.7 {
+		t.Fatalf("temperature %.2f drifted out of the comfort band", temp)
+	}
+}
+
+func TestMPCPrefersOffInsideBand(t *testing.T) {
+	m := newThermalModel()
+	m.theta = [4]float64{0.2, -0.02, 0.3, -0.3}
+	z := &mpcZone{model: m, heatKW: 2, coolKW: 2}
+	mpc := newMPCController(&AppConfig{})
+	seq, _ := mpc.optimise(z, ControlInput{TempC: 10.5, Target: 10.5, Hyst: 1, Dt: time.Second}, DefaultMPCParams(), []string{"OFF", "HEAT", "COOL"})
+	for _, a := range seq {
+		if a != "OFF" {
+			t.Fatalf("expected an all-OFF plan at equilibrium, got %v", seq)
+		}
+	}
+}
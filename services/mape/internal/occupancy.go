@@ -0,0 +1,353 @@
+// v0
+// services/mape/internal/occupancy.go
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Occupancy states and the signal that decided them, recorded in the ledger event.
+const (
+	OccupancyOccupied        = "occupied"
+	OccupancyUnoccupied      = "unoccupied"
+	OccupancyPreconditioning = "preconditioning"
+
+	OccupancySourceOverride = "override"
+	OccupancySourceSensor   = "sensor"
+	OccupancySourceSchedule = "schedule"
+	OccupancySourceDefault  = "default"
+)
+
+// ErrInvalidOccupancy wraps validation failures of occupancy hours and overrides.
+var ErrInvalidOccupancy = errors.New("invalid occupancy")
+
+// OccupancyParams configures the occupied and unoccupied comfort bands. Zones are in the
+// occupied band, the setpoint ± hysteresis, unless a signal says they are empty; the
+// unoccupied band lets the temperature drift SetbackC further in either direction.
+type OccupancyParams struct {
+	SetbackC    float64
+	VacantAfter time.Duration // an occupancy sensor must see nobody this long
+	Lead        time.Duration // pre-conditioning lead until warm-ups were observed
+	MaxLead     time.Duration // upper bound of the learned lead
+	LeadAlpha   float64       // weight of a new warm-up in the learned lead
+	// Hours are the occupied hours of each zone, in the schedule time zone.
+	Hours map[string][]ScheduleRule
+}
+
+// DefaultOccupancyParams set zones back 2C after 30 minutes without occupants and start
+// pre-conditioning 30 minutes ahead until warm-ups were learned.
+func DefaultOccupancyParams() OccupancyParams {
+	return OccupancyParams{SetbackC: 2, VacantAfter: 30 * time.Minute, Lead: 30 * time.Minute, MaxLead: 3 * time.Hour, LeadAlpha: 0.3}
+}
+
+func (p *OccupancyParams) set(param, v string) error {
+	if zone, ok := strings.CutPrefix(param, "hours."); ok {
+		rules, err := parseOccupancyHours(v)
+		if err != nil {
+			return fmt.Errorf("occupancy.%s: %w", param, err)
+		}
+		if p.Hours == nil {
+			p.Hours = map[string][]ScheduleRule{}
+		}
+		p.Hours[zone] = rules
+		return nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fmt.Errorf("occupancy.%s: %w", param, err)
+	}
+	minutes := time.Duration(f * float64(time.Minute))
+	switch param {
+	case "setback_c":
+		if f < 0 {
+			return fmt.Errorf("occupancy.setback_c must not be negative, got %g", f)
+		}
+		p.SetbackC = f
+	case "vacant_after_min":
+		p.VacantAfter = minutes
+	case "lead_min":
+		p.Lead = minutes
+	case "lead_max_min":
+		p.MaxLead = minutes
+	case "lead_alpha":
+		if f <= 0 || f > 1 {
+			return fmt.Errorf("occupancy.lead_alpha must be in (0,1], got %g", f)
+		}
+		p.LeadAlpha = f
+	default:
+		return fmt.Errorf("unknown occupancy parameter %q", param)
+	}
+	return nil
+}
+
+// parseOccupancyHours reads "mon,tue,wed,thu,fri 08:00-18:00; sat 09:00-12:00".
+func parseOccupancyHours(v string) ([]ScheduleRule, error) {
+	var rules []ScheduleRule
+	for i, part := range strings.Split(v, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		days, window, ok := strings.Cut(part, " ")
+		start, end, ok2 := strings.Cut(strings.TrimSpace(window), "-")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("%w: %q must be \"days HH:MM-HH:MM\"", ErrInvalidOccupancy, part)
+		}
+		r := ScheduleRule{Days: split(days), Start: strings.TrimSpace(start), End: strings.TrimSpace(end)}
+		if err := validateRuleWindow(i, r); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// occupiedAt reports whether local falls within one of the rules.
+func occupiedAt(rules []ScheduleRule, local time.Time) bool {
+	minute := local.Hour()*60 + local.Minute()
+	for _, r := range rules {
+		if !r.hasDay(local.Weekday()) {
+			continue
+		}
+		start, _ := parseClock(r.Start)
+		end, _ := parseClock(r.End)
+		if minute >= start && minute < end {
+			return true
+		}
+	}
+	return false
+}
+
+// OccupancyOverride forces a zone occupied or unoccupied until ExpiresAt.
+type OccupancyOverride struct {
+	Occupied  bool      `json:"occupied"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Reason    string    `json:"reason,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+}
+
+// OccupancyState is the occupancy a zone was controlled for in one epoch.
+type OccupancyState struct {
+	State  string `json:"state"`
+	Source string `json:"source"`
+	// Occupants is the largest head count of the epoch, when an occupancy sensor reported.
+	Occupants *int `json:"occupants,omitempty"`
+	// SetbackC is the band widening applied, LeadMinutes the pre-conditioning lead in
+	// force and NextOccupied the scheduled start being pre-conditioned for.
+	SetbackC     float64    `json:"setbackC,omitempty"`
+	LeadMinutes  float64    `json:"leadMinutes,omitempty"`
+	NextOccupied *time.Time `json:"nextOccupied,omitempty"`
+}
+
+type zoneOccupancy struct {
+	override  *OccupancyOverride
+	firstSeen time.Time // first epoch an occupancy sensor reported
+	sensorAt  time.Time // last epoch an occupancy sensor reported
+	present   time.Time // last epoch it counted someone
+	// warmFrom is when the zone left the unoccupied band with the temperature outside
+	// the occupied one; the time it takes to get there is a warm-up.
+	warmFrom time.Time
+	lead     Learned // warm-up duration, minutes
+	last     OccupancyState
+}
+
+// Occupancy decides, per zone and epoch, whether the occupied or the unoccupied comfort
+// band applies. A manual override wins, then a recently reporting occupancy sensor, then
+// the configured occupied hours; zones without any signal count as occupied. Ahead of
+// scheduled occupied hours an unoccupied zone is pre-conditioned in the occupied band, as
+// early as its warm-ups have taken so far.
+type Occupancy struct {
+	cfg   *AppConfig
+	mu    sync.Mutex
+	zones map[string]*zoneOccupancy
+}
+
+func NewOccupancy(cfg *AppConfig) *Occupancy {
+	return &Occupancy{cfg: cfg, zones: map[string]*zoneOccupancy{}}
+}
+
+func (o *Occupancy) zone(zone string) *zoneOccupancy {
+	z, ok := o.zones[zone]
+	if !ok {
+		z = &zoneOccupancy{}
+		o.zones[zone] = z
+	}
+	return z
+}
+
+// Resolve returns the zone's occupancy for the epoch starting at and learns warm-up
+// durations; target and hyst describe the occupied band.
+func (o *Occupancy) Resolve(zone string, read Reading, at time.Time, target, hyst float64) OccupancyState {
+	p := o.cfg.Occupancy
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	z := o.zone(zone)
+	st := OccupancyState{State: OccupancyOccupied, Source: OccupancySourceDefault}
+	if read.HasOccupancy {
+		n := read.Occupants
+		st.Occupants = &n
+		if z.firstSeen.IsZero() {
+			z.firstSeen = at
+		}
+		z.sensorAt = at
+		if n > 0 {
+			z.present = at
+		}
+	}
+	hours := p.Hours[zone]
+	switch {
+	case z.override != nil && at.Before(z.override.ExpiresAt):
+		st.Source = OccupancySourceOverride
+		if !z.override.Occupied {
+			st.State = OccupancyUnoccupied
+		}
+	case !z.sensorAt.IsZero() && at.Sub(z.sensorAt) < p.VacantAfter:
+		st.Source = OccupancySourceSensor
+		since := z.present
+		if since.Before(z.firstSeen) {
+			since = z.firstSeen
+		}
+		if at.Sub(since) >= p.VacantAfter {
+			st.State = OccupancyUnoccupied
+		}
+	case len(hours) > 0:
+		st.Source = OccupancySourceSchedule
+		if !occupiedAt(hours, at.In(o.cfg.location())) {
+			st.State = OccupancyUnoccupied
+		}
+	}
+	if z.override != nil && !at.Before(z.override.ExpiresAt) {
+		z.override = nil
+	}
+	lead := z.leadFor(p)
+	if st.State == OccupancyUnoccupied && st.Source != OccupancySourceOverride && len(hours) > 0 {
+		if next, ok := nextOccupied(hours, at.In(o.cfg.location()), lead); ok {
+			st.State = OccupancyPreconditioning
+			st.LeadMinutes = lead.Minutes()
+			st.NextOccupied = &next
+		}
+	}
+	if st.State == OccupancyUnoccupied {
+		st.SetbackC = p.SetbackC
+	}
+	z.learn(st, z.last, read, at, target, hyst, p)
+	z.last = st
+	return st
+}
+
+// leadFor is the learned warm-up duration, or the configured lead before any.
+func (z *zoneOccupancy) leadFor(p OccupancyParams) time.Duration {
+	if z.lead.Samples == 0 {
+		return p.Lead
+	}
+	lead := time.Duration(z.lead.Mean * float64(time.Minute))
+	if p.MaxLead > 0 && lead > p.MaxLead {
+		lead = p.MaxLead
+	}
+	return lead
+}
+
+// learn times how long the zone takes from leaving the unoccupied band to reaching the
+// occupied one.
+func (z *zoneOccupancy) learn(st, prev OccupancyState, read Reading, at time.Time, target, hyst float64, p OccupancyParams) {
+	if st.State == OccupancyUnoccupied || !read.HasTemp {
+		z.warmFrom = time.Time{}
+		return
+	}
+	inBand := abs(read.AvgTempC-target) <= hyst
+	if prev.State == OccupancyUnoccupied && !inBand {
+		z.warmFrom = at
+		return
+	}
+	if inBand && !z.warmFrom.IsZero() {
+		z.lead.add(at.Sub(z.warmFrom).Minutes(), p.LeadAlpha)
+		z.warmFrom = time.Time{}
+	}
+}
+
+// nextOccupied returns the start of occupied hours within lead of local, searched in
+// 5-minute steps.
+func nextOccupied(rules []ScheduleRule, local time.Time, lead time.Duration) (time.Time, bool) {
+	prev := occupiedAt(rules, local)
+	for m := 5 * time.Minute; m <= lead; m += 5 * time.Minute {
+		at := local.Add(m).Truncate(5 * time.Minute)
+		now := occupiedAt(rules, at)
+		if now && !prev {
+			return at, true
+		}
+		prev = now
+	}
+	return time.Time{}, false
+}
+
+// SetOverride forces the zone's occupancy until ov.ExpiresAt.
+func (o *Occupancy) SetOverride(zone string, ov OccupancyOverride, now time.Time) error {
+	if _, ok := o.cfg.ZoneTargets[zone]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownZone, zone)
+	}
+	if !ov.ExpiresAt.After(now) {
+		return fmt.Errorf("%w: override must expire in the future", ErrInvalidOccupancy)
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.zone(zone).override = &ov
+	return nil
+}
+
+// ClearOverride removes the zone's override; the boolean reports whether one was active.
+func (o *Occupancy) ClearOverride(zone string, now time.Time) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	z, ok := o.zones[zone]
+	if !ok || z.override == nil {
+		return false
+	}
+	active := now.Before(z.override.ExpiresAt)
+	z.override = nil
+	return active
+}
+
+// OccupancyStatus is what GET /zones/{zone}/occupancy shows.
+type OccupancyStatus struct {
+	ZoneID string `json:"zoneId"`
+	// Last is the occupancy of the zone's latest epoch; empty before its first report.
+	Last        *OccupancyState    `json:"last,omitempty"`
+	Override    *OccupancyOverride `json:"override,omitempty"`
+	Hours       []ScheduleRule     `json:"hours,omitempty"`
+	LeadMinutes float64            `json:"leadMinutes"`
+	WarmUps     int                `json:"warmUps"`
+}
+
+// Status reports the zone's latest occupancy, active override, hours and learned lead.
+func (o *Occupancy) Status(zone string, now time.Time) OccupancyStatus {
+	p := o.cfg.Occupancy
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	st := OccupancyStatus{ZoneID: zone, Hours: p.Hours[zone], LeadMinutes: p.Lead.Minutes()}
+	z, ok := o.zones[zone]
+	if !ok {
+		return st
+	}
+	if z.last.State != "" {
+		last := z.last
+		st.Last = &last
+	}
+	if z.override != nil && now.Before(z.override.ExpiresAt) {
+		ov := *z.override
+		st.Override = &ov
+	}
+	st.LeadMinutes, st.WarmUps = z.leadFor(p).Minutes(), z.lead.Samples
+	return st
+}
+
+// Forget drops the state of a removed zone.
+func (o *Occupancy) Forget(zone string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.zones, zone)
+}
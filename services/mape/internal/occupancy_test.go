@@ -0,0 +1,162 @@
+// v0
+// services/mape/internal/occupancy_test.go
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOccupancyHoursSensorAndLead(t *testing.T) {
+	hours, err := parseOccupancyHours("mon,tue,wed,thu,fri 08:00-18:00; sat 09:00-12:00")
+	if err != nil || len(hours) != 2 {
+		t.Fatalf("hours: %v %v", hours, err)
+	}
+	if _, err := parseOccupancyHours("mon 18:00-08:00"); err == nil {
+		t.Fatalf("an inverted window must be rejected")
+	}
+	p := DefaultOccupancyParams()
+	p.Hours = map[string][]ScheduleRule{"zone-A": hours}
+	cfg := &AppConfig{Zones: []string{"zone-A"}, ZoneTargets: map[string]float64{"zone-A": 21}, Occupancy: p}
+	occ := NewOccupancy(cfg)
+	monday := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	at := func(hhmm string) time.Time {
+		d, _ := time.ParseDuration(strings.Replace(hhmm, ":", "h", 1) + "m")
+		return monday.Add(d)
+	}
+	resolve := func(hhmm string, temp float64, occupants int) OccupancyState {
+		read := Reading{ZoneID: "zone-A", AvgTempC: temp, HasTemp: true}
+		if occupants >= 0 {
+			read.Occupants, read.HasOccupancy = occupants, true
+		}
+		return occ.Resolve("zone-A", read, at(hhmm), 21, 0.5)
+	}
+
+	if st := resolve("07:00", 17, -1); st.State != OccupancyUnoccupied || st.Source != OccupancySourceSchedule || st.SetbackC != 2 {
+		t.Fatalf("before hours: %+v", st)
+	}
+	// Within the 30-minute default lead the zone is brought into the occupied band; it
+	// takes 45 minutes to get there, which becomes the lead.
+	st := resolve("07:35", 17, -1)
+	if st.State != OccupancyPreconditioning || st.SetbackC != 0 || st.LeadMinutes != 30 || !st.NextOccupied.Equal(at("08:00")) {
+		t.Fatalf("pre-conditioning: %+v", st)
+	}
+	if st := resolve("08:00", 19, -1); st.State != OccupancyOccupied {
+		t.Fatalf("in hours: %+v", st)
+	}
+	resolve("08:20", 20.6, -1)
+	if s := occ.Status("zone-A", at("08:20")); s.WarmUps != 1 || s.LeadMinutes != 45 {
+		t.Fatalf("warm-up not learned: %+v", s)
+	}
+	if st := resolve("18:00", 21, -1); st.State != OccupancyUnoccupied {
+		t.Fatalf("after hours: %+v", st)
+	}
+
+	// A reporting sensor takes precedence over the hours: the zone empties after 30
+	// minutes without occupants and is occupied again as soon as someone is counted.
+	for _, step := range []struct {
+		hhmm      string
+		occupants int
+		state     string
+	}{{"10:00", 0, OccupancyOccupied}, {"10:15", 0, OccupancyOccupied}, {"10:30", 0, OccupancyUnoccupied}, {"10:45", 3, OccupancyOccupied}} {
+		st := resolve(step.hhmm, 21, step.occupants)
+		if st.State != step.state || st.Source != OccupancySourceSensor || *st.Occupants != step.occupants {
+			t.Fatalf("%s: %+v", step.hhmm, st)
+		}
+	}
+
+	// An override wins over the sensor and is not pre-conditioned for.
+	if err := occ.SetOverride("zone-A", OccupancyOverride{Occupied: false, ExpiresAt: at("12:00")}, at("11:00")); err != nil {
+		t.Fatalf("override: %v", err)
+	}
+	if st := resolve("11:00", 21, 2); st.State != OccupancyUnoccupied || st.Source != OccupancySourceOverride {
+		t.Fatalf("override: %+v", st)
+	}
+	if st := resolve("12:00", 21, 2); st.Source != OccupancySourceSensor {
+		t.Fatalf("override must lapse: %+v", st)
+	}
+	if err := occ.SetOverride("zone-Z", OccupancyOverride{ExpiresAt: at("12:00")}, at("11:00")); err == nil {
+		t.Fatalf("unknown zone must be rejected")
+	}
+}
+
+func TestOccupancyOverrideShapesBandAndLedger(t *testing.T) {
+	p := DefaultOccupancyParams()
+	cfg := &AppConfig{
+		Zones:          []string{"zone-A"},
+		ZoneTargets:    map[string]float64{"zone-A": 21},
+		ZoneHysteresis: map[string]float64{"zone-A": 0.5},
+		FanSteps:       []float64{0.5, 1.0},
+		FanSpeeds:      []int{25, 100},
+		Actuators:      map[string]ZoneActuators{"zone-A": {Heating: []string{"h1"}, Cooling: []string{"c1"}}},
+		PollIntervalMs: 10,
+		Occupancy:      p,
+	}
+	store, err := NewZoneSetpoints(cfg.Zones, cfg.ZoneTargets, 10.0, 35.0)
+	if err != nil {
+		t.Fatalf("setpoints: %v", err)
+	}
+	prev := engineRef
+	defer func() { engineRef = prev }()
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	e := NewEngine(cfg, store, nil, nil, lg, nil)
+	src := chanSource{"zone-A": make(chan Reading)}
+	pub := &ledgerRecorder{}
+	e.mon.src, e.exe.pub = src, pub
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+
+	srv := NewHTTPServer(cfg, store, nil, nil, lg)
+	do := func(method, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/zones/zone-A/occupancy", strings.NewReader(body))
+		req.Header.Set("X-Actor", "facility")
+		srv.http.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	for body, code := range map[string]int{
+		`{"occupied":false}`: http.StatusBadRequest,
+		`{"occupied":false,"expiresAt":"2001-01-01T00:00:00Z"}`:      http.StatusBadRequest,
+		`{"occupied":false,"durationMinutes":60,"reason":"holiday"}`: http.StatusOK,
+	} {
+		if rec := do(http.MethodPut, body); rec.Code != code {
+			t.Fatalf("%s: expected %d, got %d %s", body, code, rec.Code, rec.Body.String())
+		}
+	}
+
+	// 19.5C is within the unoccupied band 21±2.5 but below the occupied one.
+	src["zone-A"] <- Reading{ZoneID: "zone-A", EpochIndex: 1, AvgTempC: 19.5, HasTemp: true}
+	waitFor(t, "ledger event", func() bool { return len(pub.events()) == 1 })
+	led := pub.events()[0]
+	if led.Occupancy == nil || led.Occupancy.State != OccupancyUnoccupied || led.Occupancy.Source != OccupancySourceOverride || led.HystC != 2.5 || led.Action != "OFF" {
+		t.Fatalf("unexpected ledger event: %+v %+v", led, led.Occupancy)
+	}
+	if !strings.Contains(led.Explanation, "zone unoccupied (override), band widened 2.00C") {
+		t.Fatalf("explanation lacks occupancy: %q", led.Explanation)
+	}
+	var st OccupancyStatus
+	rec := do(http.MethodGet, "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil || st.Override == nil || st.Override.Actor != "facility" || st.Last.State != OccupancyUnoccupied {
+		t.Fatalf("get: %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := do(http.MethodDelete, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("second delete: %d", rec.Code)
+	}
+	src["zone-A"] <- Reading{ZoneID: "zone-A", EpochIndex: 2, AvgTempC: 19.5, HasTemp: true}
+	waitFor(t, "ledger event", func() bool { return len(pub.events()) == 2 })
+	if led := pub.events()[1]; led.Occupancy.State != OccupancyOccupied || led.Occupancy.Source != OccupancySourceDefault || led.HystC != 0.5 || led.Action != "HEAT" {
+		t.Fatalf("unexpected ledger event: %+v %+v", led, led.Occupancy)
+	}
+}
@@ -1,4 +1,4 @@
-// v9
+// v19
 // services/mape/internal/plan.go
 package internal
 
@@ -9,55 +9,75 @@ import (
 
 // Plan reads per-zone actuator IDs from properties and enforces complementary OFF.
 // Additionally, ventilation devices receive VENTILATE with FanPercent when action is HEAT/COOL.
+// Zones with several heaters or coolers run as many of them as Staging selects.
+// Requested actions pass through ActuatorGuards first, so HEAT/COOL may be held or
+// dropped to respect minimum run/rest times and start limits. Safety interlocks have the
+// last word: the safe state and the frost/overheat limits bypass the guards, and heating
+// and cooling are never commanded ON together.
 type Plan struct {
-	cfg *AppConfig
-	lg  *slog.Logger
-	sp  *ZoneSetpoints
+	cfg     *AppConfig
+	lg      *slog.Logger
+	sp      *ZoneSetpoints
+	guards  *ActuatorGuards
+	staging *Staging
 }
 
 func NewPlan(cfg *AppConfig, sp *ZoneSetpoints, lg *slog.Logger) *Plan {
-	return &Plan{cfg: cfg, lg: lg, sp: sp}
+	guards, staging := NewActuatorGuards(cfg), NewStaging(cfg)
+	staging.guards = guards
+	return &Plan{cfg: cfg, lg: lg, sp: sp, guards: guards, staging: staging}
 }
 
 func (p *Plan) Build(zone string, epochIndex int64, epochStart, epochEnd string, res AnalysisResult) ([]PlanCommand, LedgerEvent) {
 	acts := p.cfg.Actuators[zone]
-	cmds := make([]PlanCommand, 0, len(acts.Heating)+len(acts.Cooling)+len(acts.Ventilation))
-	target, ok := p.sp.Get(zone)
-	if !ok {
-		target = p.cfg.ZoneTargets[zone]
-		p.lg.Warn("plan setpoint missing", "zone", zone, "fallback", target)
+	target := res.Target
+	requested := res.Action
+	action, suppressed := p.guards.Check(zone, requested)
+	if suppressed != "" && len(res.Interlocks) > 0 {
+		action, suppressed = requested, ""
 	}
-	appendCmds := func(ids []string, mode string, fan int, reason string) {
-		for _, id := range ids {
-			cmds = append(cmds, PlanCommand{
-				ZoneID: zone, ActuatorID: id, Mode: mode, FanPercent: fanIf(mode, fan),
-				Reason: reason, EpochIndex: epochIndex, IssuedAt: time.Now().UnixMilli(),
-			})
+	if suppressed != "" {
+		p.lg.Warn("plan action suppressed", "zone", zone, "requested", requested, "applied", action, "reason", suppressed)
+		res.Action = action
+		res.Reason = suppressed
+		if action == "OFF" {
+			res.Fan = 0
+		} else if res.Fan == 0 {
+			res.Fan = p.cfg.fanFor(zone, abs(res.Delta))
+		}
+	}
+	interlocks := append([]Interlock(nil), res.Interlocks...)
+	if ilks := enforceLimits(p.cfg, zone, &res); len(ilks) > 0 {
+		for _, ilk := range ilks {
+			p.lg.Warn("safety interlock", "zone", zone, "type", ilk.Type, "reason", ilk.Reason)
 		}
+		interlocks = append(interlocks, ilks...)
 	}
 	switch res.Action {
 	case "HEAT":
 		{
 			p.lg.Info("plan", "zone", zone, "action", "HEAT", "fan", res.Fan, "setpoint_c", target, "heaters", len(acts.Heating), "coolers_off", len(acts.Cooling), "vents", len(acts.Ventilation), "zone_energy_kwh_epoch", res.ZoneEnergyKWhEpoch, "energy_source", res.ZoneEnergySource, "actuator_energy_entries", len(res.ActuatorEnergyKWh))
-			appendCmds(acts.Heating, "ON", res.Fan, res.Reason)
-			appendCmds(acts.Cooling, "OFF", 0, "complementary off (heating active)")
-			appendCmds(acts.Ventilation, itoa(res.Fan), res.Fan, res.Reason)
 		}
 	case "COOL":
 		{
 			p.lg.Info("plan", "zone", zone, "action", "COOL", "fan", res.Fan, "setpoint_c", target, "coolers", len(acts.Cooling), "heaters_off", len(acts.Heating), "vents", len(acts.Ventilation), "zone_energy_kwh_epoch", res.ZoneEnergyKWhEpoch, "energy_source", res.ZoneEnergySource, "actuator_energy_entries", len(res.ActuatorEnergyKWh))
-			appendCmds(acts.Cooling, "ON", res.Fan, res.Reason)
-			appendCmds(acts.Heating, "OFF", 0, "complementary off (cooling active)")
-			appendCmds(acts.Ventilation, itoa(res.Fan), res.Fan, res.Reason)
 		}
 	default:
 		{
 			p.lg.Info("plan", "zone", zone, "action", "OFF", "fan", res.Fan, "setpoint_c", target, "coolers_off", len(acts.Cooling), "heaters_off", len(acts.Heating), "vents", len(acts.Ventilation), "zone_energy_kwh_epoch", res.ZoneEnergyKWhEpoch, "energy_source", res.ZoneEnergySource, "actuator_energy_entries", len(res.ActuatorEnergyKWh))
-			appendCmds(acts.Heating, "OFF", 0, res.Reason)
-			appendCmds(acts.Cooling, "OFF", 0, res.Reason)
-			appendCmds(acts.Ventilation, itoa(res.Fan), res.Fan, res.Reason)
 		}
 	}
+	cmds := commandsFor(zone, acts, epochIndex, res)
+	staged := p.staging.Apply(zone, res, cmds)
+	if staged != "" {
+		p.lg.Info("plan staged", "zone", zone, "action", res.Action, "staging", staged)
+	}
+	if ilk := enforceExclusive(p.cfg, zone, cmds); ilk != nil {
+		p.lg.Error("safety interlock", "zone", zone, "type", ilk.Type, "reason", ilk.Reason)
+		res.Action = "OFF"
+		interlocks = append(interlocks, *ilk)
+	}
+	p.guards.Commit(zone, res.Action, cmds)
 	p.lg.Info("commands", "list", cmds)
 	led := LedgerEvent{
 		SchemaVersion: LedgerSchemaVersion,
@@ -65,10 +85,58 @@ func (p *Plan) Build(zone string, epochIndex int64, epochStart, epochEnd string,
 		Planned: "action=" + res.Action + " heaters=" + itoa(len(acts.Heating)) + " coolers=" + itoa(len(acts.Cooling)) + " vents=" + itoa(len(acts.Ventilation)) + " fan=" + itoa(res.Fan),
 		TargetC: res.Target, HystC: res.Hyst, DeltaC: res.Delta, Fan: res.Fan, Start: epochStart, End: epochEnd, Timestamp: time.Now().UnixMilli(),
 		ZoneEnergy: res.ZoneEnergyKWhEpoch, EnergyFrom: res.ZoneEnergySource, ActEnergy: cloneEnergyMap(res.ActuatorEnergyKWh),
+		Strategy: res.Strategy, Duty: res.Duty, SetpointSource: res.TargetSource,
+		Action:   res.Action,
+		PriceKWh: res.Tariff.Price, CostEpoch: res.CostEpoch, Currency: res.Tariff.Currency, TariffPeriod: res.Tariff.Period,
+		DREvent: res.Tariff.DREventID, BandWidenC: res.Tariff.WidenC, PrecondC: res.Tariff.PrecondC,
+		Interlocks: interlocks, Staging: staged,
+	}
+	if res.Occupancy.State != "" {
+		occ := res.Occupancy
+		led.Occupancy = &occ
+	}
+	if suppressed != "" {
+		led.Requested = requested
+		led.Suppressed = suppressed
 	}
 	return cmds, led
 }
 
+// commandsFor expands a zone action into per-actuator commands: the active mode's devices
+// ON, the complementary ones OFF and ventilation at the chosen fan speed. It has no side
+// effects, so shadow strategies use it to describe the plan they would have issued.
+func commandsFor(zone string, acts ZoneActuators, epochIndex int64, res AnalysisResult) []PlanCommand {
+	cmds := make([]PlanCommand, 0, len(acts.Heating)+len(acts.Cooling)+len(acts.Ventilation))
+	appendCmds := func(ids []string, mode string, fan int, reason string) {
+		for _, id := range ids {
+			cmds = append(cmds, PlanCommand{
+				ZoneID: zone, ActuatorID: id, Mode: mode, FanPercent: fanIf(mode, fan),
+				Reason: reason, EpochIndex: epochIndex, IssuedAt: time.Now().UnixMilli(),
+			})
+		}
+	}
+	switch res.Action {
+	case "HEAT":
+		appendCmds(acts.Heating, "ON", res.Fan, res.Reason)
+		appendCmds(acts.Cooling, "OFF", 0, "complementary off (heating active)")
+	case "COOL":
+		appendCmds(acts.Cooling, "ON", res.Fan, res.Reason)
+		appendCmds(acts.Heating, "OFF", 0, "complementary off (cooling active)")
+	default:
+		appendCmds(acts.Heating, "OFF", 0, res.Reason)
+		appendCmds(acts.Cooling, "OFF", 0, res.Reason)
+	}
+	appendCmds(acts.Ventilation, itoa(res.Fan), res.Fan, res.Reason)
+	return cmds
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
 func fanIf(mode string, fan int) int {
 	if mode == "OFF" {
 		return 0
@@ -0,0 +1,290 @@
+// v1
+// services/mape/internal/policy.go
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrPolicyConflict is returned when a policy update names a version that is no longer
+// the zone's current one.
+var ErrPolicyConflict = errors.New("policy changed since it was read")
+
+// ErrInvalidPolicy wraps the reason a policy was rejected.
+var ErrInvalidPolicy = errors.New("invalid policy")
+
+// Policy sources reported by GET /zones/{zone}/policy.
+const (
+	PolicySourceProperties = "properties"
+	PolicySourceAPI        = "api"
+)
+
+// ZonePolicy is the full control policy of a zone. Without an API override it mirrors the
+// properties: hysteresis, the global fan curve, the global setpoint range and the
+// zone's strategy.
+type ZonePolicy struct {
+	HysteresisC  float64   `json:"hysteresisC"`
+	FanSteps     []float64 `json:"fanSteps"`
+	FanSpeeds    []int     `json:"fanSpeeds"`
+	MinSetpointC float64   `json:"minSetpointC"`
+	MaxSetpointC float64   `json:"maxSetpointC"`
+	Strategy     string    `json:"strategy"`
+}
+
+// ETag identifies this version of the policy for If-Match and If-None-Match.
+func (p ZonePolicy) ETag() string {
+	b, _ := json.Marshal(p)
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+func (p ZonePolicy) clone() ZonePolicy {
+	p.FanSteps = slices.Clone(p.FanSteps)
+	p.FanSpeeds = slices.Clone(p.FanSpeeds)
+	return p
+}
+
+// validate checks the policy on its own and against the global setpoint bounds.
+func (p ZonePolicy) validate(min, max float64) error {
+	if !(p.HysteresisC > 0) {
+		return fmt.Errorf("%w: hysteresisC must be positive", ErrInvalidPolicy)
+	}
+	if len(p.FanSteps) == 0 || len(p.FanSteps) != len(p.FanSpeeds) {
+		return fmt.Errorf("%w: fanSteps and fanSpeeds must be non-empty and of equal length", ErrInvalidPolicy)
+	}
+	for i, s := range p.FanSteps {
+		if !(s > 0) || (i > 0 && s <= p.FanSteps[i-1]) {
+			return fmt.Errorf("%w: fanSteps must be positive and strictly ascending", ErrInvalidPolicy)
+		}
+	}
+	for i, s := range p.FanSpeeds {
+		if s < 0 || s > 100 || (i > 0 && s < p.FanSpeeds[i-1]) {
+			return fmt.Errorf("%w: fanSpeeds must be within 0..100 and non-decreasing", ErrInvalidPolicy)
+		}
+	}
+	if p.MinSetpointC >= p.MaxSetpointC {
+		return fmt.Errorf("%w: minSetpointC must be below maxSetpointC", ErrInvalidPolicy)
+	}
+	if p.MinSetpointC < min || p.MaxSetpointC > max {
+		return fmt.Errorf("%w: setpoint range must stay within %.1f..%.1f", ErrInvalidPolicy, min, max)
+	}
+	if _, ok := knownStrategies[p.Strategy]; !ok {
+		return fmt.Errorf("%w: unknown strategy %q", ErrInvalidPolicy, p.Strategy)
+	}
+	return nil
+}
+
+// PolicyAuditPublisher delivers policy audit events to the zone's ledger topic.
+type PolicyAuditPublisher interface {
+	PublishPolicyAudit(ctx context.Context, ev PolicyAudit) error
+}
+
+type policyFile struct {
+	SavedAt  time.Time             `json:"savedAt"`
+	Policies map[string]ZonePolicy `json:"policies"`
+}
+
+// Policies holds the zone policies set over the HTTP API. They take precedence over the
+// properties, which stay the baseline a zone reverts to, and are read by the pipeline on
+// every round, so a change applies from the next epoch. Policies are persisted like the
+// setpoints and every change is audited on the ledger.
+type Policies struct {
+	cfg  *AppConfig
+	sp   *ZoneSetpoints
+	path string
+	lg   *slog.Logger
+
+	mu    sync.RWMutex
+	zones map[string]ZonePolicy
+
+	amu     sync.Mutex
+	pub     PolicyAuditPublisher
+	pending []PolicyAudit
+}
+
+// NewPolicies stores policies at path; an empty path disables persistence while audits
+// are still published.
+func NewPolicies(cfg *AppConfig, sp *ZoneSetpoints, path string, lg *slog.Logger) *Policies {
+	return &Policies{cfg: cfg, sp: sp, path: path, lg: lg, zones: map[string]ZonePolicy{}}
+}
+
+// Load applies the persisted policies. A missing file is not an error; policies that no
+// longer validate, e.g. because a restored setpoint falls outside their range, are
+// skipped with a warning and the zone follows its properties.
+func (p *Policies) Load() (int, error) {
+	if p.path == "" {
+		return 0, nil
+	}
+	b, err := os.ReadFile(p.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("policy store: %w", err)
+	}
+	var f policyFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return 0, fmt.Errorf("policy store %s: %w", p.path, err)
+	}
+	min, max := p.sp.Range()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for zone, pol := range f.Policies {
+		err := pol.validate(min, max)
+		if err == nil {
+			err = p.sp.SetRange(zone, pol.MinSetpointC, pol.MaxSetpointC)
+		}
+		if err != nil {
+			p.lg.Warn("policy store: policy skipped", "zone", zone, "error", err)
+			continue
+		}
+		p.zones[zone] = pol
+	}
+	return len(p.zones), nil
+}
+
+// override returns the API policy of the zone; p may be nil.
+func (p *Policies) override(zone string) (ZonePolicy, bool) {
+	if p == nil {
+		return ZonePolicy{}, false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	pol, ok := p.zones[zone]
+	return pol, ok
+}
+
+// Get returns the policy in force for the zone and where it comes from.
+func (p *Policies) Get(zone string) (ZonePolicy, string, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.effectiveLocked(zone)
+}
+
+func (p *Policies) effectiveLocked(zone string) (ZonePolicy, string, error) {
+	if _, ok := p.sp.Get(zone); !ok {
+		return ZonePolicy{}, "", fmt.Errorf("%w: %s", ErrUnknownZone, zone)
+	}
+	if pol, ok := p.zones[zone]; ok {
+		return pol.clone(), PolicySourceAPI, nil
+	}
+	return p.baseline(zone), PolicySourceProperties, nil
+}
+
+// baseline is the policy the properties give the zone.
+func (p *Policies) baseline(zone string) ZonePolicy {
+	min, max := p.sp.Range()
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	return ZonePolicy{
+		HysteresisC:  p.cfg.ZoneHysteresis[zone],
+		FanSteps:     slices.Clone(p.cfg.FanSteps),
+		FanSpeeds:    slices.Clone(p.cfg.FanSpeeds),
+		MinSetpointC: min,
+		MaxSetpointC: max,
+		Strategy:     p.cfg.propertyStrategy(zone),
+	}
+}
+
+// Update replaces the zone's policy provided etag is the ETag of the policy in force; a
+// nil next reverts the zone to its properties. The zone's current setpoint must lie in
+// the new setpoint range. The change is persisted and audited; the policy now in force,
+// its source and the change are returned.
+func (p *Policies) Update(zone, etag string, next *ZonePolicy, actor, reason string) (ZonePolicy, string, error) {
+	p.mu.Lock()
+	cur, source, err := p.effectiveLocked(zone)
+	if err != nil {
+		p.mu.Unlock()
+		return ZonePolicy{}, "", err
+	}
+	if etag != cur.ETag() {
+		p.mu.Unlock()
+		return ZonePolicy{}, "", ErrPolicyConflict
+	}
+	var pol ZonePolicy
+	if next != nil {
+		pol = next.clone()
+		min, max := p.sp.Range()
+		if err := pol.validate(min, max); err != nil {
+			p.mu.Unlock()
+			return ZonePolicy{}, "", err
+		}
+		if err := p.sp.SetRange(zone, pol.MinSetpointC, pol.MaxSetpointC); err != nil {
+			p.mu.Unlock()
+			return ZonePolicy{}, "", err
+		}
+		p.zones[zone] = pol
+		source = PolicySourceAPI
+	} else {
+		if source == PolicySourceProperties {
+			p.mu.Unlock()
+			return cur, source, nil
+		}
+		p.sp.ClearRange(zone)
+		delete(p.zones, zone)
+		pol, source = p.baseline(zone), PolicySourceProperties
+	}
+	snapshot := make(map[string]ZonePolicy, len(p.zones))
+	for z, v := range p.zones {
+		snapshot[z] = v
+	}
+	p.mu.Unlock()
+
+	now := time.Now()
+	if err := writeFileAtomic(p.path, ".policies-*.json", policyFile{SavedAt: now.UTC(), Policies: snapshot}); err != nil {
+		p.lg.Error("policy store: save failed", "path", p.path, "error", err)
+	}
+	p.amu.Lock()
+	p.pending = append(p.pending, PolicyAudit{
+		SchemaVersion: LedgerSchemaVersion, Type: AuditTypePolicy, ZoneID: zone, Actor: actor, Reason: reason,
+		Old: cur, New: pol, Source: source, Timestamp: now.UnixMilli(),
+	})
+	p.amu.Unlock()
+	p.flush()
+	return pol.clone(), source, nil
+}
+
+// SetPublisher attaches the ledger publisher and flushes audits queued until then.
+func (p *Policies) SetPublisher(pub PolicyAuditPublisher) {
+	p.amu.Lock()
+	p.pub = pub
+	p.amu.Unlock()
+	p.flush()
+}
+
+func (p *Policies) flush() {
+	p.amu.Lock()
+	defer p.amu.Unlock()
+	if p.pub == nil {
+		return
+	}
+	for len(p.pending) > 0 {
+		ev := p.pending[0]
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := p.pub.PublishPolicyAudit(ctx, ev)
+		cancel()
+		if err != nil {
+			p.lg.Error("policy audit publish failed", "zone", ev.ZoneID, "queued", len(p.pending), "error", err)
+			return
+		}
+		p.lg.Info("[MAPE] policy audit", "zone", ev.ZoneID, "actor", ev.Actor, "source", ev.Source, "reason", ev.Reason)
+		p.pending = p.pending[1:]
+	}
+}
+
+// Pending returns how many audits are waiting for delivery.
+func (p *Policies) Pending() int {
+	p.amu.Lock()
+	defer p.amu.Unlock()
+	return len(p.pending)
+}
@@ -0,0 +1,146 @@
+// v0
+// services/mape/internal/policy_test.go
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type policyAuditRecorder struct {
+	mu  sync.Mutex
+	evs []PolicyAudit
+}
+
+func (r *policyAuditRecorder) PublishPolicyAudit(_ context.Context, ev PolicyAudit) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.evs = append(r.evs, ev)
+	return nil
+}
+
+func TestZonePolicyAPI(t *testing.T) {
+	cfg := &AppConfig{
+		Zones:          []string{"zone-A"},
+		ZoneTargets:    map[string]float64{"zone-A": 21},
+		ZoneHysteresis: map[string]float64{"zone-A": 0.5},
+		FanSteps:       []float64{0.5, 1.0},
+		FanSpeeds:      []int{25, 100},
+	}
+	store, err := NewZoneSetpoints(cfg.Zones, cfg.ZoneTargets, 10.0, 35.0)
+	if err != nil {
+		t.Fatalf("setpoints: %v", err)
+	}
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	path := filepath.Join(t.TempDir(), "policies.json")
+	cfg.Policies = NewPolicies(cfg, store, path, lg)
+	audits := &policyAuditRecorder{}
+	cfg.Policies.SetPublisher(audits)
+	srv := NewHTTPServer(cfg, store, nil, nil, lg)
+	do := func(method, target, ifMatch, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		req.Header.Set("X-Actor", "alice")
+		rec := httptest.NewRecorder()
+		srv.http.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	var got struct {
+		Source string     `json:"source"`
+		Policy ZonePolicy `json:"policy"`
+	}
+
+	rec := do(http.MethodGet, "/zones/zone-A/policy", "", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("get: %d %s", rec.Code, rec.Body.String())
+	}
+	if got.Source != PolicySourceProperties || got.Policy.HysteresisC != 0.5 || got.Policy.Strategy != StrategyHysteresis || got.Policy.MaxSetpointC != 35 {
+		t.Fatalf("unexpected baseline: %+v", got)
+	}
+	base := rec.Header().Get("ETag")
+	req := httptest.NewRequest(http.MethodGet, "/zones/zone-A/policy", nil)
+	req.Header.Set("If-None-Match", base)
+	rec = httptest.NewRecorder()
+	srv.http.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", rec.Code)
+	}
+
+	body := `{"hysteresisC":0.3,"fanSteps":[0.4,1.2],"fanSpeeds":[40,90],"minSetpointC":19,"maxSetpointC":24,"strategy":"pid","reason":"open space"}`
+	for name, tc := range map[string]struct {
+		ifMatch, body string
+		code          int
+	}{
+		"missing If-Match": {"", body, http.StatusPreconditionRequired},
+		"stale ETag":       {`"0000"`, body, http.StatusPreconditionFailed},
+		"descending steps": {base, strings.Replace(body, "[0.4,1.2]", "[1.2,0.4]", 1), http.StatusBadRequest},
+		"outside global":   {base, strings.Replace(body, `"maxSetpointC":24`, `"maxSetpointC":40`, 1), http.StatusBadRequest},
+		"excludes current": {base, strings.Replace(body, `"minSetpointC":19`, `"minSetpointC":22`, 1), http.StatusBadRequest},
+		"unknown strategy": {base, strings.Replace(body, `"pid"`, `"fuzzy"`, 1), http.StatusBadRequest},
+	} {
+		if rec := do(http.MethodPut, "/zones/zone-A/policy", tc.ifMatch, tc.body); rec.Code != tc.code {
+			t.Fatalf("%s: expected %d, got %d %s", name, tc.code, rec.Code, rec.Body.String())
+		}
+	}
+	if cfg.HysteresisFor("zone-A") != 0.5 || len(audits.evs) != 0 {
+		t.Fatalf("rejected updates must not apply")
+	}
+
+	rec = do(http.MethodPut, "/zones/zone-A/policy", base, body)
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || rec.Code != http.StatusOK || got.Source != PolicySourceAPI {
+		t.Fatalf("put: %d %s", rec.Code, rec.Body.String())
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == base || etag != got.Policy.ETag() {
+		t.Fatalf("unexpected ETag %s", etag)
+	}
+	steps, speeds := cfg.FanFor("zone-A")
+	if cfg.HysteresisFor("zone-A") != 0.3 || cfg.StrategyFor("zone-A") != StrategyPID || steps[0] != 0.4 || speeds[1] != 90 {
+		t.Fatalf("policy not in force")
+	}
+	if _, err := store.Set("zone-A", 25); err == nil {
+		t.Fatalf("setpoint outside the policy range must be refused")
+	}
+	if len(audits.evs) != 1 || audits.evs[0].Actor != "alice" || audits.evs[0].Old.HysteresisC != 0.5 || audits.evs[0].New.Strategy != StrategyPID {
+		t.Fatalf("unexpected audits: %+v", audits.evs)
+	}
+	if rec := do(http.MethodPut, "/zones/zone-A/policy", base, body); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("reusing the old ETag must conflict, got %d", rec.Code)
+	}
+
+	// The policy survives a restart.
+	restored := NewPolicies(cfg, store, path, lg)
+	if n, err := restored.Load(); err != nil || n != 1 {
+		t.Fatalf("load: %d %v", n, err)
+	}
+	if pol, source, _ := restored.Get("zone-A"); source != PolicySourceAPI || pol.ETag() != etag {
+		t.Fatalf("unexpected restored policy: %s %+v", source, pol)
+	}
+
+	rec = do(http.MethodDelete, "/zones/zone-A/policy?reason=done", etag, "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || rec.Code != http.StatusOK || got.Source != PolicySourceProperties {
+		t.Fatalf("delete: %d %s", rec.Code, rec.Body.String())
+	}
+	if cfg.HysteresisFor("zone-A") != 0.5 || cfg.StrategyFor("zone-A") != StrategyHysteresis {
+		t.Fatalf("zone must follow its properties again")
+	}
+	if min, max := store.RangeFor("zone-A"); min != 10 || max != 35 {
+		t.Fatalf("range not reverted: %v..%v", min, max)
+	}
+	if len(audits.evs) != 2 || audits.evs[1].Source != PolicySourceProperties || audits.evs[1].Reason != "done" {
+		t.Fatalf("unexpected audits: %+v", audits.evs)
+	}
+	if rec := do(http.MethodGet, "/zones/zone-Z/policy", "", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown zone: expected 404, got %d", rec.Code)
+	}
+}
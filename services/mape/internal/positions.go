@@ -0,0 +1,88 @@
+// v0
+// services/mape/internal/positions.go
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type positionsFile struct {
+	SavedAt time.Time `json:"savedAt"`
+	// Offsets maps "<topic>/<partition>" to the offset of the last planned report.
+	Offsets map[string]int64 `json:"offsets"`
+}
+
+// readPositions persists, per aggregator partition, the offset of the last report the
+// engine planned, so that zone readers resume right after it on restart. The zone readers
+// join no consumer group, so this file is the only record of how far MAPE got.
+type readPositions struct {
+	path    string
+	lg      *slog.Logger
+	mu      sync.Mutex
+	offsets map[string]int64
+}
+
+// newReadPositions loads the positions stored at path. A missing file is not an error; an
+// empty path keeps positions in memory only.
+func newReadPositions(path string, lg *slog.Logger) (*readPositions, error) {
+	p := &readPositions{path: path, lg: lg, offsets: map[string]int64{}}
+	if path == "" {
+		return p, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("offset store: %w", err)
+	}
+	var f positionsFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("offset store %s: %w", path, err)
+	}
+	for k, off := range f.Offsets {
+		p.offsets[k] = off
+	}
+	return p, nil
+}
+
+func positionKey(topic string, partition int) string {
+	return topic + "/" + strconv.Itoa(partition)
+}
+
+// next returns the offset to resume the partition from, if a report of it was planned.
+// p may be nil.
+func (p *readPositions) next(topic string, partition int) (int64, bool) {
+	if p == nil {
+		return 0, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	off, ok := p.offsets[positionKey(topic, partition)]
+	return off + 1, ok
+}
+
+// done records that the report at offset was planned and saves the positions; offsets
+// at or before the recorded one are ignored. p may be nil.
+func (p *readPositions) done(topic string, partition int, offset int64) {
+	if p == nil {
+		return
+	}
+	key := positionKey(topic, partition)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cur, ok := p.offsets[key]; ok && offset <= cur {
+		return
+	}
+	p.offsets[key] = offset
+	if err := writeFileAtomic(p.path, ".offsets-*.json", positionsFile{SavedAt: time.Now().UTC(), Offsets: p.offsets}); err != nil {
+		p.lg.Error("offset store: save failed", "path", p.path, "error", err)
+	}
+}
@@ -0,0 +1,49 @@
+// v0
+// services/mape/internal/positions_test.go
+package internal
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadPositionsResumeAfterLastPlanned(t *testing.T) {
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	path := filepath.Join(t.TempDir(), "data", "offsets.json")
+	p, err := newReadPositions(path, lg)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if _, ok := p.next("agg-to-mape", 0); ok {
+		t.Fatalf("no position expected before a report is planned")
+	}
+	p.done("agg-to-mape", 0, 41)
+	p.done("agg-to-mape", 0, 40) // an older report never moves the position back
+	p.done("agg-to-mape", 1, 7)
+
+	reloaded, err := newReadPositions(path, lg)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if off, ok := reloaded.next("agg-to-mape", 0); !ok || off != 42 {
+		t.Fatalf("expected to resume partition 0 at 42, got %d %v", off, ok)
+	}
+	if off, ok := reloaded.next("agg-to-mape", 1); !ok || off != 8 {
+		t.Fatalf("expected to resume partition 1 at 8, got %d %v", off, ok)
+	}
+
+	var none *readPositions
+	none.done("agg-to-mape", 0, 1)
+	if _, ok := none.next("agg-to-mape", 0); ok {
+		t.Fatalf("nil positions resume nothing")
+	}
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := newReadPositions(path, lg); err == nil {
+		t.Fatalf("a corrupt offset store must fail the start")
+	}
+}
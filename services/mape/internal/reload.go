@@ -0,0 +1,330 @@
+// v5
+// services/mape/internal/reload.go
+package internal
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// zoneWiring is the part of KafkaIO a reload drives.
+type zoneWiring interface {
+	Prepare(ctx context.Context, next *AppConfig, added, moved []string) error
+	Rewire(next *AppConfig, added, moved, removed []string)
+	PublishCommands(ctx context.Context, zone string, cmds []PlanCommand) error
+}
+
+// PartitionMove is a zone whose position in `zones`, and so its aggregator partition,
+// changed.
+type PartitionMove struct {
+	Zone string `json:"zone"`
+	From int    `json:"from"`
+	To   int    `json:"to"`
+}
+
+// ActuatorChanges lists the actuator IDs of a zone that a reload added, removed or moved
+// between heating, cooling and ventilation.
+type ActuatorChanges struct {
+	Added      []string `json:"added,omitempty"`
+	Removed    []string `json:"removed,omitempty"`
+	Reassigned []string `json:"reassigned,omitempty"`
+}
+
+func (c ActuatorChanges) empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Reassigned) == 0
+}
+
+// ReloadReport is the answer of POST /config/reload: what the new properties changed in
+// the zones, their actuators and setpoints, and what was done about it. Other properties
+// are applied without being listed. Actor names who asked for the reload; the setpoint
+// changes are recorded and audited in its name.
+type ReloadReport struct {
+	Actor        string                     `json:"actor"`
+	Changed      bool                       `json:"changed"`
+	ZonesAdded   []string                   `json:"zonesAdded,omitempty"`
+	ZonesRemoved []string                   `json:"zonesRemoved,omitempty"`
+	Moved        []PartitionMove            `json:"partitionsMoved,omitempty"`
+	Actuators    map[string]ActuatorChanges `json:"actuators,omitempty"`
+	SwitchedOff  []string                   `json:"switchedOff,omitempty"`
+	Setpoints    []SetpointChange           `json:"setpoints,omitempty"`
+	Warnings     []string                   `json:"warnings,omitempty"`
+}
+
+func (r *ReloadReport) moved() []string {
+	out := make([]string, 0, len(r.Moved))
+	for _, m := range r.Moved {
+		out = append(out, m.Zone)
+	}
+	return out
+}
+
+// reloadMu runs reloads one at a time.
+var reloadMu sync.Mutex
+
+// Reload re-reads the properties file into a copy of cfg and applies it. Nothing changes
+// when the file or its setpoints are invalid, or when the topics of added zones cannot be
+// ensured. With a running engine, removed zones and actuators are switched OFF before
+// their writers close, added zones get their Kafka plumbing and workers, and moved zones
+// reopen their reader; eng may be nil, in which case only cfg and sp are updated. actor is
+// recorded with the report and the setpoint changes.
+func Reload(ctx context.Context, cfg *AppConfig, sp *ZoneSetpoints, eng *Engine, actor string) (ReloadReport, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	// Only a reload writes the configuration, so under reloadMu it may be read unlocked.
+	next := *cfg
+	if err := next.loadProperties(cfg.PropertiesPath); err != nil {
+		return ReloadReport{}, err
+	}
+	if err := sp.Check(next.ZoneTargets); err != nil {
+		return ReloadReport{}, err
+	}
+	rep := diffConfig(cfg, &next)
+	rep.Actor = actor
+	if next.Engine != cfg.Engine {
+		rep.Warnings = append(rep.Warnings, "engine.* settings take effect on restart")
+	}
+	if (next.Ack.Timeout > 0) != (cfg.Ack.Timeout > 0) {
+		rep.Warnings = append(rep.Warnings, "enabling or disabling ack.timeout_ms takes effect on restart")
+	}
+	if eng != nil {
+		if err := eng.reconfigure(ctx, &next, &rep); err != nil {
+			return ReloadReport{}, err
+		}
+	} else {
+		swapConfig(cfg, next)
+		changes, err := sp.Reset(next.ZoneTargets, actor)
+		if err != nil {
+			return ReloadReport{}, err
+		}
+		rep.Setpoints = changes
+	}
+	rep.Changed = rep.Changed || len(rep.Setpoints) > 0
+	return rep, nil
+}
+
+// diffConfig compares the zones and actuators of two configurations. A zone's partition
+// is its index in `zones`, so removing or reordering zones moves the ones after it.
+func diffConfig(old, next *AppConfig) ReloadReport {
+	var rep ReloadReport
+	was, now := positions(old.Zones), positions(next.Zones)
+	for i, zone := range next.Zones {
+		j, ok := was[zone]
+		switch {
+		case !ok:
+			rep.ZonesAdded = append(rep.ZonesAdded, zone)
+		case i != j:
+			rep.Moved = append(rep.Moved, PartitionMove{Zone: zone, From: j, To: i})
+		}
+	}
+	for _, zone := range old.Zones {
+		if _, ok := now[zone]; !ok {
+			rep.ZonesRemoved = append(rep.ZonesRemoved, zone)
+		}
+	}
+	for _, zone := range append(append([]string(nil), old.Zones...), rep.ZonesAdded...) {
+		var prev, cur ZoneActuators
+		if _, ok := was[zone]; ok {
+			prev = old.Actuators[zone]
+		}
+		if _, ok := now[zone]; ok {
+			cur = next.Actuators[zone]
+		}
+		if ch := diffActuators(prev, cur); !ch.empty() {
+			if rep.Actuators == nil {
+				rep.Actuators = map[string]ActuatorChanges{}
+			}
+			rep.Actuators[zone] = ch
+		}
+	}
+	rep.Changed = len(rep.ZonesAdded) > 0 || len(rep.ZonesRemoved) > 0 || len(rep.Moved) > 0 || len(rep.Actuators) > 0
+	return rep
+}
+
+func positions(zones []string) map[string]int {
+	out := make(map[string]int, len(zones))
+	for i, z := range zones {
+		out[z] = i
+	}
+	return out
+}
+
+// actuatorRoles maps each actuator ID to its roles, e.g. "heating" or "cooling+heating".
+func actuatorRoles(a ZoneActuators) map[string]string {
+	roles := map[string][]string{}
+	for _, r := range []struct {
+		name string
+		ids  []string
+	}{{"cooling", a.Cooling}, {"heating", a.Heating}, {"ventilation", a.Ventilation}} {
+		for _, id := range r.ids {
+			roles[id] = append(roles[id], r.name)
+		}
+	}
+	out := make(map[string]string, len(roles))
+	for id, names := range roles {
+		out[id] = strings.Join(names, "+")
+	}
+	return out
+}
+
+func diffActuators(prev, next ZoneActuators) ActuatorChanges {
+	var ch ActuatorChanges
+	was, now := actuatorRoles(prev), actuatorRoles(next)
+	for id, role := range now {
+		old, ok := was[id]
+		switch {
+		case !ok:
+			ch.Added = append(ch.Added, id)
+		case old != role:
+			ch.Reassigned = append(ch.Reassigned, id)
+		}
+	}
+	for id := range was {
+		if _, ok := now[id]; !ok {
+			ch.Removed = append(ch.Removed, id)
+		}
+	}
+	sort.Strings(ch.Added)
+	sort.Strings(ch.Removed)
+	sort.Strings(ch.Reassigned)
+	return ch
+}
+
+// subset keeps the actuators of a whose ID is in ids, each under its current roles.
+func (a ZoneActuators) subset(ids []string) ZoneActuators {
+	keep := map[string]bool{}
+	for _, id := range ids {
+		keep[id] = true
+	}
+	pick := func(src []string) []string {
+		var out []string
+		for _, id := range src {
+			if keep[id] {
+				out = append(out, id)
+			}
+		}
+		return out
+	}
+	return ZoneActuators{Heating: pick(a.Heating), Cooling: pick(a.Cooling), Ventilation: pick(a.Ventilation)}
+}
+
+// reconfigure applies next to the running engine. The workers of removed and moved zones
+// are stopped first, so no round is in flight while actuators are switched off, Kafka is
+// rewired and the configuration is swapped. Reassigned actuators are switched off too:
+// the zone's next plan commands them in their new role.
+func (e *Engine) reconfigure(ctx context.Context, next *AppConfig, rep *ReloadReport) error {
+	e.wmu.Lock()
+	defer e.wmu.Unlock()
+	moved := rep.moved()
+	if e.wire != nil {
+		if err := e.wire.Prepare(ctx, next, rep.ZonesAdded, moved); err != nil {
+			return fmt.Errorf("reload: %w", err)
+		}
+	} else if len(rep.ZonesAdded) > 0 || len(moved) > 0 {
+		rep.Warnings = append(rep.Warnings, "no Kafka wiring: zones added or moved receive no reports")
+	}
+	removedZone := map[string]bool{}
+	for _, zone := range rep.ZonesRemoved {
+		removedZone[zone] = true
+	}
+	released := map[string][]string{}
+	for zone, ch := range rep.Actuators {
+		if ids := append(append([]string(nil), ch.Removed...), ch.Reassigned...); len(ids) > 0 {
+			released[zone] = ids
+		}
+	}
+	zones := make([]string, 0, len(released))
+	for zone := range released {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	// Zones that keep running but release actuators are paused too, so that no round
+	// commands those actuators after they were switched off.
+	restart := append([]string(nil), moved...)
+	for _, zone := range zones {
+		if !removedZone[zone] && !slices.Contains(moved, zone) {
+			restart = append(restart, zone)
+		}
+	}
+	for _, zone := range append(append([]string(nil), rep.ZonesRemoved...), restart...) {
+		if w, ok := e.workers[zone]; ok {
+			w.stop()
+		}
+	}
+
+	// Switch off what is no longer controlled while its writer still exists.
+	for _, zone := range zones {
+		ids := released[zone]
+		// The reconciler must not re-send the commands these actuators were last given.
+		e.acks.Forget(ids)
+		var epoch int64
+		if w, ok := e.workers[zone]; ok {
+			epoch = w.epoch
+		}
+		reason := "released by properties reload"
+		if removedZone[zone] {
+			reason = "zone removed by properties reload"
+		}
+		cmds := commandsFor(zone, e.cfg.Actuators[zone].subset(ids), epoch, AnalysisResult{Action: "OFF", Reason: reason})
+		if e.wire == nil {
+			continue
+		}
+		if err := e.wire.PublishCommands(ctx, zone, cmds); err != nil {
+			e.lg.Error("reload switch-off", "zone", zone, "actuators", ids, "error", err)
+			rep.Warnings = append(rep.Warnings, fmt.Sprintf("zone %s: could not switch off %s: %v", zone, strings.Join(ids, ","), err))
+			continue
+		}
+		e.lg.Info("actuators switched off", "zone", zone, "actuators", ids, "reason", reason)
+		rep.SwitchedOff = append(rep.SwitchedOff, ids...)
+	}
+	if e.wire != nil {
+		e.wire.Rewire(next, rep.ZonesAdded, moved, rep.ZonesRemoved)
+	}
+
+	swapConfig(e.cfg, *next)
+	changes, err := e.sp.Reset(next.ZoneTargets, rep.Actor)
+	if err != nil {
+		rep.Warnings = append(rep.Warnings, "setpoints not reset: "+err.Error())
+	}
+	rep.Setpoints = changes
+
+	for _, ids := range released {
+		e.pln.guards.Forget("", ids)
+		e.diag.Forget("", ids)
+		e.pln.staging.Forget("", ids)
+	}
+	for _, zone := range rep.ZonesRemoved {
+		e.pln.guards.Forget(zone, nil)
+		e.an.Forget(zone)
+		e.an.sched.Forget(zone)
+		e.bud.Forget(zone)
+		e.shad.Forget(zone)
+		e.dec.Forget(zone)
+		e.diag.Forget(zone, nil)
+		e.pln.staging.Forget(zone, nil)
+		delete(e.workers, zone)
+		e.mu.Lock()
+		delete(e.zones, zone)
+		delete(e.stats.ZoneEnergy, zone)
+		delete(e.stats.EnergyField, zone)
+		e.mu.Unlock()
+	}
+	now := time.Now()
+	for _, zone := range rep.ZonesAdded {
+		e.workers[zone] = newZoneWorker(zone, now, e.params)
+		e.mu.Lock()
+		e.zones[zone] = &ZoneLoopStats{}
+		e.mu.Unlock()
+	}
+	if e.ctx != nil {
+		for _, zone := range append(append([]string(nil), rep.ZonesAdded...), restart...) {
+			e.start(e.workers[zone])
+		}
+	}
+	e.lg.Info("configuration reloaded", "zones", next.Zones, "added", rep.ZonesAdded, "removed", rep.ZonesRemoved, "moved", moved, "switched_off", rep.SwitchedOff)
+	return nil
+}
@@ -0,0 +1,227 @@
+// v3
+// services/mape/internal/reload_test.go
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiffConfigZonesAndActuators(t *testing.T) {
+	old := &AppConfig{
+		Zones: []string{"zone-A", "zone-B", "zone-C"},
+		Actuators: map[string]ZoneActuators{
+			"zone-A": {Heating: []string{"h1", "h2"}, Ventilation: []string{"v1"}},
+			"zone-B": {Heating: []string{"h3"}},
+		},
+	}
+	next := &AppConfig{
+		Zones: []string{"zone-A", "zone-C", "zone-D"},
+		Actuators: map[string]ZoneActuators{
+			"zone-A": {Heating: []string{"h1"}, Cooling: []string{"v1"}, Ventilation: []string{"v2"}},
+			"zone-B": {Heating: []string{"h3"}}, // left behind for a zone no longer listed
+			"zone-D": {Cooling: []string{"c4"}},
+		},
+	}
+	rep := diffConfig(old, next)
+	if !rep.Changed || len(rep.ZonesAdded) != 1 || rep.ZonesAdded[0] != "zone-D" || len(rep.ZonesRemoved) != 1 || rep.ZonesRemoved[0] != "zone-B" {
+		t.Fatalf("unexpected zones: %+v", rep)
+	}
+	if len(rep.Moved) != 1 || rep.Moved[0] != (PartitionMove{Zone: "zone-C", From: 2, To: 1}) {
+		t.Fatalf("unexpected moves: %+v", rep.Moved)
+	}
+	a := rep.Actuators["zone-A"]
+	if len(a.Added) != 1 || a.Added[0] != "v2" || len(a.Removed) != 1 || a.Removed[0] != "h2" || len(a.Reassigned) != 1 || a.Reassigned[0] != "v1" {
+		t.Fatalf("unexpected zone-A actuators: %+v", a)
+	}
+	if b := rep.Actuators["zone-B"]; len(b.Removed) != 1 || b.Removed[0] != "h3" {
+		t.Fatalf("removed zone must release its actuators: %+v", b)
+	}
+	if d := rep.Actuators["zone-D"]; len(d.Added) != 1 || d.Added[0] != "c4" {
+		t.Fatalf("added zone must list its actuators: %+v", d)
+	}
+	if _, ok := rep.Actuators["zone-C"]; ok {
+		t.Fatalf("zone-C did not change: %+v", rep.Actuators)
+	}
+	if rep := diffConfig(old, old); rep.Changed {
+		t.Fatalf("identical configurations must not differ: %+v", rep)
+	}
+}
+
+// fakeWiring records what a reload asked of Kafka.
+type fakeWiring struct {
+	mu      sync.Mutex
+	fail    error
+	rewired [][]string // added, moved, removed
+	off     map[string][]PlanCommand
+}
+
+func (f *fakeWiring) Prepare(context.Context, *AppConfig, []string, []string) error { return f.fail }
+
+func (f *fakeWiring) Rewire(_ *AppConfig, added, moved, removed []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rewired = [][]string{added, moved, removed}
+}
+
+func (f *fakeWiring) PublishCommands(_ context.Context, zone string, cmds []PlanCommand) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.off[zone] = append(f.off[zone], cmds...)
+	return nil
+}
+
+func TestReloadRewiresRunningEngine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mape.properties")
+	write := func(body string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatalf("write properties: %v", err)
+		}
+	}
+	write("zones=zone-A,zone-B\ntarget=21\nhysteresis=0.5\nactuators.heating.zone-A=h1,h2\nactuators.heating.zone-B=h3\nactuators.ventilation.zone-B=v3\n")
+	cfg := &AppConfig{PropertiesPath: path, PollIntervalMs: 10}
+	if err := cfg.loadProperties(path); err != nil {
+		t.Fatalf("loadProperties: %v", err)
+	}
+	store, err := NewZoneSetpoints(cfg.Zones, cfg.ZoneTargets, 10.0, 35.0)
+	if err != nil {
+		t.Fatalf("setpoints: %v", err)
+	}
+	prev := engineRef
+	defer func() { engineRef = prev }()
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	e := NewEngine(cfg, store, nil, nil, lg, nil)
+	src := chanSource{"zone-A": make(chan Reading), "zone-B": make(chan Reading), "zone-C": make(chan Reading)}
+	pub := &gatedPublisher{got: map[string][]LedgerEvent{}}
+	wire := &fakeWiring{off: map[string][]PlanCommand{}}
+	e.mon.src, e.exe.pub, e.wire = src, pub, wire
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	read := func(zone string, epoch int64) Reading {
+		return Reading{ZoneID: zone, EpochIndex: epoch, AvgTempC: 19, HasTemp: true}
+	}
+	src["zone-B"] <- read("zone-B", 1)
+	waitFor(t, "zone-B plan", func() bool { return len(pub.epochs("zone-B")) == 1 })
+
+	// A failing Prepare leaves everything as it was.
+	write("zones=zone-A,zone-C\ntarget=21\nhysteresis=0.5\ntarget.zone-C=19\nactuators.heating.zone-A=h1\nactuators.heating.zone-C=h4\n")
+	wire.fail = context.DeadlineExceeded
+	if _, err := Reload(ctx, cfg, store, e, "alice"); err == nil || len(cfg.Zones) != 2 || cfg.Zones[1] != "zone-B" {
+		t.Fatalf("failed reload must not apply: %v %v", err, cfg.Zones)
+	}
+	wire.fail = nil
+
+	srv := NewHTTPServer(cfg, store, nil, nil, lg)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/config/reload", nil)
+	req.Header.Set("X-Actor", "alice")
+	srv.http.Handler.ServeHTTP(rec, req)
+	var rep ReloadReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &rep); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("reload: %d %s", rec.Code, rec.Body.String())
+	}
+	if rep.Actor != "alice" {
+		t.Fatalf("reload actor not recorded: %+v", rep)
+	}
+	for _, c := range rep.Setpoints {
+		if c.Actor != "alice" {
+			t.Fatalf("setpoint change not recorded for the reload actor: %+v", c)
+		}
+	}
+	if len(rep.ZonesAdded) != 1 || rep.ZonesAdded[0] != "zone-C" || len(rep.ZonesRemoved) != 1 || rep.ZonesRemoved[0] != "zone-B" {
+		t.Fatalf("unexpected report: %+v", rep)
+	}
+	if len(rep.SwitchedOff) != 3 || len(wire.off["zone-A"]) != 1 || wire.off["zone-A"][0].ActuatorID != "h2" || len(wire.off["zone-B"]) != 2 {
+		t.Fatalf("released actuators must be switched off: %+v %+v", rep.SwitchedOff, wire.off)
+	}
+	for _, c := range wire.off["zone-B"] {
+		if c.Mode != "OFF" && c.Mode != "0" {
+			t.Fatalf("unexpected switch-off command: %+v", c)
+		}
+	}
+	if got := wire.rewired; len(got[0]) != 1 || len(got[2]) != 1 || got[2][0] != "zone-B" {
+		t.Fatalf("unexpected rewire: %v", got)
+	}
+	if v, ok := store.Get("zone-C"); !ok || v != 19 {
+		t.Fatalf("zone-C setpoint not adopted: %v %v", v, ok)
+	}
+	if _, ok := store.Get("zone-B"); ok {
+		t.Fatalf("zone-B setpoint must be dropped")
+	}
+
+	// The added zone is served without a restart; the removed one is gone from the stats.
+	src["zone-C"] <- read("zone-C", 1)
+	waitFor(t, "zone-C plan", func() bool { return len(pub.epochs("zone-C")) == 1 })
+	if _, ok := globalStats().Zones["zone-B"]; ok {
+		t.Fatalf("zone-B stats must be dropped")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("engine did not stop")
+	}
+}
+
+func TestReloadWithoutEngineSwapsUnderLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mape.properties")
+	if err := os.WriteFile(path, []byte("zones=zone-A\ntarget=21\nhysteresis=0.5\nactuators.heating.zone-A=h1\n"), 0o644); err != nil {
+		t.Fatalf("write properties: %v", err)
+	}
+	cfg := &AppConfig{PropertiesPath: path}
+	if err := cfg.loadProperties(path); err != nil {
+		t.Fatalf("loadProperties: %v", err)
+	}
+	store, err := NewZoneSetpoints(cfg.Zones, cfg.ZoneTargets, 10.0, 35.0)
+	if err != nil {
+		t.Fatalf("setpoints: %v", err)
+	}
+	srv := NewHTTPServer(cfg, store, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// HTTP handlers keep consulting the configuration while it is reloaded; run with -race.
+	stop, started := make(chan struct{}), make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			rec := httptest.NewRecorder()
+			srv.http.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/zones/zone-A/occupancy", nil))
+			if i == 0 {
+				close(started)
+			}
+			if rec.Code == http.StatusNotFound {
+				t.Errorf("zone-A vanished during a reload")
+				return
+			}
+		}
+	}()
+	<-started
+	for i := 0; i < 200; i++ {
+		if _, err := Reload(context.Background(), cfg, store, nil, "alice"); err != nil {
+			t.Fatalf("reload: %v", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,256 @@
+// v2
+// services/mape/internal/safety.go
+package internal
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Interlock types recorded in the ledger event and counted on /status.
+const (
+	InterlockNoTemperature = "no_temperature"
+	InterlockStaleData     = "stale_data"
+	InterlockFrozenSensor  = "frozen_sensor"
+	InterlockFrost         = "frost_protection"
+	InterlockOverheat      = "overheat_cutoff"
+	InterlockHeatCool      = "heat_cool_conflict"
+)
+
+// StrategySafety marks decisions taken by the safety layer instead of a strategy.
+const StrategySafety = "safety"
+
+// Interlock is one safety rule that overrode the zone's decision.
+type Interlock struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// SafetyParams are the absolute limits and sensor checks enforced on every zone.
+type SafetyParams struct {
+	MinTempC       float64       // frost protection: HEAT is forced below
+	MaxTempC       float64       // overheat cutoff: heating is cut and COOL forced above
+	StaleAfter     time.Duration // a report, or the lack of one, older than this is stale (0 disables)
+	FrozenEpochs   int           // identical temperatures in a row that mark the sensor frozen (0 disables)
+	FallbackAction string        // safe state while the temperature cannot be trusted
+	FallbackFan    int
+}
+
+// DefaultSafetyParams keep zones above 5°C and below 35°C and switch them OFF when no
+// temperature has been seen for five minutes.
+func DefaultSafetyParams() SafetyParams {
+	return SafetyParams{MinTempC: 5, MaxTempC: 35, StaleAfter: 5 * time.Minute, FallbackAction: "OFF"}
+}
+
+func (p *SafetyParams) set(param, v string) error {
+	if param == "fallback_action" {
+		a := strings.ToUpper(v)
+		if a != "OFF" && a != "HEAT" && a != "COOL" {
+			return fmt.Errorf("safety.fallback_action must be OFF, HEAT or COOL, got %q", v)
+		}
+		p.FallbackAction = a
+		return nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fmt.Errorf("safety.%s: %w", param, err)
+	}
+	switch param {
+	case "min_temp_c":
+		p.MinTempC = f
+	case "max_temp_c":
+		p.MaxTempC = f
+	case "stale_s":
+		p.StaleAfter = time.Duration(f * float64(time.Second))
+	case "frozen_epochs":
+		p.FrozenEpochs = int(f)
+	case "fallback_fan":
+		if f < 0 || f > 100 {
+			return fmt.Errorf("safety.fallback_fan must be within 0..100, got %g", f)
+		}
+		p.FallbackFan = int(f)
+	default:
+		return fmt.Errorf("unknown safety parameter %q", param)
+	}
+	return nil
+}
+
+type sensorTrack struct {
+	epoch  int64
+	temp   float64
+	repeat int
+}
+
+// SafetyStatus lists the interlocks active per zone in the latest round and how many
+// times each type fired since start.
+type SafetyStatus struct {
+	Active      map[string][]Interlock `json:"active,omitempty"`
+	Activations map[string]int64       `json:"activations,omitempty"`
+}
+
+// SafetyLayer decides when a zone's temperature cannot be trusted and keeps the interlock
+// history shown on /status. Absolute limits are applied by Plan through enforceLimits.
+type SafetyLayer struct {
+	cfg     *AppConfig
+	mu      sync.Mutex
+	sensors map[string]*sensorTrack
+	active  map[string][]Interlock
+	count   map[string]int64
+	now     func() time.Time
+}
+
+func NewSafetyLayer(cfg *AppConfig) *SafetyLayer {
+	return &SafetyLayer{cfg: cfg, sensors: map[string]*sensorTrack{}, active: map[string][]Interlock{}, count: map[string]int64{}, now: time.Now}
+}
+
+// Inspect returns the sensor interlock raised by the reading, or nil when its temperature
+// can be used: it must be present, finite, recent and not stuck at the same value.
+func (s *SafetyLayer) Inspect(zone string, read Reading) *Interlock {
+	p := s.cfg.Safety
+	if !read.HasTemp || math.IsNaN(read.AvgTempC) || math.IsInf(read.AvgTempC, 0) {
+		return &Interlock{Type: InterlockNoTemperature, Reason: fmt.Sprintf("epoch %d carries no temperature", read.EpochIndex)}
+	}
+	if p.StaleAfter > 0 {
+		if end, err := time.Parse(time.RFC3339Nano, read.EpochEnd); err == nil {
+			if age := s.now().Sub(end); age > p.StaleAfter {
+				return &Interlock{Type: InterlockStaleData, Reason: fmt.Sprintf("epoch %d ended %s ago (limit %s)", read.EpochIndex, age.Round(time.Second), p.StaleAfter)}
+			}
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tr, ok := s.sensors[zone]
+	if !ok {
+		tr = &sensorTrack{epoch: read.EpochIndex, temp: read.AvgTempC, repeat: 1}
+		s.sensors[zone] = tr
+		return nil
+	}
+	if read.EpochIndex != tr.epoch {
+		if read.AvgTempC == tr.temp {
+			tr.repeat++
+		} else {
+			tr.repeat = 1
+		}
+		tr.epoch, tr.temp = read.EpochIndex, read.AvgTempC
+	}
+	if p.FrozenEpochs > 0 && tr.repeat >= p.FrozenEpochs {
+		return &Interlock{Type: InterlockFrozenSensor, Reason: fmt.Sprintf("temperature stuck at %.2fC for %d epochs", tr.temp, tr.repeat)}
+	}
+	return nil
+}
+
+// Fallback is the safe-state decision used while the zone's temperature is not trusted.
+func (s *SafetyLayer) Fallback(ilk Interlock) AnalysisResult {
+	p := s.cfg.Safety
+	action := p.FallbackAction
+	if action == "" {
+		action = "OFF"
+	}
+	return AnalysisResult{
+		Action: action, Fan: p.FallbackFan, Strategy: StrategySafety,
+		Reason: "safe state: " + ilk.Reason, Interlocks: []Interlock{ilk},
+	}
+}
+
+// Observe records the interlocks that shaped the zone's executed plan.
+func (s *SafetyLayer) Observe(zone string, ilks []Interlock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(ilks) == 0 {
+		delete(s.active, zone)
+		return
+	}
+	s.active[zone] = append([]Interlock(nil), ilks...)
+	for _, ilk := range ilks {
+		s.count[ilk.Type]++
+	}
+}
+
+// Forget drops the sensor history and active interlocks of a removed zone.
+func (s *SafetyLayer) Forget(zone string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sensors, zone)
+	delete(s.active, zone)
+}
+
+// Status returns the active interlocks and activation counters.
+func (s *SafetyLayer) Status() SafetyStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := SafetyStatus{Active: map[string][]Interlock{}, Activations: map[string]int64{}}
+	for z, ilks := range s.active {
+		st.Active[z] = append([]Interlock(nil), ilks...)
+	}
+	for t, n := range s.count {
+		st.Activations[t] = n
+	}
+	return st
+}
+
+// enforceLimits forces HEAT below the frost limit and cuts heating above the overheat
+// limit, cooling where coolers exist. It only applies to decisions with a trusted
+// temperature and returns the interlocks that changed res.
+func enforceLimits(cfg *AppConfig, zone string, res *AnalysisResult) []Interlock {
+	p := cfg.Safety
+	if !res.HasTemp || p.MinTempC >= p.MaxTempC {
+		return nil
+	}
+	acts := cfg.Actuators[zone]
+	fan := cfg.fanFor(zone, abs(res.Delta))
+	switch {
+	case res.TempC < p.MinTempC && res.Action != "HEAT":
+		ilk := Interlock{Type: InterlockFrost, Reason: fmt.Sprintf("%.2fC below frost limit %.2fC, %s overridden", res.TempC, p.MinTempC, res.Action)}
+		res.Action, res.Fan, res.Duty, res.Reason = "HEAT", max(fan, res.Fan), 100, ilk.Reason
+		return []Interlock{ilk}
+	case res.TempC > p.MaxTempC && res.Action != "COOL":
+		if res.Action != "HEAT" && len(acts.Cooling) == 0 {
+			return nil
+		}
+		ilk := Interlock{Type: InterlockOverheat, Reason: fmt.Sprintf("%.2fC above overheat limit %.2fC, %s overridden", res.TempC, p.MaxTempC, res.Action)}
+		res.Action, res.Duty, res.Reason = "COOL", 100, ilk.Reason
+		if len(acts.Cooling) == 0 {
+			res.Action, res.Duty = "OFF", 0
+		}
+		res.Fan = max(fan, res.Fan)
+		return []Interlock{ilk}
+	}
+	return nil
+}
+
+// enforceExclusive guarantees heating and cooling are never ON together: if any heater
+// and any cooler would both be ON, or one actuator is listed as both, every heater and
+// cooler command is turned OFF.
+func enforceExclusive(cfg *AppConfig, zone string, cmds []PlanCommand) *Interlock {
+	acts := cfg.Actuators[zone]
+	heaters, coolers := map[string]bool{}, map[string]bool{}
+	for _, id := range acts.Heating {
+		heaters[id] = true
+	}
+	for _, id := range acts.Cooling {
+		coolers[id] = true
+	}
+	var heatOn, coolOn, shared bool
+	for _, c := range cmds {
+		on := strings.EqualFold(c.Mode, "ON")
+		if heaters[c.ActuatorID] && coolers[c.ActuatorID] {
+			shared = true
+		}
+		heatOn = heatOn || (on && heaters[c.ActuatorID])
+		coolOn = coolOn || (on && coolers[c.ActuatorID])
+	}
+	if !(heatOn && coolOn) && !(shared && (heatOn || coolOn)) {
+		return nil
+	}
+	ilk := &Interlock{Type: InterlockHeatCool, Reason: "heating and cooling would both be ON; both forced OFF"}
+	for i := range cmds {
+		if heaters[cmds[i].ActuatorID] || coolers[cmds[i].ActuatorID] {
+			cmds[i].Mode, cmds[i].FanPercent, cmds[i].Reason = "OFF", 0, ilk.Reason
+		}
+	}
+	return ilk
+}
@@ -0,0 +1,108 @@
+// v0
+// services/mape/internal/safety_test.go
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestSafetyLayerInspectsSensorData(t *testing.T) {
+	cfg := &AppConfig{Safety: SafetyParams{MinTempC: 5, MaxTempC: 35, StaleAfter: time.Minute, FrozenEpochs: 3, FallbackAction: "OFF", FallbackFan: 25}}
+	s := NewSafetyLayer(cfg)
+	now := time.Date(2025, 1, 6, 8, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	read := func(epoch int64, temp float64, has bool, end time.Time) Reading {
+		return Reading{EpochIndex: epoch, AvgTempC: temp, HasTemp: has, EpochEnd: end.Format(time.RFC3339Nano)}
+	}
+
+	ilk := s.Inspect("zone-A", read(1, 0, false, now))
+	if ilk == nil || ilk.Type != InterlockNoTemperature {
+		t.Fatalf("missing temperature must raise an interlock, got %+v", ilk)
+	}
+	if res := s.Fallback(*ilk); res.Action != "OFF" || res.Fan != 25 || res.Strategy != StrategySafety || len(res.Interlocks) != 1 {
+		t.Fatalf("unexpected fallback: %+v", res)
+	}
+	if ilk := s.Inspect("zone-A", read(1, 21, true, now.Add(-2*time.Minute))); ilk == nil || ilk.Type != InterlockStaleData {
+		t.Fatalf("old epoch must be stale, got %+v", ilk)
+	}
+	// The same epoch read twice does not count towards a frozen sensor.
+	for _, epoch := range []int64{1, 1, 2} {
+		if ilk := s.Inspect("zone-A", read(epoch, 21, true, now)); ilk != nil {
+			t.Fatalf("epoch %d: unexpected interlock %+v", epoch, ilk)
+		}
+	}
+	if ilk := s.Inspect("zone-A", read(3, 21, true, now)); ilk == nil || ilk.Type != InterlockFrozenSensor {
+		t.Fatalf("third identical epoch must mark the sensor frozen, got %+v", ilk)
+	}
+	if ilk := s.Inspect("zone-A", read(4, 21.1, true, now)); ilk != nil {
+		t.Fatalf("a changing temperature clears the frozen state, got %+v", ilk)
+	}
+
+	var rep AggregatedReport
+	if err := json.Unmarshal([]byte(`{"zoneId":"zone-A","summary":{"avgPowerW":10}}`), &rep); err != nil || rep.Summary.AvgTemp != nil {
+		t.Fatalf("absent avgTemp must decode as nil: %+v %v", rep.Summary, err)
+	}
+}
+
+func TestPlanEnforcesSafetyInterlocks(t *testing.T) {
+	cfg := &AppConfig{
+		Actuators: map[string]ZoneActuators{"zone-A": {Heating: []string{"h1"}, Cooling: []string{"c1"}, Ventilation: []string{"v1"}}},
+		FanSteps:  []float64{0.5, 1.0, 2.0},
+		FanSpeeds: []int{0, 25, 50, 100},
+		Guard:     GuardParams{MinOn: 10 * time.Minute},
+		Safety:    SafetyParams{MinTempC: 5, MaxTempC: 35, FallbackAction: "OFF"},
+	}
+	p := NewPlan(cfg, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	modes := func(cmds []PlanCommand) map[string]string {
+		out := map[string]string{}
+		for _, c := range cmds {
+			out[c.ActuatorID] = c.Mode
+		}
+		return out
+	}
+
+	if _, led := p.Build("zone-A", 1, "", "", AnalysisResult{HasTemp: true, TempC: 25, Target: 21, Delta: 4, Action: "COOL", Fan: 100}); led.Action != "COOL" {
+		t.Fatalf("expected cooling to start, got %s", led.Action)
+	}
+	// The cooler is within its minimum run time, yet frost protection switches to heating.
+	cmds, led := p.Build("zone-A", 2, "", "", AnalysisResult{HasTemp: true, TempC: 3, Target: 21, Delta: -18, Action: "OFF"})
+	if m := modes(cmds); led.Action != "HEAT" || m["h1"] != "ON" || m["c1"] != "OFF" {
+		t.Fatalf("expected frost protection to heat, got %s %v", led.Action, m)
+	}
+	if len(led.Interlocks) != 1 || led.Interlocks[0].Type != InterlockFrost {
+		t.Fatalf("frost interlock not recorded: %+v", led.Interlocks)
+	}
+	// Overheating cuts the heater even though its minimum run time has not elapsed.
+	cmds, led = p.Build("zone-A", 3, "", "", AnalysisResult{HasTemp: true, TempC: 36, Target: 21, Delta: 15, Action: "HEAT", Fan: 100})
+	if m := modes(cmds); led.Action != "COOL" || m["h1"] != "OFF" || m["c1"] != "ON" || led.Interlocks[0].Type != InterlockOverheat {
+		t.Fatalf("expected overheat cutoff, got %s %v %+v", led.Action, m, led.Interlocks)
+	}
+	// A sensor fallback bypasses the guards as well.
+	_, led = p.Build("zone-A", 4, "", "", NewSafetyLayer(cfg).Fallback(Interlock{Type: InterlockNoTemperature, Reason: "no data"}))
+	if led.Action != "OFF" || led.Suppressed != "" || led.Interlocks[0].Type != InterlockNoTemperature {
+		t.Fatalf("expected safe state, got %+v", led)
+	}
+
+	// An actuator listed as both heater and cooler can never be switched ON.
+	cfg.Actuators["zone-B"] = ZoneActuators{Heating: []string{"x1"}, Cooling: []string{"x1"}}
+	cmds, led = p.Build("zone-B", 1, "", "", AnalysisResult{HasTemp: true, TempC: 18, Target: 21, Delta: -3, Action: "HEAT", Fan: 100})
+	for _, c := range cmds {
+		if c.Mode == "ON" {
+			t.Fatalf("conflicting actuator switched on: %+v", cmds)
+		}
+	}
+	if led.Action != "OFF" || led.Interlocks[0].Type != InterlockHeatCool {
+		t.Fatalf("expected heat/cool conflict interlock, got %s %+v", led.Action, led.Interlocks)
+	}
+
+	s := NewSafetyLayer(cfg)
+	s.Observe("zone-A", led.Interlocks)
+	s.Observe("zone-B", nil)
+	if st := s.Status(); len(st.Active["zone-A"]) != 1 || st.Activations[InterlockHeatCool] != 1 {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+}
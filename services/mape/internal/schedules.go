@@ -0,0 +1,341 @@
+// v5
+// services/mape/internal/schedules.go
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Setpoint sources recorded in the ledger event.
+const (
+	SourceDefault  = "default"
+	SourceSchedule = "schedule"
+	SourceOverride = "override"
+)
+
+// ErrInvalidSchedule wraps every validation failure of a schedule or override payload.
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ScheduleRule applies SetpointC on the listed days between Start (inclusive) and End
+// (exclusive), both "HH:MM" in the schedule time zone; End may be "24:00".
+type ScheduleRule struct {
+	Days      []string `json:"days"`
+	Start     string   `json:"start"`
+	End       string   `json:"end"`
+	SetpointC float64  `json:"setpointC"`
+}
+
+// Holiday replaces the weekly rules for a whole calendar day (YYYY-MM-DD).
+type Holiday struct {
+	Date      string  `json:"date"`
+	SetpointC float64 `json:"setpointC"`
+	Name      string  `json:"name,omitempty"`
+}
+
+// Schedule is the weekly calendar of a zone. Rules are evaluated in order and the first
+// match wins; outside every rule SetbackC applies, or the zone's static setpoint when unset.
+type Schedule struct {
+	Rules    []ScheduleRule `json:"rules"`
+	Holidays []Holiday      `json:"holidays,omitempty"`
+	SetbackC *float64       `json:"setbackC,omitempty"`
+}
+
+// Override is a temporary setpoint that takes precedence until ExpiresAt. Actor names
+// who set it.
+type Override struct {
+	SetpointC float64   `json:"setpointC"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Actor     string    `json:"actor,omitempty"`
+}
+
+// Schedules resolves the effective setpoint of each zone: an unexpired override first,
+// then the zone schedule, then the static value kept in ZoneSetpoints.
+type Schedules struct {
+	mu        sync.RWMutex
+	sp        *ZoneSetpoints
+	loc       *time.Location
+	schedules map[string]Schedule
+	overrides map[string]Override
+	// onChange, when set, is told about every schedule and override change made on
+	// behalf of an actor, as a change of the setpoint in force.
+	onChange func(SetpointChange)
+}
+
+// NewSchedules builds an empty schedule store on top of the static setpoints. Times of
+// day are interpreted in loc (UTC when nil).
+func NewSchedules(sp *ZoneSetpoints, loc *time.Location) *Schedules {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return &Schedules{sp: sp, loc: loc, schedules: map[string]Schedule{}, overrides: map[string]Override{}}
+}
+
+// Resolve returns the setpoint in force for zone at the given instant and its source. An
+// override that lapsed by then is dropped.
+func (s *Schedules) Resolve(zone string, at time.Time) (float64, string, bool) {
+	s.mu.RLock()
+	ov, hasOv := s.overrides[zone]
+	s.mu.RUnlock()
+	if hasOv && !at.Before(ov.ExpiresAt) {
+		s.expire(zone, ov)
+	}
+	return s.resolveAt(zone, at)
+}
+
+// resolveAt is Resolve without side effects: a lapsed override is passed over but kept, so
+// simulations may look ahead without touching the store.
+func (s *Schedules) resolveAt(zone string, at time.Time) (float64, string, bool) {
+	s.mu.RLock()
+	ov, hasOv := s.overrides[zone]
+	sched, hasSched := s.schedules[zone]
+	s.mu.RUnlock()
+	if hasOv && at.Before(ov.ExpiresAt) {
+		return s.clamp(zone, ov.SetpointC), SourceOverride, true
+	}
+	if hasSched {
+		if v, ok := sched.valueAt(at.In(s.loc)); ok {
+			return s.clamp(zone, v), SourceSchedule, true
+		}
+	}
+	v, ok := s.sp.Get(zone)
+	return v, SourceDefault, ok
+}
+
+// clamp keeps scheduled and override values set before the zone's policy narrowed its
+// setpoint range within that range.
+func (s *Schedules) clamp(zone string, v float64) float64 {
+	min, max := s.sp.RangeFor(zone)
+	return clamp(v, min, max)
+}
+
+// expire drops an override once it lapsed, unless it was replaced in the meantime.
+func (s *Schedules) expire(zone string, ov Override) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur, ok := s.overrides[zone]; ok && cur == ov {
+		delete(s.overrides, zone)
+	}
+}
+
+func (sc Schedule) valueAt(local time.Time) (float64, bool) {
+	date := local.Format(time.DateOnly)
+	for _, h := range sc.Holidays {
+		if h.Date == date {
+			return h.SetpointC, true
+		}
+	}
+	minute := local.Hour()*60 + local.Minute()
+	day := local.Weekday()
+	for _, r := range sc.Rules {
+		if !r.hasDay(day) {
+			continue
+		}
+		start, _ := parseClock(r.Start)
+		end, _ := parseClock(r.End)
+		if minute >= start && minute < end {
+			return r.SetpointC, true
+		}
+	}
+	if sc.SetbackC != nil {
+		return *sc.SetbackC, true
+	}
+	return 0, false
+}
+
+func (r ScheduleRule) hasDay(day time.Weekday) bool {
+	for _, d := range r.Days {
+		if weekdayNames[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock converts "HH:MM" into minutes after midnight; "24:00" is accepted as an end.
+func parseClock(v string) (int, error) {
+	hh, mm, ok := strings.Cut(v, ":")
+	if !ok {
+		return 0, fmt.Errorf("%w: time %q must be HH:MM", ErrInvalidSchedule, v)
+	}
+	h, err1 := strconv.Atoi(hh)
+	m, err2 := strconv.Atoi(mm)
+	if err1 != nil || err2 != nil || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("%w: time %q must be HH:MM", ErrInvalidSchedule, v)
+	}
+	return h*60 + m, nil
+}
+
+func (s *Schedules) validate(zone string, sc Schedule) error {
+	if !s.known(zone) {
+		return fmt.Errorf("%w: %s", ErrUnknownZone, zone)
+	}
+	min, max := s.sp.RangeFor(zone)
+	inRange := func(v float64) error {
+		if v < min || v > max {
+			return fmt.Errorf("%w: %.2f", ErrSetpointRange, v)
+		}
+		return nil
+	}
+	for i, r := range sc.Rules {
+		if err := validateRuleWindow(i, r); err != nil {
+			return err
+		}
+		if err := inRange(r.SetpointC); err != nil {
+			return err
+		}
+	}
+	for _, h := range sc.Holidays {
+		if _, err := time.Parse(time.DateOnly, h.Date); err != nil {
+			return fmt.Errorf("%w: holiday date %q must be YYYY-MM-DD", ErrInvalidSchedule, h.Date)
+		}
+		if err := inRange(h.SetpointC); err != nil {
+			return err
+		}
+	}
+	if sc.SetbackC != nil {
+		if err := inRange(*sc.SetbackC); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schedules) known(zone string) bool {
+	_, ok := s.sp.Get(zone)
+	return ok
+}
+
+// Forget drops the schedule and override of a removed zone.
+func (s *Schedules) Forget(zone string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.schedules, zone)
+	delete(s.overrides, zone)
+}
+
+// Get returns the schedule of a zone, if any.
+func (s *Schedules) Get(zone string) (Schedule, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sc, ok := s.schedules[zone]
+	return sc, ok
+}
+
+// All returns a copy of every configured schedule.
+func (s *Schedules) All() map[string]Schedule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]Schedule, len(s.schedules))
+	for z, sc := range s.schedules {
+		out[z] = sc
+	}
+	return out
+}
+
+// Observe registers the callback notified of the changes made through Set, Delete,
+// SetOverride and ClearOverride. Expired overrides and Forget are not reported.
+func (s *Schedules) Observe(fn func(SetpointChange)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = fn
+}
+
+// changed reports a change by actor to the observer with the setpoint in force at now
+// before and after it.
+func (s *Schedules) changed(zone string, now time.Time, old float64, actor, reason string) {
+	v, _, _ := s.resolveAt(zone, now)
+	s.mu.RLock()
+	fn := s.onChange
+	s.mu.RUnlock()
+	if fn != nil {
+		fn(SetpointChange{ZoneID: zone, OldC: old, NewC: v, Actor: actor, Reason: reason, At: now})
+	}
+}
+
+// Set validates and stores the schedule of a zone on behalf of actor, replacing any
+// previous one.
+func (s *Schedules) Set(zone string, sc Schedule, actor string) error {
+	if err := s.validate(zone, sc); err != nil {
+		return err
+	}
+	sort.SliceStable(sc.Holidays, func(i, j int) bool { return sc.Holidays[i].Date < sc.Holidays[j].Date })
+	now := time.Now()
+	old, _, _ := s.resolveAt(zone, now)
+	s.mu.Lock()
+	s.schedules[zone] = sc
+	s.mu.Unlock()
+	s.changed(zone, now, old, actor, "schedule updated")
+	return nil
+}
+
+// Delete removes the schedule of a zone on behalf of actor; the boolean reports whether
+// one existed.
+func (s *Schedules) Delete(zone, actor string) bool {
+	now := time.Now()
+	old, _, _ := s.resolveAt(zone, now)
+	s.mu.Lock()
+	_, ok := s.schedules[zone]
+	delete(s.schedules, zone)
+	s.mu.Unlock()
+	if ok {
+		s.changed(zone, now, old, actor, "schedule deleted")
+	}
+	return ok
+}
+
+// Override returns the active override of a zone, if any.
+func (s *Schedules) Override(zone string, now time.Time) (Override, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ov, ok := s.overrides[zone]
+	if !ok || !now.Before(ov.ExpiresAt) {
+		return Override{}, false
+	}
+	return ov, true
+}
+
+// SetOverride installs a temporary setpoint on behalf of ov.Actor; it must expire in the
+// future.
+func (s *Schedules) SetOverride(zone string, ov Override, now time.Time) error {
+	if !s.known(zone) {
+		return fmt.Errorf("%w: %s", ErrUnknownZone, zone)
+	}
+	if min, max := s.sp.RangeFor(zone); ov.SetpointC < min || ov.SetpointC > max {
+		return fmt.Errorf("%w: %.2f", ErrSetpointRange, ov.SetpointC)
+	}
+	if !ov.ExpiresAt.After(now) {
+		return fmt.Errorf("%w: override must expire in the future", ErrInvalidSchedule)
+	}
+	old, _, _ := s.resolveAt(zone, now)
+	s.mu.Lock()
+	s.overrides[zone] = ov
+	s.mu.Unlock()
+	s.changed(zone, now, old, ov.Actor, "override until "+ov.ExpiresAt.UTC().Format(time.RFC3339))
+	return nil
+}
+
+// ClearOverride removes the override of a zone on behalf of actor; the boolean reports
+// whether one existed.
+func (s *Schedules) ClearOverride(zone, actor string) bool {
+	now := time.Now()
+	old, _, _ := s.resolveAt(zone, now)
+	s.mu.Lock()
+	_, ok := s.overrides[zone]
+	delete(s.overrides, zone)
+	s.mu.Unlock()
+	if ok {
+		s.changed(zone, now, old, actor, "override cleared")
+	}
+	return ok
+}
@@ -0,0 +1,140 @@
+// v1
+// services/mape/internal/schedules_test.go
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSchedulesResolvePrecedence(t *testing.T) {
+	store, err := NewZoneSetpoints([]string{"zone-A"}, map[string]float64{"zone-A": 20.0}, 10.0, 35.0)
+	if err != nil {
+		t.Fatalf("setpoints: %v", err)
+	}
+	sched := NewSchedules(store, time.UTC)
+	var changes []SetpointChange
+	sched.Observe(func(c SetpointChange) { changes = append(changes, c) })
+	setback := 17.0
+	err = sched.Set("zone-A", Schedule{
+		Rules:    []ScheduleRule{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "08:00", End: "18:00", SetpointC: 21}},
+		Holidays: []Holiday{{Date: "2025-12-25", SetpointC: 16}},
+		SetbackC: &setback,
+	}, "alice")
+	if err != nil {
+		t.Fatalf("set schedule: %v", err)
+	}
+	cases := []struct {
+		at     string
+		want   float64
+		source string
+	}{
+		{"2025-12-22T09:30:00Z", 21, SourceSchedule}, // Monday, comfort
+		{"2025-12-22T18:00:00Z", 17, SourceSchedule}, // end is exclusive
+		{"2025-12-27T10:00:00Z", 17, SourceSchedule}, // Saturday setback
+		{"2025-12-25T10:00:00Z", 16, SourceSchedule}, // holiday
+	}
+	for _, tc := range cases {
+		at, _ := time.Parse(time.RFC3339, tc.at)
+		v, src, ok := sched.Resolve("zone-A", at)
+		if !ok || v != tc.want || src != tc.source {
+			t.Fatalf("%s: got %.1f/%s, want %.1f/%s", tc.at, v, src, tc.want, tc.source)
+		}
+	}
+	now, _ := time.Parse(time.RFC3339, "2025-12-22T09:30:00Z")
+	if err := sched.SetOverride("zone-A", Override{SetpointC: 24, ExpiresAt: now.Add(time.Hour), Actor: "bob"}, now); err != nil {
+		t.Fatalf("override: %v", err)
+	}
+	if v, src, _ := sched.Resolve("zone-A", now.Add(30*time.Minute)); v != 24 || src != SourceOverride {
+		t.Fatalf("override not applied: %.1f/%s", v, src)
+	}
+	if v, src, _ := sched.Resolve("zone-A", now.Add(2*time.Hour)); v != 21 || src != SourceSchedule {
+		t.Fatalf("expired override still applied: %.1f/%s", v, src)
+	}
+	if _, ok := sched.Override("zone-A", now); ok {
+		t.Fatalf("expired override should have been dropped")
+	}
+	if !sched.Delete("zone-A", "alice") || sched.Delete("zone-A", "alice") {
+		t.Fatalf("delete should report the schedule once")
+	}
+	if v, src, _ := sched.Resolve("zone-A", now); v != 20 || src != SourceDefault {
+		t.Fatalf("expected static default, got %.1f/%s", v, src)
+	}
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 reported changes, got %+v", changes)
+	}
+	if c := changes[1]; c.Actor != "bob" || c.NewC != 24 || c.ZoneID != "zone-A" {
+		t.Fatalf("override change: %+v", c)
+	}
+	if c := changes[2]; c.Actor != "alice" || c.Reason != "schedule deleted" || c.NewC != 20 {
+		t.Fatalf("delete change: %+v", c)
+	}
+}
+
+func TestScheduleEndpoints(t *testing.T) {
+	cfg := &AppConfig{Zones: []string{"zone-A"}, ZoneTargets: map[string]float64{"zone-A": 22.0}}
+	store, err := NewZoneSetpoints(cfg.Zones, cfg.ZoneTargets, 10.0, 35.0)
+	if err != nil {
+		t.Fatalf("new setpoints: %v", err)
+	}
+	srv := NewHTTPServer(cfg, store, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		var r io.Reader
+		if body != nil {
+			b, _ := json.Marshal(body)
+			r = bytes.NewReader(b)
+		}
+		rec := httptest.NewRecorder()
+		srv.http.Handler.ServeHTTP(rec, httptest.NewRequest(method, path, r))
+		return rec
+	}
+
+	allDays := []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}
+	if rec := do(http.MethodPut, "/config/schedules/zone-A", Schedule{Rules: []ScheduleRule{{Days: allDays, Start: "00:00", End: "24:00", SetpointC: 19}}}); rec.Code != http.StatusOK {
+		t.Fatalf("put schedule status=%d body=%s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodPut, "/config/schedules/zone-A", Schedule{Rules: []ScheduleRule{{Days: []string{"xyz"}, Start: "08:00", End: "18:00", SetpointC: 19}}}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid day accepted: status=%d", rec.Code)
+	}
+	if rec := do(http.MethodPut, "/config/schedules/zone-A", Schedule{Rules: []ScheduleRule{{Days: allDays, Start: "08:00", End: "18:00", SetpointC: 50}}}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("out of range setpoint accepted: status=%d", rec.Code)
+	}
+	var body struct {
+		Effective struct {
+			SetpointC float64 `json:"setpointC"`
+			Source    string  `json:"source"`
+		} `json:"effective"`
+	}
+	rec := do(http.MethodGet, "/config/schedules/zone-A", nil)
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Effective.SetpointC != 19 || body.Effective.Source != SourceSchedule {
+		t.Fatalf("unexpected effective setpoint %+v", body.Effective)
+	}
+	if rec := do(http.MethodPut, "/config/schedules/zone-A/override", map[string]any{"setpointC": 23, "durationMinutes": 30}); rec.Code != http.StatusOK {
+		t.Fatalf("put override status=%d body=%s", rec.Code, rec.Body)
+	}
+	rec = do(http.MethodGet, "/config/schedules/zone-A", nil)
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Effective.SetpointC != 23 || body.Effective.Source != SourceOverride {
+		t.Fatalf("override not effective %+v", body.Effective)
+	}
+	if rec := do(http.MethodDelete, "/config/schedules/zone-A/override", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete override status=%d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/config/schedules/zone-A", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete schedule status=%d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/config/schedules/zone-X", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown zone status=%d", rec.Code)
+	}
+}
@@ -1,4 +1,4 @@
-// v8
+// v21
 // services/mape/internal/server.go
 package internal
 
@@ -9,26 +9,51 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type HTTPServer struct {
-	cfg  *AppConfig
-	sp   *ZoneSetpoints
-	lg   *slog.Logger
-	http *http.Server
+	cfg   *AppConfig
+	sp    *ZoneSetpoints
+	sched *Schedules
+	dr    *DREvents
+	lg    *slog.Logger
+	http  *http.Server
+	// auth identifies callers; nil leaves the API open.
+	auth Authenticator
 }
 
-func NewHTTPServer(cfg *AppConfig, sp *ZoneSetpoints, lg *slog.Logger) *HTTPServer {
+func NewHTTPServer(cfg *AppConfig, sp *ZoneSetpoints, sched *Schedules, dr *DREvents, lg *slog.Logger) *HTTPServer {
+	if sched == nil {
+		sched = NewSchedules(sp, cfg.ScheduleLocation)
+	}
+	if dr == nil {
+		dr = NewDREvents(cfg)
+	}
 	mux := http.NewServeMux()
-	s := &HTTPServer{cfg: cfg, sp: sp, lg: lg, http: &http.Server{Addr: cfg.HTTPBind, Handler: mux}}
+	s := &HTTPServer{cfg: cfg, sp: sp, sched: sched, dr: dr, lg: lg, http: &http.Server{Addr: cfg.HTTPBind, Handler: mux}}
 	mux.HandleFunc("/health", s.getHealth)
-	mux.HandleFunc("/status", s.getStatus)
-	mux.HandleFunc("/config/reload", s.postReload)
-	mux.HandleFunc("/config/temperature", s.getAllSetpoints)
-	mux.HandleFunc("/config/temperature/", s.handleZoneSetpoint)
+	mux.HandleFunc("/status", s.guard(s.getStatus, nil))
+	mux.HandleFunc("/config/reload", s.guard(s.postReload, nil))
+	mux.HandleFunc("/config/temperature", s.guard(s.getAllSetpoints, nil))
+	mux.HandleFunc("/config/temperature/", s.guard(s.handleZoneSetpoint, zoneAt("/config/temperature/")))
+	mux.HandleFunc("/config/schedules", s.guard(s.getAllSchedules, nil))
+	mux.HandleFunc("/config/schedules/", s.guard(s.handleZoneSchedule, zoneAt("/config/schedules/")))
+	mux.HandleFunc("/dr/events", s.guard(s.handleDREvents, nil))
+	mux.HandleFunc("/dr/events/", s.guard(s.deleteDREvent, nil))
+	mux.HandleFunc("/shadow/compare", s.guard(s.getShadowCompare, nil))
+	mux.HandleFunc("/shadow/plans", s.guard(s.getShadowPlans, nil))
+	mux.HandleFunc("/diagnostics", s.guard(s.getDiagnostics, nil))
+	mux.HandleFunc("/zones/", s.guard(s.handleZone, zoneAt("/zones/")))
 	return s
 }
+
+// UseAuth requires every request but GET /health to authenticate with a; it must be
+// called before Start.
+func (s *HTTPServer) UseAuth(a Authenticator) { s.auth = a }
+
 func (s *HTTPServer) Start() error {
 	s.lg.Info("http start", "bind", s.cfg.HTTPBind)
 	return s.http.ListenAndServe()
@@ -65,29 +90,14 @@ func (s *HTTPServer) postReload(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	if err := s.cfg.ReloadProperties(); err != nil {
-		s.lg.Error("reload", "error", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		_, err := w.Write([]byte(err.Error()))
-		if err != nil {
-			return
-		}
-		return
-	}
-	if err := s.sp.Reset(s.cfg.ZoneTargets); err != nil {
-		s.lg.Error("setpoints reset", "error", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		_, err := w.Write([]byte(err.Error()))
-		if err != nil {
-			return
-		}
-		return
-	}
-	w.WriteHeader(http.StatusOK)
-	_, err := w.Write([]byte("reloaded"))
+	rep, err := Reload(r.Context(), s.cfg, s.sp, engineRef, actorOf(r))
 	if err != nil {
+		s.lg.Error("reload", "error", err)
+		s.writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		return
 	}
+	s.lg.Info("reload", "actor", rep.Actor, "changed", rep.Changed, "added", rep.ZonesAdded, "removed", rep.ZonesRemoved, "warnings", len(rep.Warnings))
+	s.writeJSON(w, http.StatusOK, rep)
 }
 
 func (s *HTTPServer) getAllSetpoints(w http.ResponseWriter, r *http.Request) {
@@ -129,23 +139,24 @@ func (s *HTTPServer) putZoneSetpoint(w http.ResponseWriter, r *http.Request, zon
 	dec.DisallowUnknownFields()
 	var req struct {
 		SetpointC *float64 `json:"setpointC"`
+		Reason    string   `json:"reason"`
 	}
 	if err := dec.Decode(&req); err != nil {
 		s.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid payload"})
 		return
 	}
 	if req.SetpointC == nil {
-		min, max := s.sp.Range()
+		min, max := s.sp.RangeFor(zone)
 		s.writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid setpointC, expected %.1f..%.1f", min, max)})
 		return
 	}
-	value, err := s.sp.Set(zone, *req.SetpointC)
+	value, err := s.sp.Update(zone, *req.SetpointC, actorOf(r), req.Reason)
 	if err != nil {
 		switch {
 		case errors.Is(err, ErrUnknownZone):
 			s.writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("unknown zoneId: %s", zone)})
 		case errors.Is(err, ErrSetpointRange):
-			min, max := s.sp.Range()
+			min, max := s.sp.RangeFor(zone)
 			s.writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid setpointC, expected %.1f..%.1f", min, max)})
 		default:
 			s.lg.Error("setpoint update", "zone", zone, "error", err)
@@ -153,10 +164,465 @@ func (s *HTTPServer) putZoneSetpoint(w http.ResponseWriter, r *http.Request, zon
 		}
 		return
 	}
-	s.lg.Info("[MAPE] setpoint updated", "zone", zone, "setpointC", value)
+	s.lg.Info("[MAPE] setpoint updated", "zone", zone, "setpointC", value, "actor", actorOf(r))
 	s.writeJSON(w, http.StatusOK, map[string]any{"zoneId": zone, "setpointC": value})
 }
 
+// actorOf names who made a change: the authenticated principal, else the X-Actor
+// header, else the client address.
+func actorOf(r *http.Request) string {
+	if p, ok := PrincipalFrom(r.Context()); ok {
+		return p.Subject
+	}
+	if a := strings.TrimSpace(r.Header.Get("X-Actor")); a != "" {
+		return a
+	}
+	return r.RemoteAddr
+}
+
+func (s *HTTPServer) getAllSchedules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]any{"schedules": s.sched.All()})
+}
+
+// handleZoneSchedule serves /config/schedules/{zone} and /config/schedules/{zone}/override.
+func (s *HTTPServer) handleZoneSchedule(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/config/schedules/")
+	zone, sub, _ := strings.Cut(rest, "/")
+	if _, ok := s.sp.Get(zone); !ok || (sub != "" && sub != "override") {
+		s.writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("unknown zoneId: %s", zone)})
+		return
+	}
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		s.getZoneSchedule(w, zone)
+	case sub == "" && r.Method == http.MethodPut:
+		var sc Schedule
+		if !s.decodeStrict(w, r, &sc) {
+			return
+		}
+		if err := s.sched.Set(zone, sc, actorOf(r)); err != nil {
+			s.writeScheduleErr(w, zone, err)
+			return
+		}
+		s.lg.Info("[MAPE] schedule updated", "zone", zone, "rules", len(sc.Rules), "holidays", len(sc.Holidays), "actor", actorOf(r))
+		s.getZoneSchedule(w, zone)
+	case sub == "" && r.Method == http.MethodDelete:
+		if !s.sched.Delete(zone, actorOf(r)) {
+			s.writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("no schedule for zoneId: %s", zone)})
+			return
+		}
+		s.lg.Info("[MAPE] schedule deleted", "zone", zone, "actor", actorOf(r))
+		w.WriteHeader(http.StatusNoContent)
+	case sub == "override" && r.Method == http.MethodPut:
+		var req struct {
+			SetpointC       *float64   `json:"setpointC"`
+			ExpiresAt       *time.Time `json:"expiresAt"`
+			DurationMinutes int        `json:"durationMinutes"`
+		}
+		if !s.decodeStrict(w, r, &req) {
+			return
+		}
+		now := time.Now()
+		if req.SetpointC == nil || (req.ExpiresAt == nil) == (req.DurationMinutes <= 0) {
+			s.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "setpointC and exactly one of expiresAt or durationMinutes are required"})
+			return
+		}
+		ov := Override{SetpointC: *req.SetpointC, Actor: actorOf(r)}
+		if req.ExpiresAt != nil {
+			ov.ExpiresAt = *req.ExpiresAt
+		} else {
+			ov.ExpiresAt = now.Add(time.Duration(req.DurationMinutes) * time.Minute)
+		}
+		if err := s.sched.SetOverride(zone, ov, now); err != nil {
+			s.writeScheduleErr(w, zone, err)
+			return
+		}
+		s.lg.Info("[MAPE] setpoint override", "zone", zone, "setpointC", ov.SetpointC, "expires_at", ov.ExpiresAt, "actor", ov.Actor)
+		s.getZoneSchedule(w, zone)
+	case sub == "override" && r.Method == http.MethodDelete:
+		if !s.sched.ClearOverride(zone, actorOf(r)) {
+			s.writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("no override for zoneId: %s", zone)})
+			return
+		}
+		s.lg.Info("[MAPE] setpoint override cleared", "zone", zone, "actor", actorOf(r))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// getZoneSchedule reports the schedule, the active override and the setpoint in force now.
+func (s *HTTPServer) getZoneSchedule(w http.ResponseWriter, zone string) {
+	now := time.Now()
+	value, source, _ := s.sched.Resolve(zone, now)
+	resp := map[string]any{"zoneId": zone, "effective": map[string]any{"setpointC": value, "source": source}}
+	if sc, ok := s.sched.Get(zone); ok {
+		resp["schedule"] = sc
+	}
+	if ov, ok := s.sched.Override(zone, now); ok {
+		resp["override"] = ov
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *HTTPServer) decodeStrict(w http.ResponseWriter, r *http.Request, dst any) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		s.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid payload"})
+		return false
+	}
+	return true
+}
+
+func (s *HTTPServer) writeScheduleErr(w http.ResponseWriter, zone string, err error) {
+	switch {
+	case errors.Is(err, ErrUnknownZone):
+		s.writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("unknown zoneId: %s", zone)})
+	case errors.Is(err, ErrSetpointRange):
+		min, max := s.sp.RangeFor(zone)
+		s.writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("%v, expected %.1f..%.1f", err, min, max)})
+	case errors.Is(err, ErrInvalidSchedule):
+		s.writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	default:
+		s.lg.Error("schedule update", "zone", zone, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// handleDREvents lists demand-response events (GET) or accepts a new one (POST).
+func (s *HTTPServer) handleDREvents(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.writeJSON(w, http.StatusOK, map[string]any{"events": s.dr.All()})
+	case http.MethodPost:
+		var ev DREvent
+		if !s.decodeStrict(w, r, &ev) {
+			return
+		}
+		stored, err := s.dr.Add(ev, actorOf(r), time.Now())
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrUnknownZone), errors.Is(err, ErrInvalidDREvent):
+				s.writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			default:
+				s.lg.Error("dr event", "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+		s.lg.Info("[MAPE] DR event accepted", "id", stored.ID, "start", stored.Start, "end", stored.End, "widen_c", stored.WidenC, "zones", stored.Zones, "actor", stored.Actor)
+		s.writeJSON(w, http.StatusCreated, stored)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *HTTPServer) deleteDREvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/dr/events/")
+	if !s.dr.Cancel(id, actorOf(r), time.Now()) {
+		s.writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("unknown DR event: %s", id)})
+		return
+	}
+	s.lg.Info("[MAPE] DR event cancelled", "id", id, "actor", actorOf(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getShadowCompare reports, per zone and candidate strategy, how often and how far the
+// shadow plans diverged from the live strategy.
+func (s *HTTPServer) getShadowCompare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	cmp := map[string]map[string]ShadowComparison{}
+	if sl := globalShadow(); sl != nil {
+		cmp = sl.Compare()
+	}
+	s.writeJSON(w, http.StatusOK, map[string]any{"zones": cmp})
+}
+
+// getShadowPlans lists the latest shadow records; ?zone= filters and ?limit= bounds them.
+func (s *HTTPServer) getShadowPlans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	zone := r.URL.Query().Get("zone")
+	if zone != "" {
+		if !s.cfg.hasZone(zone) {
+			s.writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("%v: %s", ErrUnknownZone, zone)})
+			return
+		}
+	}
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			s.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+	var recs []ShadowRecord
+	if sl := globalShadow(); sl != nil {
+		recs = sl.Recent(zone, limit)
+	}
+	if recs == nil {
+		recs = []ShadowRecord{}
+	}
+	s.writeJSON(w, http.StatusOK, map[string]any{"records": recs})
+}
+
+// getDiagnostics returns the learned actuator power and zone temperature response and
+// the active findings, of one zone with ?zone=.
+func (s *HTTPServer) getDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	zone := r.URL.Query().Get("zone")
+	if zone != "" {
+		if !s.cfg.hasZone(zone) {
+			s.writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("%v: %s", ErrUnknownZone, zone)})
+			return
+		}
+	}
+	d := globalDiagnostics()
+	if d == nil {
+		s.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "engine not running"})
+		return
+	}
+	s.writeJSON(w, http.StatusOK, d.Status(zone))
+}
+
+// handleZone serves /zones/{zone}/decisions, /zones/{zone}/policy,
+// /zones/{zone}/occupancy and /zones/{zone}/simulate.
+func (s *HTTPServer) handleZone(w http.ResponseWriter, r *http.Request) {
+	zone, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/zones/"), "/")
+	sub, tail, hasTail := strings.Cut(rest, "/")
+	switch {
+	case sub == "decisions" && !(hasTail && tail == ""):
+		s.getZoneDecisions(w, r, zone, tail, hasTail)
+	case sub == "policy" && !hasTail:
+		s.handleZonePolicy(w, r, zone)
+	case sub == "occupancy" && !hasTail:
+		s.handleZoneOccupancy(w, r, zone)
+	case sub == "simulate" && !hasTail:
+		s.postZoneSimulate(w, r, zone)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleZoneOccupancy reports the zone's occupancy (GET), forces it until an expiry (PUT)
+// or returns it to its sensor and hours (DELETE).
+func (s *HTTPServer) handleZoneOccupancy(w http.ResponseWriter, r *http.Request, zone string) {
+	if !s.cfg.hasZone(zone) {
+		s.writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("%v: %s", ErrUnknownZone, zone)})
+		return
+	}
+	occ := globalOccupancy()
+	if occ == nil {
+		s.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "engine not running"})
+		return
+	}
+	now := time.Now()
+	switch r.Method {
+	case http.MethodGet:
+		s.writeJSON(w, http.StatusOK, occ.Status(zone, now))
+	case http.MethodPut:
+		var req struct {
+			Occupied        *bool      `json:"occupied"`
+			ExpiresAt       *time.Time `json:"expiresAt"`
+			DurationMinutes int        `json:"durationMinutes"`
+			Reason          string     `json:"reason"`
+		}
+		if !s.decodeStrict(w, r, &req) {
+			return
+		}
+		if req.Occupied == nil || (req.ExpiresAt == nil) == (req.DurationMinutes <= 0) {
+			s.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "occupied and exactly one of expiresAt or durationMinutes are required"})
+			return
+		}
+		ov := OccupancyOverride{Occupied: *req.Occupied, Reason: req.Reason, Actor: actorOf(r)}
+		if req.ExpiresAt != nil {
+			ov.ExpiresAt = *req.ExpiresAt
+		} else {
+			ov.ExpiresAt = now.Add(time.Duration(req.DurationMinutes) * time.Minute)
+		}
+		if err := occ.SetOverride(zone, ov, now); err != nil {
+			s.writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		s.lg.Info("[MAPE] occupancy override", "zone", zone, "occupied", ov.Occupied, "expires_at", ov.ExpiresAt, "reason", ov.Reason, "actor", ov.Actor)
+		s.writeJSON(w, http.StatusOK, occ.Status(zone, now))
+	case http.MethodDelete:
+		if !occ.ClearOverride(zone, now) {
+			s.writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("no occupancy override for zoneId: %s", zone)})
+			return
+		}
+		s.lg.Info("[MAPE] occupancy override cleared", "zone", zone, "actor", actorOf(r))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// postZoneSimulate predicts the zone's temperature, commands and energy over a horizon
+// under a hypothetical setpoint, strategy or tariff, without publishing anything.
+func (s *HTTPServer) postZoneSimulate(w http.ResponseWriter, r *http.Request, zone string) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.cfg.hasZone(zone) {
+		s.writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("%v: %s", ErrUnknownZone, zone)})
+		return
+	}
+	if engineRef == nil {
+		s.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "engine not running"})
+		return
+	}
+	var req SimulationRequest
+	if !s.decodeStrict(w, r, &req) {
+		return
+	}
+	res, err := engineRef.Simulate(zone, req, time.Now())
+	switch {
+	case errors.Is(err, ErrUnknownZone):
+		s.writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	case err != nil:
+		s.writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	s.lg.Info("[MAPE] simulation", "zone", zone, "strategy", res.Strategy, "model", res.Model, "epochs", res.Epochs, "energy_kwh", res.EnergyKWh, "actor", actorOf(r))
+	s.writeJSON(w, http.StatusOK, res)
+}
+
+// getZoneDecisions serves GET /zones/{zone}/decisions?last=N (newest first, 20 by
+// default) and GET /zones/{zone}/decisions/{epochIndex}.
+func (s *HTTPServer) getZoneDecisions(w http.ResponseWriter, r *http.Request, zone, epochPart string, hasEpoch bool) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.sp.Get(zone); !ok {
+		s.writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("%v: %s", ErrUnknownZone, zone)})
+		return
+	}
+	log := globalDecisions()
+	if hasEpoch {
+		epoch, err := strconv.ParseInt(epochPart, 10, 64)
+		if err != nil {
+			s.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "epochIndex must be an integer"})
+			return
+		}
+		var d Decision
+		ok := false
+		if log != nil {
+			d, ok = log.Epoch(zone, epoch)
+		}
+		if !ok {
+			s.writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("no decision for zone %s epoch %d", zone, epoch)})
+			return
+		}
+		s.writeJSON(w, http.StatusOK, d)
+		return
+	}
+	last := 20
+	if v := r.URL.Query().Get("last"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			s.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "last must be a positive integer"})
+			return
+		}
+		last = n
+	}
+	decisions := []Decision{}
+	if log != nil {
+		decisions = log.Last(zone, last)
+	}
+	s.writeJSON(w, http.StatusOK, map[string]any{"zoneId": zone, "decisions": decisions})
+}
+
+// handleZonePolicy serves GET, PUT and DELETE /zones/{zone}/policy. Responses carry the
+// policy's ETag; PUT and DELETE must send it back in If-Match, so that concurrent edits
+// are refused with 412 rather than lost. DELETE reverts the zone to its properties.
+func (s *HTTPServer) handleZonePolicy(w http.ResponseWriter, r *http.Request, zone string) {
+	if s.cfg.Policies == nil {
+		s.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "policy store not configured"})
+		return
+	}
+	var next *ZonePolicy
+	reason := r.URL.Query().Get("reason")
+	switch r.Method {
+	case http.MethodGet:
+		pol, source, err := s.cfg.Policies.Get(zone)
+		if err != nil {
+			s.writePolicyErr(w, zone, err)
+			return
+		}
+		etag := pol.ETag()
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, map[string]any{"zoneId": zone, "source": source, "policy": pol})
+		return
+	case http.MethodPut:
+		var req struct {
+			ZonePolicy
+			Reason string `json:"reason"`
+		}
+		if !s.decodeStrict(w, r, &req) {
+			return
+		}
+		next, reason = &req.ZonePolicy, req.Reason
+	case http.MethodDelete:
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	etag := r.Header.Get("If-Match")
+	if etag == "" {
+		s.writeJSON(w, http.StatusPreconditionRequired, map[string]string{"error": "If-Match with the policy ETag is required"})
+		return
+	}
+	pol, source, err := s.cfg.Policies.Update(zone, etag, next, actorOf(r), reason)
+	if err != nil {
+		s.writePolicyErr(w, zone, err)
+		return
+	}
+	s.lg.Info("[MAPE] policy updated", "zone", zone, "source", source, "strategy", pol.Strategy, "hysteresis_c", pol.HysteresisC, "actor", actorOf(r))
+	w.Header().Set("ETag", pol.ETag())
+	s.writeJSON(w, http.StatusOK, map[string]any{"zoneId": zone, "source": source, "policy": pol})
+}
+
+func (s *HTTPServer) writePolicyErr(w http.ResponseWriter, zone string, err error) {
+	switch {
+	case errors.Is(err, ErrUnknownZone):
+		s.writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("unknown zoneId: %s", zone)})
+	case errors.Is(err, ErrPolicyConflict):
+		s.writeJSON(w, http.StatusPreconditionFailed, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrInvalidPolicy), errors.Is(err, ErrSetpointRange):
+		s.writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	default:
+		s.lg.Error("policy update", "zone", zone, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
 func (s *HTTPServer) writeJSON(w http.ResponseWriter, status int, payload any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -0,0 +1,177 @@
+// v3
+// services/mape/internal/setpoint_store.go
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// AuditPublisher delivers setpoint audit events to the zone's ledger topic.
+type AuditPublisher interface {
+	PublishAudit(ctx context.Context, ev SetpointAudit) error
+}
+
+type setpointFile struct {
+	SavedAt   time.Time          `json:"savedAt"`
+	Setpoints map[string]float64 `json:"setpoints"`
+}
+
+// SetpointJournal persists the runtime setpoints to a local JSON file so that operator
+// changes survive restarts, and turns every change into a SetpointAudit for the ledger.
+// Audits that cannot be delivered yet are kept in order and retried with the next change
+// or when a publisher is attached.
+type SetpointJournal struct {
+	path    string
+	lg      *slog.Logger
+	mu      sync.Mutex
+	pub     AuditPublisher
+	pending []SetpointAudit
+}
+
+// NewSetpointJournal stores setpoints at path; an empty path disables persistence while
+// audits are still published.
+func NewSetpointJournal(path string, lg *slog.Logger) *SetpointJournal {
+	return &SetpointJournal{path: path, lg: lg}
+}
+
+// Restore applies the persisted values to sp. A missing file is not an error; zones that
+// are no longer configured or values now out of range are skipped with a warning.
+func (j *SetpointJournal) Restore(sp *ZoneSetpoints) (int, error) {
+	if j.path == "" {
+		return 0, nil
+	}
+	b, err := os.ReadFile(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("setpoint store: %w", err)
+	}
+	var f setpointFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return 0, fmt.Errorf("setpoint store %s: %w", j.path, err)
+	}
+	restored := 0
+	for zone, v := range f.Setpoints {
+		if _, err := sp.Set(zone, v); err != nil {
+			j.lg.Warn("setpoint store: value skipped", "zone", zone, "setpointC", v, "error", err)
+			continue
+		}
+		restored++
+	}
+	return restored, nil
+}
+
+// Track persists and audits every change reported by sp from now on. Zones added or
+// removed by a reload are persisted without an audit.
+func (j *SetpointJournal) Track(sp *ZoneSetpoints) {
+	sp.Observe(func(c SetpointChange) { j.record(sp, c) })
+	sp.ObserveZones(func(added, removed []string) {
+		if err := j.save(sp.All(), time.Now()); err != nil {
+			j.lg.Error("setpoint store: save failed", "path", j.path, "error", err)
+			return
+		}
+		j.lg.Info("setpoint store: zones updated", "added", added, "removed", removed)
+	})
+}
+
+// TrackSchedules audits every schedule and override change reported by sched, as a
+// change of the zone's setpoint in force. Nothing is persisted for them.
+func (j *SetpointJournal) TrackSchedules(sched *Schedules) {
+	sched.Observe(j.audit)
+}
+
+// SetPublisher attaches the ledger publisher and flushes audits queued until then.
+func (j *SetpointJournal) SetPublisher(pub AuditPublisher) {
+	j.mu.Lock()
+	j.pub = pub
+	j.mu.Unlock()
+	j.flush()
+}
+
+func (j *SetpointJournal) record(sp *ZoneSetpoints, c SetpointChange) {
+	if err := j.save(sp.All(), c.At); err != nil {
+		j.lg.Error("setpoint store: save failed", "path", j.path, "error", err)
+	}
+	j.audit(c)
+}
+
+func (j *SetpointJournal) audit(c SetpointChange) {
+	j.mu.Lock()
+	j.pending = append(j.pending, SetpointAudit{
+		SchemaVersion: LedgerSchemaVersion, Type: AuditTypeSetpoint, ZoneID: c.ZoneID,
+		Actor: c.Actor, Reason: c.Reason, OldC: c.OldC, NewC: c.NewC, Timestamp: c.At.UnixMilli(),
+	})
+	j.mu.Unlock()
+	j.flush()
+}
+
+func (j *SetpointJournal) flush() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.pub == nil {
+		return
+	}
+	for len(j.pending) > 0 {
+		ev := j.pending[0]
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := j.pub.PublishAudit(ctx, ev)
+		cancel()
+		if err != nil {
+			j.lg.Error("setpoint audit publish failed", "zone", ev.ZoneID, "queued", len(j.pending), "error", err)
+			return
+		}
+		j.lg.Info("[MAPE] setpoint audit", "zone", ev.ZoneID, "actor", ev.Actor, "old_c", ev.OldC, "new_c", ev.NewC, "reason", ev.Reason)
+		j.pending = j.pending[1:]
+	}
+}
+
+// Pending returns how many audits are waiting for delivery.
+func (j *SetpointJournal) Pending() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.pending)
+}
+
+// save writes the setpoints file.
+func (j *SetpointJournal) save(values map[string]float64, at time.Time) error {
+	return writeFileAtomic(j.path, ".setpoints-*.json", setpointFile{SavedAt: at.UTC(), Setpoints: values})
+}
+
+// writeFileAtomic writes v as indented JSON through a temporary file, named after
+// pattern, in the same directory; an empty path writes nothing.
+func writeFileAtomic(path, pattern string, v any) error {
+	if path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
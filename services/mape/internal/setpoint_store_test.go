@@ -0,0 +1,92 @@
+// v0
+// services/mape/internal/setpoint_store_test.go
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+type fakeAuditPublisher struct {
+	fail bool
+	got  []SetpointAudit
+}
+
+func (f *fakeAuditPublisher) PublishAudit(_ context.Context, ev SetpointAudit) error {
+	if f.fail {
+		return errors.New("broker down")
+	}
+	f.got = append(f.got, ev)
+	return nil
+}
+
+func TestSetpointJournalPersistsAndAudits(t *testing.T) {
+	cfg := &AppConfig{Zones: []string{"zone-A", "zone-B"}, ZoneTargets: map[string]float64{"zone-A": 21.0, "zone-B": 21.0}}
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	path := filepath.Join(t.TempDir(), "data", "setpoints.json")
+	store, err := NewZoneSetpoints(cfg.Zones, cfg.ZoneTargets, 10.0, 35.0)
+	if err != nil {
+		t.Fatalf("setpoints: %v", err)
+	}
+	journal := NewSetpointJournal(path, lg)
+	journal.Track(store)
+
+	// Changes made before Kafka is ready are persisted at once and audited later.
+	srv := NewHTTPServer(cfg, store, nil, nil, lg)
+	req := httptest.NewRequest(http.MethodPut, "/config/temperature/zone-A", bytes.NewBufferString(`{"setpointC":23.5,"reason":"meeting"}`))
+	req.Header.Set("X-Actor", "alice")
+	rec := httptest.NewRecorder()
+	srv.http.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("put: %d %s", rec.Code, rec.Body.String())
+	}
+	pub := &fakeAuditPublisher{fail: true}
+	journal.SetPublisher(pub)
+	if journal.Pending() != 1 {
+		t.Fatalf("failed audit must stay queued, pending=%d", journal.Pending())
+	}
+	pub.fail = false
+	if _, err := store.Update("zone-B", 19, "bob", ""); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if journal.Pending() != 0 || len(pub.got) != 2 {
+		t.Fatalf("expected both audits delivered, pending=%d got=%+v", journal.Pending(), pub.got)
+	}
+	a := pub.got[0]
+	if a.Type != AuditTypeSetpoint || a.ZoneID != "zone-A" || a.Actor != "alice" || a.Reason != "meeting" || a.OldC != 21 || a.NewC != 23.5 || a.Timestamp == 0 {
+		t.Fatalf("unexpected audit: %+v", a)
+	}
+
+	// A restart restores both values; restoring is not audited again.
+	restarted, _ := NewZoneSetpoints(cfg.Zones, cfg.ZoneTargets, 10.0, 35.0)
+	again := NewSetpointJournal(path, lg)
+	n, err := again.Restore(restarted)
+	if err != nil || n != 2 {
+		t.Fatalf("restore: %d %v", n, err)
+	}
+	if v, _ := restarted.Get("zone-A"); v != 23.5 {
+		t.Fatalf("zone-A not restored: %.1f", v)
+	}
+	if v, _ := restarted.Get("zone-B"); v != 19 {
+		t.Fatalf("zone-B not restored: %.1f", v)
+	}
+	if again.Pending() != 0 {
+		t.Fatalf("restore must not queue audits")
+	}
+
+	// A zone dropped from the configuration is skipped.
+	narrowed, _ := NewZoneSetpoints([]string{"zone-A"}, cfg.ZoneTargets, 10.0, 35.0)
+	if n, err := NewSetpointJournal(path, lg).Restore(narrowed); err != nil || n != 1 {
+		t.Fatalf("restore with fewer zones: %d %v", n, err)
+	}
+	if n, err := NewSetpointJournal(filepath.Join(t.TempDir(), "missing.json"), lg).Restore(narrowed); err != nil || n != 0 {
+		t.Fatalf("missing file: %d %v", n, err)
+	}
+}
@@ -1,11 +1,13 @@
-// v0
+// v4
 // services/mape/internal/setpoints.go
 package internal
 
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 )
 
 // ErrUnknownZone is returned when a setpoint operation references a zone that is not tracked.
@@ -24,6 +26,24 @@ type ZoneSetpoints struct {
 	values map[string]float64
 	min    float64
 	max    float64
+	// ranges narrows the range of zones with a control policy; it outlives the zone.
+	ranges map[string]setpointRange
+	// onChange, when set, is told about every Update and every value changed by Reset.
+	onChange func(SetpointChange)
+	// onZones, when set, is told when Reset adds or removes zones.
+	onZones func(added, removed []string)
+}
+
+type setpointRange struct{ min, max float64 }
+
+// SetpointChange describes one operator or system change of a zone setpoint.
+type SetpointChange struct {
+	ZoneID string    `json:"zoneId"`
+	OldC   float64   `json:"oldC"`
+	NewC   float64   `json:"newC"`
+	Actor  string    `json:"actor"`
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
 }
 
 // NewZoneSetpoints builds the runtime setpoint store from the parsed configuration. Each
@@ -36,6 +56,7 @@ func NewZoneSetpoints(zones []string, defaults map[string]float64, min, max floa
 	sp := &ZoneSetpoints{
 		zones:  make(map[string]struct{}, len(zones)),
 		values: make(map[string]float64, len(zones)),
+		ranges: map[string]setpointRange{},
 		min:    min,
 		max:    max,
 	}
@@ -80,41 +101,157 @@ func (s *ZoneSetpoints) All() map[string]float64 {
 func (s *ZoneSetpoints) Set(zone string, value float64) (float64, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.setLocked(zone, value)
+}
+
+func (s *ZoneSetpoints) setLocked(zone string, value float64) (float64, error) {
 	if _, ok := s.zones[zone]; !ok {
 		return 0, fmt.Errorf("%w: %s", ErrUnknownZone, zone)
 	}
-	if value < s.min || value > s.max {
+	if min, max := s.rangeLocked(zone); value < min || value > max {
 		return 0, fmt.Errorf("%w: %.2f", ErrSetpointRange, value)
 	}
 	s.values[zone] = value
 	return value, nil
 }
 
-// Reset replaces all zone setpoints with the provided defaults. The helper is used when
-// properties are reloaded so that the runtime store mirrors the latest configuration. Any
-// validation failure leaves the previous values untouched.
-func (s *ZoneSetpoints) Reset(defaults map[string]float64) error {
+// Observe registers the callback notified of setpoint changes made through Update and
+// Reset. Set stays silent so that restoring persisted values is not reported again.
+func (s *ZoneSetpoints) Observe(fn func(SetpointChange)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = fn
+}
+
+// Update is Set on behalf of an actor: the change, with the previous value and the
+// reason, is passed to the observer once it has been applied.
+func (s *ZoneSetpoints) Update(zone string, value float64, actor, reason string) (float64, error) {
+	s.mu.Lock()
+	old := s.values[zone]
+	v, err := s.setLocked(zone, value)
+	fn := s.onChange
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	if fn != nil {
+		fn(SetpointChange{ZoneID: zone, OldC: old, NewC: v, Actor: actor, Reason: reason, At: time.Now()})
+	}
+	return v, nil
+}
+
+// ObserveZones registers the callback told which zones a Reset added and removed.
+func (s *ZoneSetpoints) ObserveZones(fn func(added, removed []string)) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.onZones = fn
+}
+
+// Check validates defaults as Reset would, without applying them.
+func (s *ZoneSetpoints) Check(defaults map[string]float64) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.checkLocked(defaults)
+}
+
+func (s *ZoneSetpoints) checkLocked(defaults map[string]float64) error {
+	if len(defaults) == 0 {
+		return fmt.Errorf("setpoints: no zones configured")
+	}
+	for zone, val := range defaults {
+		if min, max := s.rangeLocked(zone); val < min || val > max {
+			return fmt.Errorf("%w: zone %s %.2f", ErrSetpointRange, zone, val)
+		}
+	}
+	return nil
+}
+
+// Reset replaces all zone setpoints with the provided defaults. The helper is used when
+// properties are reloaded so that the runtime store mirrors the latest configuration: the
+// zones of defaults become the tracked zones, so a reload may add and remove zones. Any
+// validation failure leaves the previous values untouched. Zones kept whose value changes
+// are returned and reported to the observer as changes by actor.
+func (s *ZoneSetpoints) Reset(defaults map[string]float64, actor string) ([]SetpointChange, error) {
+	s.mu.Lock()
+	if err := s.checkLocked(defaults); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	var changes []SetpointChange
+	var added, removed []string
+	now := time.Now()
 	for zone := range s.zones {
-		val, ok := defaults[zone]
-		if !ok {
-			return fmt.Errorf("%w: %s", ErrUnknownZone, zone)
+		if _, ok := defaults[zone]; !ok {
+			removed = append(removed, zone)
+			delete(s.zones, zone)
+			delete(s.values, zone)
 		}
-		if val < s.min || val > s.max {
-			return fmt.Errorf("%w: %.2f", ErrSetpointRange, val)
+	}
+	for zone, val := range defaults {
+		if _, ok := s.zones[zone]; !ok {
+			added = append(added, zone)
+			s.zones[zone] = struct{}{}
+		} else if old := s.values[zone]; old != val {
+			changes = append(changes, SetpointChange{ZoneID: zone, OldC: old, NewC: val, Actor: actor, Reason: "properties reload", At: now})
 		}
+		s.values[zone] = val
 	}
-	for zone := range s.zones {
-		s.values[zone] = defaults[zone]
+	fn, zfn := s.onChange, s.onZones
+	s.mu.Unlock()
+	sort.Slice(changes, func(i, j int) bool { return changes[i].ZoneID < changes[j].ZoneID })
+	sort.Strings(added)
+	sort.Strings(removed)
+	if zfn != nil && (len(added) > 0 || len(removed) > 0) {
+		zfn(added, removed)
 	}
-	return nil
+	if fn != nil {
+		for _, c := range changes {
+			fn(c)
+		}
+	}
+	return changes, nil
 }
 
-// Range exposes the allowable bounds so that HTTP validation can present user-friendly
-// error messages without duplicating configuration knowledge.
+// Range exposes the global bounds, which every zone's range lies within.
 func (s *ZoneSetpoints) Range() (float64, float64) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return s.min, s.max
 }
+
+// RangeFor returns the bounds of the zone's setpoint: its policy range if one was set,
+// else the global range.
+func (s *ZoneSetpoints) RangeFor(zone string) (float64, float64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rangeLocked(zone)
+}
+
+func (s *ZoneSetpoints) rangeLocked(zone string) (float64, float64) {
+	if r, ok := s.ranges[zone]; ok {
+		return r.min, r.max
+	}
+	return s.min, s.max
+}
+
+// SetRange narrows the zone's setpoint range. The range must lie within the global one
+// and contain the zone's current setpoint.
+func (s *ZoneSetpoints) SetRange(zone string, min, max float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if min >= max || min < s.min || max > s.max {
+		return fmt.Errorf("%w: range %.2f..%.2f not within %.2f..%.2f", ErrSetpointRange, min, max, s.min, s.max)
+	}
+	if v, ok := s.values[zone]; ok && (v < min || v > max) {
+		return fmt.Errorf("%w: current setpoint %.2f outside %.2f..%.2f", ErrSetpointRange, v, min, max)
+	}
+	s.ranges[zone] = setpointRange{min: min, max: max}
+	return nil
+}
+
+// ClearRange returns the zone to the global setpoint range.
+func (s *ZoneSetpoints) ClearRange(zone string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.ranges, zone)
+}
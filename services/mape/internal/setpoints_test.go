@@ -48,7 +48,7 @@ func TestTemperatureEndpoints(t *testing.T) {
 	if err != nil {
 		t.Fatalf("new setpoints: %v", err)
 	}
-	srv := NewHTTPServer(cfg, store, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	srv := NewHTTPServer(cfg, store, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
 
 	t.Run("get all", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/config/temperature", nil)
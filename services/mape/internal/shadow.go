@@ -0,0 +1,174 @@
+// v1
+// services/mape/internal/shadow.go
+package internal
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ShadowPlan is what a candidate strategy would have issued in an epoch. It is never
+// executed and does not pass through the actuator guards or the site budget.
+type ShadowPlan struct {
+	Strategy string        `json:"strategy"`
+	Action   string        `json:"action"`
+	Fan      int           `json:"fan"`
+	Duty     int           `json:"duty"`
+	Reason   string        `json:"reason"`
+	Diverged bool          `json:"diverged"`
+	Commands []PlanCommand `json:"commands"`
+}
+
+// ShadowRecord pairs one zone epoch of the live strategy with its shadow plans. Live* is
+// the live strategy's own decision, Executed the action finally applied after guards and
+// budget.
+type ShadowRecord struct {
+	ZoneID       string       `json:"zoneId"`
+	EpochIndex   int64        `json:"epochIndex"`
+	Timestamp    int64        `json:"timestamp"`
+	LiveStrategy string       `json:"liveStrategy"`
+	LiveAction   string       `json:"liveAction"`
+	LiveFan      int          `json:"liveFan"`
+	LiveDuty     int          `json:"liveDuty"`
+	Executed     string       `json:"executed"`
+	Shadows      []ShadowPlan `json:"shadows"`
+}
+
+// ShadowComparison aggregates how a candidate strategy diverged from the live one in a
+// zone. An epoch diverges when the action or the fan speed differs.
+type ShadowComparison struct {
+	LiveStrategy    string           `json:"liveStrategy"`
+	Epochs          int64            `json:"epochs"`
+	Diverged        int64            `json:"diverged"`
+	DivergenceRate  float64          `json:"divergenceRate"`
+	MeanAbsFanDiff  float64          `json:"meanAbsFanDiff"`
+	MeanAbsDutyDiff float64          `json:"meanAbsDutyDiff"`
+	Transitions     map[string]int64 `json:"transitions,omitempty"` // "LIVE->SHADOW" action pairs that differed
+	LastDivergedAt  int64            `json:"lastDivergedEpoch,omitempty"`
+
+	sumFan, sumDuty float64
+}
+
+// ShadowLog keeps the recent shadow records of every zone (bounded by shadow.history)
+// and running comparisons per zone and candidate strategy.
+type ShadowLog struct {
+	cfg     *AppConfig
+	mu      sync.Mutex
+	records map[string][]ShadowRecord
+	cmp     map[string]map[string]*ShadowComparison
+}
+
+func NewShadowLog(cfg *AppConfig) *ShadowLog {
+	return &ShadowLog{cfg: cfg, records: map[string][]ShadowRecord{}, cmp: map[string]map[string]*ShadowComparison{}}
+}
+
+// Record compares the shadow decisions carried by live against it and stores the result;
+// it returns nil when the zone has no shadow strategies.
+func (l *ShadowLog) Record(zone string, epochIndex int64, live AnalysisResult, executed string) *ShadowRecord {
+	if len(live.Shadow) == 0 {
+		return nil
+	}
+	rec := ShadowRecord{
+		ZoneID: zone, EpochIndex: epochIndex, Timestamp: time.Now().UnixMilli(),
+		LiveStrategy: live.Strategy, LiveAction: live.Action, LiveFan: live.Fan, LiveDuty: live.Duty, Executed: executed,
+	}
+	acts := l.cfg.Actuators[zone]
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cmp[zone] == nil {
+		l.cmp[zone] = map[string]*ShadowComparison{}
+	}
+	for _, sr := range live.Shadow {
+		sp := ShadowPlan{
+			Strategy: sr.Strategy, Action: sr.Action, Fan: sr.Fan, Duty: sr.Duty, Reason: sr.Reason,
+			Diverged: sr.Action != live.Action || sr.Fan != live.Fan,
+			Commands: commandsFor(zone, acts, epochIndex, sr),
+		}
+		rec.Shadows = append(rec.Shadows, sp)
+		c, ok := l.cmp[zone][sr.Strategy]
+		if !ok {
+			c = &ShadowComparison{}
+			l.cmp[zone][sr.Strategy] = c
+		}
+		c.LiveStrategy = live.Strategy
+		c.Epochs++
+		c.sumFan += math.Abs(float64(sr.Fan - live.Fan))
+		c.sumDuty += math.Abs(float64(sr.Duty - live.Duty))
+		if sp.Diverged {
+			c.Diverged++
+			c.LastDivergedAt = epochIndex
+		}
+		if sr.Action != live.Action {
+			if c.Transitions == nil {
+				c.Transitions = map[string]int64{}
+			}
+			c.Transitions[live.Action+"->"+sr.Action]++
+		}
+	}
+	hist := append(l.records[zone], rec)
+	if keep := l.cfg.ShadowHistory; keep > 0 && len(hist) > keep {
+		hist = hist[len(hist)-keep:]
+	}
+	l.records[zone] = hist
+	return &rec
+}
+
+// Compare returns the comparison of every zone and candidate strategy.
+func (l *ShadowLog) Compare() map[string]map[string]ShadowComparison {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make(map[string]map[string]ShadowComparison, len(l.cmp))
+	for zone, byStrategy := range l.cmp {
+		out[zone] = make(map[string]ShadowComparison, len(byStrategy))
+		for name, c := range byStrategy {
+			cp := *c
+			if cp.Epochs > 0 {
+				n := float64(cp.Epochs)
+				cp.DivergenceRate = float64(cp.Diverged) / n
+				cp.MeanAbsFanDiff = cp.sumFan / n
+				cp.MeanAbsDutyDiff = cp.sumDuty / n
+			}
+			if c.Transitions != nil {
+				cp.Transitions = make(map[string]int64, len(c.Transitions))
+				for k, v := range c.Transitions {
+					cp.Transitions[k] = v
+				}
+			}
+			out[zone][name] = cp
+		}
+	}
+	return out
+}
+
+// Forget drops the records and comparisons of a removed zone.
+func (l *ShadowLog) Forget(zone string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.records, zone)
+	delete(l.cmp, zone)
+}
+
+// Recent returns up to limit of the latest records, newest first, for one zone or for
+// every zone when zone is empty.
+func (l *ShadowLog) Recent(zone string, limit int) []ShadowRecord {
+	l.mu.Lock()
+	var out []ShadowRecord
+	for z, recs := range l.records {
+		if zone == "" || z == zone {
+			out = append(out, recs...)
+		}
+	}
+	l.mu.Unlock()
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Timestamp != out[j].Timestamp {
+			return out[i].Timestamp > out[j].Timestamp
+		}
+		return out[i].EpochIndex > out[j].EpochIndex
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
@@ -0,0 +1,109 @@
+// v1
+// services/mape/internal/shadow_test.go
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShadowStrategiesAreComparedNotExecuted(t *testing.T) {
+	cfg := &AppConfig{
+		Zones:          []string{"zone-A"},
+		ZoneTargets:    map[string]float64{"zone-A": 21.0},
+		ZoneHysteresis: map[string]float64{"zone-A": 0.5},
+		FanSteps:       []float64{0.5, 1.0, 2.0},
+		FanSpeeds:      []int{0, 25, 50, 100},
+		Actuators:      map[string]ZoneActuators{"zone-A": {Heating: []string{"h1"}, Cooling: []string{"c1"}, Ventilation: []string{"v1"}}},
+		Strategy:       StrategyHysteresis,
+		Shadow:         []string{StrategyHysteresis, StrategyPID},
+		ShadowHistory:  2,
+		PID:            DefaultPIDParams(),
+	}
+	store, err := NewZoneSetpoints(cfg.Zones, cfg.ZoneTargets, 10.0, 35.0)
+	if err != nil {
+		t.Fatalf("setpoints: %v", err)
+	}
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	an := NewAnalyze(cfg, store, nil, nil, lg)
+	log := NewShadowLog(cfg)
+
+	// Inside the band hysteresis stays OFF while PID heats on the small error; its low duty
+	// is time-proportioned, so only the first epoch of the cycle is ON.
+	for epoch := int64(1); epoch <= 3; epoch++ {
+		res := an.Run("zone-A", Reading{ZoneID: "zone-A", EpochIndex: epoch, AvgTempC: 20.7, HasTemp: true})
+		if res.Strategy != StrategyHysteresis || res.Action != "OFF" {
+			t.Fatalf("live decision changed: %+v", res)
+		}
+		if len(res.Shadow) != 1 || res.Shadow[0].Strategy != StrategyPID {
+			t.Fatalf("expected only the pid shadow (live is skipped), got %+v", res.Shadow)
+		}
+		rec := log.Record("zone-A", epoch, res, res.Action)
+		if rec == nil || len(rec.Shadows[0].Commands) != 3 {
+			t.Fatalf("expected a shadow plan with 3 commands, got %+v", rec)
+		}
+	}
+	cmp := log.Compare()["zone-A"][StrategyPID]
+	if cmp.Epochs != 3 || cmp.Diverged != 1 || cmp.LastDivergedAt != 1 || cmp.Transitions["OFF->HEAT"] != 1 || cmp.MeanAbsDutyDiff <= 0 {
+		t.Fatalf("unexpected comparison: %+v", cmp)
+	}
+	if recs := log.Recent("zone-A", 0); len(recs) != 2 || recs[0].EpochIndex != 3 {
+		t.Fatalf("history should keep the latest 2 records, got %+v", recs)
+	}
+
+	prev := engineRef
+	engineRef = &Engine{shad: log}
+	defer func() { engineRef = prev }()
+	srv := NewHTTPServer(cfg, store, nil, nil, lg)
+	rec := httptest.NewRecorder()
+	srv.http.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/shadow/compare", nil))
+	var body struct {
+		Zones map[string]map[string]ShadowComparison `json:"zones"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Zones["zone-A"][StrategyPID].Epochs != 3 {
+		t.Fatalf("compare: %d %s", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	srv.http.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/shadow/plans?zone=zone-A&limit=1", nil))
+	var plans struct {
+		Records []ShadowRecord `json:"records"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &plans); err != nil || len(plans.Records) != 1 {
+		t.Fatalf("plans: %d %s", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	srv.http.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/shadow/plans?zone=zone-Z", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown zone: %d", rec.Code)
+	}
+}
+
+func TestLoadPropertiesShadowStrategies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mape.properties")
+	body := "zones=zone-A,zone-B\ntarget=22.0\nstrategy=hysteresis\nshadow.strategies=pid,mpc\nshadow.strategies.zone-B=hysteresis\n"
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatalf("write properties: %v", err)
+	}
+	cfg := &AppConfig{}
+	if err := cfg.loadProperties(path); err != nil {
+		t.Fatalf("loadProperties: %v", err)
+	}
+	if got := cfg.ShadowFor("zone-A"); len(got) != 2 || got[0] != StrategyPID || got[1] != StrategyMPC {
+		t.Fatalf("zone-A shadows: %v", got)
+	}
+	if got := cfg.ShadowFor("zone-B"); len(got) != 0 {
+		t.Fatalf("zone-B shadows only its live strategy, got %v", got)
+	}
+	if err := os.WriteFile(path, []byte(body+"shadow.strategies=fuzzy\n"), 0o644); err != nil {
+		t.Fatalf("write properties: %v", err)
+	}
+	if err := (&AppConfig{}).loadProperties(path); err == nil {
+		t.Fatalf("unknown shadow strategy must be rejected")
+	}
+}
@@ -0,0 +1,345 @@
+// v2
+// services/mape/internal/simulate.go
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ErrInvalidSimulation wraps the reason a what-if simulation was rejected.
+var ErrInvalidSimulation = errors.New("invalid simulation")
+
+// Thermal models a simulation can run on.
+const (
+	SimModelLearned = "learned" // the zone's model fitted by the MPC strategy
+	SimModelPhysics = "physics" // the zone simulator's capacity/conductance equations
+)
+
+// simMaxPoints bounds the trajectory returned; longer runs are sampled evenly.
+const simMaxPoints = 720
+
+// SimulateParams configures what-if simulations. The physical model defaults to the zone
+// simulator's constants and ratings.
+type SimulateParams struct {
+	CapacityJPerC float64 // thermal capacity
+	EnvelopeWPerC float64 // conductance of the envelope to outdoors
+	VentWPerC     float64 // additional conductance at 100% fan
+	HeatKW        float64 // zone heating power
+	CoolKW        float64 // zone cooling power
+	OutdoorC      float64 // outdoor temperature unless the request sets one
+	StepS         float64 // epoch length of the physical model unless the request sets one
+	MaxSteps      int     // largest number of epochs a single simulation may run
+}
+
+// DefaultSimulateParams mirror zone_simulator/simulate.go and sim.properties.
+func DefaultSimulateParams() SimulateParams {
+	return SimulateParams{CapacityJPerC: 5e4, EnvelopeWPerC: 30, VentWPerC: 20, HeatKW: 1.5, CoolKW: 1.2, OutdoorC: 32, StepS: 1, MaxSteps: 50000}
+}
+
+func (p *SimulateParams) set(param string, v float64) error {
+	if param != "outdoor_c" && !(v > 0) {
+		return fmt.Errorf("simulate.%s must be positive, got %g", param, v)
+	}
+	switch param {
+	case "capacity_j_per_c":
+		p.CapacityJPerC = v
+	case "envelope_w_per_c":
+		p.EnvelopeWPerC = v
+	case "vent_w_per_c":
+		p.VentWPerC = v
+	case "heat_kw":
+		p.HeatKW = v
+	case "cool_kw":
+		p.CoolKW = v
+	case "outdoor_c":
+		p.OutdoorC = v
+	case "step_s":
+		p.StepS = v
+	case "max_steps":
+		p.MaxSteps = int(v)
+	default:
+		return fmt.Errorf("unknown simulate parameter %q", param)
+	}
+	return nil
+}
+
+// SimulationRequest is the body of POST /zones/{zone}/simulate. Every field but the
+// horizon is optional: unset ones keep what is in force for the zone.
+type SimulationRequest struct {
+	HorizonMinutes int             `json:"horizonMinutes"`
+	SetpointC      *float64        `json:"setpointC,omitempty"`
+	HysteresisC    *float64        `json:"hysteresisC,omitempty"`
+	Strategy       string          `json:"strategy,omitempty"`
+	Tariff         *TariffCalendar `json:"tariff,omitempty"`
+	Model          string          `json:"model,omitempty"`
+	StartTempC     *float64        `json:"startTempC,omitempty"`
+	OutdoorC       *float64        `json:"outdoorC,omitempty"`
+	StepSeconds    float64         `json:"stepSeconds,omitempty"`
+	Start          *time.Time      `json:"start,omitempty"`
+}
+
+// SimulationPoint is the predicted state at the end of an epoch.
+type SimulationPoint struct {
+	At      time.Time `json:"at"`
+	TempC   float64   `json:"tempC"`
+	TargetC float64   `json:"targetC"`
+	HystC   float64   `json:"hysteresisC"`
+	Action  string    `json:"action"`
+	Fan     int       `json:"fan"`
+}
+
+// SimulationCommand is a change of the zone's action and the commands it would issue.
+type SimulationCommand struct {
+	At       time.Time     `json:"at"`
+	Epoch    int64         `json:"epoch"`
+	Action   string        `json:"action"`
+	Fan      int           `json:"fan"`
+	Reason   string        `json:"reason"`
+	Commands []PlanCommand `json:"commands"`
+}
+
+// SimulationResult is the predicted outcome of running the zone over the horizon.
+type SimulationResult struct {
+	ZoneID       string              `json:"zoneId"`
+	Strategy     string              `json:"strategy"`
+	Model        string              `json:"model"`
+	Start        time.Time           `json:"start"`
+	StepSeconds  float64             `json:"stepSeconds"`
+	Epochs       int                 `json:"epochs"`
+	StartTempC   float64             `json:"startTempC"`
+	EndTempC     float64             `json:"endTempC"`
+	MinTempC     float64             `json:"minTempC"`
+	MaxTempC     float64             `json:"maxTempC"`
+	OutsideBandM float64             `json:"minutesOutsideBand"`
+	Starts       int                 `json:"starts"`
+	HeatKWh      float64             `json:"heatKWh"`
+	CoolKWh      float64             `json:"coolKWh"`
+	EnergyKWh    float64             `json:"energyKWh"`
+	Cost         float64             `json:"cost,omitempty"`
+	Currency     string              `json:"currency,omitempty"`
+	Trajectory   []SimulationPoint   `json:"trajectory"`
+	Commands     []SimulationCommand `json:"commands"`
+}
+
+// zoneModel advances a zone's temperature by one epoch under an action and fan speed.
+type zoneModel interface {
+	step(t float64, action string, fan int) float64
+}
+
+// learnedModel steps the MPC strategy's fitted model, whose gains are per fitted epoch.
+type learnedModel struct{ m *thermalModel }
+
+func (l learnedModel) step(t float64, action string, _ int) float64 { return l.m.step(t, action) }
+
+// physicsModel integrates the zone simulator's equations over dt.
+type physicsModel struct {
+	p        SimulateParams
+	outdoorC float64
+	dt       time.Duration
+	vents    bool
+}
+
+func (m physicsModel) step(t float64, action string, fan int) float64 {
+	q := m.p.EnvelopeWPerC * (m.outdoorC - t)
+	if m.vents {
+		q += m.p.VentWPerC * float64(fan) / 100 * (m.outdoorC - t)
+	}
+	switch action {
+	case "HEAT":
+		q += m.p.HeatKW * 1000
+	case "COOL":
+		q -= m.p.CoolKW * 1000
+	}
+	return t + q*m.dt.Seconds()/m.p.CapacityJPerC
+}
+
+// Simulate predicts how the zone would evolve over the horizon under the request's
+// setpoint, strategy and tariff. It runs fresh controller instances against a thermal model
+// and never touches live controller state, actuators or Kafka. Schedules, overrides and
+// demand-response events in force are honoured; guards, staging, the site budget,
+// occupancy and safety interlocks are not simulated.
+func (e *Engine) Simulate(zone string, req SimulationRequest, now time.Time) (SimulationResult, error) {
+	cfgMu.RLock()
+	sim := *e.cfg
+	cfgMu.RUnlock()
+	if _, ok := sim.ZoneTargets[zone]; !ok {
+		return SimulationResult{}, fmt.Errorf("%w: %s", ErrUnknownZone, zone)
+	}
+	params := sim.SimulateConfig()
+	if req.HorizonMinutes <= 0 {
+		return SimulationResult{}, fmt.Errorf("%w: horizonMinutes must be positive", ErrInvalidSimulation)
+	}
+	strategy := sim.StrategyFor(zone)
+	if req.Strategy != "" {
+		if _, ok := knownStrategies[req.Strategy]; !ok {
+			return SimulationResult{}, fmt.Errorf("%w: unknown strategy %q", ErrInvalidSimulation, req.Strategy)
+		}
+		strategy = req.Strategy
+	}
+	if req.SetpointC != nil {
+		lo, hi := e.sp.RangeFor(zone)
+		if *req.SetpointC < lo || *req.SetpointC > hi {
+			return SimulationResult{}, fmt.Errorf("%w: setpointC %.2f outside %.1f..%.1f", ErrInvalidSimulation, *req.SetpointC, lo, hi)
+		}
+	}
+	if req.HysteresisC != nil && !(*req.HysteresisC > 0) {
+		return SimulationResult{}, fmt.Errorf("%w: hysteresisC must be positive", ErrInvalidSimulation)
+	}
+	if req.Tariff != nil {
+		if err := req.Tariff.validate(); err != nil {
+			return SimulationResult{}, fmt.Errorf("%w: tariff: %v", ErrInvalidSimulation, err)
+		}
+		sim.TariffCalendar = req.Tariff
+	}
+
+	controllers := newControllers(&sim)
+	mpc, _ := e.an.controllers[StrategyMPC].(*mpcController)
+	fitted, hasFit := mpcZone{}, false
+	if mpc != nil {
+		fitted, hasFit = mpc.fitted(zone)
+	}
+	res := SimulationResult{ZoneID: zone, Strategy: strategy, Model: req.Model}
+	if res.Model == "" {
+		res.Model = SimModelPhysics
+		if hasFit {
+			res.Model = SimModelLearned
+		}
+	}
+	var model zoneModel
+	var dt time.Duration
+	heatKW, coolKW := params.HeatKW, params.CoolKW
+	switch res.Model {
+	case SimModelLearned:
+		if !hasFit {
+			return SimulationResult{}, fmt.Errorf("%w: no fitted model for zone %s yet", ErrInvalidSimulation, zone)
+		}
+		if req.StepSeconds != 0 {
+			return SimulationResult{}, fmt.Errorf("%w: the learned model steps by the epoch it was fitted on", ErrInvalidSimulation)
+		}
+		m := *fitted.model
+		if req.OutdoorC != nil && m.theta[1] < 0 {
+			// The model's outdoor temperature is implicit in its offset: -θ0/θ1.
+			m.theta[0] = -m.theta[1] * *req.OutdoorC
+		}
+		model, dt = learnedModel{m: &m}, fitted.dt
+		heatKW, coolKW = fitted.heatKW, fitted.coolKW
+	case SimModelPhysics:
+		step := params.StepS
+		if req.StepSeconds != 0 {
+			step = req.StepSeconds
+		}
+		if !(step > 0) {
+			return SimulationResult{}, fmt.Errorf("%w: stepSeconds must be positive", ErrInvalidSimulation)
+		}
+		outdoor := params.OutdoorC
+		if req.OutdoorC != nil {
+			outdoor = *req.OutdoorC
+		}
+		dt = time.Duration(step * float64(time.Second))
+		model = physicsModel{p: params, outdoorC: outdoor, dt: dt, vents: len(sim.Actuators[zone].Ventilation) > 0}
+	default:
+		return SimulationResult{}, fmt.Errorf("%w: unknown model %q", ErrInvalidSimulation, req.Model)
+	}
+	if dt <= 0 {
+		dt = time.Duration(sim.PollIntervalMs) * time.Millisecond
+	}
+	epochs := int(time.Duration(req.HorizonMinutes) * time.Minute / dt)
+	if epochs < 1 || (params.MaxSteps > 0 && epochs > params.MaxSteps) {
+		return SimulationResult{}, fmt.Errorf("%w: %d epochs of %s exceed simulate.max_steps %d", ErrInvalidSimulation, epochs, dt, params.MaxSteps)
+	}
+	// The strategy continues from the live fitted model rather than warming up again.
+	if c, ok := controllers[StrategyMPC].(*mpcController); ok && hasFit {
+		c.adopt(zone, fitted)
+	}
+
+	switch {
+	case req.StartTempC != nil:
+		res.StartTempC = *req.StartTempC
+	default:
+		last := e.dec.Last(zone, 1)
+		if len(last) == 0 || !last[0].Input.HasTemp {
+			return SimulationResult{}, fmt.Errorf("%w: no temperature seen for zone %s, startTempC is required", ErrInvalidSimulation, zone)
+		}
+		res.StartTempC = last[0].Input.TempC
+	}
+	res.Start = now
+	if req.Start != nil {
+		res.Start = *req.Start
+	}
+	res.StepSeconds, res.Epochs = dt.Seconds(), epochs
+
+	// A private copy of the DR events keeps simulated pre-conditioning out of live state.
+	dr := NewDREvents(&sim)
+	for _, ev := range e.an.dr.All() {
+		dr.events[ev.ID] = ev
+	}
+	ctrl := controllers[strategy]
+	acts := sim.Actuators[zone]
+	hours := dt.Hours()
+	every := max(1, epochs/simMaxPoints)
+	t := res.StartTempC
+	res.MinTempC, res.MaxTempC = t, t
+	prevAction, prevFan, lastMode := "OFF", 0, ""
+	for i := 0; i < epochs; i++ {
+		at := res.Start.Add(time.Duration(i) * dt)
+		target, _, ok := e.an.sched.resolveAt(zone, at)
+		if !ok {
+			target = sim.ZoneTargets[zone]
+		}
+		if req.SetpointC != nil {
+			target = *req.SetpointC
+		}
+		hyst := sim.HysteresisFor(zone)
+		if req.HysteresisC != nil {
+			hyst = *req.HysteresisC
+		}
+		adj := dr.adjust(zone, at, lastMode)
+		target += adj.PrecondC
+		hyst += adj.WidenC
+		out := ctrl.Decide(zone, ControlInput{TempC: t, Target: target, Hyst: hyst, Dt: dt})
+		if obs, ok := ctrl.(IssueObserver); ok {
+			obs.ObserveIssued(zone, out.Action)
+		}
+		if out.Action == "HEAT" || out.Action == "COOL" {
+			lastMode = out.Action
+		}
+		if i == 0 || out.Action != prevAction || out.Fan != prevFan {
+			if out.Action != "OFF" && prevAction != out.Action {
+				res.Starts++
+			}
+			res.Commands = append(res.Commands, SimulationCommand{
+				At: at, Epoch: int64(i), Action: out.Action, Fan: out.Fan, Reason: out.Reason,
+				Commands: commandsFor(zone, acts, int64(i), out),
+			})
+		}
+		prevAction, prevFan = out.Action, out.Fan
+
+		var kwh float64
+		switch out.Action {
+		case "HEAT":
+			kwh = heatKW * hours
+			res.HeatKWh += kwh
+		case "COOL":
+			kwh = coolKW * hours
+			res.CoolKWh += kwh
+		}
+		res.Cost += kwh * adj.Price
+		if adj.Currency != "" {
+			res.Currency = adj.Currency
+		}
+		t = model.step(t, out.Action, out.Fan)
+		res.MinTempC, res.MaxTempC = math.Min(res.MinTempC, t), math.Max(res.MaxTempC, t)
+		if math.Abs(t-target) > hyst {
+			res.OutsideBandM += dt.Minutes()
+		}
+		if i%every == every-1 || i == epochs-1 {
+			res.Trajectory = append(res.Trajectory, SimulationPoint{At: at.Add(dt), TempC: t, TargetC: target, HystC: hyst, Action: out.Action, Fan: out.Fan})
+		}
+	}
+	res.EndTempC = t
+	res.EnergyKWh = res.HeatKWh + res.CoolKWh
+	return res, nil
+}
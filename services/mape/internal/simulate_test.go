@@ -0,0 +1,127 @@
+// v1
+// services/mape/internal/simulate_test.go
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulateLearnedAndPhysicsModels(t *testing.T) {
+	cfg := &AppConfig{
+		Zones:          []string{"zone-A", "zone-B"},
+		ZoneTargets:    map[string]float64{"zone-A": 21, "zone-B": 21},
+		ZoneHysteresis: map[string]float64{"zone-A": 0.5, "zone-B": 0.5},
+		FanSteps:       []float64{0.5, 1.0},
+		FanSpeeds:      []int{50, 100},
+		Actuators: map[string]ZoneActuators{
+			"zone-A": {Heating: []string{"heat-1"}},
+			"zone-B": {Heating: []string{"heat-2"}, Cooling: []string{"cool-2"}},
+		},
+		ZoneStrategy:   map[string]string{"zone-A": StrategyMPC},
+		MPC:            MPCParams{Horizon: 4, StepEpochs: 2, HeatKW: 2, CoolKW: 2, ComfortWeight: 10, Forgetting: 1, MinSamples: 20},
+		PollIntervalMs: 1000,
+		SetpointMinC:   10,
+		SetpointMaxC:   35,
+	}
+	store, err := NewZoneSetpoints(cfg.Zones, cfg.ZoneTargets, 10.0, 35.0)
+	if err != nil {
+		t.Fatalf("setpoints: %v", err)
+	}
+	prev := engineRef
+	defer func() { engineRef = prev }()
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	e := NewEngine(cfg, store, nil, nil, lg, nil)
+
+	// Fit the live MPC model of zone-A on the same plant as the MPC tests.
+	live := e.an.controllers[StrategyMPC]
+	temp := 18.0
+	for i := 0; i < 300; i++ {
+		res := live.Decide("zone-A", ControlInput{TempC: temp, Target: 21, Hyst: 0.5, Dt: time.Second})
+		temp = plant(temp, res.Action)
+	}
+	before := e.an.MPCStatus()["zone-A"]
+
+	srv := NewHTTPServer(cfg, store, nil, nil, lg)
+	simulate := func(zone, body string) (*httptest.ResponseRecorder, SimulationResult) {
+		rec := httptest.NewRecorder()
+		srv.http.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/zones/"+zone+"/simulate", strings.NewReader(body)))
+		var res SimulationResult
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+		}
+		return rec, res
+	}
+
+	rec, res := simulate("zone-A", `{"horizonMinutes":5,"startTempC":18}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("learned: %d %s", rec.Code, rec.Body.String())
+	}
+	if res.Model != SimModelLearned || res.Strategy != StrategyMPC || res.Epochs != 300 || res.StepSeconds != 1 {
+		t.Fatalf("unexpected run: %+v", res)
+	}
+	if res.Commands[0].Action != "HEAT" || res.Commands[0].Commands[0].ActuatorID != "heat-1" || res.HeatKWh <= 0 {
+		t.Fatalf("expected the zone to heat first: %+v", res.Commands[0])
+	}
+	if res.EndTempC < 20.3 || res.EndTempC > 21.7 || len(res.Trajectory) != 300 {
+		t.Fatalf("predicted end %.2f with %d points", res.EndTempC, len(res.Trajectory))
+	}
+	if after := e.an.MPCStatus()["zone-A"]; after.Samples != before.Samples || after.Theta != before.Theta {
+		t.Fatalf("simulation leaked into the live model: %+v -> %+v", before, after)
+	}
+
+	// The physical model needs no history; a cheaper tariff is priced in.
+	rec, res = simulate("zone-B", `{"horizonMinutes":60,"setpointC":24,"startTempC":20,"outdoorC":10,"model":"physics",
+		"tariff":{"currency":"EUR","defaultPrice":0.2}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("physics: %d %s", rec.Code, rec.Body.String())
+	}
+	if res.Model != SimModelPhysics || res.Strategy != StrategyHysteresis || res.Epochs != 3600 || len(res.Trajectory) != 720 {
+		t.Fatalf("unexpected run: %+v", res)
+	}
+	if res.EndTempC < 23 || res.EndTempC > 25 || res.MinTempC != 20 || res.Starts < 2 {
+		t.Fatalf("expected the zone to reach and hold 24C: %+v", res)
+	}
+	if res.Currency != "EUR" || math.Abs(res.Cost-res.EnergyKWh*0.2) > 1e-9 || res.CoolKWh != 0 {
+		t.Fatalf("unexpected energy: %+v", res)
+	}
+
+	// Looking past an override's expiry must not drop the live override.
+	if err := e.an.sched.SetOverride("zone-B", Override{SetpointC: 25, ExpiresAt: time.Now().Add(10 * time.Minute)}, time.Now()); err != nil {
+		t.Fatalf("override: %v", err)
+	}
+	rec, res = simulate("zone-B", `{"horizonMinutes":60,"startTempC":20,"model":"physics","stepSeconds":10}`)
+	if rec.Code != http.StatusOK || res.Trajectory[0].TargetC != 25 || res.Trajectory[len(res.Trajectory)-1].TargetC != 21 {
+		t.Fatalf("override not followed: %d %s", rec.Code, rec.Body.String())
+	}
+	if _, ok := e.an.sched.Override("zone-B", time.Now()); !ok {
+		t.Fatalf("simulation removed the live override")
+	}
+
+	for _, tc := range []struct {
+		zone, body string
+		code       int
+	}{
+		{"zone-A", `{"horizonMinutes":0}`, http.StatusBadRequest},
+		{"zone-A", `{"horizonMinutes":5,"strategy":"fuzzy"}`, http.StatusBadRequest},
+		{"zone-A", `{"horizonMinutes":5,"setpointC":50}`, http.StatusBadRequest},
+		{"zone-A", `{"horizonMinutes":5,"tariff":{"periods":[{"days":["mon"],"start":"10:00","end":"09:00"}]}}`, http.StatusBadRequest},
+		{"zone-B", `{"horizonMinutes":5,"model":"learned","startTempC":20}`, http.StatusBadRequest},
+		{"zone-B", `{"horizonMinutes":5}`, http.StatusBadRequest}, // no temperature seen yet
+		{"zone-B", `{"horizonMinutes":100000,"startTempC":20}`, http.StatusBadRequest},
+		{"zone-Z", `{"horizonMinutes":5}`, http.StatusNotFound},
+	} {
+		if rec, _ := simulate(tc.zone, tc.body); rec.Code != tc.code {
+			t.Fatalf("%s %s: expected %d, got %d %s", tc.zone, tc.body, tc.code, rec.Code, rec.Body.String())
+		}
+	}
+}
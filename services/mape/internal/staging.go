@@ -0,0 +1,229 @@
+// v1
+// services/mape/internal/staging.go
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// StagingParams configures how zones with several heaters or coolers bring them online.
+type StagingParams struct {
+	// StepC is the additional |deltaT| that brings one more unit online; zero runs every
+	// unit of the active mode together.
+	StepC float64
+}
+
+func (p *StagingParams) set(param string, v float64) error {
+	switch param {
+	case "step_c":
+		if v < 0 {
+			return fmt.Errorf("staging.step_c must not be negative, got %g", v)
+		}
+		p.StepC = v
+	default:
+		return fmt.Errorf("unknown staging parameter %q", param)
+	}
+	return nil
+}
+
+// UnitRuntime is one heater's or cooler's accumulated run time, as shown on /status.
+type UnitRuntime struct {
+	ZoneID   string    `json:"zoneId"`
+	On       bool      `json:"on"`
+	Since    time.Time `json:"since,omitempty"`
+	RuntimeH float64   `json:"runtimeH"`
+	Starts   int       `json:"starts"`
+}
+
+type stagedUnit struct {
+	zone    string
+	on      bool
+	since   time.Time
+	runtime time.Duration // completed ON periods
+	starts  int
+}
+
+func (u *stagedUnit) ranFor(now time.Time) time.Duration {
+	if u.on {
+		return u.runtime + now.Sub(u.since)
+	}
+	return u.runtime
+}
+
+// Staging decides which of a zone's heaters or coolers run. The first unit starts with the
+// mode and one more joins per staging.step_c of |deltaT|; a running unit only leaves once
+// |deltaT| fell half a step below the point where it joined. Units join in order of least
+// run time, so the lead unit rotates between cycles and run hours even out, and leave in
+// order of most. The zone's minimum run and rest times and start limit hold per unit, as
+// tracked by the plan's ActuatorGuards, and units flagged faulty are skipped while a
+// healthy one is left.
+type Staging struct {
+	cfg   *AppConfig
+	mu    sync.Mutex
+	units map[string]*stagedUnit
+	// faulted reports whether an actuator is flagged by acknowledgements or diagnostics;
+	// nil when nothing is.
+	faulted func(id string) bool
+	// guards holds the per-unit on/off history; nil leaves units unguarded.
+	guards *ActuatorGuards
+	now    func() time.Time
+}
+
+func NewStaging(cfg *AppConfig) *Staging {
+	return &Staging{cfg: cfg, units: map[string]*stagedUnit{}, now: time.Now}
+}
+
+// Apply turns the commands of the active mode's units that should not run OFF and records
+// which units run; it returns a note for the ledger, empty when every unit runs.
+func (s *Staging) Apply(zone string, res AnalysisResult, cmds []PlanCommand) string {
+	acts := s.cfg.Actuators[zone]
+	ids := modeActuators(acts, res.Action)
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run := map[string]bool{}
+	var skipped []string
+	if len(ids) > 0 {
+		healthy := ids
+		if s.faulted != nil {
+			healthy = nil
+			for _, id := range ids {
+				if s.faulted(id) {
+					skipped = append(skipped, id)
+				} else {
+					healthy = append(healthy, id)
+				}
+			}
+			if len(healthy) == 0 {
+				healthy, skipped = ids, nil
+			}
+		}
+		for _, id := range s.pick(zone, healthy, abs(res.Delta), now) {
+			run[id] = true
+		}
+	}
+	note := ""
+	if n := len(run); n < len(ids) {
+		note = fmt.Sprintf("staged %d of %d units", n, len(ids))
+		if len(skipped) > 0 {
+			note += fmt.Sprintf(", faulty skipped: %v", skipped)
+		}
+		for i, c := range cmds {
+			if c.Mode == "ON" && !run[c.ActuatorID] {
+				cmds[i].Mode, cmds[i].FanPercent = "OFF", 0
+				cmds[i].Reason = note
+			}
+		}
+	}
+	for _, id := range append(append([]string(nil), acts.Heating...), acts.Cooling...) {
+		s.record(zone, id, run[id], now)
+	}
+	return note
+}
+
+// pick returns the units of ids that should run for a temperature error of absDelta.
+func (s *Staging) pick(zone string, ids []string, absDelta float64, now time.Time) []string {
+	var running, idle []string
+	for _, id := range ids {
+		if s.unit(zone, id).on {
+			running = append(running, id)
+		} else {
+			idle = append(idle, id)
+		}
+	}
+	want := len(ids)
+	if step := s.cfg.Staging.StepC; step > 0 {
+		want = 1 + int(absDelta/step)
+		if hold := 1 + int((absDelta+step/2)/step); len(running) > want {
+			want = min(len(running), hold)
+		}
+		want = min(want, len(ids))
+	}
+	guard := s.cfg.GuardFor(zone)
+	// Least run time first; a unit ahead in the list joins earlier and leaves later.
+	byRuntime := func(list []string) {
+		sort.SliceStable(list, func(i, j int) bool {
+			return s.units[list[i]].ranFor(now) < s.units[list[j]].ranFor(now)
+		})
+	}
+	byRuntime(running)
+	byRuntime(idle)
+	for i := len(running) - 1; i >= 0 && len(running) > want; i-- {
+		if s.guards != nil && s.guards.mustRun(running[i], guard, now) {
+			continue
+		}
+		running = append(running[:i], running[i+1:]...)
+	}
+	for _, id := range idle {
+		if len(running) >= want {
+			break
+		}
+		if s.guards != nil && !s.guards.mayStart(id, guard, now) {
+			continue
+		}
+		running = append(running, id)
+	}
+	return running
+}
+
+func (s *Staging) unit(zone, id string) *stagedUnit {
+	u, ok := s.units[id]
+	if !ok {
+		u = &stagedUnit{}
+		s.units[id] = u
+	}
+	u.zone = zone
+	return u
+}
+
+func (s *Staging) record(zone, id string, on bool, now time.Time) {
+	u := s.unit(zone, id)
+	if u.on == on {
+		return
+	}
+	if u.on {
+		u.runtime += now.Sub(u.since)
+	} else {
+		u.starts++
+	}
+	u.on, u.since = on, now
+}
+
+// Forget drops the units of a removed zone, when zone is not empty, and the given units.
+func (s *Staging) Forget(zone string, ids []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		delete(s.units, id)
+	}
+	if zone == "" {
+		return
+	}
+	for id, u := range s.units {
+		if u.zone == zone {
+			delete(s.units, id)
+		}
+	}
+}
+
+// Status returns the run time of every heater and cooler commanded so far.
+func (s *Staging) Status() map[string]UnitRuntime {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.units) == 0 {
+		return nil
+	}
+	out := make(map[string]UnitRuntime, len(s.units))
+	for id, u := range s.units {
+		rt := UnitRuntime{ZoneID: u.zone, On: u.on, RuntimeH: u.ranFor(now).Hours(), Starts: u.starts}
+		if u.on {
+			rt.Since = u.since
+		}
+		out[id] = rt
+	}
+	return out
+}
@@ -0,0 +1,119 @@
+// v1
+// services/mape/internal/staging_test.go
+package internal
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStagingRotatesAndSkipsFaulty(t *testing.T) {
+	cfg := &AppConfig{
+		Zones:     []string{"zone-A"},
+		Actuators: map[string]ZoneActuators{"zone-A": {Heating: []string{"h1", "h2", "h3"}, Cooling: []string{"c1"}}},
+		Staging:   StagingParams{StepC: 1},
+	}
+	p := NewPlan(cfg, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	start := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	now := start
+	p.staging.now = func() time.Time { return now }
+	p.guards.now = p.staging.now
+	var epoch int64
+	// step plans the zone at minute m and returns the units switched ON.
+	step := func(m int, action string, delta float64) (string, LedgerEvent) {
+		t.Helper()
+		now = start.Add(time.Duration(m) * time.Minute)
+		epoch++
+		cmds, led := p.Build("zone-A", epoch, "", "", AnalysisResult{Action: action, Delta: delta, Reason: "test"})
+		var on []string
+		for _, c := range cmds {
+			if c.Mode == "ON" {
+				on = append(on, c.ActuatorID)
+			}
+		}
+		return strings.Join(on, ","), led
+	}
+
+	for _, tc := range []struct {
+		m      int
+		action string
+		delta  float64
+		on     string
+	}{
+		{0, "HEAT", -0.6, "h1"},        // one unit starts
+		{10, "HEAT", -2.2, "h1,h2,h3"}, // one more per degree
+		{15, "HEAT", -1.6, "h1,h2,h3"}, // within half a step of the third unit: all stay
+		{20, "HEAT", -0.3, "h2"},       // units with the most run time leave first
+		{30, "OFF", 0, ""},
+		{40, "HEAT", -0.2, "h3"}, // the lead rotates to the least run unit
+		{50, "COOL", 1.0, "c1"},  // a single cooler is not staged
+	} {
+		on, _ := step(tc.m, tc.action, tc.delta)
+		if on != tc.on {
+			t.Fatalf("minute %d: expected %q ON, got %q", tc.m, tc.on, on)
+		}
+	}
+
+	p.staging.faulted = func(id string) bool { return id == "h2" }
+	on, led := step(60, "HEAT", -0.4)
+	if on != "h1" || led.Staging != "staged 1 of 3 units, faulty skipped: [h2]" {
+		t.Fatalf("faulty unit not skipped: %q %q", on, led.Staging)
+	}
+	if on, _ = step(70, "HEAT", -3); on != "h1,h3" {
+		t.Fatalf("a faulty unit must not join: %q", on)
+	}
+	p.staging.faulted = func(string) bool { return true }
+	if on, _ = step(80, "HEAT", -0.4); on != "h3" {
+		t.Fatalf("with every unit faulty the zone still heats: %q", on)
+	}
+	rt := p.staging.Status()
+	if h1 := rt["h1"]; h1.On || h1.Starts != 2 || h1.RuntimeH != 40.0/60 {
+		t.Fatalf("unexpected h1 run time: %+v", h1)
+	}
+	if h3 := rt["h3"]; !h3.On || h3.Starts != 3 || h3.RuntimeH != 0.5 {
+		t.Fatalf("unexpected h3 run time: %+v", h3)
+	}
+
+	// Units within the minimum run time keep running although they are no longer needed.
+	cfg.Guard = GuardParams{MinOn: time.Hour}
+	p.staging.faulted = nil
+	step(90, "HEAT", -3)
+	if on, _ := step(100, "HEAT", -0.2); on != "h1,h2,h3" {
+		t.Fatalf("units within min on must keep running: %q", on)
+	}
+
+	// A unit staged in moments ago holds the zone in its mode although the rest may stop.
+	step(170, "HEAT", -0.2)
+	if on, _ := step(180, "HEAT", -1.2); on != "h1,h2" {
+		t.Fatalf("expected a second unit to join: %q", on)
+	}
+	if on, led := step(181, "OFF", 0); on != "h1" || !strings.Contains(led.Suppressed, "h1 must run") {
+		t.Fatalf("the unit staged in must keep the zone heating: %q %+v", on, led)
+	}
+	if on, _ := step(240, "OFF", 0); on != "" {
+		t.Fatalf("expected every unit off after min on: %q", on)
+	}
+
+	// Restarts caused by staging count against the unit's own start limit.
+	cfg.Guard = GuardParams{MaxStartsPerHour: 2}
+	for _, tc := range []struct {
+		m     int
+		delta float64
+		on    string
+	}{
+		{300, -1.2, "h2,h3"},
+		{305, -0.2, "h2"},
+		{310, -1.2, "h2,h3"},
+		{315, -0.2, "h2"},
+		{320, -1.2, "h1,h2"}, // h3 started twice this hour
+		{370, -0.2, "h2"},
+		{375, -1.2, "h2,h3"}, // the first start of h3 left the hour
+	} {
+		if on, _ := step(tc.m, "HEAT", tc.delta); on != tc.on {
+			t.Fatalf("minute %d: expected %q ON, got %q", tc.m, tc.on, on)
+		}
+	}
+}
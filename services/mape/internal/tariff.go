@@ -0,0 +1,306 @@
+// v2
+// services/mape/internal/tariff.go
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidDREvent wraps validation failures of demand-response events.
+var ErrInvalidDREvent = errors.New("invalid demand-response event")
+
+// TariffPeriod prices energy on the listed days between Start and End ("HH:MM", End
+// exclusive and may be "24:00"). The first matching period wins.
+type TariffPeriod struct {
+	Name  string   `json:"name"`
+	Days  []string `json:"days"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+	Price float64  `json:"price"`
+}
+
+// TariffCalendar is the time-of-use price list loaded from tariff.file.
+type TariffCalendar struct {
+	Currency     string         `json:"currency"`
+	DefaultPrice float64        `json:"defaultPrice"`
+	Periods      []TariffPeriod `json:"periods"`
+}
+
+// LoadTariffCalendar reads and validates a JSON tariff calendar.
+func LoadTariffCalendar(path string) (*TariffCalendar, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("tariff file: %w", err)
+	}
+	var cal TariffCalendar
+	if err := json.Unmarshal(b, &cal); err != nil {
+		return nil, fmt.Errorf("tariff file %s: %w", path, err)
+	}
+	if err := cal.validate(); err != nil {
+		return nil, fmt.Errorf("tariff file %s: %w", path, err)
+	}
+	return &cal, nil
+}
+
+// validate checks every period's days and window and that no price is negative.
+func (c *TariffCalendar) validate() error {
+	if c.DefaultPrice < 0 {
+		return errors.New("negative default price")
+	}
+	for i, p := range c.Periods {
+		rule := ScheduleRule{Days: p.Days, Start: p.Start, End: p.End}
+		if err := validateRuleWindow(i, rule); err != nil {
+			return err
+		}
+		if p.Price < 0 {
+			return fmt.Errorf("period %d has a negative price", i)
+		}
+	}
+	return nil
+}
+
+// PriceAt returns the price per kWh and the period name in force at local time t.
+func (c *TariffCalendar) PriceAt(t time.Time) (float64, string) {
+	minute := t.Hour()*60 + t.Minute()
+	for _, p := range c.Periods {
+		rule := ScheduleRule{Days: p.Days}
+		if !rule.hasDay(t.Weekday()) {
+			continue
+		}
+		start, _ := parseClock(p.Start)
+		end, _ := parseClock(p.End)
+		if minute >= start && minute < end {
+			return p.Price, p.Name
+		}
+	}
+	return c.DefaultPrice, "default"
+}
+
+// TariffParams configures how prices and demand-response events shape the comfort band.
+type TariffParams struct {
+	File                string
+	PreconditionMinutes int     // look-ahead for expensive periods
+	PreconditionRatio   float64 // a period is expensive when price ≥ ratio × current price
+	PreconditionOffsetC float64 // setpoint shift towards the active mode before it starts
+	DRDefaultWidenC     float64 // band widening for events that do not specify one
+	DRMaxWidenC         float64 // upper bound accepted for any event
+}
+
+func (p *TariffParams) set(param, v string) error {
+	if param == "file" {
+		p.File = v
+		return nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fmt.Errorf("tariff.%s: %w", param, err)
+	}
+	switch param {
+	case "precondition_minutes":
+		p.PreconditionMinutes = int(f)
+	case "precondition_ratio":
+		p.PreconditionRatio = f
+	case "precondition_offset_c":
+		p.PreconditionOffsetC = f
+	case "dr_default_widen_c":
+		p.DRDefaultWidenC = f
+	case "dr_max_widen_c":
+		p.DRMaxWidenC = f
+	default:
+		return fmt.Errorf("unknown tariff parameter %q", param)
+	}
+	return nil
+}
+
+// DREvent is a demand-response request from the utility. Zones empty means site-wide.
+// Actor names who accepted it; a cancelled event stays listed, with who cancelled it and
+// when, until it would have ended.
+type DREvent struct {
+	ID          string     `json:"id"`
+	Start       time.Time  `json:"start"`
+	End         time.Time  `json:"end"`
+	WidenC      float64    `json:"widenC,omitempty"`
+	Zones       []string   `json:"zones,omitempty"`
+	Actor       string     `json:"actor,omitempty"`
+	CancelledBy string     `json:"cancelledBy,omitempty"`
+	CancelledAt *time.Time `json:"cancelledAt,omitempty"`
+}
+
+func (ev DREvent) covers(zone string, at time.Time) bool {
+	if ev.CancelledAt != nil || at.Before(ev.Start) || !at.Before(ev.End) {
+		return false
+	}
+	if len(ev.Zones) == 0 {
+		return true
+	}
+	for _, z := range ev.Zones {
+		if z == zone {
+			return true
+		}
+	}
+	return false
+}
+
+// DREvents stores accepted demand-response events in memory; ended events are pruned
+// when new ones are added.
+type DREvents struct {
+	cfg    *AppConfig
+	mu     sync.RWMutex
+	events map[string]DREvent
+}
+
+func NewDREvents(cfg *AppConfig) *DREvents {
+	return &DREvents{cfg: cfg, events: map[string]DREvent{}}
+}
+
+// Add validates and stores an event accepted by actor, applying the default widening and
+// the cap.
+func (d *DREvents) Add(ev DREvent, actor string, now time.Time) (DREvent, error) {
+	if ev.ID == "" {
+		return ev, fmt.Errorf("%w: id is required", ErrInvalidDREvent)
+	}
+	if !ev.End.After(ev.Start) || !ev.End.After(now) {
+		return ev, fmt.Errorf("%w: end must be after start and in the future", ErrInvalidDREvent)
+	}
+	for _, z := range ev.Zones {
+		if _, ok := d.cfg.ZoneTargets[z]; !ok {
+			return ev, fmt.Errorf("%w: %s", ErrUnknownZone, z)
+		}
+	}
+	p := d.cfg.Tariff
+	if ev.WidenC <= 0 {
+		ev.WidenC = p.DRDefaultWidenC
+	}
+	if ev.WidenC > p.DRMaxWidenC {
+		return ev, fmt.Errorf("%w: widenC %.2f exceeds dr_max_widen_c %.2f", ErrInvalidDREvent, ev.WidenC, p.DRMaxWidenC)
+	}
+	ev.Actor, ev.CancelledBy, ev.CancelledAt = actor, "", nil
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id, old := range d.events {
+		if !old.End.After(now) {
+			delete(d.events, id)
+		}
+	}
+	d.events[ev.ID] = ev
+	return ev, nil
+}
+
+// Cancel records that actor cancelled an event; the boolean reports whether it existed
+// and was not cancelled yet.
+func (d *DREvents) Cancel(id, actor string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ev, ok := d.events[id]
+	if !ok || ev.CancelledAt != nil {
+		return false
+	}
+	ev.CancelledBy, ev.CancelledAt = actor, &now
+	d.events[id] = ev
+	return true
+}
+
+// All returns the stored events ordered by start.
+func (d *DREvents) All() []DREvent {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	out := make([]DREvent, 0, len(d.events))
+	for _, ev := range d.events {
+		out = append(out, ev)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out
+}
+
+// Active returns the event with the largest widening that covers zone at t.
+func (d *DREvents) Active(zone string, at time.Time) (DREvent, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var best DREvent
+	found := false
+	for _, ev := range d.events {
+		if ev.covers(zone, at) && (!found || ev.WidenC > best.WidenC) {
+			best, found = ev, true
+		}
+	}
+	return best, found
+}
+
+// TariffAdjustment is how prices and DR shaped one zone's epoch.
+type TariffAdjustment struct {
+	Price     float64
+	Period    string
+	Currency  string
+	DREventID string
+	WidenC    float64
+	PrecondC  float64 // signed setpoint shift applied ahead of an expensive period
+	Reason    string
+}
+
+// adjust returns the tariff/DR adjustment for a zone at epoch time at. lastMode is the
+// last HEAT/COOL applied in the zone and decides the direction of pre-conditioning.
+func (d *DREvents) adjust(zone string, at time.Time, lastMode string) TariffAdjustment {
+	var adj TariffAdjustment
+	if ev, ok := d.Active(zone, at); ok {
+		adj.DREventID, adj.WidenC = ev.ID, ev.WidenC
+		adj.Reason = fmt.Sprintf("DR event %s widens band by %.2fC", ev.ID, ev.WidenC)
+	}
+	cal := d.cfg.TariffCalendar
+	if cal == nil {
+		return adj
+	}
+	p := d.cfg.Tariff
+	local := at.In(d.cfg.location())
+	adj.Currency = cal.Currency
+	adj.Price, adj.Period = cal.PriceAt(local)
+	if adj.DREventID != "" || p.PreconditionMinutes <= 0 || p.PreconditionOffsetC == 0 || lastMode == "" {
+		return adj
+	}
+	// Look ahead in 5-minute steps for the next period that is markedly more expensive.
+	for m := 5; m <= p.PreconditionMinutes; m += 5 {
+		price, name := cal.PriceAt(local.Add(time.Duration(m) * time.Minute))
+		if price >= adj.Price*p.PreconditionRatio && price > adj.Price {
+			sign := 1.0
+			if lastMode == "COOL" {
+				sign = -1
+			}
+			adj.PrecondC = sign * math.Abs(p.PreconditionOffsetC)
+			adj.Reason = fmt.Sprintf("pre-conditioning %+.2fC before %s in %d min", adj.PrecondC, name, m)
+			break
+		}
+	}
+	return adj
+}
+
+// validateRuleWindow checks a rule's days and window, shared by schedules and tariffs.
+func validateRuleWindow(i int, r ScheduleRule) error {
+	if len(r.Days) == 0 {
+		return fmt.Errorf("%w: rule %d has no days", ErrInvalidSchedule, i)
+	}
+	for _, d := range r.Days {
+		if _, ok := weekdayNames[strings.ToLower(d)]; !ok {
+			return fmt.Errorf("%w: rule %d unknown day %q", ErrInvalidSchedule, i, d)
+		}
+	}
+	start, err := parseClock(r.Start)
+	if err != nil {
+		return err
+	}
+	end, err := parseClock(r.End)
+	if err != nil {
+		return err
+	}
+	if start >= end {
+		return fmt.Errorf("%w: rule %d start %s not before end %s", ErrInvalidSchedule, i, r.Start, r.End)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+// v2
+// services/mape/internal/tariff_test.go
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tariffTestConfig(t *testing.T) *AppConfig {
+	t.Helper()
+	cal := `{"currency":"EUR","defaultPrice":0.10,"periods":[
+		{"name":"peak","days":["mon","tue","wed","thu","fri"],"start":"17:00","end":"20:00","price":0.30}]}`
+	path := filepath.Join(t.TempDir(), "tariff.json")
+	if err := os.WriteFile(path, []byte(cal), 0o644); err != nil {
+		t.Fatalf("write calendar: %v", err)
+	}
+	loaded, err := LoadTariffCalendar(path)
+	if err != nil {
+		t.Fatalf("load calendar: %v", err)
+	}
+	return &AppConfig{
+		Zones:          []string{"zone-A"},
+		ZoneTargets:    map[string]float64{"zone-A": 21.0},
+		ZoneHysteresis: map[string]float64{"zone-A": 0.5},
+		FanSteps:       []float64{0.5, 1.0, 2.0},
+		FanSpeeds:      []int{0, 25, 50, 100},
+		Tariff:         TariffParams{File: path, PreconditionMinutes: 60, PreconditionRatio: 1.5, PreconditionOffsetC: 1, DRDefaultWidenC: 1, DRMaxWidenC: 2},
+		TariffCalendar: loaded,
+	}
+}
+
+func TestTariffPreconditionAndCost(t *testing.T) {
+	cfg := tariffTestConfig(t)
+	store, err := NewZoneSetpoints(cfg.Zones, cfg.ZoneTargets, 10.0, 35.0)
+	if err != nil {
+		t.Fatalf("setpoints: %v", err)
+	}
+	an := NewAnalyze(cfg, store, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	read := func(start string, temp float64) Reading {
+		return Reading{ZoneID: "zone-A", EpochStart: start, AvgTempC: temp, HasTemp: true, ZoneEnergyKWhEpoch: 2}
+	}
+
+	// Without a known mode there is no direction to pre-condition in.
+	res := an.Run("zone-A", read("2025-12-22T16:30:00Z", 21))
+	if res.Tariff.PrecondC != 0 || res.Tariff.Period != "default" || res.CostEpoch != 0.2 {
+		t.Fatalf("unexpected adjustment: %+v cost=%.2f", res.Tariff, res.CostEpoch)
+	}
+
+	// After heating, 30 minutes before peak the target is raised and the zone keeps heating.
+	an.ObserveIssued("zone-A", "HEAT")
+	res = an.Run("zone-A", read("2025-12-22T16:30:00Z", 21))
+	if res.Tariff.PrecondC != 1 || res.Target != 22 || res.Action != "HEAT" {
+		t.Fatalf("expected pre-heating, got %+v action=%s target=%.1f", res.Tariff, res.Action, res.Target)
+	}
+
+	// During peak the price applies and no further shift is made.
+	res = an.Run("zone-A", read("2025-12-22T17:30:00Z", 21))
+	if res.Tariff.PrecondC != 0 || res.Tariff.Period != "peak" || res.CostEpoch != 0.6 {
+		t.Fatalf("unexpected peak adjustment: %+v cost=%.2f", res.Tariff, res.CostEpoch)
+	}
+
+	// Cooling pre-conditions the other way; weekends have no peak.
+	an.ObserveIssued("zone-A", "COOL")
+	if res = an.Run("zone-A", read("2025-12-22T16:30:00Z", 21)); res.Tariff.PrecondC != -1 {
+		t.Fatalf("expected pre-cooling, got %+v", res.Tariff)
+	}
+	if res = an.Run("zone-A", read("2025-12-27T16:30:00Z", 21)); res.Tariff.PrecondC != 0 {
+		t.Fatalf("no peak on Saturday, got %+v", res.Tariff)
+	}
+}
+
+func TestDREventsWidenBand(t *testing.T) {
+	cfg := tariffTestConfig(t)
+	store, err := NewZoneSetpoints(cfg.Zones, cfg.ZoneTargets, 10.0, 35.0)
+	if err != nil {
+		t.Fatalf("setpoints: %v", err)
+	}
+	now, _ := time.Parse(time.RFC3339, "2025-12-22T16:00:00Z")
+	dr := NewDREvents(cfg)
+	if _, err := dr.Add(DREvent{ID: "too-wide", Start: now, End: now.Add(time.Hour), WidenC: 3}, "alice", now); err == nil {
+		t.Fatalf("widening above dr_max_widen_c must be rejected")
+	}
+	ev, err := dr.Add(DREvent{ID: "dr-1", Start: now, End: now.Add(time.Hour)}, "alice", now)
+	if err != nil || ev.WidenC != 1 || ev.Actor != "alice" {
+		t.Fatalf("add: %+v %v", ev, err)
+	}
+	an := NewAnalyze(cfg, store, nil, dr, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	an.ObserveIssued("zone-A", "HEAT")
+
+	// 20.2 is below target-h (20.5) but inside the band widened to 1.5C, so heating stays off.
+	res := an.Run("zone-A", Reading{ZoneID: "zone-A", EpochStart: "2025-12-22T16:30:00Z", AvgTempC: 20.2, HasTemp: true})
+	if res.Tariff.DREventID != "dr-1" || res.Action != "OFF" || res.Tariff.PrecondC != 0 {
+		t.Fatalf("expected DR to hold heating off, got %+v action=%s", res.Tariff, res.Action)
+	}
+	res = an.Run("zone-A", Reading{ZoneID: "zone-A", EpochStart: "2025-12-22T17:30:00Z", AvgTempC: 20.2, HasTemp: true})
+	if res.Tariff.DREventID != "" || res.Action != "HEAT" {
+		t.Fatalf("event ended, expected heating, got %+v action=%s", res.Tariff, res.Action)
+	}
+	if !dr.Cancel("dr-1", "bob", now) || dr.Cancel("dr-1", "bob", now) {
+		t.Fatalf("cancel should succeed once")
+	}
+	if _, ok := dr.Active("zone-A", now.Add(30*time.Minute)); ok {
+		t.Fatalf("cancelled event still active")
+	}
+}
+
+func TestDREventEndpoints(t *testing.T) {
+	cfg := tariffTestConfig(t)
+	store, err := NewZoneSetpoints(cfg.Zones, cfg.ZoneTargets, 10.0, 35.0)
+	if err != nil {
+		t.Fatalf("setpoints: %v", err)
+	}
+	srv := NewHTTPServer(cfg, store, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		var r io.Reader
+		if body != nil {
+			b, _ := json.Marshal(body)
+			r = bytes.NewReader(b)
+		}
+		req := httptest.NewRequest(method, path, r)
+		req.Header.Set("X-Actor", "alice")
+		rec := httptest.NewRecorder()
+		srv.http.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	start := time.Now().Add(time.Hour).UTC()
+	ev := DREvent{ID: "dr-7", Start: start, End: start.Add(2 * time.Hour), WidenC: 1.5, Zones: []string{"zone-A"}}
+	if rec := do(http.MethodPost, "/dr/events", ev); rec.Code != http.StatusCreated {
+		t.Fatalf("post: %d %s", rec.Code, rec.Body.String())
+	}
+	bad := ev
+	bad.Zones = []string{"zone-Z"}
+	if rec := do(http.MethodPost, "/dr/events", bad); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown zone: %d", rec.Code)
+	}
+	rec := do(http.MethodGet, "/dr/events", nil)
+	var list struct {
+		Events []DREvent `json:"events"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list.Events) != 1 || list.Events[0].WidenC != 1.5 {
+		t.Fatalf("list: %s %v", rec.Body.String(), err)
+	}
+	if rec := do(http.MethodDelete, "/dr/events/dr-7", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/dr/events/dr-7", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("delete twice: %d", rec.Code)
+	}
+	rec = do(http.MethodGet, "/dr/events", nil)
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list.Events) != 1 ||
+		list.Events[0].Actor != "alice" || list.Events[0].CancelledBy != "alice" || list.Events[0].CancelledAt == nil {
+		t.Fatalf("cancelled event: %s %v", rec.Body.String(), err)
+	}
+}
@@ -0,0 +1,186 @@
+// v2
+// services/mape/internal/worker.go
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Consumption modes (engine.consume) and catch-up policies (engine.catch_up).
+const (
+	ConsumeLatest = "latest" // plan the newest report, dropping older ones a busy worker missed
+	ConsumeEvery  = "every"  // plan every report in order
+	CatchUpAll    = "all"    // a zone that fell behind plans every queued epoch
+	CatchUpLatest = "latest" // a zone that fell behind plans the newest and records the rest as skipped
+)
+
+// EngineParams size the per-zone workers.
+type EngineParams struct {
+	Workers        int           // zones analysed and executed at once; 0 runs every zone in parallel
+	ExecuteTimeout time.Duration // a publish still pending after this is abandoned (0 waits)
+	SlowAfter      time.Duration // rounds slower than this are logged as lagging (0 disables)
+	Consume        string        // ConsumeLatest or ConsumeEvery
+	CatchUp        string        // with ConsumeEvery: CatchUpAll or CatchUpLatest
+	QueueEpochs    int           // with ConsumeEvery: reports a zone may queue before its fetcher waits
+}
+
+// DefaultEngineParams run every zone in parallel, give a publish five seconds and plan the
+// latest report only.
+func DefaultEngineParams() EngineParams {
+	return EngineParams{ExecuteTimeout: 5 * time.Second, SlowAfter: time.Second, Consume: ConsumeLatest, CatchUp: CatchUpAll, QueueEpochs: 64}
+}
+
+func (p *EngineParams) set(param, v string) error {
+	switch param {
+	case "consume":
+		if v != ConsumeLatest && v != ConsumeEvery {
+			return fmt.Errorf("engine.consume must be %s or %s, got %q", ConsumeLatest, ConsumeEvery, v)
+		}
+		p.Consume = v
+		return nil
+	case "catch_up":
+		if v != CatchUpAll && v != CatchUpLatest {
+			return fmt.Errorf("engine.catch_up must be %s or %s, got %q", CatchUpAll, CatchUpLatest, v)
+		}
+		p.CatchUp = v
+		return nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fmt.Errorf("engine.%s: %w", param, err)
+	}
+	if f < 0 {
+		return fmt.Errorf("engine.%s must not be negative, got %g", param, f)
+	}
+	switch param {
+	case "workers":
+		p.Workers = int(f)
+	case "execute_timeout_ms":
+		p.ExecuteTimeout = time.Duration(f) * time.Millisecond
+	case "slow_ms":
+		p.SlowAfter = time.Duration(f) * time.Millisecond
+	case "queue_epochs":
+		if f < 1 {
+			return fmt.Errorf("engine.queue_epochs must be at least 1, got %g", f)
+		}
+		p.QueueEpochs = int(f)
+	default:
+		return fmt.Errorf("unknown engine parameter %q", param)
+	}
+	return nil
+}
+
+// inbound is a report handed from a zone's fetcher to its worker.
+type inbound struct {
+	read Reading
+	at   time.Time // when it was fetched
+}
+
+// mailbox hands a zone's reports from its fetcher to its worker. By default it holds the
+// newest report the worker has not processed yet: a report arriving while the previous one
+// still waits replaces it, so a worker that falls behind skips to the latest epoch instead
+// of queueing stale ones, and the fetcher never blocks. With engine.consume=every it is a
+// queue of engine.queue_epochs reports instead, which the fetcher waits on when full, so
+// every epoch reaches the worker in order.
+type mailbox struct {
+	ch    chan inbound
+	queue bool
+	// catchUp is the engine.catch_up policy of a queue.
+	catchUp string
+}
+
+func newMailbox() *mailbox { return &mailbox{ch: make(chan inbound, 1)} }
+
+// newMailboxFor returns the mailbox engine.consume asks for.
+func newMailboxFor(p EngineParams) *mailbox {
+	if p.Consume != ConsumeEvery {
+		return newMailbox()
+	}
+	return &mailbox{ch: make(chan inbound, max(1, p.QueueEpochs)), queue: true, catchUp: p.CatchUp}
+}
+
+// put stores in and reports whether it replaced a waiting report. Only the zone's fetcher
+// may call it: with a single producer the final send always finds room.
+func (m *mailbox) put(in inbound) bool {
+	select {
+	case m.ch <- in:
+		return false
+	default:
+	}
+	replaced := false
+	select {
+	case <-m.ch:
+		replaced = true
+	default:
+	}
+	m.ch <- in
+	return replaced
+}
+
+// push appends in to a queue, waiting for room; it fails only when ctx is done.
+func (m *mailbox) push(ctx context.Context, in inbound) bool {
+	select {
+	case m.ch <- in:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// waiting reports whether a report is queued.
+func (m *mailbox) waiting() bool { return len(m.ch) > 0 }
+
+// zoneWorker is the loop state of one zone. Apart from cancel and wg, which belong to the
+// engine, its fields are only touched by the zone's own worker goroutine.
+type zoneWorker struct {
+	zone   string
+	box    *mailbox
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// applied is the last action executed, used to attribute metered energy.
+	applied string
+	// seen is when the zone last delivered a report; a silent zone goes to its safe state
+	// once safety.stale_s elapses, and lost marks a zone already switched to it.
+	seen time.Time
+	lost bool
+	// epoch is the last epoch index, reused for safe-state plans.
+	epoch int64
+}
+
+func newZoneWorker(zone string, now time.Time, p EngineParams) *zoneWorker {
+	return &zoneWorker{zone: zone, box: newMailboxFor(p), seen: now}
+}
+
+// stop cancels the zone's goroutines and waits for them; a round in progress finishes
+// first. It is a no-op for a worker that is not running.
+func (w *zoneWorker) stop() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	w.wg.Wait()
+	w.cancel = nil
+}
+
+// observeLatency folds one round into the zone's latency figures.
+func (s *ZoneLoopStats) observeLatency(d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	s.LastLatencyMs = ms
+	if s.Rounds == 0 {
+		s.AvgLatencyMs = ms
+	} else {
+		s.AvgLatencyMs = 0.8*s.AvgLatencyMs + 0.2*ms
+	}
+	s.MaxLatencyMs = max(s.MaxLatencyMs, ms)
+	s.Rounds++
+}
+
+func (s *ZoneLoopStats) observeExecute(d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	s.LastExecuteMs = ms
+	s.MaxExecuteMs = max(s.MaxExecuteMs, ms)
+}
@@ -1,4 +1,4 @@
-// v1
+// v2
 // config.go
 
 package main
@@ -63,8 +63,15 @@ type SimConfig struct {
 	KafkaBrokers       []string
 	TopicReadingPrefix string
 	TopicCommandPrefix string
+	TopicAckPrefix     string
+
+	// StuckDevices ignore commands (but still acknowledge their unchanged state), to
+	// exercise MAPE's fault detection.
+	StuckDevices map[string]bool
 }
 
+func (c *SimConfig) isStuck(deviceID string) bool { return c.StuckDevices[deviceID] }
+
 func loadProps(path string) (map[string]string, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
@@ -166,6 +173,15 @@ func buildConfig(log *slog.Logger) (SimConfig, error) {
 		commandPrefix = "zone.commands"
 	}
 
+	ackPrefix := os.Getenv("TOPIC_ACKS_PREFIX")
+	if ackPrefix == "" {
+		ackPrefix = "zone.acks"
+	}
+	stuck := map[string]bool{}
+	for _, id := range splitCSV(props["stuck_devices"]) {
+		stuck[id] = true
+	}
+
 	cfg := SimConfig{
 		ZoneID: zone, ListenAddr: addr,
 		Alpha: alpha, Beta: beta,
@@ -176,6 +192,8 @@ func buildConfig(log *slog.Logger) (SimConfig, error) {
 		KafkaBrokers:       splitCSV(brokersEnv),
 		TopicReadingPrefix: readingsPrefix,
 		TopicCommandPrefix: commandPrefix,
+		TopicAckPrefix:     ackPrefix,
+		StuckDevices:       stuck,
 	}
 
 	if v, ok := props["device.tempSensorId"]; ok && v != "" {
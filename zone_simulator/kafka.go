@@ -1,4 +1,4 @@
-// v5
+// v6
 // kafka.go
 
 package main
@@ -46,6 +46,18 @@ type ActuatorCommand struct {
 	IssuedAt   int64  `json:"issuedAt"`
 }
 
+// CommandAck reports the state an actuator is in after handling a command.
+type CommandAck struct {
+	ZoneID     string `json:"zoneId"`
+	ActuatorID string `json:"actuatorId"`
+	EpochIndex int64  `json:"epochIndex"`
+	Requested  string `json:"requestedMode"`
+	State      string `json:"state"`
+	Applied    bool   `json:"applied"`
+	Error      string `json:"error,omitempty"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
 func newKafkaWriter(log *slog.Logger, brokers []string, topic string) kafkaMessageWriter {
 	baseWriter := &kafka.Writer{
 		Addr:     kafka.TCP(brokers...),
@@ -168,21 +180,47 @@ func (s *Simulator) startPartitionConsumerForDevice(ctx context.Context, deviceI
 			if rd.ActuatorId != deviceID {
 				continue
 			}
-			switch devType {
-			case DeviceHeating:
-				s.setHeating(rd.Mode)
-			case DeviceCooling:
-				s.setCooling(rd.Mode)
-			case DeviceVentilation:
-				lvl := 0
-				if rd.Mode != "" {
-					if v, err := strconv.Atoi(rd.Mode); err == nil {
-						lvl = v
+			ack := CommandAck{ZoneID: s.cfg.ZoneID, ActuatorID: deviceID, EpochIndex: rd.EpochIndex, Requested: rd.Mode, Applied: true}
+			if s.cfg.isStuck(deviceID) {
+				ack.Applied = false
+				ack.Error = "actuator stuck"
+				s.log.Warn("command ignored by stuck actuator", "deviceId", deviceID, "mode", rd.Mode)
+			} else {
+				switch devType {
+				case DeviceHeating:
+					s.setHeating(rd.Mode)
+				case DeviceCooling:
+					s.setCooling(rd.Mode)
+				case DeviceVentilation:
+					lvl := 0
+					if rd.Mode != "" {
+						if v, err := strconv.Atoi(rd.Mode); err == nil {
+							lvl = v
+						}
 					}
+					s.setVent(lvl)
 				}
-				s.setVent(lvl)
+				s.log.Info("applied command", "deviceId", deviceID, "type", devType)
 			}
-			s.log.Info("applied command", "deviceId", deviceID, "type", devType)
+			ack.State = s.actuatorState(devType)
+			ack.Timestamp = time.Now().UnixMilli()
+			s.publishAck(ctx, ack)
 		}
 	}()
 }
+
+// publishAck reports the resulting actuator state back to MAPE; failures are only logged
+// because MAPE re-sends commands it does not see acknowledged.
+func (s *Simulator) publishAck(ctx context.Context, ack CommandAck) {
+	if s.acks == nil {
+		return
+	}
+	b, err := json.Marshal(ack)
+	if err != nil {
+		s.log.Error("ack marshal failed", "err", err)
+		return
+	}
+	if err := s.acks.WriteMessages(ctx, kafka.Message{Key: []byte(ack.ActuatorID), Value: b, Time: time.Now()}); err != nil {
+		s.log.Warn("ack write failed", "err", err, "deviceId", ack.ActuatorID)
+	}
+}
@@ -1,4 +1,4 @@
-// v5
+// v6
 // main.go
 
 package main
@@ -51,6 +51,9 @@ func main() {
 	topic := cfg.TopicReadingPrefix + "." + cfg.ZoneID
 	writer := newKafkaWriter(logger, cfg.KafkaBrokers, topic)
 	logger.Info("kafka writer ready", "topic", topic, "brokers", cfg.KafkaBrokers)
+	ackTopic := cfg.TopicAckPrefix + "." + cfg.ZoneID
+	sim.acks = newKafkaWriter(logger, cfg.KafkaBrokers, ackTopic)
+	logger.Info("ack writer ready", "topic", ackTopic, "stuck_devices", len(cfg.StuckDevices))
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
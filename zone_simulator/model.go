@@ -1,4 +1,4 @@
-// v2
+// v3
 // model.go
 
 package main
@@ -44,6 +44,8 @@ type Simulator struct {
 	heatID       string
 	coolID       string
 	fanID        string
+
+	acks kafkaMessageWriter // command acknowledgements; nil disables them
 }
 
 func (s *Simulator) integrate(now time.Time) {
@@ -105,6 +107,21 @@ func (s *Simulator) logActuatorSample() {
 	s.log.Info("actuator power sample", "zoneId", s.cfg.ZoneID, "t_in", tIn, "t_out", tOut, "sample", sample)
 }
 
+// actuatorState returns the current state of an actuator type as reported in acks.
+func (s *Simulator) actuatorState(t DeviceType) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch t {
+	case DeviceHeating:
+		return string(s.heat)
+	case DeviceCooling:
+		return string(s.cool)
+	case DeviceVentilation:
+		return strconv.Itoa(s.vent)
+	}
+	return ""
+}
+
 func (s *Simulator) ventState() string {
 	s.mu.Lock()
 	v := s.vent